@@ -0,0 +1,158 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/pow/cpuminer"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/types"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+// BillingHash returns the hash of the billing summary signed by the replicas of the database, it
+// covers the billed height range, the hash of the last billed SQL chain block and the items.
+func BillingHash(p *types.BillingPayload) (h hash.Hash, err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = utils.WriteElements(buffer, binary.BigEndian,
+		p.DatabaseID,
+		p.StartHeight,
+		p.EndHeight,
+		p.GetEndBlockHash().GetHash(),
+		uint32(len(p.Items)),
+	); err != nil {
+		return
+	}
+
+	for _, item := range p.Items {
+		if err = utils.WriteElements(buffer, binary.BigEndian,
+			item.GetMiner().GetNodeID(),
+			item.QueryCount,
+		); err != nil {
+			return
+		}
+	}
+
+	return hash.THashH(buffer.Bytes()), nil
+}
+
+// SignBilling signs the billing summary with the private key of a replica and appends the
+// signature to the payload, nonce is the nonce of the replica node id.
+func SignBilling(p *types.BillingPayload, signer *asymmetric.PrivateKey,
+	nonce cpuminer.Uint256) (err error) {
+	h, err := BillingHash(p)
+
+	if err != nil {
+		return
+	}
+
+	s, err := signReplica(h, signer, nonce)
+
+	if err != nil {
+		return
+	}
+
+	p.Signatures = append(p.Signatures, s)
+	return
+}
+
+// signReplica signs the hash with the private key of a replica, nonce is the nonce of the replica
+// node id.
+func signReplica(h hash.Hash, signer *asymmetric.PrivateKey, nonce cpuminer.Uint256) (
+	s *types.BillingSignature, err error) {
+	signature, err := signer.Sign(h[:])
+
+	if err != nil {
+		return
+	}
+
+	return &types.BillingSignature{
+		Signee: &types.PublicKey{PublicKey: signer.PubKey().Serialize()},
+		Nonce:  nonce.Bytes(),
+		Signature: &types.Signature{
+			R: signature.R.String(),
+			S: signature.S.String(),
+		},
+	}, nil
+}
+
+// VerifyBillingSignature verifies a signature of the billing summary, and returns the node id of
+// the signer which is mined from its public key and nonce.
+func VerifyBillingSignature(p *types.BillingPayload, s *types.BillingSignature) (
+	id proto2.NodeID, err error) {
+	h, err := BillingHash(p)
+
+	if err != nil {
+		return
+	}
+
+	return verifyReplicaSignature(h, s)
+}
+
+// verifyReplicaSignature verifies a signature of the hash by a replica, and returns the node id of
+// the signer.
+func verifyReplicaSignature(h hash.Hash, s *types.BillingSignature) (
+	id proto2.NodeID, err error) {
+	pub, err := asymmetric.ParsePubKey(s.GetSignee().GetPublicKey())
+
+	if err != nil {
+		return
+	}
+
+	nonce, err := cpuminer.FromBytes(s.Nonce)
+
+	if err != nil {
+		return
+	}
+
+	r, rok := new(big.Int).SetString(s.GetSignature().GetR(), 10)
+	ss, sok := new(big.Int).SetString(s.GetSignature().GetS(), 10)
+
+	if !rok || !sok {
+		return id, ErrInvalidTxPayload
+	}
+
+	signature := &asymmetric.Signature{R: r, S: ss}
+
+	if !signature.Verify(h[:], pub) {
+		return id, ErrSignVerification
+	}
+
+	return proto2.NodeID(cpuminer.HashBlock(pub.Serialize(), *nonce).String()), nil
+}
+
+// billingSigners verifies the signatures of the billing summary, and returns the node ids of the
+// signers.
+func billingSigners(p *types.BillingPayload) (ids []proto2.NodeID, err error) {
+	for _, s := range p.Signatures {
+		var id proto2.NodeID
+
+		if id, err = VerifyBillingSignature(p, s); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return
+}
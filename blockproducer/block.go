@@ -24,6 +24,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
 	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/merkle"
 	proto2 "github.com/thunderdb/ThunderDB/proto"
 	"github.com/thunderdb/ThunderDB/types"
 )
@@ -36,20 +37,34 @@ type Header struct {
 	Parent     hash.Hash
 	MerkleRoot hash.Hash
 	Timestamp  time.Time
+
+	// ParamsHash commits the block producers and the chain parameters of the genesis in the
+	// genesis block, it's empty in the other blocks.
+	ParamsHash hash.Hash
 }
 
-func (h *Header) marshal() ([]byte, error) {
-	return proto.Marshal(&types.BPHeader{
+func (h *Header) toBPHeader() *types.BPHeader {
+	return &types.BPHeader{
 		Version:    h.Version,
 		Producer:   &types.AccountAddress{AccountAddress: string(h.Producer)},
 		Root:       &types.Hash{Hash: h.Root[:]},
 		Parent:     &types.Hash{Hash: h.Parent[:]},
 		MerkleRoot: &types.Hash{Hash: h.MerkleRoot[:]},
 		Timestamp:  h.Timestamp.UnixNano(),
-	})
+		ParamsHash: &types.Hash{Hash: h.ParamsHash[:]},
+	}
+}
+
+func (h *Header) marshal() ([]byte, error) {
+	return proto.Marshal(h.toBPHeader())
 }
 
 func (h *Header) fromBPHeader(bpHeader *types.BPHeader) error {
+	if bpHeader == nil || bpHeader.Producer == nil || bpHeader.Root == nil ||
+		bpHeader.Parent == nil || bpHeader.MerkleRoot == nil {
+		return ErrNilValue
+	}
+
 	rootHash, err := hash.NewHash(bpHeader.Root.Hash)
 	if err != nil {
 		return err
@@ -72,6 +87,15 @@ func (h *Header) fromBPHeader(bpHeader *types.BPHeader) error {
 	h.MerkleRoot = *MerkleRootHash
 	h.Timestamp = time.Unix(0, bpHeader.Timestamp).UTC()
 
+	if bpHeader.ParamsHash != nil {
+		paramsHash, err := hash.NewHash(bpHeader.ParamsHash.Hash)
+		if err != nil {
+			return err
+		}
+
+		h.ParamsHash = *paramsHash
+	}
+
 	return nil
 }
 
@@ -86,14 +110,7 @@ type SignedHeader struct {
 
 func (h *SignedHeader) toBPSignedHeader() *types.BPSignedHeader {
 	return &types.BPSignedHeader{
-		Header: &types.BPHeader{
-			Version:    h.Version,
-			Producer:   &types.AccountAddress{AccountAddress: string(h.Producer)},
-			Root:       &types.Hash{Hash: h.Root[:]},
-			Parent:     &types.Hash{Hash: h.Parent[:]},
-			MerkleRoot: &types.Hash{Hash: h.MerkleRoot[:]},
-			Timestamp:  h.Timestamp.UnixNano(),
-		},
+		Header:    h.Header.toBPHeader(),
 		BlockHash: &types.Hash{Hash: h.BlockHash[:]},
 		Signee: &types.PublicKey{
 			PublicKey: h.PublicKey.Serialize(),
@@ -106,6 +123,11 @@ func (h *SignedHeader) toBPSignedHeader() *types.BPSignedHeader {
 }
 
 func (h *SignedHeader) fromBPSignedHeader(bpSignedHeader *types.BPSignedHeader) error {
+	if bpSignedHeader == nil || bpSignedHeader.BlockHash == nil ||
+		bpSignedHeader.Signee == nil || bpSignedHeader.Signature == nil {
+		return ErrNilValue
+	}
+
 	blockHash, err := hash.NewHash(bpSignedHeader.BlockHash.Hash)
 	if err != nil {
 		return err
@@ -160,6 +182,8 @@ type TxData struct {
 	Recipient    *types.AccountAddress
 	Amount       *big.Int
 	Payload      []byte
+	Fee          uint64
+	Type         types.BPTxType
 
 	Signature *asymmetric.Signature
 	PublicKey *asymmetric.PublicKey
@@ -171,6 +195,8 @@ func (t *TxData) toBPTxData() *types.BPTxData {
 		Recipient:    t.Recipient,
 		Amount:       t.Amount.Bytes(),
 		Payload:      t.Payload,
+		Fee:          t.Fee,
+		Type:         t.Type,
 		Signature: &types.Signature{
 			R: t.Signature.R.String(),
 			S: t.Signature.S.String(),
@@ -186,6 +212,10 @@ func (t *TxData) marshal() ([]byte, error) {
 }
 
 func (t *TxData) fromBPTxData(bpTxData *types.BPTxData) error {
+	if bpTxData == nil || bpTxData.Signee == nil || bpTxData.Signature == nil {
+		return ErrNilValue
+	}
+
 	publicKey, err := asymmetric.ParsePubKey(bpTxData.Signee.PublicKey)
 	if err != nil {
 		return err
@@ -203,6 +233,8 @@ func (t *TxData) fromBPTxData(bpTxData *types.BPTxData) error {
 	t.Recipient = bpTxData.Recipient
 	t.Amount = amount
 	t.Payload = bpTxData.Payload
+	t.Fee = bpTxData.Fee
+	t.Type = bpTxData.Type
 	t.Signature = &asymmetric.Signature{
 		R: r,
 		S: s,
@@ -212,13 +244,128 @@ func (t *TxData) fromBPTxData(bpTxData *types.BPTxData) error {
 	return nil
 }
 
+// hash returns the hash of the tx data signed by the sender, which excludes the signature.
+func (t *TxData) hash() (h hash.Hash, err error) {
+	if t.Amount == nil || t.PublicKey == nil {
+		return h, ErrNilValue
+	}
+
+	buffer, err := proto.Marshal(&types.BPTxData{
+		AccountNonce: t.AccountNonce,
+		Recipient:    t.Recipient,
+		Amount:       t.Amount.Bytes(),
+		Payload:      t.Payload,
+		Fee:          t.Fee,
+		Type:         t.Type,
+		Signee: &types.PublicKey{
+			PublicKey: t.PublicKey.Serialize(),
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	return hash.THashH(buffer), nil
+}
+
+// Sign sets the public key of the sender and signs the tx data with the given private key.
+func (t *TxData) Sign(signer *asymmetric.PrivateKey) (err error) {
+	t.PublicKey = signer.PubKey()
+	h, err := t.hash()
+	if err != nil {
+		return
+	}
+
+	t.Signature, err = signer.Sign(h[:])
+	return
+}
+
+// Verify verifies the signature of the tx data.
+func (t *TxData) Verify() (err error) {
+	if t.Signature == nil {
+		return ErrNilValue
+	}
+
+	h, err := t.hash()
+	if err != nil {
+		return
+	}
+
+	if !t.Signature.Verify(h[:], t.PublicKey) {
+		return ErrSignVerification
+	}
+
+	return
+}
+
+// Sender returns the account address of the tx sender.
+func (t *TxData) Sender() proto2.AccountAddress {
+	return AccountAddressFromPublicKey(t.PublicKey)
+}
+
+// Cost returns the total amount of the tx sender spent, which is the transferred amount plus the
+// fee. A withdrawal only costs the fee since its amount is taken from the database.
+func (t *TxData) Cost() *big.Int {
+	if t.Type == types.BPTxType_WITHDRAW {
+		return new(big.Int).SetUint64(t.Fee)
+	}
+
+	return new(big.Int).Add(t.Amount, new(big.Int).SetUint64(t.Fee))
+}
+
 // Tx includes TxData and TxData's hash
 type Tx struct {
 	TxHash hash.Hash
 	TxData TxData
 }
 
+// Sign signs the tx data with the given private key and computes the tx hash.
+func (t *Tx) Sign(signer *asymmetric.PrivateKey) (err error) {
+	if err = t.TxData.Sign(signer); err != nil {
+		return
+	}
+
+	buffer, err := t.TxData.marshal()
+	if err != nil {
+		return
+	}
+
+	t.TxHash = hash.THashH(buffer)
+	return
+}
+
+// Verify verifies the signature and the payload of the tx data, and the tx hash.
+func (t *Tx) Verify() (err error) {
+	if err = t.TxData.Verify(); err != nil {
+		return
+	}
+
+	if err = t.TxData.Validate(); err != nil {
+		return
+	}
+
+	buffer, err := t.TxData.marshal()
+	if err != nil {
+		return
+	}
+
+	if h := hash.THashH(buffer); !h.IsEqual(&t.TxHash) {
+		return ErrHashVerification
+	}
+
+	return
+}
+
+// Hash implements merkle.Hashable.
+func (t *Tx) Hash() *hash.Hash {
+	return &t.TxHash
+}
+
 func (t *Tx) fromBPTx(BPTx *types.BPTx) error {
+	if BPTx == nil || BPTx.TxHash == nil {
+		return ErrNilValue
+	}
+
 	txHash, err := hash.NewHash(BPTx.TxHash.Hash)
 	if err != nil {
 		return err
@@ -257,6 +404,16 @@ func (t *Tx) unmarshal(buff []byte) error {
 	return err
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (t *Tx) MarshalBinary() ([]byte, error) {
+	return t.marshal()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (t *Tx) UnmarshalBinary(buff []byte) error {
+	return t.unmarshal(buff)
+}
+
 // Block is generated by Block Producer
 type Block struct {
 	Header *SignedHeader
@@ -306,3 +463,73 @@ func (b *Block) unmarshal(buff []byte) error {
 	b.Tx = txes
 	return nil
 }
+
+// MerkleRoot computes the merkle root of the tx hashes, it returns an empty hash if there is no
+// tx.
+func (b *Block) MerkleRoot() hash.Hash {
+	if len(b.Tx) == 0 {
+		return hash.Hash{}
+	}
+
+	items := make([]*merkle.Hashable, len(b.Tx))
+
+	for i := range b.Tx {
+		item := merkle.Hashable(b.Tx[i])
+		items[i] = &item
+	}
+
+	return *merkle.NewMerkle(items).GetRoot()
+}
+
+// SignHeader sets the merkle root of the txs, computes the block hash and signs the block header
+// with the given private key.
+func (b *Block) SignHeader(signer *asymmetric.PrivateKey) (err error) {
+	b.Header.MerkleRoot = b.MerkleRoot()
+	buffer, err := b.Header.Header.marshal()
+	if err != nil {
+		return
+	}
+
+	b.Header.BlockHash = hash.THashH(buffer)
+	b.Header.PublicKey = signer.PubKey()
+	b.Header.Signature, err = signer.Sign(b.Header.BlockHash[:])
+	return
+}
+
+// AccountAddressFromPublicKey returns the account address owned by the given public key.
+func AccountAddressFromPublicKey(pub *asymmetric.PublicKey) proto2.AccountAddress {
+	return proto2.AccountAddress(hash.THashH(pub.Serialize()).String())
+}
+
+// Verify verifies the txs, the merkle root, the block hash and the signature of the block
+// header.
+func (b *Block) Verify() error {
+	if b.Header == nil || b.Header.PublicKey == nil || b.Header.Signature == nil {
+		return ErrNilValue
+	}
+
+	for _, tx := range b.Tx {
+		if err := tx.Verify(); err != nil {
+			return err
+		}
+	}
+
+	if mr := b.MerkleRoot(); !mr.IsEqual(&b.Header.MerkleRoot) {
+		return ErrMerkleRootVerification
+	}
+
+	buffer, err := b.Header.Header.marshal()
+	if err != nil {
+		return err
+	}
+
+	if h := hash.THashH(buffer); !h.IsEqual(&b.Header.BlockHash) {
+		return ErrHashVerification
+	}
+
+	if !b.Header.Signature.Verify(b.Header.BlockHash[:], b.Header.PublicKey) {
+		return ErrSignVerification
+	}
+
+	return nil
+}
@@ -200,3 +200,135 @@ func TestBlock(t *testing.T) {
 
 	}
 }
+
+func TestMalformedMessages(t *testing.T) {
+	bpHeader := header.toBPSignedHeader()
+	bpHeader.Header.Root = nil
+
+	if err := new(SignedHeader).fromBPSignedHeader(bpHeader); err != ErrNilValue {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, bpHeader := range []*types.BPSignedHeader{
+		{BlockHash: bpHeader.BlockHash, Signee: bpHeader.Signee, Signature: bpHeader.Signature},
+		{Header: bpHeader.Header, Signee: bpHeader.Signee, Signature: bpHeader.Signature},
+		{Header: bpHeader.Header, BlockHash: bpHeader.BlockHash, Signature: bpHeader.Signature},
+		{Header: bpHeader.Header, BlockHash: bpHeader.BlockHash, Signee: bpHeader.Signee},
+	} {
+		if err := new(SignedHeader).fromBPSignedHeader(bpHeader); err != ErrNilValue {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	bpTx := txSlice[0].toBPTx()
+	bpTx.TxData.Signee = nil
+
+	if err := new(Tx).fromBPTx(bpTx); err != ErrNilValue {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	bpTx = txSlice[0].toBPTx()
+	bpTx.TxData.Signature = nil
+
+	if err := new(Tx).fromBPTx(bpTx); err != ErrNilValue {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := new(Tx).fromBPTx(&types.BPTx{TxHash: bpTx.TxHash}); err != ErrNilValue {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := new(Tx).fromBPTx(&types.BPTx{TxData: bpTx.TxData}); err != ErrNilValue {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestTxSign(t *testing.T) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	tx := createTestTx(t, priv, 1, 10, 1)
+	if err = tx.Verify(); err != nil {
+		t.Errorf("tx should be verified: %v", err)
+	}
+
+	// tx survives serialization
+	buff, err := tx.marshal()
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+	deserializedTx := &Tx{}
+	if err = deserializedTx.unmarshal(buff); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+	if err = deserializedTx.Verify(); err != nil {
+		t.Errorf("deserialized tx should be verified: %v", err)
+	}
+
+	// tampered tx data
+	tx.TxData.Amount = big.NewInt(11)
+	if err = tx.Verify(); err != ErrSignVerification {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// tampered tx hash
+	tx.TxData.Amount = big.NewInt(10)
+	tx.TxHash = hash.THashH([]byte{1})
+	if err = tx.Verify(); err != ErrHashVerification {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBlockVerify(t *testing.T) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	txs := []*Tx{
+		createTestTx(t, priv, 0, 10, 1),
+		createTestTx(t, priv, 1, 10, 1),
+		createTestTx(t, priv, 2, 10, 1),
+	}
+	b, err := createTestBlock(priv, hash.THashH([]byte{1}), hash.Hash{}, txs)
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+	if err = b.Verify(); err != nil {
+		t.Errorf("block should be verified: %v", err)
+	}
+
+	// block survives serialization
+	buff, err := b.marshal()
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+	deserializedBlock := &Block{}
+	if err = deserializedBlock.unmarshal(buff); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+	if err = deserializedBlock.Verify(); err != nil {
+		t.Errorf("deserialized block should be verified: %v", err)
+	}
+
+	// tx removed
+	b.Tx = txs[:2]
+	if err = b.Verify(); err != ErrMerkleRootVerification {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// tx tampered
+	b.Tx = txs
+	txs[1].TxData.Fee = 2
+	if err = b.Verify(); err != ErrSignVerification {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// tx replaced by another valid one
+	txs[1] = createTestTx(t, priv, 1, 10, 2)
+	if err = b.Verify(); err != ErrMerkleRootVerification {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
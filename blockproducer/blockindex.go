@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+)
+
+type blockNode struct {
+	parent    *blockNode
+	hash      hash.Hash
+	height    int32
+	timestamp time.Time
+}
+
+func newBlockNode(header *SignedHeader, parent *blockNode) (node *blockNode) {
+	node = &blockNode{
+		hash:      header.BlockHash,
+		timestamp: header.Timestamp,
+	}
+
+	if parent != nil {
+		node.parent = parent
+		node.height = parent.height + 1
+	}
+
+	return
+}
+
+func (bn *blockNode) ancestor(height int32) (ancestor *blockNode) {
+	if height < 0 || height > bn.height {
+		return nil
+	}
+
+	ancestor = bn
+
+	for ancestor != nil && ancestor.height > height {
+		ancestor = ancestor.parent
+	}
+
+	return
+}
+
+func (bn *blockNode) indexKey() []byte {
+	indexKey := make([]byte, hash.HashSize+4)
+	binary.BigEndian.PutUint32(indexKey[0:4], uint32(bn.height))
+	copy(indexKey[4:], bn.hash[:])
+	return indexKey
+}
+
+type blockIndex struct {
+	mu    sync.RWMutex
+	index map[hash.Hash]*blockNode
+}
+
+func newBlockIndex() *blockIndex {
+	return &blockIndex{
+		index: make(map[hash.Hash]*blockNode),
+	}
+}
+
+func (bi *blockIndex) addBlock(node *blockNode) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	bi.index[node.hash] = node
+}
+
+func (bi *blockIndex) removeBlock(node *blockNode) {
+	bi.mu.Lock()
+	defer bi.mu.Unlock()
+	delete(bi.index, node.hash)
+}
+
+func (bi *blockIndex) lookupNode(h *hash.Hash) *blockNode {
+	bi.mu.RLock()
+	defer bi.mu.RUnlock()
+	return bi.index[*h]
+}
@@ -0,0 +1,983 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"sync"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+var (
+	metaBucket           = [4]byte{0x0, 0x0, 0x0, 0x0}
+	metaStateKey         = []byte("thunderdb-state")
+	metaBlockIndexBucket = []byte("thunderdb-block-index-bucket")
+	metaAccountBucket    = []byte("thunderdb-account-bucket")
+	metaDatabaseBucket   = []byte("thunderdb-database-bucket")
+	metaMinerBucket      = []byte("thunderdb-miner-bucket")
+	metaUndoBucket       = []byte("thunderdb-undo-bucket")
+	metaVoteBucket       = []byte("thunderdb-vote-bucket")
+	metaHeightVoteBucket = []byte("thunderdb-height-vote-bucket")
+
+	// stateBuckets are the buckets committed by the state root in order
+	stateBuckets = [][]byte{metaAccountBucket, metaDatabaseBucket, metaMinerBucket}
+)
+
+// State represents a snapshot of current best chain.
+type State struct {
+	node   *blockNode
+	Head   hash.Hash
+	Height int32
+
+	// Finalized is the height of the last finalized block, which can't be popped from the chain.
+	Finalized int32
+}
+
+func (s *State) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		s.Head,
+		s.Height,
+		s.Finalized,
+	); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (s *State) unmarshal(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	return utils.ReadElements(reader, binary.BigEndian,
+		&s.Head,
+		&s.Height,
+		&s.Finalized,
+	)
+}
+
+// Chain represents the main chain of the block producers.
+type Chain struct {
+	mu    sync.Mutex
+	cfg   *Config
+	db    *bolt.DB
+	index *blockIndex
+	state *State
+
+	// votes collects the votes of the validators on the unfinalized blocks
+	votes map[hash.Hash]*voteSet
+
+	// side keeps the blocks of the side branches forking from the best chain above the finalized
+	// height in memory, they are fetched again from the other block producers after a restart
+	side map[hash.Hash]*Block
+}
+
+// voteSet is the votes of the validators on a block.
+type voteSet struct {
+	height int32
+	votes  map[*Validator]*Vote
+}
+
+// NewChain creates a new main chain with the genesis block of the config.
+func NewChain(cfg *Config) (chain *Chain, err error) {
+	if cfg.Genesis == nil || cfg.Genesis.Header == nil {
+		return nil, ErrNilValue
+	}
+
+	if err = cfg.Genesis.Verify(); err != nil {
+		return
+	}
+
+	// The genesis block has no parent and no tx, and commits the initial account states
+	if root := StateRoot(cfg.Accounts); !cfg.Genesis.Header.Parent.IsEqual(&hash.Hash{}) ||
+		len(cfg.Genesis.Tx) != 0 || !cfg.Genesis.Header.Root.IsEqual(&root) {
+		return nil, ErrInvalidGenesis
+	}
+
+	// Open DB file
+	db, err := bolt.Open(cfg.DataDir, 0600, nil)
+
+	if err != nil {
+		return
+	}
+
+	// Create buckets for chain meta
+	err = db.Update(func(tx *bolt.Tx) (err error) {
+		bucket, err := tx.CreateBucketIfNotExists(metaBucket[:])
+
+		if err != nil {
+			return
+		}
+
+		if _, err = bucket.CreateBucketIfNotExists(metaBlockIndexBucket); err != nil {
+			return
+		}
+
+		if _, err = bucket.CreateBucketIfNotExists(metaUndoBucket); err != nil {
+			return
+		}
+
+		if _, err = bucket.CreateBucketIfNotExists(metaVoteBucket); err != nil {
+			return
+		}
+
+		if _, err = bucket.CreateBucketIfNotExists(metaHeightVoteBucket); err != nil {
+			return
+		}
+
+		for _, name := range stateBuckets {
+			if _, err = bucket.CreateBucketIfNotExists(name); err != nil {
+				return
+			}
+		}
+
+		// Write the initial account states
+		state := &accountState{meta: bucket}
+
+		for _, a := range cfg.Accounts {
+			if err = state.put(a); err != nil {
+				return
+			}
+		}
+
+		return
+	})
+
+	if err != nil {
+		db.Close()
+		return
+	}
+
+	chain = &Chain{
+		cfg:   cfg,
+		db:    db,
+		index: newBlockIndex(),
+		state: &State{
+			node:   nil,
+			Head:   hash.Hash{},
+			Height: -1,
+		},
+	}
+
+	if err = chain.PushBlock(cfg.Genesis); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// LoadChain rebuilds the chain from the database of the config.
+func LoadChain(cfg *Config) (chain *Chain, err error) {
+	// Open DB file
+	db, err := bolt.Open(cfg.DataDir, 0600, nil)
+
+	if err != nil {
+		return
+	}
+
+	chain = &Chain{
+		cfg:   cfg,
+		db:    db,
+		index: newBlockIndex(),
+		state: &State{},
+	}
+
+	err = chain.db.View(func(tx *bolt.Tx) (err error) {
+		// Read state struct
+		bucket := tx.Bucket(metaBucket[:])
+
+		if bucket == nil {
+			return ErrBlockNotFound
+		}
+
+		if err = chain.state.unmarshal(bucket.Get(metaStateKey)); err != nil {
+			return
+		}
+
+		// Rebuild memory index, blocks are sorted by height so that parents are always added
+		// before their children
+		var last *blockNode
+		cursor := bucket.Bucket(metaBlockIndexBucket).Cursor()
+
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			block := &Block{}
+
+			if err = block.unmarshal(v); err != nil {
+				return
+			}
+
+			if err = block.Verify(); err != nil {
+				return
+			}
+
+			var parent *blockNode
+
+			if last == nil {
+				if !block.Header.Parent.IsEqual(&hash.Hash{}) {
+					return ErrInvalidGenesis
+				}
+			} else if parent = chain.index.lookupNode(&block.Header.Parent); parent == nil {
+				return ErrParentNotFound
+			}
+
+			last = newBlockNode(block.Header, parent)
+			chain.index.addBlock(last)
+		}
+
+		if last == nil || !last.hash.IsEqual(&chain.state.Head) ||
+			last.height != chain.state.Height {
+			return ErrBlockNotFound
+		}
+
+		chain.state.node = last
+		vs := cfg.Validators
+
+		if vs == nil {
+			return
+		}
+
+		// The finalized block must be finalized by the votes of a quorum of the validators
+		if chain.state.Finalized > 0 {
+			node := last.ancestor(chain.state.Finalized)
+			v := bucket.Bucket(metaVoteBucket).Get(node.hash[:])
+
+			if v == nil {
+				return ErrInsufficientVotes
+			}
+
+			var votes []*Vote
+
+			if votes, err = unmarshalVotes(v); err != nil {
+				return
+			}
+
+			if err = vs.VerifyVotes(node.height, node.hash, votes); err != nil {
+				return
+			}
+		}
+
+		// Collect the votes on the unfinalized blocks of the best chain again
+		start := make([]byte, 4)
+		binary.BigEndian.PutUint32(start, uint32(chain.state.Finalized+1))
+		cursor = bucket.Bucket(metaHeightVoteBucket).Cursor()
+
+		for k, v := cursor.Seek(start); k != nil; k, v = cursor.Next() {
+			vote := &Vote{}
+
+			if err = vote.unmarshal(v); err != nil {
+				return
+			}
+
+			val := vs.lookup(vote.Signee)
+
+			if node := last.ancestor(vote.Height); val != nil && node != nil &&
+				node.hash.IsEqual(&vote.BlockHash) {
+				chain.addVote(val, vote)
+			}
+		}
+
+		return
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// Stop closes the chain database.
+func (c *Chain) Stop() error {
+	return c.db.Close()
+}
+
+// PushBlock validates the block, checks that it is signed by its producer, which must be the
+// validator in turn if the chain has a validator set, and pushes it to the chain. A block
+// extending the head is applied to the account states, which must match the state root committed
+// in the block header. A block extending a side branch is kept until the side branch is longer
+// than the best chain, then the chain is reorganized to the side branch.
+func (c *Chain) PushBlock(block *Block) (err error) {
+	if err = block.Verify(); err != nil {
+		return
+	}
+
+	if block.Header.Producer != AccountAddressFromPublicKey(block.Header.PublicKey) {
+		return ErrInvalidProducer
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if block.Header.Parent.IsEqual(&c.state.Head) {
+		return c.pushBlock(block)
+	}
+
+	return c.pushSideBlock(block)
+}
+
+// pushBlock applies the block extending the head to the chain, the caller must hold the chain
+// lock.
+func (c *Chain) pushBlock(block *Block) (err error) {
+	if vs := c.cfg.Validators; vs != nil {
+		var parent time.Time
+
+		if c.state.node != nil {
+			parent = c.state.node.timestamp
+		}
+
+		if err = vs.VerifyProducer(c.state.Height+1, parent, block.Header); err != nil {
+			return
+		}
+	}
+
+	node := newBlockNode(block.Header, c.state.node)
+	state := &State{
+		node:      node,
+		Head:      node.hash,
+		Height:    node.height,
+		Finalized: c.state.Finalized,
+	}
+
+	sb, err := state.marshal()
+
+	if err != nil {
+		return
+	}
+
+	bb, err := block.marshal()
+
+	if err != nil {
+		return
+	}
+
+	err = c.db.Update(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(metaBucket[:])
+		accounts := &accountState{
+			meta: bucket,
+			undo: &undoData{},
+			cfg:  c.cfg,
+		}
+
+		if err = accounts.applyBlock(block); err != nil {
+			return
+		}
+
+		root, err := accounts.root()
+
+		if err != nil {
+			return
+		}
+
+		if !root.IsEqual(&block.Header.Root) {
+			return ErrStateRootMismatch
+		}
+
+		ub, err := accounts.undo.marshal()
+
+		if err != nil {
+			return
+		}
+
+		if err = bucket.Bucket(metaUndoBucket).Put(node.hash[:], ub); err != nil {
+			return
+		}
+
+		if err = bucket.Bucket(metaBlockIndexBucket).Put(node.indexKey(), bb); err != nil {
+			return
+		}
+
+		return bucket.Put(metaStateKey, sb)
+	})
+
+	if err != nil {
+		return
+	}
+
+	c.index.addBlock(node)
+	c.state = state
+	log.Debugf("pushed main chain block: height = %d, hash = %s", node.height, node.hash.String())
+	return
+}
+
+// pushSideBlock keeps the block extending a side branch, and reorganizes the chain to the side
+// branch once it's longer than the best chain. The best chain is restored if any block of the
+// side branch fails to be applied, the caller must hold the chain lock.
+func (c *Chain) pushSideBlock(block *Block) (err error) {
+	if c.index.lookupNode(&block.Header.BlockHash) != nil ||
+		c.side[block.Header.BlockHash] != nil {
+		return ErrBlockExists
+	}
+
+	// Collect the side branch from the block down to the fork point on the best chain
+	branch := []*Block{block}
+
+	for b := c.side[block.Header.Parent]; b != nil; b = c.side[b.Header.Parent] {
+		branch = append(branch, b)
+	}
+
+	fork := c.index.lookupNode(&branch[len(branch)-1].Header.Parent)
+
+	if fork == nil {
+		return ErrParentNotFound
+	}
+
+	if fork.height < c.state.Finalized {
+		return ErrForkFinalized
+	}
+
+	height := fork.height + int32(len(branch))
+
+	if vs := c.cfg.Validators; vs != nil {
+		parent := fork.timestamp
+
+		if len(branch) > 1 {
+			parent = branch[1].Header.Timestamp
+		}
+
+		if err = vs.VerifyProducer(height, parent, block.Header); err != nil {
+			return
+		}
+	}
+
+	if c.side == nil {
+		c.side = make(map[hash.Hash]*Block)
+	}
+
+	c.side[block.Header.BlockHash] = block
+
+	if height <= c.state.Height {
+		log.Debugf("kept main chain side block: height = %d, hash = %s",
+			height, block.Header.BlockHash.String())
+		return
+	}
+
+	var popped []*Block
+
+	for c.state.node != fork {
+		var b *Block
+
+		if b, err = c.popBlock(); err != nil {
+			return
+		}
+
+		popped = append(popped, b)
+	}
+
+	for i := len(branch) - 1; i >= 0; i-- {
+		if err = c.pushBlock(branch[i]); err != nil {
+			// Drop the invalid block and its descendants, and switch back to the best chain
+			for _, b := range branch[:i+1] {
+				delete(c.side, b.Header.BlockHash)
+			}
+
+			for c.state.node != fork {
+				if _, perr := c.popBlock(); perr != nil {
+					return perr
+				}
+			}
+
+			for j := len(popped) - 1; j >= 0; j-- {
+				if perr := c.pushBlock(popped[j]); perr != nil {
+					return perr
+				}
+			}
+
+			return
+		}
+
+		delete(c.side, branch[i].Header.BlockHash)
+	}
+
+	for _, b := range popped {
+		c.side[b.Header.BlockHash] = b
+	}
+
+	log.Infof("reorganized main chain: fork height = %d, popped = %d, pushed = %d",
+		fork.height, len(popped), len(branch))
+	return
+}
+
+// PopBlock disconnects the head block from the chain and reverts the account states with its
+// undo data, which is used to switch to another branch during a reorganization.
+func (c *Chain) PopBlock() (block *Block, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.popBlock()
+}
+
+// popBlock disconnects the head block from the chain, the caller must hold the chain lock.
+func (c *Chain) popBlock() (block *Block, err error) {
+	node := c.state.node
+
+	if node.parent == nil {
+		return nil, ErrPopGenesis
+	}
+
+	if node.height <= c.state.Finalized {
+		return nil, ErrPopFinalized
+	}
+
+	if block, err = c.fetchBlock(node); err != nil {
+		return
+	}
+
+	state := &State{
+		node:      node.parent,
+		Head:      node.parent.hash,
+		Height:    node.parent.height,
+		Finalized: c.state.Finalized,
+	}
+
+	sb, err := state.marshal()
+
+	if err != nil {
+		return
+	}
+
+	err = c.db.Update(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(metaBucket[:])
+		ub := bucket.Bucket(metaUndoBucket)
+		v := ub.Get(node.hash[:])
+
+		if v == nil {
+			return ErrUndoDataNotFound
+		}
+
+		undo := &undoData{}
+
+		if err = undo.unmarshal(v); err != nil {
+			return
+		}
+
+		accounts := &accountState{meta: bucket}
+
+		if err = accounts.revert(undo); err != nil {
+			return
+		}
+
+		if err = ub.Delete(node.hash[:]); err != nil {
+			return
+		}
+
+		if err = bucket.Bucket(metaBlockIndexBucket).Delete(node.indexKey()); err != nil {
+			return
+		}
+
+		return bucket.Put(metaStateKey, sb)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.index.removeBlock(node)
+	c.state = state
+	log.Debugf("popped main chain block: height = %d, hash = %s", node.height, node.hash.String())
+	return
+}
+
+// AddVote adds the vote of a validator on a block of the chain. The block and its ancestors are
+// finalized once the votes of a quorum of the validators are collected, it returns the height of
+// the last finalized block.
+func (c *Chain) AddVote(vote *Vote) (finalized int32, err error) {
+	vs := c.cfg.Validators
+
+	if vs == nil {
+		return 0, ErrInvalidValidatorSet
+	}
+
+	if err = vote.Verify(); err != nil {
+		return
+	}
+
+	v := vs.lookup(vote.Signee)
+
+	if v == nil {
+		return 0, ErrInvalidVote
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A validator can only vote on one block of each height, the votes are kept after the height
+	// is finalized and after a restart
+	key := heightVoteKey(vote.Height, vote.Signee)
+	var voted *Vote
+
+	if err = c.db.View(func(tx *bolt.Tx) (err error) {
+		v := tx.Bucket(metaBucket[:]).Bucket(metaHeightVoteBucket).Get(key)
+
+		if v == nil {
+			return
+		}
+
+		voted = &Vote{}
+		return voted.unmarshal(v)
+	}); err != nil {
+		return c.state.Finalized, err
+	}
+
+	if voted != nil && !voted.BlockHash.IsEqual(&vote.BlockHash) {
+		return c.state.Finalized, ErrDoubleVote
+	}
+
+	// Votes on the finalized blocks are not needed anymore
+	if vote.Height <= c.state.Finalized {
+		return c.state.Finalized, nil
+	}
+
+	if node := c.state.node.ancestor(vote.Height); node == nil ||
+		!node.hash.IsEqual(&vote.BlockHash) {
+		return c.state.Finalized, ErrBlockNotFound
+	}
+
+	if voted == nil {
+		vb, err := vote.marshal()
+
+		if err != nil {
+			return c.state.Finalized, err
+		}
+
+		if err = c.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(metaBucket[:]).Bucket(metaHeightVoteBucket).Put(key, vb)
+		}); err != nil {
+			return c.state.Finalized, err
+		}
+	}
+
+	set := c.addVote(v, vote)
+
+	if len(set.votes) < vs.Quorum() {
+		return c.state.Finalized, nil
+	}
+
+	votes := make([]*Vote, 0, len(set.votes))
+
+	for _, val := range vs.validators {
+		if voted, ok := set.votes[val]; ok {
+			votes = append(votes, voted)
+		}
+	}
+
+	if err = c.finalize(vote.Height, vote.BlockHash, votes); err != nil {
+		return c.state.Finalized, err
+	}
+
+	return c.state.Finalized, nil
+}
+
+// addVote adds the vote to the vote set of its block, the caller must hold the chain lock.
+func (c *Chain) addVote(v *Validator, vote *Vote) (set *voteSet) {
+	if c.votes == nil {
+		c.votes = make(map[hash.Hash]*voteSet)
+	}
+
+	if set = c.votes[vote.BlockHash]; set == nil {
+		set = &voteSet{height: vote.Height, votes: make(map[*Validator]*Vote)}
+		c.votes[vote.BlockHash] = set
+	}
+
+	set.votes[v] = vote
+	return
+}
+
+// Votes returns the collected votes of the validators on the unfinalized block, in the order of
+// the validator set.
+func (c *Chain) Votes(blockHash hash.Hash) (votes []*Vote) {
+	vs := c.cfg.Validators
+
+	if vs == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	set := c.votes[blockHash]
+
+	if set == nil {
+		return
+	}
+
+	votes = make([]*Vote, 0, len(set.votes))
+
+	for _, v := range vs.validators {
+		if vote, ok := set.votes[v]; ok {
+			votes = append(votes, vote)
+		}
+	}
+
+	return
+}
+
+// heightVoteKey returns the key of the vote of the validator on the height, the keys are sorted by
+// height.
+func heightVoteKey(height int32, signee *asymmetric.PublicKey) []byte {
+	pub := signee.Serialize()
+	key := make([]byte, 4+len(pub))
+	binary.BigEndian.PutUint32(key[0:4], uint32(height))
+	copy(key[4:], pub)
+	return key
+}
+
+// Finalize finalizes the block of the height on the best chain with the votes of a quorum of the
+// validators, which are synced from another block producer.
+func (c *Chain) Finalize(height int32, blockHash hash.Hash, votes []*Vote) (err error) {
+	vs := c.cfg.Validators
+
+	if vs == nil {
+		return ErrInvalidValidatorSet
+	}
+
+	if err = vs.VerifyVotes(height, blockHash, votes); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if height <= c.state.Finalized {
+		return
+	}
+
+	if node := c.state.node.ancestor(height); node == nil || !node.hash.IsEqual(&blockHash) {
+		return ErrBlockNotFound
+	}
+
+	return c.finalize(height, blockHash, votes)
+}
+
+// FinalizedVotes returns the current state of the chain and the votes finalizing the block of the
+// finalized height, there is no vote if only the genesis block is finalized.
+func (c *Chain) FinalizedVotes() (state State, votes []*Vote, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state = *c.state
+
+	if state.Finalized == 0 {
+		return
+	}
+
+	node := state.node.ancestor(state.Finalized)
+	err = c.db.View(func(tx *bolt.Tx) (err error) {
+		v := tx.Bucket(metaBucket[:]).Bucket(metaVoteBucket).Get(node.hash[:])
+
+		if v == nil {
+			return ErrInsufficientVotes
+		}
+
+		votes, err = unmarshalVotes(v)
+		return
+	})
+
+	return
+}
+
+// finalize persists the votes of the block and the finalized height, the caller must hold the
+// chain lock.
+func (c *Chain) finalize(height int32, blockHash hash.Hash, votes []*Vote) (err error) {
+	state := *c.state
+	state.Finalized = height
+	sb, err := state.marshal()
+
+	if err != nil {
+		return
+	}
+
+	vb, err := marshalVotes(votes)
+
+	if err != nil {
+		return
+	}
+
+	if err = c.db.Update(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(metaBucket[:])
+
+		if err = bucket.Bucket(metaVoteBucket).Put(blockHash[:], vb); err != nil {
+			return
+		}
+
+		return bucket.Put(metaStateKey, sb)
+	}); err != nil {
+		return
+	}
+
+	*c.state = state
+
+	for h, set := range c.votes {
+		if set.height <= state.Finalized {
+			delete(c.votes, h)
+		}
+	}
+
+	// The side branches forking below the finalized height can never be switched to
+	for h, b := range c.side {
+		if fork := c.sideFork(b); fork == nil || fork.height < state.Finalized {
+			delete(c.side, h)
+		}
+	}
+
+	log.Debugf("finalized main chain block: height = %d, hash = %s", height, blockHash.String())
+	return
+}
+
+// sideFork returns the node on the best chain which the side branch of the block forks from.
+func (c *Chain) sideFork(block *Block) *blockNode {
+	for b := c.side[block.Header.Parent]; b != nil; b = c.side[b.Header.Parent] {
+		block = b
+	}
+
+	return c.index.lookupNode(&block.Header.Parent)
+}
+
+// ComputeStateRoot returns the state root after applying the txs in a block produced by the
+// producer on the current head, the chain is not changed.
+func (c *Chain) ComputeStateRoot(producer proto2.AccountAddress, txs []*Tx) (
+	root hash.Hash, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, err := c.db.Begin(true)
+
+	if err != nil {
+		return
+	}
+
+	// Always roll back the changes
+	defer tx.Rollback()
+	accounts := &accountState{meta: tx.Bucket(metaBucket[:]), cfg: c.cfg}
+
+	for _, t := range txs {
+		if err = accounts.applyTx(producer, t); err != nil {
+			return
+		}
+	}
+
+	return accounts.root()
+}
+
+// GetAccount returns the account state on the head of the chain.
+func (c *Chain) GetAccount(addr proto2.AccountAddress) (account *Account, err error) {
+	err = c.db.View(func(tx *bolt.Tx) (err error) {
+		accounts := &accountState{meta: tx.Bucket(metaBucket[:])}
+		account, err = accounts.get(addr)
+		return
+	})
+
+	return
+}
+
+// GetDatabase returns the database state on the head of the chain.
+func (c *Chain) GetDatabase(id string) (database *Database, err error) {
+	err = c.db.View(func(tx *bolt.Tx) (err error) {
+		state := &accountState{meta: tx.Bucket(metaBucket[:])}
+		database, err = state.getDatabase(id)
+		return
+	})
+
+	return
+}
+
+// GetMiner returns the registered miner state on the head of the chain.
+func (c *Chain) GetMiner(id proto2.NodeID) (miner *Miner, err error) {
+	err = c.db.View(func(tx *bolt.Tx) (err error) {
+		state := &accountState{meta: tx.Bucket(metaBucket[:])}
+		miner, err = state.getMiner(id)
+		return
+	})
+
+	return
+}
+
+// Nonce returns the next nonce of the account, it implements AccountState.
+func (c *Chain) Nonce(addr proto2.AccountAddress) (nonce uint64, err error) {
+	account, err := c.GetAccount(addr)
+
+	if err != nil {
+		return
+	}
+
+	return account.Nonce, nil
+}
+
+// Balance returns the balance of the account, it implements AccountState.
+func (c *Chain) Balance(addr proto2.AccountAddress) (balance *big.Int, err error) {
+	account, err := c.GetAccount(addr)
+
+	if err != nil {
+		return
+	}
+
+	return account.Balance, nil
+}
+
+// Head returns the current state of the chain.
+func (c *Chain) Head() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.state
+}
+
+// fetchBlock reads the block of the node from the chain database.
+func (c *Chain) fetchBlock(node *blockNode) (block *Block, err error) {
+	err = c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(metaBucket[:]).Bucket(metaBlockIndexBucket).Get(node.indexKey())
+
+		if v == nil {
+			return ErrBlockNotFound
+		}
+
+		block = &Block{}
+		return block.unmarshal(v)
+	})
+
+	return
+}
+
+// GetBlock returns the block of the given block hash.
+func (c *Chain) GetBlock(h *hash.Hash) (block *Block, err error) {
+	node := c.index.lookupNode(h)
+
+	if node == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	return c.fetchBlock(node)
+}
+
+// GetBlockByHeight returns the block at the given height.
+func (c *Chain) GetBlockByHeight(height int32) (block *Block, err error) {
+	c.mu.Lock()
+	node := c.state.node.ancestor(height)
+	c.mu.Unlock()
+
+	if node == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	return c.fetchBlock(node)
+}
@@ -0,0 +1,475 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+)
+
+func createTestBlock(producer *asymmetric.PrivateKey, parent hash.Hash, root hash.Hash,
+	txs []*Tx) (b *Block, err error) {
+	b = &Block{
+		Header: &SignedHeader{
+			Header: Header{
+				Version:   0x01000000,
+				Producer:  AccountAddressFromPublicKey(producer.PubKey()),
+				Root:      root,
+				Parent:    parent,
+				Timestamp: time.Now().UTC(),
+			},
+		},
+		Tx: txs,
+	}
+
+	err = b.SignHeader(producer)
+	return
+}
+
+// createNextBlock creates a block extending the head of the chain with the txs.
+func createNextBlock(t *testing.T, chain *Chain, producer *asymmetric.PrivateKey, txs []*Tx) (
+	b *Block) {
+	root, err := chain.ComputeStateRoot(AccountAddressFromPublicKey(producer.PubKey()), txs)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if b, err = createTestBlock(producer, chain.Head().Head, root, txs); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+func createTestChain(t *testing.T, accounts []*Account) (chain *Chain, cfg *Config) {
+	fl, err := ioutil.TempFile("", "mainchain")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	genesis, err := createTestBlock(priv, hash.Hash{}, StateRoot(accounts), nil)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	cfg = &Config{
+		DataDir:  fl.Name(),
+		Genesis:  genesis,
+		Accounts: accounts,
+	}
+
+	if chain, err = NewChain(cfg); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+func TestChain(t *testing.T) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Genesis block must have no parent
+	invalid, err := createTestBlock(priv, hash.THashH([]byte("other")), hash.Hash{}, nil)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = NewChain(&Config{Genesis: invalid}); err != ErrInvalidGenesis {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	chain, cfg := createTestChain(t, nil)
+	blocks := []*Block{cfg.Genesis}
+
+	for i := 0; i < 10; i++ {
+		b := createNextBlock(t, chain, priv, nil)
+
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		blocks = append(blocks, b)
+	}
+
+	// Blocks of a side branch are kept until the side branch is longer than the best chain
+	side := make([]*Block, 4)
+	parent := blocks[7].Header.BlockHash
+
+	for i := range side {
+		if side[i], err = createTestBlock(priv, parent, cfg.Genesis.Header.Root,
+			nil); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		parent = side[i].Header.BlockHash
+	}
+
+	for _, b := range side[:3] {
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if head := chain.Head(); !head.Head.IsEqual(&blocks[10].Header.BlockHash) {
+			t.Fatalf("Unexpected head: %+v", head)
+		}
+	}
+
+	for _, b := range []*Block{side[0], blocks[5]} {
+		if err = chain.PushBlock(b); err != ErrBlockExists {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	b, err := createTestBlock(priv, hash.THashH([]byte("unknown")), hash.Hash{}, nil)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(b); err != ErrParentNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The longer side branch becomes the best chain
+	if err = chain.PushBlock(side[3]); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if head := chain.Head(); head.Height != 11 || !head.Head.IsEqual(&side[3].Header.BlockHash) {
+		t.Fatalf("Unexpected head: %+v", head)
+	}
+
+	if b, err = chain.GetBlockByHeight(8); err != nil ||
+		!b.Header.BlockHash.IsEqual(&side[0].Header.BlockHash) {
+		t.Fatalf("Unexpected block: %v, err = %v", b, err)
+	}
+
+	// A longer branch with an invalid block is dropped, and the best chain is restored
+	extended, err := createTestBlock(priv, blocks[10].Header.BlockHash, cfg.Genesis.Header.Root,
+		nil)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(extended); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	invalid, err = createTestBlock(priv, extended.Header.BlockHash,
+		hash.THashH([]byte("root")), nil)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(invalid); err != ErrStateRootMismatch {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if head := chain.Head(); head.Height != 11 || !head.Head.IsEqual(&side[3].Header.BlockHash) {
+		t.Fatalf("Unexpected head: %+v", head)
+	}
+
+	if _, ok := chain.side[invalid.Header.BlockHash]; ok {
+		t.Fatal("Unexpected result: invalid block is kept in the side branch")
+	}
+
+	blocks = append(blocks[:8], side...)
+
+	// Block with a bad hash or signature
+	b = createNextBlock(t, chain, priv, nil)
+	b.Header.Timestamp = b.Header.Timestamp.Add(time.Second)
+
+	if err = chain.PushBlock(b); err != ErrHashVerification {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	b.Header.Timestamp = b.Header.Timestamp.Add(-time.Second)
+	b.Header.Signature = blocks[1].Header.Signature
+
+	if err = chain.PushBlock(b); err != ErrSignVerification {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Block signed by a key other than its producer's
+	b = createNextBlock(t, chain, priv, nil)
+	other, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = b.SignHeader(other); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(b); err != ErrInvalidProducer {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Reload the chain
+	if err = chain.Stop(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain, err = LoadChain(cfg)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer chain.Stop()
+	head := chain.Head()
+
+	if head.Height != int32(len(blocks)-1) ||
+		!head.Head.IsEqual(&blocks[len(blocks)-1].Header.BlockHash) {
+		t.Fatalf("Unexpected head: %+v", head)
+	}
+
+	for i, b := range blocks {
+		byHeight, err := chain.GetBlockByHeight(int32(i))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		byHash, err := chain.GetBlock(&b.Header.BlockHash)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		for _, v := range []*Block{byHeight, byHash} {
+			if !reflect.DeepEqual(v.Header.Header, b.Header.Header) ||
+				!v.Header.BlockHash.IsEqual(&b.Header.BlockHash) || len(v.Tx) != len(b.Tx) {
+				t.Fatalf("Values don't match: v1 = %+v, v2 = %+v", v.Header, b.Header)
+			}
+
+			if err = v.Verify(); err != nil {
+				t.Fatalf("Error occurred: %v", err)
+			}
+		}
+	}
+
+	if _, err = chain.GetBlockByHeight(int32(len(blocks))); err != ErrBlockNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	h := hash.THashH([]byte("unknown"))
+
+	if _, err = chain.GetBlock(&h); err != ErrBlockNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The reloaded chain continues to grow
+	if err = chain.PushBlock(createNextBlock(t, chain, priv, nil)); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+}
+
+func checkAccount(t *testing.T, chain *Chain, addr proto2.AccountAddress, balance int64,
+	nonce uint64) {
+	account, err := chain.GetAccount(addr)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if account.Balance.Cmp(big.NewInt(balance)) != 0 || account.Nonce != nonce {
+		t.Fatalf("Unexpected account state of %s: balance = %s, nonce = %d",
+			addr, account.Balance.String(), account.Nonce)
+	}
+}
+
+func TestAccountState(t *testing.T) {
+	alice, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	bp, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	aliceAddr := AccountAddressFromPublicKey(alice.PubKey())
+	bpAddr := AccountAddressFromPublicKey(bp.PubKey())
+	accounts := []*Account{{
+		Address:  aliceAddr,
+		Balance:  big.NewInt(100),
+		Metadata: map[string]string{"name": "alice"},
+	}}
+	chain, cfg := createTestChain(t, accounts)
+	checkAccount(t, chain, aliceAddr, 100, 0)
+
+	// Transfer to the recipient and pay the fee to the producer
+	txs := []*Tx{createTestTx(t, alice, 0, 10, 1), createTestTx(t, alice, 1, 20, 2)}
+	b := createNextBlock(t, chain, bp, txs)
+
+	if err = chain.PushBlock(b); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkAccount(t, chain, aliceAddr, 67, 2)
+	checkAccount(t, chain, "recipient", 30, 0)
+	checkAccount(t, chain, bpAddr, 3, 0)
+
+	// Invalid state transitions
+	for _, c := range []struct {
+		tx  *Tx
+		err error
+	}{
+		{createTestTx(t, alice, 1, 10, 1), ErrNonceMismatch},
+		{createTestTx(t, alice, 2, 100, 1), ErrInsufficientBalance},
+	} {
+		if _, err = chain.ComputeStateRoot(bpAddr, []*Tx{c.tx}); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		invalid, err := createTestBlock(bp, b.Header.BlockHash, hash.Hash{}, []*Tx{c.tx})
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(invalid); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Block committing a wrong state root
+	invalid, err := createTestBlock(bp, b.Header.BlockHash, b.Header.Root,
+		[]*Tx{createTestTx(t, alice, 2, 1, 1)})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(invalid); err != ErrStateRootMismatch {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Failed blocks don't change the state
+	checkAccount(t, chain, aliceAddr, 67, 2)
+
+	// Pop the block and restore the account states
+	b2 := createNextBlock(t, chain, bp, []*Tx{createTestTx(t, alice, 2, 7, 0)})
+
+	if err = chain.PushBlock(b2); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkAccount(t, chain, aliceAddr, 60, 3)
+	checkAccount(t, chain, "recipient", 37, 0)
+	popped, err := chain.PopBlock()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !popped.Header.BlockHash.IsEqual(&b2.Header.BlockHash) {
+		t.Fatalf("Unexpected popped block: %s", popped.Header.BlockHash.String())
+	}
+
+	checkAccount(t, chain, aliceAddr, 67, 2)
+	checkAccount(t, chain, "recipient", 30, 0)
+
+	if _, err = chain.PopBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkAccount(t, chain, aliceAddr, 100, 0)
+	checkAccount(t, chain, "recipient", 0, 0)
+	checkAccount(t, chain, bpAddr, 0, 0)
+
+	if _, err = chain.PopBlock(); err != ErrPopGenesis {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if head := chain.Head(); head.Height != 0 ||
+		!head.Head.IsEqual(&cfg.Genesis.Header.BlockHash) {
+		t.Fatalf("Unexpected head: %+v", head)
+	}
+
+	// The popped block can be pushed again
+	if err = chain.PushBlock(b); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Account states are persistent and queried by RPC
+	if err = chain.Stop(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if chain, err = LoadChain(cfg); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer chain.Stop()
+	service := NewChainRPCService(chain, nil)
+	resp := &QueryAccountResp{}
+
+	if err = service.QueryAccount(&QueryAccountReq{Address: aliceAddr}, resp); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if new(big.Int).SetBytes(resp.Balance).Int64() != 67 || resp.Nonce != 2 ||
+		resp.Metadata["name"] != "alice" || resp.Height != 1 ||
+		!resp.Head.IsEqual(&b.Header.BlockHash) {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+
+	// The chain serves as the account state of the tx pool
+	pool, err := NewTxPool(&TxPoolConfig{State: chain})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = pool.AddTx(createTestTx(t, alice, 1, 1, 1)); err != ErrNonceTooLow {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = pool.AddTx(createTestTx(t, alice, 2, 66, 1)); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"math/big"
+)
+
+// Config represents a main chain config.
+type Config struct {
+	DataDir string
+	Genesis *Block
+
+	// Accounts are the initial account states committed by the state root of the genesis block.
+	Accounts []*Account
+
+	// QueryPrice is the amount paid from the database deposit to the miners for each query in a
+	// billing tx.
+	QueryPrice uint64
+
+	// StakePerReplica is the stake required for a miner to host each database replica, the
+	// number of replicas on a miner is not limited if it's nil.
+	StakePerReplica *big.Int
+
+	// MaxFailures is the number of reported failures before a miner is no longer assigned any
+	// replica, the failures are not limited if it's zero.
+	MaxFailures uint32
+
+	// Validators are the block producers taking turns to produce the blocks and voting to
+	// finalize them, a block from any producer is accepted if it's nil.
+	Validators *ValidatorSet
+}
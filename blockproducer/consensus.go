@@ -0,0 +1,334 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+const (
+	// MaxClockDrift is the maximum duration a block timestamp can be ahead of the local clock.
+	MaxClockDrift = 10 * time.Second
+)
+
+// Validator is a block producer of the validator set.
+type Validator struct {
+	NodeID    proto2.NodeID
+	PublicKey *asymmetric.PublicKey
+}
+
+// Address returns the account address of the validator, which is the producer address of the
+// blocks it produces.
+func (v *Validator) Address() proto2.AccountAddress {
+	return AccountAddressFromPublicKey(v.PublicKey)
+}
+
+// ValidatorSet is the ordered set of the block producers, which take turns to produce the blocks
+// by height and vote to finalize them.
+type ValidatorSet struct {
+	validators  []*Validator
+	turnTimeout time.Duration
+}
+
+// NewValidatorSet returns a new validator set of the validators in order. If the validator in
+// turn doesn't produce a block in the turn timeout since the parent block, the turn passes to the
+// next validator, the turn never passes if it's zero.
+func NewValidatorSet(validators []*Validator, turnTimeout time.Duration) (s *ValidatorSet,
+	err error) {
+	if len(validators) == 0 {
+		return nil, ErrInvalidValidatorSet
+	}
+
+	keys := make(map[string]struct{}, len(validators))
+
+	for _, v := range validators {
+		if v == nil || v.PublicKey == nil {
+			return nil, ErrInvalidValidatorSet
+		}
+
+		key := string(v.PublicKey.Serialize())
+
+		if _, ok := keys[key]; ok {
+			return nil, ErrInvalidValidatorSet
+		}
+
+		keys[key] = struct{}{}
+	}
+
+	return &ValidatorSet{
+		validators:  append([]*Validator{}, validators...),
+		turnTimeout: turnTimeout,
+	}, nil
+}
+
+// Size returns the number of the validators.
+func (s *ValidatorSet) Size() int {
+	return len(s.validators)
+}
+
+// Validators returns the validators in order.
+func (s *ValidatorSet) Validators() []*Validator {
+	return append([]*Validator{}, s.validators...)
+}
+
+// Quorum returns the minimum number of the votes to finalize a block, which is more than 2/3 of
+// the validators.
+func (s *ValidatorSet) Quorum() int {
+	return len(s.validators)*2/3 + 1
+}
+
+// TurnTimeout returns the duration before the turn passes to the next validator.
+func (s *ValidatorSet) TurnTimeout() time.Duration {
+	return s.turnTimeout
+}
+
+// Round returns the number of the turn timeouts elapsed from the parent block timestamp to the
+// block timestamp.
+func (s *ValidatorSet) Round(parent, timestamp time.Time) int {
+	if s.turnTimeout <= 0 || !timestamp.After(parent) {
+		return 0
+	}
+
+	return int(timestamp.Sub(parent) / s.turnTimeout)
+}
+
+// Producer returns the validator whose turn it is to produce the block of the height in the
+// round, the turn passes to the next validator in each round.
+func (s *ValidatorSet) Producer(height int32, round int) *Validator {
+	n := len(s.validators)
+	return s.validators[(int(height)%n+round%n)%n]
+}
+
+func (s *ValidatorSet) lookup(pub *asymmetric.PublicKey) *Validator {
+	if pub == nil {
+		return nil
+	}
+
+	key := pub.Serialize()
+
+	for _, v := range s.validators {
+		if bytes.Equal(v.PublicKey.Serialize(), key) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// VerifyProducer verifies that the block header of the height is signed by the validator in turn
+// at its timestamp, which must follow the parent block timestamp and must not be ahead of the
+// local clock by more than MaxClockDrift. The genesis block is always produced by the first
+// validator.
+func (s *ValidatorSet) VerifyProducer(height int32, parent time.Time,
+	header *SignedHeader) error {
+	round := 0
+
+	if height > 0 {
+		if !header.Timestamp.After(parent) ||
+			header.Timestamp.After(time.Now().Add(MaxClockDrift)) {
+			return ErrInvalidTimestamp
+		}
+
+		round = s.Round(parent, header.Timestamp)
+	}
+
+	v := s.Producer(height, round)
+
+	if header.PublicKey == nil ||
+		!bytes.Equal(header.PublicKey.Serialize(), v.PublicKey.Serialize()) ||
+		header.Producer != v.Address() {
+		return ErrInvalidProducer
+	}
+
+	return nil
+}
+
+// VerifyVotes verifies that the block of the height is finalized by the votes of a quorum of the
+// validators.
+func (s *ValidatorSet) VerifyVotes(height int32, blockHash hash.Hash, votes []*Vote) (
+	err error) {
+	voted := make(map[*Validator]struct{}, len(votes))
+
+	for _, vote := range votes {
+		if vote.Height != height || !vote.BlockHash.IsEqual(&blockHash) {
+			return ErrInvalidVote
+		}
+
+		if err = vote.Verify(); err != nil {
+			return
+		}
+
+		v := s.lookup(vote.Signee)
+
+		if v == nil {
+			return ErrInvalidVote
+		}
+
+		voted[v] = struct{}{}
+	}
+
+	if len(voted) < s.Quorum() {
+		return ErrInsufficientVotes
+	}
+
+	return
+}
+
+// Vote is the signature of a validator on the block of a height.
+type Vote struct {
+	Height    int32
+	BlockHash hash.Hash
+	Signee    *asymmetric.PublicKey
+	Signature *asymmetric.Signature
+}
+
+func (v *Vote) hash() (h hash.Hash, err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = utils.WriteElements(buffer, binary.BigEndian, v.Height, v.BlockHash); err != nil {
+		return
+	}
+
+	return hash.THashH(buffer.Bytes()), nil
+}
+
+// Sign signs the vote with the private key of the validator.
+func (v *Vote) Sign(signer *asymmetric.PrivateKey) (err error) {
+	h, err := v.hash()
+
+	if err != nil {
+		return
+	}
+
+	v.Signee = signer.PubKey()
+	v.Signature, err = signer.Sign(h[:])
+	return
+}
+
+// Verify verifies the signature of the vote.
+func (v *Vote) Verify() (err error) {
+	if v.Signee == nil || v.Signature == nil {
+		return ErrNilValue
+	}
+
+	h, err := v.hash()
+
+	if err != nil {
+		return
+	}
+
+	if !v.Signature.Verify(h[:], v.Signee) {
+		return ErrSignVerification
+	}
+
+	return
+}
+
+func (v *Vote) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		v.Height,
+		v.BlockHash,
+		v.Signee,
+		v.Signature,
+	); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (v *Vote) unmarshal(b []byte) error {
+	reader := bytes.NewReader(b)
+	return utils.ReadElements(reader, binary.BigEndian,
+		&v.Height,
+		&v.BlockHash,
+		&v.Signee,
+		&v.Signature,
+	)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (v *Vote) MarshalBinary() ([]byte, error) {
+	return v.marshal()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (v *Vote) UnmarshalBinary(b []byte) error {
+	return v.unmarshal(b)
+}
+
+func marshalVotes(votes []*Vote) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian, uint32(len(votes))); err != nil {
+		return nil, err
+	}
+
+	for _, v := range votes {
+		vb, err := v.marshal()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = utils.WriteElements(buffer, binary.BigEndian, vb); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func unmarshalVotes(b []byte) (votes []*Vote, err error) {
+	reader := bytes.NewReader(b)
+	var l uint32
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &l); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return nil, utils.ErrInsufficientBuffer
+	}
+
+	votes = make([]*Vote, l)
+
+	for i := range votes {
+		var vb []byte
+
+		if err = utils.ReadElements(reader, binary.BigEndian, &vb); err != nil {
+			return nil, err
+		}
+
+		votes[i] = &Vote{}
+
+		if err = votes[i].unmarshal(vb); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
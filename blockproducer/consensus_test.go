@@ -0,0 +1,422 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+)
+
+func createTestValidators(t *testing.T, n int, turnTimeout time.Duration) (
+	keys []*asymmetric.PrivateKey, vs *ValidatorSet) {
+	keys = make([]*asymmetric.PrivateKey, n)
+	validators := make([]*Validator, n)
+
+	for i := range keys {
+		priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		keys[i] = priv
+		validators[i] = &Validator{
+			NodeID:    proto2.NodeID(fmt.Sprintf("bp%d", i)),
+			PublicKey: pub,
+		}
+	}
+
+	vs, err := NewValidatorSet(validators, turnTimeout)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+func createTestVote(t *testing.T, signer *asymmetric.PrivateKey, height int32,
+	blockHash hash.Hash) (vote *Vote) {
+	vote = &Vote{Height: height, BlockHash: blockHash}
+
+	if err := vote.Sign(signer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+// createTurnBlock creates a block extending the head of the chain, which is produced at the offset
+// after the head block.
+func createTurnBlock(t *testing.T, chain *Chain, producer *asymmetric.PrivateKey,
+	offset time.Duration) (b *Block) {
+	b = createNextBlock(t, chain, producer, nil)
+	b.Header.Timestamp = chain.Head().node.timestamp.Add(offset)
+
+	if err := b.SignHeader(producer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+func TestValidatorSet(t *testing.T) {
+	keys, vs := createTestValidators(t, 4, time.Second)
+
+	if vs.Size() != 4 || vs.Quorum() != 3 || vs.TurnTimeout() != time.Second {
+		t.Fatalf("Unexpected validator set: size = %d, quorum = %d", vs.Size(), vs.Quorum())
+	}
+
+	for h := int32(0); h < 8; h++ {
+		for r := 0; r < 8; r++ {
+			if v := vs.Producer(h, r); v != vs.Validators()[(int(h)+r)%4] {
+				t.Fatalf("Unexpected producer of height %d round %d: %s", h, r, v.NodeID)
+			}
+		}
+	}
+
+	// The turn passes to the next validator every turn timeout
+	parent := time.Unix(1528000000, 0)
+
+	for _, c := range []struct {
+		offset time.Duration
+		round  int
+	}{
+		{-time.Second, 0},
+		{0, 0},
+		{999 * time.Millisecond, 0},
+		{time.Second, 1},
+		{5500 * time.Millisecond, 5},
+	} {
+		if r := vs.Round(parent, parent.Add(c.offset)); r != c.round {
+			t.Fatalf("Unexpected round of offset %v: %d", c.offset, r)
+		}
+	}
+
+	if _, never := createTestValidators(t, 4, 0); never.Round(parent,
+		parent.Add(time.Hour)) != 0 {
+		t.Fatal("Unexpected result: turn passes without a turn timeout")
+	}
+
+	if _, err := NewValidatorSet(nil, 0); err != ErrInvalidValidatorSet {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dup := append(vs.Validators(), vs.Validators()[0])
+
+	if _, err := NewValidatorSet(dup, 0); err != ErrInvalidValidatorSet {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Verify the votes of a block, duplicate votes are counted once
+	blockHash := hash.THashH([]byte("block"))
+	votes := []*Vote{
+		createTestVote(t, keys[0], 1, blockHash),
+		createTestVote(t, keys[1], 1, blockHash),
+		createTestVote(t, keys[1], 1, blockHash),
+	}
+
+	if err := vs.VerifyVotes(1, blockHash, votes); err != ErrInsufficientVotes {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := vs.VerifyVotes(2, blockHash, votes); err != ErrInvalidVote {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	votes = append(votes, createTestVote(t, keys[3], 1, blockHash))
+
+	if err := vs.VerifyVotes(1, blockHash, votes); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Tampered vote
+	votes[0].Height = 2
+
+	if err := votes[0].Verify(); err != ErrSignVerification {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestChainConsensus(t *testing.T) {
+	keys, vs := createTestValidators(t, 4, time.Second)
+	chain, cfg := createTestChain(t, nil)
+	cfg.Validators = vs
+
+	// The block producers take turns by height, and the turn passes to the next validator after
+	// the turn timeout
+	for _, c := range []struct {
+		producer int
+		offset   time.Duration
+		err      error
+	}{
+		{2, 100 * time.Millisecond, ErrInvalidProducer},
+		{1, 1100 * time.Millisecond, ErrInvalidProducer},
+		{1, 0, ErrInvalidTimestamp},
+		{1, -time.Second, ErrInvalidTimestamp},
+		{1, time.Minute, ErrInvalidTimestamp},
+	} {
+		invalid := createTurnBlock(t, chain, keys[c.producer], c.offset)
+
+		if err := chain.PushBlock(invalid); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	blocks := make([]*Block, 3)
+
+	for i, c := range []struct {
+		producer int
+		offset   time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{3, 1100 * time.Millisecond},
+		{3, 100 * time.Millisecond},
+	} {
+		blocks[i] = createTurnBlock(t, chain, keys[c.producer], c.offset)
+
+		if err := chain.PushBlock(blocks[i]); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	// Invalid votes
+	outsider, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// A validator can't vote on another block of the same height
+	if _, err = chain.AddVote(createTestVote(t, keys[3], 3,
+		blocks[2].Header.BlockHash)); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	for _, c := range []struct {
+		vote *Vote
+		err  error
+	}{
+		{createTestVote(t, keys[3], 3, hash.THashH([]byte("fork"))), ErrDoubleVote},
+		{createTestVote(t, outsider, 2, blocks[1].Header.BlockHash), ErrInvalidVote},
+		{createTestVote(t, keys[0], 2, blocks[0].Header.BlockHash), ErrBlockNotFound},
+		{createTestVote(t, keys[0], 4, blocks[1].Header.BlockHash), ErrBlockNotFound},
+	} {
+		if _, err = chain.AddVote(c.vote); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Finalize the block of height 2 by a quorum of votes
+	service := NewChainRPCService(chain, nil)
+
+	for i, k := range []*asymmetric.PrivateKey{keys[0], keys[0], keys[1], keys[2]} {
+		vb, err := createTestVote(t, k, 2, blocks[1].Header.BlockHash).marshal()
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		resp := &SubmitVoteResp{}
+
+		if err = service.SubmitVote(&SubmitVoteReq{Vote: vb}, resp); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		// The third distinct validator completes the quorum
+		expected := int32(0)
+
+		if i == 3 {
+			expected = 2
+		}
+
+		if resp.Finalized != expected {
+			t.Fatalf("Unexpected finalized height: %d", resp.Finalized)
+		}
+	}
+
+	if len(chain.votes) != 1 {
+		t.Fatalf("Unexpected votes of the finalized blocks: %d", len(chain.votes))
+	}
+
+	// The votes are kept after the height is finalized
+	if _, err = chain.AddVote(createTestVote(t, keys[0], 2,
+		hash.THashH([]byte("fork")))); err != ErrDoubleVote {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A block can't fork from the chain below the finalized height
+	fork, err := createTestBlock(keys[2], blocks[0].Header.BlockHash, cfg.Genesis.Header.Root,
+		nil)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(fork); err != ErrForkFinalized {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The quorum votes are persisted with the finalized block
+	resp := &QueryFinalizedResp{}
+
+	if err = service.QueryFinalized(&QueryFinalizedReq{}, resp); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if resp.Height != 2 || resp.BlockHash != blocks[1].Header.BlockHash || len(resp.Votes) != 3 {
+		t.Fatalf("Unexpected finalized block: %+v", resp)
+	}
+
+	votes := make([]*Vote, len(resp.Votes))
+
+	for i, vb := range resp.Votes {
+		votes[i] = &Vote{}
+
+		if err = votes[i].UnmarshalBinary(vb); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	// Sync the finalized block to another chain
+	fl, err := ioutil.TempFile("", "mainchain")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	// The genesis block of the test chain isn't produced by the validators
+	synced := *cfg
+	synced.DataDir = fl.Name()
+	synced.Validators = nil
+	replica, err := NewChain(&synced)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer replica.Stop()
+
+	synced.Validators = vs
+
+	if err = replica.Finalize(2, resp.BlockHash, votes); err != ErrBlockNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, b := range blocks {
+		if err = replica.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		height    int32
+		blockHash hash.Hash
+		votes     []*Vote
+		err       error
+	}{
+		{2, resp.BlockHash, votes[:2], ErrInsufficientVotes},
+		{3, resp.BlockHash, votes, ErrInvalidVote},
+		{2, blocks[0].Header.BlockHash, votes, ErrInvalidVote},
+	} {
+		if err = replica.Finalize(c.height, c.blockHash, c.votes); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if err = replica.Finalize(2, resp.BlockHash, votes); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if head := replica.Head(); head.Finalized != 2 {
+		t.Fatalf("Unexpected head: %+v", head)
+	}
+
+	if _, err = replica.PopBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = replica.PopBlock(); err != ErrPopFinalized {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Only the unfinalized blocks can be popped
+	if _, err = chain.PopBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = chain.PopBlock(); err != ErrPopFinalized {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = chain.PushBlock(blocks[2]); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	for _, k := range []*asymmetric.PrivateKey{keys[3], keys[0]} {
+		if _, err = chain.AddVote(createTestVote(t, k, 3, blocks[2].Header.BlockHash)); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	// The finalized height and the votes are persistent, the votes finalizing the block are
+	// verified on loading
+	if err = chain.Stop(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	_, cfg.Validators = createTestValidators(t, 4, time.Second)
+
+	if _, err = LoadChain(cfg); err != ErrInvalidVote {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cfg.Validators = vs
+
+	if chain, err = LoadChain(cfg); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer chain.Stop()
+
+	if head := chain.Head(); head.Height != 3 || head.Finalized != 2 {
+		t.Fatalf("Unexpected head: %+v", head)
+	}
+
+	if _, err = chain.AddVote(createTestVote(t, keys[3], 3,
+		hash.THashH([]byte("fork")))); err != ErrDoubleVote {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The votes on the unfinalized block are collected again
+	finalized, err := chain.AddVote(createTestVote(t, keys[1], 3, blocks[2].Header.BlockHash))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if finalized != 3 {
+		t.Fatalf("Unexpected finalized height: %d", finalized)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"sort"
+
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+// Permission bits of a database user.
+const (
+	// PermissionRead allows the user to query the database.
+	PermissionRead uint32 = 1 << iota
+
+	// PermissionWrite allows the user to modify the database.
+	PermissionWrite
+
+	// PermissionAdmin allows the user to withdraw from the database, and to change its
+	// permissions and replica count.
+	PermissionAdmin
+
+	// PermissionAll is the permission of the database owner.
+	PermissionAll = PermissionRead | PermissionWrite | PermissionAdmin
+)
+
+// MaxReplicaCount is the maximum replica count of a database.
+const MaxReplicaCount = 32
+
+// Database is the state of a database created on the main chain.
+type Database struct {
+	ID           string
+	Owner        proto2.AccountAddress
+	ReplicaCount uint32
+
+	// Balance is the deposit of the database to pay for its usage.
+	Balance *big.Int
+
+	// BilledHeight is the next SQL chain height to be billed, the usage before it has been paid
+	// to the miners.
+	BilledHeight int32
+
+	// Permissions maps the users to their permission bits.
+	Permissions map[proto2.AccountAddress]uint32
+
+	// Replicas are the miners hosting the database, the first one is the leader. Term is
+	// increased each time the replicas change.
+	Replicas []proto2.NodeID
+	Term     uint64
+}
+
+// DatabaseIDFromTx returns the ID of the database created by the tx with the given sender and
+// nonce.
+func DatabaseIDFromTx(sender proto2.AccountAddress, nonce uint64) string {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian, string(sender), nonce); err != nil {
+		return ""
+	}
+
+	return hash.THashH(buffer.Bytes()).String()
+}
+
+// HasPermission returns whether the user has all the given permission bits.
+func (d *Database) HasPermission(user proto2.AccountAddress, perm uint32) bool {
+	return d.Permissions[user]&perm == perm
+}
+
+func (d *Database) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	users := make([]string, 0, len(d.Permissions))
+
+	for u := range d.Permissions {
+		users = append(users, string(u))
+	}
+
+	sort.Strings(users)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		d.ID,
+		string(d.Owner),
+		d.ReplicaCount,
+		d.Balance.Bytes(),
+		d.BilledHeight,
+		uint32(len(users)),
+	); err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		if err := utils.WriteElements(buffer, binary.BigEndian,
+			u,
+			d.Permissions[proto2.AccountAddress(u)],
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		d.Term,
+		uint32(len(d.Replicas)),
+	); err != nil {
+		return nil, err
+	}
+
+	for _, id := range d.Replicas {
+		if err := utils.WriteElements(buffer, binary.BigEndian, string(id)); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (d *Database) unmarshal(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	var owner string
+	var balance []byte
+	var l uint32
+
+	if err = utils.ReadElements(reader, binary.BigEndian,
+		&d.ID,
+		&owner,
+		&d.ReplicaCount,
+		&balance,
+		&d.BilledHeight,
+		&l,
+	); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return utils.ErrInsufficientBuffer
+	}
+
+	d.Owner = proto2.AccountAddress(owner)
+	d.Balance = new(big.Int).SetBytes(balance)
+	d.Permissions = make(map[proto2.AccountAddress]uint32, l)
+
+	for i := uint32(0); i < l; i++ {
+		var u string
+		var perm uint32
+
+		if err = utils.ReadElements(reader, binary.BigEndian, &u, &perm); err != nil {
+			return
+		}
+
+		d.Permissions[proto2.AccountAddress(u)] = perm
+	}
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &d.Term, &l); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return utils.ErrInsufficientBuffer
+	}
+
+	d.Replicas = nil
+
+	for i := uint32(0); i < l; i++ {
+		var id string
+
+		if err = utils.ReadElements(reader, binary.BigEndian, &id); err != nil {
+			return
+		}
+
+		d.Replicas = append(d.Replicas, proto2.NodeID(id))
+	}
+
+	return
+}
+
+// HasReplica returns whether the miner hosts a replica of the database.
+func (d *Database) HasReplica(id proto2.NodeID) bool {
+	for _, r := range d.Replicas {
+		if r == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Miner is the state of a miner registered on the main chain.
+type Miner struct {
+	NodeID proto2.NodeID
+	Owner  proto2.AccountAddress
+
+	// Stake is the amount locked by the owner to register the miner.
+	Stake *big.Int
+
+	// Load is the number of the database replicas hosted by the miner, and Failures is the
+	// number of its reported failures.
+	Load     uint32
+	Failures uint32
+}
+
+func (m *Miner) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		string(m.NodeID),
+		string(m.Owner),
+		m.Stake.Bytes(),
+		m.Load,
+		m.Failures,
+	); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (m *Miner) unmarshal(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	var nodeID, owner string
+	var stake []byte
+
+	if err = utils.ReadElements(reader, binary.BigEndian,
+		&nodeID,
+		&owner,
+		&stake,
+		&m.Load,
+		&m.Failures,
+	); err != nil {
+		return
+	}
+
+	m.NodeID = proto2.NodeID(nodeID)
+	m.Owner = proto2.AccountAddress(owner)
+	m.Stake = new(big.Int).SetBytes(stake)
+	return
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"errors"
+)
+
+var (
+	// ErrHashVerification indicates a failed hash verification.
+	ErrHashVerification = errors.New("hash verification failed")
+
+	// ErrSignVerification indicates a failed signature verification.
+	ErrSignVerification = errors.New("signature verification failed")
+
+	// ErrMerkleRootVerification indicates a failed merkle root verification.
+	ErrMerkleRootVerification = errors.New("merkle root verification failed")
+
+	// ErrNilValue indicates that an unexpected but not fatal nil value is detected, hence return
+	// it as an error.
+	ErrNilValue = errors.New("unexpected nil value")
+
+	// ErrParentNotFound indicates an error failing to find parent node during a chain reloading,
+	// or a pushed block whose parent is neither in the chain nor in a side branch.
+	ErrParentNotFound = errors.New("could not find parent node")
+
+	// ErrInvalidGenesis indicates that the genesis block doesn't extend the root.
+	ErrInvalidGenesis = errors.New("invalid genesis block")
+
+	// ErrInvalidProducer indicates that a block is not signed by the producer named in its header,
+	// or isn't produced by the validator in turn.
+	ErrInvalidProducer = errors.New("invalid block producer")
+
+	// ErrBlockNotFound indicates that the requested block is not found in the chain.
+	ErrBlockNotFound = errors.New("block not found")
+
+	// ErrInvalidTxPoolConfig indicates that some required fields of the tx pool config are
+	// missing.
+	ErrInvalidTxPoolConfig = errors.New("invalid tx pool config")
+
+	// ErrTxExists indicates that the tx is already in the tx pool.
+	ErrTxExists = errors.New("tx already exists")
+
+	// ErrNonceTooLow indicates that the tx nonce is lower than the next nonce of the account.
+	ErrNonceTooLow = errors.New("tx nonce too low")
+
+	// ErrNonceGap indicates that the tx nonce is not continuous with the pending txs of the
+	// account.
+	ErrNonceGap = errors.New("tx nonce gap")
+
+	// ErrReplaceUnderpriced indicates that the tx doesn't pay a higher fee than the pending tx
+	// with the same nonce.
+	ErrReplaceUnderpriced = errors.New("replacement tx underpriced")
+
+	// ErrInsufficientBalance indicates that the account balance can't afford the pending txs.
+	ErrInsufficientBalance = errors.New("insufficient balance")
+
+	// ErrTxPoolFull indicates that the tx pool is full and the tx doesn't pay a higher fee than
+	// any evictable tx.
+	ErrTxPoolFull = errors.New("tx pool is full")
+
+	// ErrInvalidProducerConfig indicates that some required fields of the producer config are
+	// missing.
+	ErrInvalidProducerConfig = errors.New("invalid producer config")
+
+	// ErrProducerStarted indicates that the producer is already started.
+	ErrProducerStarted = errors.New("producer already started")
+
+	// ErrNotInTurn indicates that it isn't the turn of the local validator to produce the next
+	// block, or the local node isn't a validator of the chain.
+	ErrNotInTurn = errors.New("not in turn to produce block")
+
+	// ErrNonceMismatch indicates that the tx nonce is not the next nonce of the sender while
+	// applying a block.
+	ErrNonceMismatch = errors.New("tx nonce doesn't match the account nonce")
+
+	// ErrStateRootMismatch indicates that the state root committed in the block header doesn't
+	// match the account state after applying the block.
+	ErrStateRootMismatch = errors.New("state root doesn't match")
+
+	// ErrUndoDataNotFound indicates that the undo data of a block is missing from the chain
+	// database.
+	ErrUndoDataNotFound = errors.New("undo data not found")
+
+	// ErrPopGenesis indicates an attempt to pop the genesis block from the chain.
+	ErrPopGenesis = errors.New("cannot pop the genesis block")
+
+	// ErrUnknownTxType indicates that the type of a tx is unknown.
+	ErrUnknownTxType = errors.New("unknown tx type")
+
+	// ErrInvalidTxPayload indicates that the payload of a tx doesn't match the rules of its type.
+	ErrInvalidTxPayload = errors.New("invalid tx payload")
+
+	// ErrDatabaseExists indicates an attempt to create a database which already exists.
+	ErrDatabaseExists = errors.New("database already exists")
+
+	// ErrDatabaseNotFound indicates that the database of a tx is not found on the chain.
+	ErrDatabaseNotFound = errors.New("database not found")
+
+	// ErrPermissionDenied indicates that the tx sender doesn't have the admin permission of the
+	// database, or attempts to revoke the permission of the database owner.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrMinerExists indicates an attempt to register a miner which is already registered.
+	ErrMinerExists = errors.New("miner already registered")
+
+	// ErrMinerNotFound indicates that the requested miner is not registered on the chain.
+	ErrMinerNotFound = errors.New("miner not found")
+
+	// ErrInsufficientMiners indicates that there are not enough eligible miners to host the
+	// replicas of a database.
+	ErrInsufficientMiners = errors.New("insufficient miners")
+
+	// ErrNotReplica indicates that the miner doesn't host a replica of the database.
+	ErrNotReplica = errors.New("miner is not a replica of the database")
+
+	// ErrInsufficientSignatures indicates that a billing or replica replacement tx isn't signed by
+	// a majority of the replicas of the database.
+	ErrInsufficientSignatures = errors.New("insufficient replica signatures")
+
+	// ErrBillingHeightMismatch indicates that a billing tx doesn't start from the next unbilled
+	// height of the database, which prevents a height range from being billed twice.
+	ErrBillingHeightMismatch = errors.New("billing height doesn't match")
+
+	// ErrTermMismatch indicates that a replica replacement tx doesn't report a failure in the
+	// current term of the database, which prevents a report from being replayed.
+	ErrTermMismatch = errors.New("database term doesn't match")
+
+	// ErrInvalidValidatorSet indicates that the validator set is empty, or has a nil or
+	// duplicate validator.
+	ErrInvalidValidatorSet = errors.New("invalid validator set")
+
+	// ErrInvalidTimestamp indicates that the block timestamp doesn't follow its parent or is too
+	// far ahead of the local clock.
+	ErrInvalidTimestamp = errors.New("invalid block timestamp")
+
+	// ErrInvalidVote indicates that the vote doesn't match the voted block or isn't signed by a
+	// validator.
+	ErrInvalidVote = errors.New("invalid vote")
+
+	// ErrDoubleVote indicates that a validator votes on two different blocks of the same height.
+	ErrDoubleVote = errors.New("validator voted on another block of the same height")
+
+	// ErrInsufficientVotes indicates that the votes are not from a quorum of the validators.
+	ErrInsufficientVotes = errors.New("insufficient votes")
+
+	// ErrPopFinalized indicates an attempt to pop a finalized block from the chain.
+	ErrPopFinalized = errors.New("cannot pop a finalized block")
+
+	// ErrBlockExists indicates that the pushed block is already in the chain or a side branch.
+	ErrBlockExists = errors.New("block already exists")
+
+	// ErrForkFinalized indicates that the pushed block forks from the chain below the finalized
+	// height.
+	ErrForkFinalized = errors.New("block forks below the finalized height")
+
+	// ErrInvalidGenesisBP indicates that the node id of a genesis block producer isn't mined from
+	// its public key and nonce.
+	ErrInvalidGenesisBP = errors.New("genesis block producer node id doesn't match its key and nonce")
+)
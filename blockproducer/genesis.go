@@ -0,0 +1,363 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/pow/cpuminer"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+// GenesisBP describes an initial block producer in the genesis file.
+type GenesisBP struct {
+	NodeID proto2.NodeID `json:"node_id"`
+
+	// PublicKey is the hex encoded compressed public key of the block producer.
+	PublicKey string           `json:"public_key"`
+	Nonce     cpuminer.Uint256 `json:"nonce"`
+	Addr      string           `json:"addr"`
+}
+
+// GenesisAccount describes an initial account in the genesis file.
+type GenesisAccount struct {
+	Address proto2.AccountAddress `json:"address"`
+
+	// Balance is the decimal encoded initial balance of the account.
+	Balance string `json:"balance"`
+}
+
+// GenesisParams holds the chain parameters in the genesis file.
+type GenesisParams struct {
+	QueryPrice uint64 `json:"query_price"`
+
+	// StakePerReplica is the decimal encoded stake required for a miner to host each replica,
+	// the replicas on a miner are not limited if it's empty.
+	StakePerReplica string `json:"stake_per_replica,omitempty"`
+	MaxFailures     uint32 `json:"max_failures"`
+
+	// TurnTimeout is the duration, e.g. "10s", before the turn to produce a block passes to the
+	// next block producer, the turn never passes if it's empty.
+	TurnTimeout string `json:"turn_timeout,omitempty"`
+}
+
+// Genesis describes the initial block producers, account balances and chain parameters of the
+// main chain. The genesis block committing them is signed by the first block producer.
+type Genesis struct {
+	Timestamp      time.Time         `json:"timestamp"`
+	BlockProducers []*GenesisBP      `json:"block_producers"`
+	Accounts       []*GenesisAccount `json:"accounts"`
+	Params         GenesisParams     `json:"params"`
+
+	// Block is the hex encoded signed genesis block, it's empty until the genesis is signed.
+	Block string `json:"block,omitempty"`
+}
+
+// LoadGenesis reads the genesis from the JSON file at path.
+func LoadGenesis(path string) (g *Genesis, err error) {
+	buffer, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	g = &Genesis{}
+
+	if err = json.Unmarshal(buffer, g); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Save writes the genesis as a JSON file to path.
+func (g *Genesis) Save(path string) (err error) {
+	buffer, err := json.MarshalIndent(g, "", "  ")
+
+	if err != nil {
+		return
+	}
+
+	return ioutil.WriteFile(path, append(buffer, '\n'), 0644)
+}
+
+// Nodes returns the block producer nodes, it also verifies that each node id is mined from the
+// public key and the nonce.
+func (g *Genesis) Nodes() (nodes []*proto2.Node, err error) {
+	if len(g.BlockProducers) == 0 {
+		return nil, ErrInvalidGenesis
+	}
+
+	nodes = make([]*proto2.Node, len(g.BlockProducers))
+
+	for i, bp := range g.BlockProducers {
+		if bp == nil {
+			return nil, ErrInvalidGenesis
+		}
+
+		buffer, err := hex.DecodeString(bp.PublicKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pub, err := asymmetric.ParsePubKey(buffer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := hash.NewHashFromStr(string(bp.NodeID))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if h := cpuminer.HashBlock(pub.Serialize(), bp.Nonce); !h.IsEqual(id) {
+			return nil, ErrInvalidGenesisBP
+		}
+
+		nodes[i] = &proto2.Node{
+			ID:        bp.NodeID,
+			Addr:      bp.Addr,
+			PublicKey: pub,
+			Nonce:     bp.Nonce,
+		}
+	}
+
+	return
+}
+
+func (g *Genesis) accounts() (accounts []*Account, err error) {
+	accounts = make([]*Account, len(g.Accounts))
+	seen := make(map[proto2.AccountAddress]bool)
+
+	for i, a := range g.Accounts {
+		if a == nil || a.Address == "" || seen[a.Address] {
+			return nil, ErrInvalidGenesis
+		}
+
+		balance, ok := new(big.Int).SetString(a.Balance, 10)
+
+		if !ok || balance.Sign() < 0 {
+			return nil, ErrInvalidGenesis
+		}
+
+		seen[a.Address] = true
+		accounts[i] = &Account{
+			Address: a.Address,
+			Balance: balance,
+		}
+	}
+
+	return
+}
+
+func (g *Genesis) validators() (*ValidatorSet, error) {
+	nodes, err := g.Nodes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	validators := make([]*Validator, len(nodes))
+
+	for i, node := range nodes {
+		validators[i] = &Validator{
+			NodeID:    node.ID,
+			PublicKey: node.PublicKey,
+		}
+	}
+
+	var timeout time.Duration
+
+	if g.Params.TurnTimeout != "" {
+		if timeout, err = time.ParseDuration(g.Params.TurnTimeout); err != nil || timeout < 0 {
+			return nil, ErrInvalidGenesis
+		}
+	}
+
+	return NewValidatorSet(validators, timeout)
+}
+
+// paramsHash returns the hash of the block producers with their addresses and the chain
+// parameters, which is committed by the genesis block.
+func (g *Genesis) paramsHash() (h hash.Hash, err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = utils.WriteElements(buffer, binary.BigEndian,
+		uint32(len(g.BlockProducers))); err != nil {
+		return
+	}
+
+	for _, bp := range g.BlockProducers {
+		if bp == nil {
+			return h, ErrInvalidGenesis
+		}
+
+		if err = utils.WriteElements(buffer, binary.BigEndian,
+			bp.NodeID,
+			bp.PublicKey,
+			bp.Nonce.A,
+			bp.Nonce.B,
+			bp.Nonce.C,
+			bp.Nonce.D,
+			bp.Addr,
+		); err != nil {
+			return
+		}
+	}
+
+	if err = utils.WriteElements(buffer, binary.BigEndian,
+		g.Params.QueryPrice,
+		g.Params.StakePerReplica,
+		g.Params.MaxFailures,
+		g.Params.TurnTimeout,
+	); err != nil {
+		return
+	}
+
+	return hash.THashH(buffer.Bytes()), nil
+}
+
+// Sign builds the genesis block committing the initial accounts, the block producers and the
+// chain parameters, and signs it with the private key of the first block producer.
+func (g *Genesis) Sign(signer *asymmetric.PrivateKey) (err error) {
+	validators, err := g.validators()
+
+	if err != nil {
+		return
+	}
+
+	if !validators.Producer(0, 0).PublicKey.IsEqual(signer.PubKey()) {
+		return ErrInvalidProducer
+	}
+
+	accounts, err := g.accounts()
+
+	if err != nil {
+		return
+	}
+
+	paramsHash, err := g.paramsHash()
+
+	if err != nil {
+		return
+	}
+
+	block := &Block{
+		Header: &SignedHeader{
+			Header: Header{
+				Version:    0x01000000,
+				Producer:   AccountAddressFromPublicKey(signer.PubKey()),
+				Root:       StateRoot(accounts),
+				Timestamp:  g.Timestamp,
+				ParamsHash: paramsHash,
+			},
+		},
+	}
+
+	if err = block.SignHeader(signer); err != nil {
+		return
+	}
+
+	buffer, err := block.marshal()
+
+	if err != nil {
+		return
+	}
+
+	g.Block = hex.EncodeToString(buffer)
+	return
+}
+
+// Config verifies the genesis block against the genesis description and returns the main chain
+// config stored in dataDir.
+func (g *Genesis) Config(dataDir string) (cfg *Config, err error) {
+	validators, err := g.validators()
+
+	if err != nil {
+		return
+	}
+
+	accounts, err := g.accounts()
+
+	if err != nil {
+		return
+	}
+
+	buffer, err := hex.DecodeString(g.Block)
+
+	if err != nil {
+		return
+	}
+
+	block := &Block{}
+
+	if err = block.unmarshal(buffer); err != nil {
+		return
+	}
+
+	if err = block.Verify(); err != nil {
+		return
+	}
+
+	if err = validators.VerifyProducer(0, time.Time{}, block.Header); err != nil {
+		return
+	}
+
+	paramsHash, err := g.paramsHash()
+
+	if err != nil {
+		return
+	}
+
+	if root := StateRoot(accounts); !block.Header.Root.IsEqual(&root) ||
+		!block.Header.Timestamp.Equal(g.Timestamp) ||
+		!block.Header.ParamsHash.IsEqual(&paramsHash) {
+		return nil, ErrInvalidGenesis
+	}
+
+	cfg = &Config{
+		DataDir:     dataDir,
+		Genesis:     block,
+		Accounts:    accounts,
+		QueryPrice:  g.Params.QueryPrice,
+		MaxFailures: g.Params.MaxFailures,
+		Validators:  validators,
+	}
+
+	if g.Params.StakePerReplica != "" {
+		var ok bool
+
+		if cfg.StakePerReplica, ok = new(big.Int).SetString(g.Params.StakePerReplica,
+			10); !ok || cfg.StakePerReplica.Sign() < 0 {
+			return nil, ErrInvalidGenesis
+		}
+	}
+
+	return
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/pow/cpuminer"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+)
+
+func createTestGenesis(t *testing.T, n int) (keys []*asymmetric.PrivateKey, g *Genesis) {
+	keys = make([]*asymmetric.PrivateKey, n)
+	g = &Genesis{
+		Timestamp: time.Unix(1528000000, 0).UTC(),
+		Params: GenesisParams{
+			QueryPrice:      10,
+			StakePerReplica: "100",
+			MaxFailures:     3,
+			TurnTimeout:     "10s",
+		},
+	}
+
+	for i := range keys {
+		priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		nonce := cpuminer.Uint256{A: uint64(i)}
+		keys[i] = priv
+		g.BlockProducers = append(g.BlockProducers, &GenesisBP{
+			NodeID:    proto2.NodeID(cpuminer.HashBlock(pub.Serialize(), nonce).String()),
+			PublicKey: hex.EncodeToString(pub.Serialize()),
+			Nonce:     nonce,
+			Addr:      "127.0.0.1:2120",
+		})
+		g.Accounts = append(g.Accounts, &GenesisAccount{
+			Address: AccountAddressFromPublicKey(pub),
+			Balance: "1000000000000000000000",
+		})
+	}
+
+	return
+}
+
+func TestGenesis(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genesis")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	keys, g := createTestGenesis(t, 3)
+
+	// Only the first block producer can sign the genesis block
+	if err = g.Sign(keys[1]); err != ErrInvalidProducer {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = g.Sign(keys[0]); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	path := filepath.Join(dir, "genesis.json")
+
+	if err = g.Save(path); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	loaded, err := LoadGenesis(path)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	nodes, err := loaded.Nodes()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(nodes) != len(keys) {
+		t.Fatalf("Unexpected node count: %d", len(nodes))
+	}
+
+	for i, node := range nodes {
+		if node.ID != g.BlockProducers[i].NodeID || !node.PublicKey.IsEqual(keys[i].PubKey()) {
+			t.Fatalf("Unexpected node: %v", node)
+		}
+	}
+
+	cfg, err := loaded.Config(filepath.Join(dir, "mainchain.db"))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if cfg.QueryPrice != g.Params.QueryPrice || cfg.StakePerReplica.Int64() != 100 ||
+		cfg.MaxFailures != g.Params.MaxFailures || cfg.Validators.Size() != len(keys) ||
+		cfg.Validators.TurnTimeout() != 10*time.Second {
+		t.Fatalf("Unexpected config: %v", cfg)
+	}
+
+	chain, err := NewChain(cfg)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer chain.Stop()
+
+	for _, a := range g.Accounts {
+		balance, err := chain.Balance(a.Address)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if balance.String() != a.Balance {
+			t.Fatalf("Unexpected balance: %v", balance)
+		}
+	}
+
+	// The genesis block commits the block producers, the accounts and the parameters of the genesis
+	// file
+	for _, c := range []struct {
+		tamper func(g *Genesis)
+		err    error
+	}{
+		{func(g *Genesis) { g.BlockProducers[1].Nonce.B++ }, ErrInvalidGenesisBP},
+		{func(g *Genesis) { g.BlockProducers = nil }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.Accounts[0].Balance = "1" }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.Accounts[0].Balance = "abc" }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.Accounts[1] = g.Accounts[0] }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.Timestamp = g.Timestamp.Add(time.Second) }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.BlockProducers = g.BlockProducers[1:] }, ErrInvalidProducer},
+		{func(g *Genesis) { g.Params.TurnTimeout = "10" }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.Params.TurnTimeout = "-1s" }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.Params.TurnTimeout = "20s" }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.Params.QueryPrice++ }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.Params.StakePerReplica = "1" }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.Params.MaxFailures = 0 }, ErrInvalidGenesis},
+		{func(g *Genesis) { g.BlockProducers[2].Addr = "127.0.0.1:2121" }, ErrInvalidGenesis},
+		{func(g *Genesis) {
+			g.BlockProducers[1], g.BlockProducers[2] = g.BlockProducers[2], g.BlockProducers[1]
+		}, ErrInvalidGenesis},
+	} {
+		tampered, err := LoadGenesis(path)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		c.tamper(tampered)
+
+		if _, err = tampered.Config(filepath.Join(dir, "tampered.db")); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
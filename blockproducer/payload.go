@@ -0,0 +1,210 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/types"
+)
+
+// SetPayload sets the type of the tx and encodes its payload, the payload of a transfer should be
+// nil.
+func (t *TxData) SetPayload(typ types.BPTxType, payload proto.Message) (err error) {
+	t.Type = typ
+	t.Payload = nil
+
+	if payload != nil {
+		t.Payload, err = proto.Marshal(payload)
+	}
+
+	return
+}
+
+// DecodePayload decodes the payload of the tx according to its type, it returns nil for a
+// transfer.
+func (t *TxData) DecodePayload() (payload proto.Message, err error) {
+	switch t.Type {
+	case types.BPTxType_TRANSFER:
+		if len(t.Payload) != 0 {
+			return nil, ErrInvalidTxPayload
+		}
+
+		return nil, nil
+	case types.BPTxType_CREATE_DATABASE:
+		payload = &types.CreateDatabasePayload{}
+	case types.BPTxType_DEPOSIT:
+		payload = &types.DepositPayload{}
+	case types.BPTxType_WITHDRAW:
+		payload = &types.WithdrawPayload{}
+	case types.BPTxType_GRANT_PERMISSION:
+		payload = &types.GrantPermissionPayload{}
+	case types.BPTxType_REVOKE_PERMISSION:
+		payload = &types.RevokePermissionPayload{}
+	case types.BPTxType_UPDATE_REPLICA_COUNT:
+		payload = &types.UpdateReplicaCountPayload{}
+	case types.BPTxType_REGISTER_MINER:
+		payload = &types.RegisterMinerPayload{}
+	case types.BPTxType_BILLING:
+		payload = &types.BillingPayload{}
+	case types.BPTxType_REPLACE_REPLICA:
+		payload = &types.ReplaceReplicaPayload{}
+	default:
+		return nil, ErrUnknownTxType
+	}
+
+	if err = proto.Unmarshal(t.Payload, payload); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Validate checks the tx against the rules of its type which don't depend on the chain state.
+func (t *TxData) Validate() (err error) {
+	if t.Amount == nil {
+		return ErrNilValue
+	}
+
+	payload, err := t.DecodePayload()
+	if err != nil {
+		return
+	}
+
+	// Only a transfer has a recipient
+	if (t.Type == types.BPTxType_TRANSFER) != (t.Recipient != nil) {
+		return ErrInvalidTxPayload
+	}
+
+	switch p := payload.(type) {
+	case *types.CreateDatabasePayload:
+		return validateReplicaCount(p.ReplicaCount)
+	case *types.DepositPayload:
+		return validateDatabaseID(p.DatabaseID)
+	case *types.WithdrawPayload:
+		return validateDatabaseID(p.DatabaseID)
+	case *types.GrantPermissionPayload:
+		if t.Amount.Sign() != 0 {
+			return ErrInvalidTxPayload
+		}
+
+		return validatePermission(p.DatabaseID, p.User, p.Permission)
+	case *types.RevokePermissionPayload:
+		if t.Amount.Sign() != 0 {
+			return ErrInvalidTxPayload
+		}
+
+		return validatePermission(p.DatabaseID, p.User, p.Permission)
+	case *types.UpdateReplicaCountPayload:
+		if t.Amount.Sign() != 0 {
+			return ErrInvalidTxPayload
+		}
+
+		if err = validateDatabaseID(p.DatabaseID); err != nil {
+			return
+		}
+
+		return validateReplicaCount(p.ReplicaCount)
+	case *types.RegisterMinerPayload:
+		if p.NodeID == nil || p.NodeID.NodeID == "" {
+			return ErrInvalidTxPayload
+		}
+	case *types.BillingPayload:
+		if t.Amount.Sign() != 0 {
+			return ErrInvalidTxPayload
+		}
+
+		return validateBilling(p)
+	case *types.ReplaceReplicaPayload:
+		if t.Amount.Sign() != 0 || p.Miner == nil || p.Miner.NodeID == "" {
+			return ErrInvalidTxPayload
+		}
+
+		if err = validateSignatures(p.Signatures); err != nil {
+			return
+		}
+
+		return validateDatabaseID(p.DatabaseID)
+	}
+
+	return
+}
+
+func validateDatabaseID(id string) error {
+	if id == "" {
+		return ErrInvalidTxPayload
+	}
+
+	return nil
+}
+
+func validateReplicaCount(count uint32) error {
+	if count == 0 || count > MaxReplicaCount {
+		return ErrInvalidTxPayload
+	}
+
+	return nil
+}
+
+// validateBilling checks the billing payload, the items must be sorted by their miners so that
+// the signed summary is canonical.
+func validateBilling(p *types.BillingPayload) error {
+	if p.StartHeight < 0 || p.StartHeight > p.EndHeight || len(p.Items) == 0 ||
+		len(p.GetEndBlockHash().GetHash()) != hash.HashSize {
+		return ErrInvalidTxPayload
+	}
+
+	for i, item := range p.Items {
+		if item.Miner == nil || item.Miner.NodeID == "" {
+			return ErrInvalidTxPayload
+		}
+
+		if i > 0 && p.Items[i-1].Miner.NodeID >= item.Miner.NodeID {
+			return ErrInvalidTxPayload
+		}
+	}
+
+	if err := validateSignatures(p.Signatures); err != nil {
+		return err
+	}
+
+	return validateDatabaseID(p.DatabaseID)
+}
+
+// validateSignatures checks that there is at least one replica signature and none of them misses
+// its signee or signature.
+func validateSignatures(signatures []*types.BillingSignature) error {
+	if len(signatures) == 0 {
+		return ErrInvalidTxPayload
+	}
+
+	for _, s := range signatures {
+		if s.Signee == nil || s.Signature == nil {
+			return ErrInvalidTxPayload
+		}
+	}
+
+	return nil
+}
+
+func validatePermission(id string, user *types.AccountAddress, perm uint32) error {
+	if user == nil || user.AccountAddress == "" || perm == 0 || perm&^PermissionAll != 0 {
+		return ErrInvalidTxPayload
+	}
+
+	return validateDatabaseID(id)
+}
@@ -0,0 +1,759 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"math/big"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/pow/cpuminer"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/types"
+)
+
+func createTestPayloadTx(t *testing.T, signer *asymmetric.PrivateKey, nonce uint64,
+	typ types.BPTxType, payload proto.Message, amount int64, fee uint64) (tx *Tx) {
+	tx = &Tx{
+		TxData: TxData{
+			AccountNonce: nonce,
+			Amount:       big.NewInt(amount),
+			Fee:          fee,
+		},
+	}
+
+	if err := tx.TxData.SetPayload(typ, payload); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err := tx.Sign(signer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+// createTestBillingPayload creates a billing payload of db with 10 queries for each miner.
+func createTestBillingPayload(start, end int32, endHash *types.Hash,
+	signatures []*types.BillingSignature, miners ...string) *types.BillingPayload {
+	p := &types.BillingPayload{
+		DatabaseID:   "db",
+		StartHeight:  start,
+		EndHeight:    end,
+		EndBlockHash: endHash,
+		Signatures:   signatures,
+	}
+
+	for _, m := range miners {
+		p.Items = append(p.Items, &types.BillingItem{
+			Miner:      &types.NodeID{NodeID: m},
+			QueryCount: 10,
+		})
+	}
+
+	return p
+}
+
+func TestTxDataValidate(t *testing.T) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	user := &types.AccountAddress{AccountAddress: "user"}
+	end := &types.Hash{Hash: make([]byte, hash.HashSize)}
+	sigs := []*types.BillingSignature{{Signee: &types.PublicKey{}, Signature: &types.Signature{}}}
+
+	for i, c := range []struct {
+		typ     types.BPTxType
+		payload proto.Message
+		amount  int64
+		err     error
+	}{
+		{types.BPTxType_CREATE_DATABASE, &types.CreateDatabasePayload{ReplicaCount: 3}, 10, nil},
+		{types.BPTxType_CREATE_DATABASE, &types.CreateDatabasePayload{}, 10, ErrInvalidTxPayload},
+		{types.BPTxType_CREATE_DATABASE,
+			&types.CreateDatabasePayload{ReplicaCount: MaxReplicaCount + 1}, 10,
+			ErrInvalidTxPayload},
+		{types.BPTxType_DEPOSIT, &types.DepositPayload{DatabaseID: "db"}, 10, nil},
+		{types.BPTxType_DEPOSIT, &types.DepositPayload{}, 10, ErrInvalidTxPayload},
+		{types.BPTxType_WITHDRAW, &types.WithdrawPayload{DatabaseID: "db"}, 10, nil},
+		{types.BPTxType_GRANT_PERMISSION, &types.GrantPermissionPayload{
+			DatabaseID: "db", User: user, Permission: PermissionRead}, 0, nil},
+		{types.BPTxType_GRANT_PERMISSION, &types.GrantPermissionPayload{
+			DatabaseID: "db", User: user, Permission: PermissionRead}, 1, ErrInvalidTxPayload},
+		{types.BPTxType_GRANT_PERMISSION, &types.GrantPermissionPayload{
+			DatabaseID: "db", Permission: PermissionRead}, 0, ErrInvalidTxPayload},
+		{types.BPTxType_GRANT_PERMISSION, &types.GrantPermissionPayload{
+			DatabaseID: "db", User: user, Permission: PermissionAll + 1}, 0,
+			ErrInvalidTxPayload},
+		{types.BPTxType_REVOKE_PERMISSION, &types.RevokePermissionPayload{
+			DatabaseID: "db", User: user, Permission: PermissionWrite}, 0, nil},
+		{types.BPTxType_REVOKE_PERMISSION, &types.RevokePermissionPayload{
+			DatabaseID: "db", User: user}, 0, ErrInvalidTxPayload},
+		{types.BPTxType_UPDATE_REPLICA_COUNT, &types.UpdateReplicaCountPayload{
+			DatabaseID: "db", ReplicaCount: 5}, 0, nil},
+		{types.BPTxType_UPDATE_REPLICA_COUNT, &types.UpdateReplicaCountPayload{
+			ReplicaCount: 5}, 0, ErrInvalidTxPayload},
+		{types.BPTxType_REGISTER_MINER, &types.RegisterMinerPayload{
+			NodeID: &types.NodeID{NodeID: "node"}}, 10, nil},
+		{types.BPTxType_REGISTER_MINER, &types.RegisterMinerPayload{}, 10, ErrInvalidTxPayload},
+		{types.BPTxType_BILLING, createTestBillingPayload(0, 9, end, sigs, "node"), 0, nil},
+		{types.BPTxType_BILLING, createTestBillingPayload(5, 4, end, sigs, "node"), 0,
+			ErrInvalidTxPayload},
+		{types.BPTxType_BILLING, createTestBillingPayload(0, 9, end, sigs), 0,
+			ErrInvalidTxPayload},
+		{types.BPTxType_BILLING, createTestBillingPayload(0, 9, end, sigs, "node", "node"), 0,
+			ErrInvalidTxPayload},
+		{types.BPTxType_BILLING, createTestBillingPayload(0, 9, end, sigs, "b", "a"), 0,
+			ErrInvalidTxPayload},
+		{types.BPTxType_BILLING, createTestBillingPayload(0, 9, end, nil, "node"), 0,
+			ErrInvalidTxPayload},
+		{types.BPTxType_BILLING, createTestBillingPayload(0, 9, nil, sigs, "node"), 0,
+			ErrInvalidTxPayload},
+		{types.BPTxType_BILLING, createTestBillingPayload(0, 9, end,
+			[]*types.BillingSignature{{Signee: &types.PublicKey{}}}, "node"), 0,
+			ErrInvalidTxPayload},
+		{types.BPTxType_REPLACE_REPLICA, &types.ReplaceReplicaPayload{
+			DatabaseID: "db", Miner: &types.NodeID{NodeID: "node"}, Signatures: sigs}, 0, nil},
+		{types.BPTxType_REPLACE_REPLICA, &types.ReplaceReplicaPayload{
+			DatabaseID: "db", Signatures: sigs}, 0, ErrInvalidTxPayload},
+		{types.BPTxType_REPLACE_REPLICA, &types.ReplaceReplicaPayload{
+			Miner: &types.NodeID{NodeID: "node"}, Signatures: sigs}, 0, ErrInvalidTxPayload},
+		{types.BPTxType_REPLACE_REPLICA, &types.ReplaceReplicaPayload{
+			DatabaseID: "db", Miner: &types.NodeID{NodeID: "node"}}, 0, ErrInvalidTxPayload},
+		{types.BPTxType_REPLACE_REPLICA, &types.ReplaceReplicaPayload{
+			DatabaseID: "db", Miner: &types.NodeID{NodeID: "node"},
+			Signatures: []*types.BillingSignature{{Signature: &types.Signature{}}}}, 0,
+			ErrInvalidTxPayload},
+		{types.BPTxType_TRANSFER, &types.DepositPayload{DatabaseID: "db"}, 10,
+			ErrInvalidTxPayload},
+		{types.BPTxType(100), nil, 10, ErrUnknownTxType},
+	} {
+		tx := createTestPayloadTx(t, priv, 0, c.typ, c.payload, c.amount, 1)
+
+		if err = tx.Verify(); err != c.err {
+			t.Fatalf("Unexpected error in case #%d: %v", i, err)
+		}
+	}
+
+	// Only a transfer has a recipient
+	tx := createTestPayloadTx(t, priv, 0, types.BPTxType_TRANSFER, nil, 10, 1)
+
+	if err = tx.Verify(); err != ErrInvalidTxPayload {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tx = createTestPayloadTx(t, priv, 0, types.BPTxType_DEPOSIT,
+		&types.DepositPayload{DatabaseID: "db"}, 10, 1)
+	tx.TxData.Recipient = user
+
+	if err = tx.Sign(priv); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = tx.Verify(); err != ErrInvalidTxPayload {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The tx type is signed
+	tx = createTestPayloadTx(t, priv, 0, types.BPTxType_DEPOSIT,
+		&types.DepositPayload{DatabaseID: "db"}, 10, 1)
+	tx.TxData.Type = types.BPTxType_WITHDRAW
+
+	if err = tx.Verify(); err != ErrSignVerification {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func checkDatabase(t *testing.T, chain *Chain, id string, balance int64, replicas uint32,
+	perms map[proto2.AccountAddress]uint32) {
+	database, err := chain.GetDatabase(id)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if database.Balance.Cmp(big.NewInt(balance)) != 0 || database.ReplicaCount != replicas ||
+		len(database.Permissions) != len(perms) {
+		t.Fatalf("Unexpected database state: %+v", database)
+	}
+
+	for u, p := range perms {
+		if database.Permissions[u] != p {
+			t.Fatalf("Unexpected permission of %s: %d", u, database.Permissions[u])
+		}
+	}
+}
+
+func checkReplicas(t *testing.T, chain *Chain, id string, term uint64,
+	replicas []proto2.NodeID) {
+	database, err := chain.GetDatabase(id)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if database.Term != term || !reflect.DeepEqual(database.Replicas, replicas) {
+		t.Fatalf("Unexpected replicas: term = %d, replicas = %v", database.Term,
+			database.Replicas)
+	}
+}
+
+func checkMiner(t *testing.T, chain *Chain, id proto2.NodeID, load, failures uint32) {
+	miner, err := chain.GetMiner(id)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if miner.Load != load || miner.Failures != failures {
+		t.Fatalf("Unexpected miner state: %+v", miner)
+	}
+}
+
+func TestDatabaseTxs(t *testing.T) {
+	alice, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	bob, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	bp, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	aliceAddr := AccountAddressFromPublicKey(alice.PubKey())
+	bobAddr := AccountAddressFromPublicKey(bob.PubKey())
+	bpAddr := AccountAddressFromPublicKey(bp.PubKey())
+	chain, cfg := createTestChain(t, []*Account{
+		{Address: aliceAddr, Balance: big.NewInt(100)},
+		{Address: bobAddr, Balance: big.NewInt(100)},
+	})
+	defer chain.Stop()
+
+	// Register the miners and create a database on them
+	dbID := DatabaseIDFromTx(aliceAddr, 0)
+	bobUser := &types.AccountAddress{AccountAddress: string(bobAddr)}
+	b1 := createNextBlock(t, chain, bp, []*Tx{
+		createTestPayloadTx(t, bob, 0, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: "m1"}}, 10, 1),
+		createTestPayloadTx(t, bob, 1, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: "m2"}}, 10, 1),
+		createTestPayloadTx(t, bob, 2, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: "m3"}}, 10, 1),
+		createTestPayloadTx(t, alice, 0, types.BPTxType_CREATE_DATABASE,
+			&types.CreateDatabasePayload{ReplicaCount: 3}, 30, 1),
+		createTestPayloadTx(t, alice, 1, types.BPTxType_GRANT_PERMISSION,
+			&types.GrantPermissionPayload{
+				DatabaseID: dbID, User: bobUser, Permission: PermissionRead | PermissionWrite,
+			}, 0, 1),
+		createTestPayloadTx(t, bob, 3, types.BPTxType_DEPOSIT,
+			&types.DepositPayload{DatabaseID: dbID}, 20, 1),
+	})
+
+	if err = chain.PushBlock(b1); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkAccount(t, chain, aliceAddr, 68, 2)
+	checkAccount(t, chain, bobAddr, 46, 4)
+	checkAccount(t, chain, bpAddr, 6, 0)
+	checkDatabase(t, chain, dbID, 50, 3, map[proto2.AccountAddress]uint32{
+		aliceAddr: PermissionAll,
+		bobAddr:   PermissionRead | PermissionWrite,
+	})
+	database, err := chain.GetDatabase(dbID)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	replicas := database.Replicas
+	checkReplicas(t, chain, dbID, 1, replicas)
+
+	for _, id := range []proto2.NodeID{"m1", "m2", "m3"} {
+		if !database.HasReplica(id) {
+			t.Fatalf("Miner %s is not placed: %v", id, replicas)
+		}
+
+		checkMiner(t, chain, id, 1, 0)
+	}
+
+	miner, err := chain.GetMiner("m1")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if miner.Owner != bobAddr || miner.Stake.Int64() != 10 {
+		t.Fatalf("Unexpected miner state: %+v", miner)
+	}
+
+	// Invalid state transitions
+	for _, c := range []struct {
+		tx  *Tx
+		err error
+	}{
+		{createTestPayloadTx(t, bob, 4, types.BPTxType_WITHDRAW,
+			&types.WithdrawPayload{DatabaseID: dbID}, 10, 1), ErrPermissionDenied},
+		{createTestPayloadTx(t, alice, 2, types.BPTxType_WITHDRAW,
+			&types.WithdrawPayload{DatabaseID: dbID}, 51, 1), ErrInsufficientBalance},
+		{createTestPayloadTx(t, alice, 2, types.BPTxType_DEPOSIT,
+			&types.DepositPayload{DatabaseID: "nonexistent"}, 1, 1), ErrDatabaseNotFound},
+		{createTestPayloadTx(t, alice, 2, types.BPTxType_REVOKE_PERMISSION,
+			&types.RevokePermissionPayload{
+				DatabaseID: dbID,
+				User:       &types.AccountAddress{AccountAddress: string(aliceAddr)},
+				Permission: PermissionAdmin,
+			}, 0, 1), ErrPermissionDenied},
+		{createTestPayloadTx(t, alice, 2, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: "m1"}}, 1, 1),
+			ErrMinerExists},
+		{createTestPayloadTx(t, alice, 2, types.BPTxType_CREATE_DATABASE,
+			&types.CreateDatabasePayload{ReplicaCount: 4}, 1, 1), ErrInsufficientMiners},
+		{createTestPayloadTx(t, alice, 2, types.BPTxType_UPDATE_REPLICA_COUNT,
+			&types.UpdateReplicaCountPayload{DatabaseID: dbID, ReplicaCount: 5}, 0, 1),
+			ErrInsufficientMiners},
+	} {
+		if _, err = chain.ComputeStateRoot(bpAddr, []*Tx{c.tx}); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// The stakes of the miners only cover their current replicas
+	cfg.StakePerReplica = big.NewInt(10)
+
+	if _, err = chain.ComputeStateRoot(bpAddr, []*Tx{
+		createTestPayloadTx(t, alice, 2, types.BPTxType_CREATE_DATABASE,
+			&types.CreateDatabasePayload{ReplicaCount: 1}, 1, 1),
+	}); err != ErrInsufficientMiners {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cfg.StakePerReplica = nil
+
+	// Withdraw, revoke and shrink the replicas
+	b2 := createNextBlock(t, chain, bp, []*Tx{
+		createTestPayloadTx(t, alice, 2, types.BPTxType_WITHDRAW,
+			&types.WithdrawPayload{DatabaseID: dbID}, 15, 1),
+		createTestPayloadTx(t, alice, 3, types.BPTxType_REVOKE_PERMISSION,
+			&types.RevokePermissionPayload{
+				DatabaseID: dbID, User: bobUser, Permission: PermissionWrite,
+			}, 0, 1),
+		createTestPayloadTx(t, alice, 4, types.BPTxType_UPDATE_REPLICA_COUNT,
+			&types.UpdateReplicaCountPayload{DatabaseID: dbID, ReplicaCount: 2}, 0, 1),
+	})
+
+	if err = chain.PushBlock(b2); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkAccount(t, chain, aliceAddr, 80, 5)
+	checkDatabase(t, chain, dbID, 35, 2, map[proto2.AccountAddress]uint32{
+		aliceAddr: PermissionAll,
+		bobAddr:   PermissionRead,
+	})
+	checkReplicas(t, chain, dbID, 2, replicas[:2])
+	checkMiner(t, chain, replicas[2], 0, 0)
+
+	// Pop the blocks and restore the database and miner states
+	if _, err = chain.PopBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkDatabase(t, chain, dbID, 50, 3, map[proto2.AccountAddress]uint32{
+		aliceAddr: PermissionAll,
+		bobAddr:   PermissionRead | PermissionWrite,
+	})
+	checkReplicas(t, chain, dbID, 1, replicas)
+	checkMiner(t, chain, replicas[2], 1, 0)
+
+	if _, err = chain.PopBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = chain.GetDatabase(dbID); err != ErrDatabaseNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = chain.GetMiner("m1"); err != ErrMinerNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	checkAccount(t, chain, aliceAddr, 100, 0)
+	checkAccount(t, chain, bobAddr, 100, 0)
+}
+
+// testMiner is a miner node whose id is mined from its public key and nonce.
+type testMiner struct {
+	priv  *asymmetric.PrivateKey
+	nonce cpuminer.Uint256
+	id    proto2.NodeID
+}
+
+func createTestMiner(t *testing.T) *testMiner {
+	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	nonce := cpuminer.Uint256{A: 1}
+	return &testMiner{
+		priv:  priv,
+		nonce: nonce,
+		id:    proto2.NodeID(cpuminer.HashBlock(pub.Serialize(), nonce).String()),
+	}
+}
+
+func createTestBillingTx(t *testing.T, signer *asymmetric.PrivateKey, nonce uint64, id string,
+	start, end int32, counts map[proto2.NodeID]uint64, signers []*testMiner,
+	tamper func(p *types.BillingPayload)) *Tx {
+	p := &types.BillingPayload{
+		DatabaseID:   id,
+		StartHeight:  start,
+		EndHeight:    end,
+		EndBlockHash: &types.Hash{Hash: make([]byte, hash.HashSize)},
+	}
+
+	for miner, count := range counts {
+		p.Items = append(p.Items, &types.BillingItem{
+			Miner:      &types.NodeID{NodeID: string(miner)},
+			QueryCount: count,
+		})
+	}
+
+	sort.Slice(p.Items, func(i, j int) bool {
+		return p.Items[i].Miner.NodeID < p.Items[j].Miner.NodeID
+	})
+
+	for _, m := range signers {
+		if err := SignBilling(p, m.priv, m.nonce); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	if tamper != nil {
+		tamper(p)
+	}
+
+	return createTestPayloadTx(t, signer, nonce, types.BPTxType_BILLING, p, 0, 1)
+}
+
+func TestBillingTxs(t *testing.T) {
+	keys := make([]*asymmetric.PrivateKey, 4)
+	addrs := make([]proto2.AccountAddress, 4)
+
+	for i := range keys {
+		priv, _, err := asymmetric.GenSecp256k1KeyPair()
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		keys[i] = priv
+		addrs[i] = AccountAddressFromPublicKey(priv.PubKey())
+	}
+
+	alice, bob, carol, bp := keys[0], keys[1], keys[2], keys[3]
+	m1, m2, m3 := createTestMiner(t), createTestMiner(t), createTestMiner(t)
+	chain, cfg := createTestChain(t, []*Account{
+		{Address: addrs[0], Balance: big.NewInt(100)},
+		{Address: addrs[1], Balance: big.NewInt(100)},
+		{Address: addrs[2], Balance: big.NewInt(100)},
+	})
+	defer chain.Stop()
+	cfg.QueryPrice = 2
+
+	// Alice creates a database served by the miners of bob and carol, and carol registers
+	// another miner afterwards
+	dbID := DatabaseIDFromTx(addrs[0], 0)
+	b1 := createNextBlock(t, chain, bp, []*Tx{
+		createTestPayloadTx(t, bob, 0, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: string(m1.id)}}, 10, 1),
+		createTestPayloadTx(t, carol, 0, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: string(m2.id)}}, 10, 1),
+		createTestPayloadTx(t, alice, 0, types.BPTxType_CREATE_DATABASE,
+			&types.CreateDatabasePayload{ReplicaCount: 2}, 50, 1),
+		createTestPayloadTx(t, carol, 1, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: string(m3.id)}}, 10, 1),
+	})
+
+	if err := chain.PushBlock(b1); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Bob bills the usage of the SQL chain heights [0, 4] signed by both replicas
+	replicas := []*testMiner{m1, m2}
+	b2 := createNextBlock(t, chain, bp, []*Tx{
+		createTestBillingTx(t, bob, 1, dbID, 0, 4,
+			map[proto2.NodeID]uint64{m1.id: 10, m2.id: 5}, replicas, nil),
+	})
+
+	if err := chain.PushBlock(b2); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkAccount(t, chain, addrs[1], 108, 2)
+	checkAccount(t, chain, addrs[2], 88, 2)
+	checkDatabase(t, chain, dbID, 20, 2, map[proto2.AccountAddress]uint32{
+		addrs[0]: PermissionAll,
+	})
+	database, err := chain.GetDatabase(dbID)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if database.BilledHeight != 5 {
+		t.Fatalf("Unexpected billed height: %d", database.BilledHeight)
+	}
+
+	// Invalid billing txs
+	for _, c := range []struct {
+		tx  *Tx
+		err error
+	}{
+		{createTestBillingTx(t, carol, 2, dbID, 0, 4, map[proto2.NodeID]uint64{m2.id: 5},
+			replicas, nil), ErrBillingHeightMismatch},
+		{createTestBillingTx(t, carol, 2, dbID, 3, 9, map[proto2.NodeID]uint64{m2.id: 5},
+			replicas, nil), ErrBillingHeightMismatch},
+		{createTestBillingTx(t, alice, 1, dbID, 5, 9, map[proto2.NodeID]uint64{m2.id: 5},
+			replicas, nil), ErrPermissionDenied},
+		{createTestBillingTx(t, carol, 2, dbID, 5, 9, map[proto2.NodeID]uint64{m2.id: 11},
+			replicas, nil), ErrInsufficientBalance},
+		{createTestBillingTx(t, carol, 2, dbID, 5, 9,
+			map[proto2.NodeID]uint64{m2.id: 1, m3.id: 1}, replicas, nil), ErrNotReplica},
+		{createTestBillingTx(t, carol, 2, dbID, 5, 9,
+			map[proto2.NodeID]uint64{m2.id: 1, "m4": 1}, replicas, nil), ErrNotReplica},
+		{createTestBillingTx(t, carol, 2, "nonexistent", 0, 4,
+			map[proto2.NodeID]uint64{m2.id: 1}, replicas, nil), ErrDatabaseNotFound},
+		{createTestBillingTx(t, carol, 2, dbID, 5, 9, map[proto2.NodeID]uint64{m2.id: 5},
+			[]*testMiner{m2}, nil), ErrInsufficientSignatures},
+		{createTestBillingTx(t, carol, 2, dbID, 5, 9, map[proto2.NodeID]uint64{m2.id: 5},
+			[]*testMiner{m2, m2}, nil), ErrInsufficientSignatures},
+		{createTestBillingTx(t, carol, 2, dbID, 5, 9, map[proto2.NodeID]uint64{m2.id: 5},
+			[]*testMiner{m1, m2, m3}, nil), ErrNotReplica},
+		{createTestBillingTx(t, carol, 2, dbID, 5, 9, map[proto2.NodeID]uint64{m2.id: 5},
+			replicas, func(p *types.BillingPayload) {
+				p.Items[0].QueryCount = 6
+			}), ErrSignVerification},
+		{createTestBillingTx(t, carol, 2, dbID, 5, 9, map[proto2.NodeID]uint64{m2.id: 5},
+			replicas, func(p *types.BillingPayload) {
+				p.EndBlockHash.Hash[0] = 1
+			}), ErrSignVerification},
+	} {
+		if _, err = chain.ComputeStateRoot(addrs[3], []*Tx{c.tx}); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Pop the billing block and restore the billed height
+	if _, err = chain.PopBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if database, err = chain.GetDatabase(dbID); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if database.BilledHeight != 0 || database.Balance.Int64() != 50 {
+		t.Fatalf("Unexpected database state: %+v", database)
+	}
+
+	checkAccount(t, chain, addrs[1], 89, 1)
+	checkAccount(t, chain, addrs[2], 78, 2)
+}
+
+func createTestReplaceTx(t *testing.T, signer *asymmetric.PrivateKey, nonce uint64, id string,
+	miner proto2.NodeID, term uint64, signers []*testMiner,
+	tamper func(p *types.ReplaceReplicaPayload)) *Tx {
+	p := &types.ReplaceReplicaPayload{
+		DatabaseID: id,
+		Miner:      &types.NodeID{NodeID: string(miner)},
+		Term:       term,
+	}
+
+	for _, m := range signers {
+		if err := SignReplaceReplica(p, m.priv, m.nonce); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	if tamper != nil {
+		tamper(p)
+	}
+
+	return createTestPayloadTx(t, signer, nonce, types.BPTxType_REPLACE_REPLICA, p, 0, 1)
+}
+
+func TestReplaceReplicaTxs(t *testing.T) {
+	keys := make([]*asymmetric.PrivateKey, 4)
+	addrs := make([]proto2.AccountAddress, 4)
+
+	for i := range keys {
+		priv, _, err := asymmetric.GenSecp256k1KeyPair()
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		keys[i] = priv
+		addrs[i] = AccountAddressFromPublicKey(priv.PubKey())
+	}
+
+	alice, bob, carol, bp := keys[0], keys[1], keys[2], keys[3]
+	miners := map[proto2.NodeID]*testMiner{}
+
+	for i := 0; i < 4; i++ {
+		m := createTestMiner(t)
+		miners[m.id] = m
+	}
+
+	ids := make([]proto2.NodeID, 0, len(miners))
+
+	for id := range miners {
+		ids = append(ids, id)
+	}
+
+	chain, _ := createTestChain(t, []*Account{
+		{Address: addrs[0], Balance: big.NewInt(100)},
+		{Address: addrs[1], Balance: big.NewInt(100)},
+		{Address: addrs[2], Balance: big.NewInt(100)},
+	})
+	defer chain.Stop()
+
+	// Alice creates a database on the three miners of bob and carol
+	dbID := DatabaseIDFromTx(addrs[0], 0)
+	b1 := createNextBlock(t, chain, bp, []*Tx{
+		createTestPayloadTx(t, bob, 0, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: string(ids[0])}}, 10, 1),
+		createTestPayloadTx(t, bob, 1, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: string(ids[1])}}, 10, 1),
+		createTestPayloadTx(t, carol, 0, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: string(ids[2])}}, 10, 1),
+	})
+
+	if err := chain.PushBlock(b1); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	b2 := createNextBlock(t, chain, bp, []*Tx{
+		createTestPayloadTx(t, alice, 0, types.BPTxType_CREATE_DATABASE,
+			&types.CreateDatabasePayload{ReplicaCount: 3}, 30, 1),
+	})
+
+	if err := chain.PushBlock(b2); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	database, err := chain.GetDatabase(dbID)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// The replicas owned by bob report the failure of the replica owned by carol
+	failed := ids[2]
+	others := []*testMiner{miners[ids[0]], miners[ids[1]]}
+	checkReplicas(t, chain, dbID, 1, database.Replicas)
+
+	// Invalid replacement txs
+	for _, c := range []struct {
+		tx  *Tx
+		err error
+	}{
+		{createTestReplaceTx(t, alice, 1, dbID, failed, 1, others, nil), ErrPermissionDenied},
+		{createTestReplaceTx(t, bob, 2, dbID, failed, 2, others, nil), ErrTermMismatch},
+		{createTestReplaceTx(t, bob, 2, dbID, ids[3], 1, others, nil), ErrNotReplica},
+		{createTestReplaceTx(t, bob, 2, "nonexistent", failed, 1, others, nil),
+			ErrDatabaseNotFound},
+		{createTestReplaceTx(t, bob, 2, dbID, failed, 1, others[:1], nil),
+			ErrInsufficientSignatures},
+		{createTestReplaceTx(t, bob, 2, dbID, failed, 1,
+			[]*testMiner{others[0], others[0]}, nil), ErrInsufficientSignatures},
+		{createTestReplaceTx(t, bob, 2, dbID, failed, 1,
+			[]*testMiner{others[0], miners[failed]}, nil), ErrNotReplica},
+		{createTestReplaceTx(t, bob, 2, dbID, failed, 1,
+			[]*testMiner{others[0], others[1], miners[ids[3]]}, nil), ErrNotReplica},
+		{createTestReplaceTx(t, bob, 2, dbID, failed, 1, others,
+			func(p *types.ReplaceReplicaPayload) {
+				p.Miner.NodeID = string(ids[0])
+			}), ErrSignVerification},
+	} {
+		if _, err = chain.ComputeStateRoot(addrs[3], []*Tx{c.tx}); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Carol registers another miner which takes over the failed replica
+	replace := createTestReplaceTx(t, bob, 2, dbID, failed, 1, others, nil)
+	b3 := createNextBlock(t, chain, bp, []*Tx{
+		createTestPayloadTx(t, carol, 1, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: string(ids[3])}}, 10, 1),
+		replace,
+	})
+
+	if err = chain.PushBlock(b3); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkMiner(t, chain, failed, 0, 1)
+	checkMiner(t, chain, ids[3], 1, 0)
+	var replicas []proto2.NodeID
+
+	for _, id := range database.Replicas {
+		if id != failed {
+			replicas = append(replicas, id)
+		}
+	}
+
+	checkReplicas(t, chain, dbID, 2, append(replicas, ids[3]))
+
+	// The report can't be replayed in the next term
+	if _, err = chain.ComputeStateRoot(addrs[3], []*Tx{
+		createTestReplaceTx(t, bob, 3, dbID, failed, 1, others, nil),
+	}); err != ErrTermMismatch {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Pop the replacement block and restore the replicas
+	if _, err = chain.PopBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkReplicas(t, chain, dbID, 1, database.Replicas)
+	checkMiner(t, chain, failed, 1, 0)
+
+	if _, err = chain.GetMiner(ids[3]); err != ErrMinerNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package placement
+
+import (
+	"github.com/thunderdb/ThunderDB/blockproducer"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/proto"
+)
+
+// State provides the databases on the main chain, it's implemented by blockproducer.Chain.
+type State interface {
+	GetDatabase(id string) (*blockproducer.Database, error)
+}
+
+// Caller calls the RPC methods of the miners, it's implemented by sqlchain.RPCCaller.
+type Caller interface {
+	CallNode(nodeID proto.NodeID, method string, args, reply interface{}) error
+}
+
+// Config represents a placement service config.
+type Config struct {
+	State State
+
+	// Signer signs the peers configurations, it's the private key of the block producer.
+	Signer *asymmetric.PrivateKey
+	Caller Caller
+}
@@ -14,17 +14,7 @@
  * limitations under the License.
  */
 
-package sqlchain
-
-import "github.com/thunderdb/ThunderDB/proto"
-
-// BlockID is the hash of block content
-type BlockID string
-
-// StorageProofBlock records block's status
-type StorageProofBlock struct {
-	// Block id
-	ID BlockID
-	// Nodes with index in the SQL chain.
-	Nodes []proto.Node
-}
+// Package placement pushes the signed kayak peers configurations of the databases to the miners
+// hosting their replicas. The replicas are placed by the database txs on the main chain, so every
+// block producer derives the same peers from the chain state.
+package placement
@@ -0,0 +1,36 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package placement
+
+import (
+	"errors"
+)
+
+var (
+	// ErrInvalidConfig indicates that the placement service config misses a required component.
+	ErrInvalidConfig = errors.New("invalid placement config")
+
+	// ErrDatabaseNotPlaced indicates that the database has no replica on the main chain.
+	ErrDatabaseNotPlaced = errors.New("database not placed")
+
+	// ErrInvalidPeers indicates that the peers configuration isn't signed by the block producer.
+	ErrInvalidPeers = errors.New("invalid peers configuration")
+
+	// ErrStaleTerm indicates that the term of the peers configuration is not newer than the
+	// current one of the database.
+	ErrStaleTerm = errors.New("stale peers term")
+)
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package placement
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+// marshalPeers encodes the peers configuration, the leader is encoded as its index in the
+// servers.
+func marshalPeers(peers *kayak.Peers) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	leader := int32(-1)
+
+	for i, s := range peers.Servers {
+		if s == peers.Leader {
+			leader = int32(i)
+		}
+	}
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		peers.Term,
+		leader,
+		uint32(len(peers.Servers)),
+	); err != nil {
+		return nil, err
+	}
+
+	for _, s := range peers.Servers {
+		if err := utils.WriteElements(buffer, binary.BigEndian,
+			int32(s.Role),
+			s.ID,
+			s.PubKey,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		peers.PubKey,
+		peers.Signature,
+	); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func unmarshalPeers(b []byte) (peers *kayak.Peers, err error) {
+	reader := bytes.NewReader(b)
+	peers = &kayak.Peers{}
+	var (
+		leader int32
+		l      uint32
+	)
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &peers.Term, &leader, &l); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return nil, utils.ErrInsufficientBuffer
+	}
+
+	peers.Servers = make([]*kayak.Server, l)
+
+	for i := range peers.Servers {
+		var (
+			role   int32
+			id     proto.NodeID
+			pubKey *asymmetric.PublicKey
+		)
+
+		if err = utils.ReadElements(reader, binary.BigEndian, &role, &id, &pubKey); err != nil {
+			return
+		}
+
+		peers.Servers[i] = &kayak.Server{
+			Role:   kayak.ServerRole(role),
+			ID:     id,
+			PubKey: pubKey,
+		}
+	}
+
+	if leader < 0 || int(leader) >= len(peers.Servers) {
+		return nil, ErrInvalidPeers
+	}
+
+	peers.Leader = peers.Servers[leader]
+	err = utils.ReadElements(reader, binary.BigEndian, &peers.PubKey, &peers.Signature)
+	return
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package placement
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+const (
+	// PeersRPCServiceName is the name of the RPC service receiving the peers configurations on
+	// the miners.
+	PeersRPCServiceName = "DBP"
+)
+
+// UpdatePeersReq defines a request of the UpdatePeers RPC method, it's signed by the block
+// producer to bind the peers configuration to the database.
+type UpdatePeersReq struct {
+	DatabaseID string
+	Peers      []byte
+	Signee     *asymmetric.PublicKey
+	Signature  *asymmetric.Signature
+}
+
+func (r *UpdatePeersReq) hash() (h hash.Hash, err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = utils.WriteElements(buffer, binary.BigEndian, r.DatabaseID, r.Peers); err != nil {
+		return
+	}
+
+	return hash.THashH(buffer.Bytes()), nil
+}
+
+// Sign signs the request with the private key of the block producer.
+func (r *UpdatePeersReq) Sign(signer *asymmetric.PrivateKey) (err error) {
+	h, err := r.hash()
+
+	if err != nil {
+		return
+	}
+
+	r.Signee = signer.PubKey()
+	r.Signature, err = signer.Sign(h[:])
+	return
+}
+
+// Verify verifies the signature of the request.
+func (r *UpdatePeersReq) Verify() (err error) {
+	if r.Signee == nil || r.Signature == nil {
+		return ErrInvalidPeers
+	}
+
+	h, err := r.hash()
+
+	if err != nil {
+		return
+	}
+
+	if !r.Signature.Verify(h[:], r.Signee) {
+		return ErrInvalidPeers
+	}
+
+	return
+}
+
+// UpdatePeersResp defines a response of the UpdatePeers RPC method.
+type UpdatePeersResp struct{}
+
+// PeersHandler applies the peers configuration of a database on a miner, e.g. by calling
+// kayak.Runtime.UpdatePeers of the database.
+type PeersHandler func(databaseID string, peers *kayak.Peers) error
+
+// PeersRPCService is the server side RPC implementation on a miner receiving the peers
+// configurations pushed by the block producer. It keeps the term of the last applied peers of
+// each database, and only applies the peers of a newer term.
+type PeersRPCService struct {
+	sync.Mutex
+
+	producer *asymmetric.PublicKey
+	handler  PeersHandler
+	terms    map[string]uint64
+}
+
+// NewPeersRPCService returns a new PeersRPCService accepting the peers configurations signed by
+// the producer key.
+func NewPeersRPCService(producer *asymmetric.PublicKey, handler PeersHandler) *PeersRPCService {
+	return &PeersRPCService{
+		producer: producer,
+		handler:  handler,
+		terms:    make(map[string]uint64),
+	}
+}
+
+// UpdatePeers RPC verifies the request and the peers configuration, and applies it with the
+// handler if its term is newer than the current one of the database.
+func (s *PeersRPCService) UpdatePeers(req *UpdatePeersReq, resp *UpdatePeersResp) (err error) {
+	if err = req.Verify(); err != nil {
+		return
+	}
+
+	if !req.Signee.IsEqual(s.producer) {
+		return ErrInvalidPeers
+	}
+
+	peers, err := unmarshalPeers(req.Peers)
+
+	if err != nil {
+		return
+	}
+
+	if peers.PubKey == nil || peers.Signature == nil || !peers.PubKey.IsEqual(s.producer) ||
+		!peers.Verify() {
+		return ErrInvalidPeers
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if peers.Term <= s.terms[req.DatabaseID] {
+		return ErrStaleTerm
+	}
+
+	if err = s.handler(req.DatabaseID, peers); err != nil {
+		return
+	}
+
+	s.terms[req.DatabaseID] = peers.Term
+	return
+}
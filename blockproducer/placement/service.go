@@ -0,0 +1,227 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package placement
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/blockproducer"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/types"
+)
+
+// Service pushes the peers configurations of the databases to their miners. The peers are built
+// from the replicas and the term of the database on the main chain, the first replica is the
+// leader.
+type Service struct {
+	sync.Mutex
+
+	cfg    *Config
+	pushed map[string]map[proto.NodeID]uint64
+}
+
+// NewService creates a new placement service with the config.
+func NewService(cfg *Config) (s *Service, err error) {
+	if cfg == nil || cfg.State == nil || cfg.Signer == nil || cfg.Caller == nil {
+		return nil, ErrInvalidConfig
+	}
+
+	s = &Service{
+		cfg:    cfg,
+		pushed: make(map[string]map[proto.NodeID]uint64),
+	}
+
+	return
+}
+
+// Peers returns the signed peers configuration of the database on the main chain.
+func (s *Service) Peers(databaseID string) (peers *kayak.Peers, err error) {
+	database, err := s.cfg.State.GetDatabase(databaseID)
+
+	if err != nil {
+		return
+	}
+
+	if len(database.Replicas) == 0 {
+		return nil, ErrDatabaseNotPlaced
+	}
+
+	servers := make([]*kayak.Server, len(database.Replicas))
+
+	for i, id := range database.Replicas {
+		pubKey, err := kms.GetPublicKey(id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		servers[i] = &kayak.Server{
+			Role:   kayak.Follower,
+			ID:     id,
+			PubKey: pubKey,
+		}
+	}
+
+	servers[0].Role = kayak.Leader
+	peers = &kayak.Peers{
+		Term:    database.Term,
+		Leader:  servers[0],
+		Servers: servers,
+		PubKey:  s.cfg.Signer.PubKey(),
+	}
+
+	if err = peers.Sign(s.cfg.Signer); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Sync pushes the peers configuration of the database to its miners which haven't accepted its
+// term yet, so that the miners failing to accept it get it again by the next Sync. The miners
+// removed from the database are notified once.
+func (s *Service) Sync(databaseID string) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	peers, err := s.Peers(databaseID)
+
+	if err != nil {
+		return
+	}
+
+	terms := s.pushed[databaseID]
+
+	if terms == nil {
+		terms = make(map[proto.NodeID]uint64)
+		s.pushed[databaseID] = terms
+	}
+
+	buffer, err := marshalPeers(peers)
+
+	if err != nil {
+		return
+	}
+
+	req := &UpdatePeersReq{
+		DatabaseID: databaseID,
+		Peers:      buffer,
+	}
+
+	if err = req.Sign(s.cfg.Signer); err != nil {
+		return
+	}
+
+	var failed []proto.NodeID
+
+	for _, srv := range peers.Servers {
+		if terms[srv.ID] >= peers.Term {
+			continue
+		}
+
+		if cerr := s.call(srv.ID, req); cerr != nil {
+			failed = append(failed, srv.ID)
+			continue
+		}
+
+		terms[srv.ID] = peers.Term
+	}
+
+	// The removed nodes are told once on a best-effort basis, they are not retried since they no
+	// longer host the database
+	for id := range terms {
+		if !hosts(peers, id) {
+			if cerr := s.call(id, req); cerr != nil {
+				log.Warnf("failed to push peers of database %s to removed node %s: %v",
+					databaseID, id, cerr)
+			}
+
+			delete(terms, id)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to push peers to nodes: %v", failed)
+	}
+
+	return
+}
+
+// Update syncs the databases placed or re-placed by the txs of the block, it should be called
+// after the block is pushed to the main chain.
+func (s *Service) Update(block *blockproducer.Block) (err error) {
+	var ids []string
+	seen := make(map[string]bool)
+
+	for _, tx := range block.Tx {
+		id, ok := placedDatabase(&tx.TxData)
+
+		if !ok || seen[id] {
+			continue
+		}
+
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		if serr := s.Sync(id); serr != nil && err == nil {
+			err = serr
+		}
+	}
+
+	return
+}
+
+// placedDatabase returns the database whose replicas are changed by the tx.
+func placedDatabase(data *blockproducer.TxData) (id string, ok bool) {
+	payload, err := data.DecodePayload()
+
+	if err != nil {
+		return
+	}
+
+	switch p := payload.(type) {
+	case *types.CreateDatabasePayload:
+		return blockproducer.DatabaseIDFromTx(data.Sender(), data.AccountNonce), true
+	case *types.UpdateReplicaCountPayload:
+		return p.DatabaseID, true
+	case *types.ReplaceReplicaPayload:
+		return p.DatabaseID, true
+	}
+
+	return
+}
+
+func (s *Service) call(nodeID proto.NodeID, req *UpdatePeersReq) error {
+	return s.cfg.Caller.CallNode(nodeID, PeersRPCServiceName+".UpdatePeers", req,
+		&UpdatePeersResp{})
+}
+
+func hosts(peers *kayak.Peers, nodeID proto.NodeID) bool {
+	for _, srv := range peers.Servers {
+		if srv.ID == nodeID {
+			return true
+		}
+	}
+
+	return false
+}
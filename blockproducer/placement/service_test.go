@@ -0,0 +1,373 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package placement
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/thunderdb/ThunderDB/blockproducer"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/pow/cpuminer"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/types"
+)
+
+type testState struct {
+	databases map[string]*blockproducer.Database
+}
+
+func (s *testState) GetDatabase(id string) (*blockproducer.Database, error) {
+	if d, ok := s.databases[id]; ok {
+		return d, nil
+	}
+
+	return nil, blockproducer.ErrDatabaseNotFound
+}
+
+// testCaller delivers the calls to the PeersRPCService of each miner, the peers accepted by the
+// miners are recorded by database.
+type testCaller struct {
+	producer *asymmetric.PublicKey
+	down     map[proto2.NodeID]bool
+	received map[proto2.NodeID]map[string]*kayak.Peers
+	services map[proto2.NodeID]*PeersRPCService
+}
+
+func (c *testCaller) CallNode(nodeID proto2.NodeID, method string, args,
+	reply interface{}) error {
+	if c.down[nodeID] {
+		return ErrInvalidPeers
+	}
+
+	service := c.services[nodeID]
+
+	if service == nil {
+		service = NewPeersRPCService(c.producer, func(id string, peers *kayak.Peers) error {
+			if c.received[nodeID] == nil {
+				c.received[nodeID] = make(map[string]*kayak.Peers)
+			}
+
+			c.received[nodeID][id] = peers
+			return nil
+		})
+		c.services[nodeID] = service
+	}
+
+	if method != PeersRPCServiceName+".UpdatePeers" {
+		return ErrInvalidPeers
+	}
+
+	return service.UpdatePeers(args.(*UpdatePeersReq), reply.(*UpdatePeersResp))
+}
+
+func createTestNodes(t *testing.T, n int) (nodes []proto2.NodeID, storePath string) {
+	kms.Unittest = true
+	fl, err := ioutil.TempFile("", "placement")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	storePath = fl.Name()
+
+	if err = kms.InitPublicKeyStore(storePath, nil); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		_, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		nonce := cpuminer.Uint256{A: uint64(i)}
+		node := &proto2.Node{
+			ID:        proto2.NodeID(cpuminer.HashBlock(pub.Serialize(), nonce).String()),
+			PublicKey: pub,
+			Nonce:     nonce,
+		}
+
+		if err = kms.SetNode(node); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		nodes = append(nodes, node.ID)
+	}
+
+	return
+}
+
+func createTestTx(t *testing.T, signer *asymmetric.PrivateKey, nonce uint64,
+	typ types.BPTxType, payload proto.Message) *blockproducer.Tx {
+	tx := &blockproducer.Tx{
+		TxData: blockproducer.TxData{
+			AccountNonce: nonce,
+			Amount:       new(big.Int),
+		},
+	}
+
+	if err := tx.TxData.SetPayload(typ, payload); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err := tx.Sign(signer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return tx
+}
+
+func checkPeers(t *testing.T, s *Service, c *testCaller, state *testState, id string) {
+	database := state.databases[id]
+	peers, err := s.Peers(id)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if peers.Term != database.Term || len(peers.Servers) != len(database.Replicas) ||
+		peers.Leader != peers.Servers[0] || !peers.Verify() {
+		t.Fatalf("Unexpected peers: %v", peers)
+	}
+
+	for i, srv := range peers.Servers {
+		if srv.ID != database.Replicas[i] || srv.PubKey == nil {
+			t.Fatalf("Unexpected server: %v", srv)
+		}
+
+		if (i == 0) != (srv.Role == kayak.Leader) {
+			t.Fatalf("Unexpected role: %v", srv)
+		}
+
+		if c.down[srv.ID] {
+			continue
+		}
+
+		if received := c.received[srv.ID][id]; received == nil ||
+			received.Term != database.Term {
+			t.Fatalf("Peers not received by %s: %v", srv.ID, received)
+		}
+	}
+}
+
+func TestService(t *testing.T) {
+	nodes, storePath := createTestNodes(t, 5)
+	defer os.Remove(storePath)
+
+	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	alice, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	db1 := blockproducer.DatabaseIDFromTx(
+		blockproducer.AccountAddressFromPublicKey(alice.PubKey()), 0)
+	state := &testState{
+		databases: map[string]*blockproducer.Database{
+			db1:   {ID: db1, Replicas: nodes[:3], Term: 1},
+			"db2": {ID: "db2", Replicas: nodes[3:4], Term: 1},
+			"db3": {ID: "db3"},
+			"db4": {ID: "db4", Replicas: []proto2.NodeID{"unknown"}, Term: 1},
+		},
+	}
+	caller := &testCaller{
+		producer: pub,
+		down:     make(map[proto2.NodeID]bool),
+		received: make(map[proto2.NodeID]map[string]*kayak.Peers),
+		services: make(map[proto2.NodeID]*PeersRPCService),
+	}
+
+	if _, err = NewService(&Config{State: state}); err != ErrInvalidConfig {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	s, err := NewService(&Config{State: state, Signer: priv, Caller: caller})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = s.Peers("db0"); err != blockproducer.ErrDatabaseNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = s.Peers("db3"); err != ErrDatabaseNotPlaced {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = s.Peers("db4"); err == nil {
+		t.Fatal("Unexpected result: returned error is nil")
+	}
+
+	// The databases created or updated in the block are pushed, the others are ignored
+	err = s.Update(&blockproducer.Block{Tx: []*blockproducer.Tx{
+		createTestTx(t, alice, 0, types.BPTxType_CREATE_DATABASE,
+			&types.CreateDatabasePayload{ReplicaCount: 3}),
+		createTestTx(t, alice, 1, types.BPTxType_UPDATE_REPLICA_COUNT,
+			&types.UpdateReplicaCountPayload{DatabaseID: "db2", ReplicaCount: 1}),
+		createTestTx(t, alice, 2, types.BPTxType_DEPOSIT,
+			&types.DepositPayload{DatabaseID: "db3"}),
+	}})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkPeers(t, s, caller, state, db1)
+	checkPeers(t, s, caller, state, "db2")
+
+	// A pushed term is not pushed again
+	caller.received = make(map[proto2.NodeID]map[string]*kayak.Peers)
+
+	if err = s.Sync(db1); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(caller.received) != 0 {
+		t.Fatalf("Unexpected pushes: %v", caller.received)
+	}
+
+	// The failed leader is replaced on the main chain, the term is kept pending while the new
+	// miner is down
+	state.databases[db1] = &blockproducer.Database{
+		ID: db1, Replicas: []proto2.NodeID{nodes[1], nodes[2], nodes[4]}, Term: 2,
+	}
+	caller.down[nodes[4]] = true
+	replace := &blockproducer.Block{Tx: []*blockproducer.Tx{
+		createTestTx(t, alice, 3, types.BPTxType_REPLACE_REPLICA,
+			&types.ReplaceReplicaPayload{
+				DatabaseID: db1, Miner: &types.NodeID{NodeID: string(nodes[0])},
+			}),
+	}}
+
+	if err = s.Update(replace); err == nil {
+		t.Fatal("Unexpected result: returned error is nil")
+	}
+
+	checkPeers(t, s, caller, state, db1)
+
+	if received := caller.received[nodes[0]][db1]; received == nil || received.Term != 2 {
+		t.Fatalf("Peers not received by the removed node: %v", received)
+	}
+
+	caller.down[nodes[4]] = false
+
+	// Only the miner which missed the term gets it again
+	caller.received = make(map[proto2.NodeID]map[string]*kayak.Peers)
+
+	if err = s.Update(replace); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(caller.received) != 1 || caller.received[nodes[4]][db1].Term != 2 {
+		t.Fatalf("Unexpected pushes: %v", caller.received)
+	}
+}
+
+func TestPeersRPCService(t *testing.T) {
+	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	other, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	var applied *kayak.Peers
+	service := NewPeersRPCService(pub, func(id string, peers *kayak.Peers) error {
+		applied = peers
+		return nil
+	})
+
+	for _, c := range []struct {
+		signer    *asymmetric.PrivateKey
+		reqSigner *asymmetric.PrivateKey
+		term      uint64
+		tamper    func(req *UpdatePeersReq)
+		err       error
+	}{
+		{other, priv, 1, nil, ErrInvalidPeers},
+		{priv, other, 1, nil, ErrInvalidPeers},
+		{priv, nil, 1, nil, ErrInvalidPeers},
+		{priv, priv, 1, func(req *UpdatePeersReq) { req.DatabaseID = "other" },
+			ErrInvalidPeers},
+		{priv, priv, 2, nil, nil},
+		{priv, priv, 2, nil, ErrStaleTerm},
+		{priv, priv, 1, nil, ErrStaleTerm},
+	} {
+		leader := &kayak.Server{Role: kayak.Leader, ID: "leader", PubKey: pub}
+		peers := &kayak.Peers{
+			Term:    c.term,
+			Leader:  leader,
+			Servers: []*kayak.Server{{Role: kayak.Follower, ID: "follower"}, leader},
+			PubKey:  c.signer.PubKey(),
+		}
+
+		if err = peers.Sign(c.signer); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		buffer, err := marshalPeers(peers)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		req := &UpdatePeersReq{DatabaseID: "db", Peers: buffer}
+
+		if c.reqSigner != nil {
+			if err = req.Sign(c.reqSigner); err != nil {
+				t.Fatalf("Error occurred: %v", err)
+			}
+		}
+
+		if c.tamper != nil {
+			c.tamper(req)
+		}
+
+		if err = service.UpdatePeers(req, &UpdatePeersResp{}); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if applied == nil || applied.Term != 2 || applied.Leader != applied.Servers[1] ||
+		applied.Servers[0].PubKey != nil || !applied.Verify() {
+		t.Fatalf("Unexpected peers: %v", applied)
+	}
+
+	if _, err = unmarshalPeers([]byte{0}); err == nil {
+		t.Fatal("Unexpected result: returned error is nil")
+	}
+}
@@ -0,0 +1,465 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+)
+
+const (
+	// MaxSyncBlocks is the maximum number of blocks fetched from a block producer in a sync.
+	MaxSyncBlocks = 100
+)
+
+// ProducerConfig represents a block producer config.
+type ProducerConfig struct {
+	// Chain is the main chain which the produced blocks are pushed to.
+	Chain *Chain
+
+	// Pool is the tx pool which the txs of the produced blocks are taken from.
+	Pool *TxPool
+
+	// Period is the block producing period.
+	Period time.Duration
+
+	// MaxTxs is the maximum number of txs packed into a block, all the pending txs are packed if
+	// it's not positive.
+	MaxTxs int
+
+	// PrivateKey signs the produced blocks and the votes, the local private key is used if it's
+	// nil.
+	PrivateKey *asymmetric.PrivateKey
+
+	// Caller calls the other validators of the chain to broadcast the blocks and votes and to
+	// fetch the missing ones, the rpc package is used if it's nil.
+	Caller Caller
+}
+
+// Producer periodically packs the pending txs of the tx pool into main chain blocks. If the chain
+// has a validator set, the blocks are only produced in the turns of the local validator, and the
+// producer votes on the head of the chain, broadcasts the blocks and votes to the other
+// validators, and syncs the missing ones from them.
+type Producer struct {
+	cfg    *ProducerConfig
+	caller Caller
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+
+	// produceLock serializes the block producing, syncing and voting, and protects the last voted
+	// height
+	produceLock sync.Mutex
+	voted       int32
+}
+
+// NewProducer creates a new block producer.
+func NewProducer(cfg *ProducerConfig) (p *Producer, err error) {
+	if cfg == nil || cfg.Chain == nil || cfg.Pool == nil || cfg.Period <= 0 {
+		return nil, ErrInvalidProducerConfig
+	}
+
+	caller := cfg.Caller
+
+	if caller == nil {
+		caller = &RPCCaller{}
+	}
+
+	return &Producer{
+		cfg:    cfg,
+		caller: caller,
+	}, nil
+}
+
+// Start starts the producing loop in a new goroutine.
+func (p *Producer) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopCh != nil {
+		return ErrProducerStarted
+	}
+
+	p.stopCh = make(chan struct{})
+	p.doneCh = make(chan struct{})
+	go p.run(p.stopCh, p.doneCh)
+	return nil
+}
+
+// Stop stops the producing loop and waits for it to exit.
+func (p *Producer) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopCh == nil {
+		return
+	}
+
+	close(p.stopCh)
+	<-p.doneCh
+	p.stopCh = nil
+	p.doneCh = nil
+}
+
+func (p *Producer) run(stopCh <-chan struct{}, doneCh chan<- struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(p.cfg.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if p.cfg.Chain.cfg.Validators != nil {
+				p.Sync()
+
+				if err := p.Vote(); err != nil {
+					log.Errorf("failed to vote on main chain head: %v", err)
+				}
+			}
+
+			if _, err := p.ProduceBlock(); err != nil && err != ErrNotInTurn {
+				log.Errorf("failed to produce main chain block: %v", err)
+			}
+		}
+	}
+}
+
+// ProduceBlock packs the pending txs of the tx pool into a new block extending the chain head,
+// signs it with the private key and pushes it to the chain. If the chain has a validator set, the
+// block is only produced in the turn of the local validator, then it's broadcast to the other
+// validators and voted by the local validator.
+func (p *Producer) ProduceBlock() (block *Block, err error) {
+	p.produceLock.Lock()
+	defer p.produceLock.Unlock()
+
+	priv, err := p.privateKey()
+
+	if err != nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	vs := p.cfg.Chain.cfg.Validators
+	var local *Validator
+
+	if vs != nil {
+		head := p.cfg.Chain.Head()
+		local = vs.lookup(priv.PubKey())
+
+		if local == nil || vs.Producer(head.Height+1, vs.Round(head.node.timestamp, now)) != local {
+			return nil, ErrNotInTurn
+		}
+	}
+
+	if block, err = p.newBlock(priv, p.cfg.Pool.PendingTxs(p.cfg.MaxTxs), now); err != nil {
+		return
+	}
+
+	if err = p.PushBlock(block); err != nil {
+		return nil, err
+	}
+
+	if local == nil {
+		return
+	}
+
+	buffer, err := block.marshal()
+
+	if err != nil {
+		return
+	}
+
+	p.broadcast(local, ".AdviseNewBlock", &AdviseNewBlockReq{Block: buffer},
+		func() interface{} { return &AdviseNewBlockResp{} })
+	return block, p.vote(priv, local)
+}
+
+// PushBlock pushes the block to the chain and removes its txs from the tx pool, together with
+// the pending txs invalidated by the block. The tx pool is not changed if the block is kept in a
+// side branch.
+func (p *Producer) PushBlock(block *Block) (err error) {
+	return pushBlock(p.cfg.Chain, p.cfg.Pool, block)
+}
+
+// pushBlock pushes the block to the chain, and updates the tx pool if the block becomes the head.
+func pushBlock(chain *Chain, pool *TxPool, block *Block) (err error) {
+	if err = chain.PushBlock(block); err != nil {
+		return
+	}
+
+	if head := chain.Head(); pool == nil || !head.Head.IsEqual(&block.Header.BlockHash) {
+		return
+	}
+
+	return pool.Update(block)
+}
+
+// Vote signs a vote on the head block of the chain if the local node is a validator, adds it to
+// the chain and broadcasts it to the other validators. The local validator votes once on each
+// height.
+func (p *Producer) Vote() (err error) {
+	p.produceLock.Lock()
+	defer p.produceLock.Unlock()
+	vs := p.cfg.Chain.cfg.Validators
+
+	if vs == nil {
+		return ErrInvalidValidatorSet
+	}
+
+	priv, err := p.privateKey()
+
+	if err != nil {
+		return
+	}
+
+	if local := vs.lookup(priv.PubKey()); local != nil {
+		return p.vote(priv, local)
+	}
+
+	return
+}
+
+// vote votes on the head block by the local validator, the caller must hold the produce lock.
+func (p *Producer) vote(priv *asymmetric.PrivateKey, local *Validator) (err error) {
+	head := p.cfg.Chain.Head()
+
+	if head.Height <= p.voted || head.Height <= head.Finalized {
+		return
+	}
+
+	vote := &Vote{
+		Height:    head.Height,
+		BlockHash: head.Head,
+	}
+
+	if err = vote.Sign(priv); err != nil {
+		return
+	}
+
+	// The local validator has voted on another block of the height before a reorganization
+	if _, err = p.cfg.Chain.AddVote(vote); err == ErrDoubleVote {
+		p.voted = head.Height
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	p.voted = head.Height
+	buffer, err := vote.marshal()
+
+	if err != nil {
+		return
+	}
+
+	p.broadcast(local, ".SubmitVote", &SubmitVoteReq{Vote: buffer},
+		func() interface{} { return &SubmitVoteResp{} })
+	return
+}
+
+// broadcast calls the method of the main chain RPC service on the other validators, the failures
+// are logged and skipped.
+func (p *Producer) broadcast(local *Validator, method string, args interface{},
+	newReply func() interface{}) {
+	for _, v := range p.cfg.Chain.cfg.Validators.validators {
+		if v == local {
+			continue
+		}
+
+		if err := p.caller.CallNode(v.NodeID, ChainRPCServiceName+method, args,
+			newReply()); err != nil {
+			log.Warnf("failed to call %s on block producer %s: %v", method, v.NodeID, err)
+		}
+	}
+}
+
+// Sync fetches the blocks extending the local head, the finalized votes and the votes on the
+// head from the other validators. The blocks of a branch forking from the local chain are fetched
+// back to the fork point, the chain is reorganized if the branch is longer.
+func (p *Producer) Sync() {
+	p.produceLock.Lock()
+	defer p.produceLock.Unlock()
+	vs := p.cfg.Chain.cfg.Validators
+
+	if vs == nil {
+		return
+	}
+
+	priv, err := p.privateKey()
+
+	if err != nil {
+		log.Errorf("failed to sync main chain: %v", err)
+		return
+	}
+
+	local := vs.lookup(priv.PubKey())
+
+	for _, v := range vs.validators {
+		if v == local {
+			continue
+		}
+
+		if err = p.syncFrom(v.NodeID); err != nil {
+			log.Warnf("failed to sync main chain from block producer %s: %v", v.NodeID, err)
+		}
+	}
+}
+
+// syncFrom syncs the blocks and votes from the block producer, the caller must hold the produce
+// lock.
+func (p *Producer) syncFrom(id proto2.NodeID) (err error) {
+	for i := 0; i < MaxSyncBlocks; i++ {
+		resp := &FetchBlockResp{}
+		req := &FetchBlockReq{
+			Height:   p.cfg.Chain.Head().Height + 1,
+			ByHeight: true,
+		}
+
+		// The remote chain isn't longer than the local chain
+		if p.caller.CallNode(id, ChainRPCServiceName+".FetchBlock", req, resp) != nil {
+			break
+		}
+
+		block := &Block{}
+
+		if err = block.unmarshal(resp.Block); err != nil {
+			return
+		}
+
+		if err = p.pushFetched(id, block, MaxSyncBlocks); err != nil {
+			return
+		}
+	}
+
+	finalized := &QueryFinalizedResp{}
+
+	if err = p.caller.CallNode(id, ChainRPCServiceName+".QueryFinalized",
+		&QueryFinalizedReq{}, finalized); err != nil {
+		return
+	}
+
+	if head := p.cfg.Chain.Head(); finalized.Height > head.Finalized {
+		var votes []*Vote
+
+		if votes, err = unmarshalVoteList(finalized.Votes); err != nil {
+			return
+		}
+
+		if err = p.cfg.Chain.Finalize(finalized.Height, finalized.BlockHash,
+			votes); err != nil {
+			return
+		}
+	}
+
+	head := p.cfg.Chain.Head()
+	votes := &FetchVotesResp{}
+
+	if err = p.caller.CallNode(id, ChainRPCServiceName+".FetchVotes",
+		&FetchVotesReq{BlockHash: head.Head}, votes); err != nil {
+		return
+	}
+
+	list, err := unmarshalVoteList(votes.Votes)
+
+	if err != nil {
+		return
+	}
+
+	for _, v := range list {
+		if _, err = p.cfg.Chain.AddVote(v); err != nil {
+			log.Warnf("failed to add vote synced from block producer %s: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+// pushFetched pushes the block fetched from the block producer, the missing ancestors of the block
+// are fetched by hash up to depth blocks.
+func (p *Producer) pushFetched(id proto2.NodeID, block *Block, depth int) (err error) {
+	if err = p.PushBlock(block); err != ErrParentNotFound || depth <= 0 {
+		return
+	}
+
+	resp := &FetchBlockResp{}
+
+	if err = p.caller.CallNode(id, ChainRPCServiceName+".FetchBlock",
+		&FetchBlockReq{Hash: block.Header.Parent}, resp); err != nil {
+		return
+	}
+
+	parent := &Block{}
+
+	if err = parent.unmarshal(resp.Block); err != nil {
+		return
+	}
+
+	if err = p.pushFetched(id, parent, depth-1); err != nil && err != ErrBlockExists {
+		return
+	}
+
+	return p.PushBlock(block)
+}
+
+// privateKey returns the key signing the blocks and votes.
+func (p *Producer) privateKey() (*asymmetric.PrivateKey, error) {
+	if p.cfg.PrivateKey != nil {
+		return p.cfg.PrivateKey, nil
+	}
+
+	return kms.GetLocalPrivateKey()
+}
+
+// newBlock builds a new block of the txs extending the chain head, commits the state root after
+// applying the txs and signs it with the private key.
+func (p *Producer) newBlock(priv *asymmetric.PrivateKey, txs []*Tx, timestamp time.Time) (
+	block *Block, err error) {
+	// The block is rejected by the chain if another block is pushed meanwhile
+	parent := p.cfg.Chain.Head().Head
+	producer := AccountAddressFromPublicKey(priv.PubKey())
+	root, err := p.cfg.Chain.ComputeStateRoot(producer, txs)
+
+	if err != nil {
+		return
+	}
+
+	block = &Block{
+		Header: &SignedHeader{
+			Header: Header{
+				Version:   p.cfg.Chain.cfg.Genesis.Header.Version,
+				Producer:  producer,
+				Root:      root,
+				Parent:    parent,
+				Timestamp: timestamp,
+			},
+		},
+		Tx: txs,
+	}
+
+	if err = block.SignHeader(priv); err != nil {
+		return nil, err
+	}
+
+	return
+}
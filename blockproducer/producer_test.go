@@ -0,0 +1,341 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+)
+
+func TestProducer(t *testing.T) {
+	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	kms.InitLocalKeyStore()
+	kms.SetLocalKeyPair(priv, pub)
+
+	alice, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	bob, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain, _ := createTestChain(t, []*Account{
+		{Address: AccountAddressFromPublicKey(alice.PubKey()), Balance: big.NewInt(100)},
+		{Address: AccountAddressFromPublicKey(bob.PubKey()), Balance: big.NewInt(100)},
+	})
+	defer chain.Stop()
+	pool, err := NewTxPool(&TxPoolConfig{State: chain})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = NewProducer(&ProducerConfig{Chain: chain, Pool: pool}); err !=
+		ErrInvalidProducerConfig {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	producer, err := NewProducer(&ProducerConfig{
+		Chain:  chain,
+		Pool:   pool,
+		Period: 100 * time.Millisecond,
+		MaxTxs: 2,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// The txs are packed by fee, the txs of the same account are kept in nonce order
+	txs := []*Tx{
+		createTestTx(t, alice, 0, 10, 1),
+		createTestTx(t, alice, 1, 10, 5),
+		createTestTx(t, bob, 0, 10, 3),
+	}
+
+	for _, tx := range txs {
+		if err = pool.AddTx(tx); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	block, err := producer.ProduceBlock()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(block.Tx) != 2 || block.Tx[0] != txs[2] || block.Tx[1] != txs[0] {
+		t.Fatalf("Unexpected txs: %v", block.Tx)
+	}
+
+	if head := chain.Head(); !head.Head.IsEqual(&block.Header.BlockHash) || head.Height != 1 {
+		t.Fatalf("Unexpected chain head: %v", head)
+	}
+
+	producerAddr := AccountAddressFromPublicKey(pub)
+
+	if block.Header.Producer != producerAddr {
+		t.Fatalf("Unexpected producer: %s", block.Header.Producer)
+	}
+
+	checkAccount(t, chain, producerAddr, 4, 0)
+
+	// The packed txs are removed from the pool, the rest tx is still valid on the new head
+	if pool.Size() != 1 || pool.GetTx(&txs[1].TxHash) == nil {
+		t.Fatalf("Unexpected tx pool size: %d", pool.Size())
+	}
+
+	// A block kept in a side branch leaves the pool untouched
+	genesis, err := chain.GetBlockByHeight(0)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	other, err := createTestBlock(priv, genesis.Header.BlockHash, block.Header.Root,
+		[]*Tx{txs[1]})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = producer.PushBlock(other); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if head := chain.Head(); !head.Head.IsEqual(&block.Header.BlockHash) {
+		t.Fatalf("Unexpected chain head: %v", head)
+	}
+
+	if pool.Size() != 1 {
+		t.Fatalf("Unexpected tx pool size: %d", pool.Size())
+	}
+
+	// Produce blocks periodically
+	if err = producer.Start(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = producer.Start(); err != ErrProducerStarted {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(350 * time.Millisecond)
+	producer.Stop()
+	producer.Stop()
+
+	if head := chain.Head(); head.Height < 2 {
+		t.Fatalf("Unexpected chain height: %d", head.Height)
+	}
+
+	if pool.Size() != 0 {
+		t.Fatalf("Unexpected tx pool size: %d", pool.Size())
+	}
+}
+
+// testValidatorCaller routes the calls between the main chain RPC services of the validators in
+// the same process.
+type testValidatorCaller struct {
+	services map[proto2.NodeID]*ChainRPCService
+	down     map[proto2.NodeID]bool
+}
+
+func (c *testValidatorCaller) CallNode(nodeID proto2.NodeID, method string, args,
+	reply interface{}) error {
+	service, ok := c.services[nodeID]
+
+	if !ok || c.down[nodeID] {
+		return fmt.Errorf("node %s is unreachable", nodeID)
+	}
+
+	name := strings.TrimPrefix(method, ChainRPCServiceName+".")
+	out := reflect.ValueOf(service).MethodByName(name).Call([]reflect.Value{
+		reflect.ValueOf(args), reflect.ValueOf(reply)})
+
+	if err, _ := out[0].Interface().(error); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func checkFinalized(t *testing.T, chains []*Chain, height int32, finalized int32) {
+	for i, chain := range chains {
+		if head := chain.Head(); head.Height != height || head.Finalized != finalized {
+			t.Fatalf("Unexpected chain state of validator %d: %v", i, head)
+		}
+	}
+}
+
+func TestProducerValidators(t *testing.T) {
+	keys, vs := createTestValidators(t, 4, 0)
+	caller := &testValidatorCaller{
+		services: make(map[proto2.NodeID]*ChainRPCService),
+		down:     make(map[proto2.NodeID]bool),
+	}
+
+	chains := make([]*Chain, len(keys))
+	producers := make([]*Producer, len(keys))
+	var genesis *Block
+
+	for i, v := range vs.Validators() {
+		var chain *Chain
+		var cfg *Config
+
+		if genesis == nil {
+			chain, cfg = createTestChain(t, nil)
+			genesis = cfg.Genesis
+		} else {
+			fl, err := ioutil.TempFile("", "mainchain")
+
+			if err != nil {
+				t.Fatalf("Error occurred: %v", err)
+			}
+
+			fl.Close()
+			cfg = &Config{DataDir: fl.Name(), Genesis: genesis}
+
+			if chain, err = NewChain(cfg); err != nil {
+				t.Fatalf("Error occurred: %v", err)
+			}
+		}
+
+		defer chain.Stop()
+		cfg.Validators = vs
+		pool, err := NewTxPool(&TxPoolConfig{State: chain})
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if producers[i], err = NewProducer(&ProducerConfig{
+			Chain:      chain,
+			Pool:       pool,
+			Period:     time.Hour,
+			PrivateKey: keys[i],
+			Caller:     caller,
+		}); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		chains[i] = chain
+		caller.services[v.NodeID] = NewChainRPCService(chain, pool)
+	}
+
+	// Only the validator in turn produces the block, which is broadcast and voted by all
+	if _, err := producers[0].ProduceBlock(); err != ErrNotInTurn {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := producers[1].ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkFinalized(t, chains, 1, 0)
+
+	for _, p := range []*Producer{producers[0], producers[0], producers[2]} {
+		if err := p.Vote(); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	checkFinalized(t, chains, 1, 1)
+
+	// The validator missing the block syncs it and the votes on it
+	caller.down["bp3"] = true
+
+	if _, err := producers[2].ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkFinalized(t, chains[:3], 2, 1)
+	checkFinalized(t, chains[3:], 1, 1)
+	caller.down["bp3"] = false
+	producers[3].Sync()
+	checkFinalized(t, chains, 2, 1)
+
+	if votes := chains[3].Votes(chains[3].Head().Head); len(votes) != 1 {
+		t.Fatalf("Unexpected votes: %v", votes)
+	}
+
+	for _, p := range []*Producer{producers[3], producers[0]} {
+		if err := p.Vote(); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	checkFinalized(t, chains, 2, 2)
+
+	// The validator missing the block and its votes syncs the finalized height
+	caller.down["bp1"] = true
+
+	if _, err := producers[3].ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	for _, p := range []*Producer{producers[0], producers[2]} {
+		if err := p.Vote(); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	checkFinalized(t, []*Chain{chains[0], chains[2], chains[3]}, 3, 3)
+	caller.down["bp1"] = false
+	producers[1].Sync()
+	checkFinalized(t, chains, 3, 3)
+
+	// The producing loops take the turns
+	for _, p := range producers {
+		p.cfg.Period = 50 * time.Millisecond
+
+		if err := p.Start(); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	time.Sleep(time.Second)
+
+	for _, p := range producers {
+		p.Stop()
+	}
+
+	for i, chain := range chains {
+		if head := chain.Head(); head.Height < 5 || head.Finalized < 4 {
+			t.Fatalf("Unexpected chain state of validator %d: %v", i, head)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/pow/cpuminer"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/types"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+// ReplaceReplicaHash returns the hash of the replica failure report signed by the other replicas
+// of the database, it covers the failed miner and the database term, so that a report can't be
+// replayed after the replicas change.
+func ReplaceReplicaHash(p *types.ReplaceReplicaPayload) (h hash.Hash, err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = utils.WriteElements(buffer, binary.BigEndian,
+		p.DatabaseID,
+		p.GetMiner().GetNodeID(),
+		p.Term,
+	); err != nil {
+		return
+	}
+
+	return hash.THashH(buffer.Bytes()), nil
+}
+
+// SignReplaceReplica signs the replica failure report with the private key of another replica
+// and appends the signature to the payload, nonce is the nonce of the replica node id.
+func SignReplaceReplica(p *types.ReplaceReplicaPayload, signer *asymmetric.PrivateKey,
+	nonce cpuminer.Uint256) (err error) {
+	h, err := ReplaceReplicaHash(p)
+
+	if err != nil {
+		return
+	}
+
+	s, err := signReplica(h, signer, nonce)
+
+	if err != nil {
+		return
+	}
+
+	p.Signatures = append(p.Signatures, s)
+	return
+}
+
+// VerifyReplaceReplicaSignature verifies a signature of the replica failure report, and returns
+// the node id of the signer which is mined from its public key and nonce.
+func VerifyReplaceReplicaSignature(p *types.ReplaceReplicaPayload, s *types.BillingSignature) (
+	id proto2.NodeID, err error) {
+	h, err := ReplaceReplicaHash(p)
+
+	if err != nil {
+		return
+	}
+
+	return verifyReplicaSignature(h, s)
+}
+
+// replaceReplicaSigners verifies the signatures of the replica failure report, and returns the
+// node ids of the signers.
+func replaceReplicaSigners(p *types.ReplaceReplicaPayload) (ids []proto2.NodeID, err error) {
+	for _, s := range p.Signatures {
+		var id proto2.NodeID
+
+		if id, err = VerifyReplaceReplicaSignature(p, s); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return
+}
@@ -0,0 +1,310 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/rpc"
+)
+
+const (
+	// ChainRPCServiceName is the name of the main chain RPC service.
+	ChainRPCServiceName = "MCC"
+)
+
+// SubmitTxReq defines a request of the SubmitTx RPC method.
+type SubmitTxReq struct {
+	Tx []byte
+}
+
+// SubmitTxResp defines a response of the SubmitTx RPC method.
+type SubmitTxResp struct {
+	TxHash hash.Hash
+}
+
+// SubmitVoteReq defines a request of the SubmitVote RPC method.
+type SubmitVoteReq struct {
+	Vote []byte
+}
+
+// SubmitVoteResp defines a response of the SubmitVote RPC method.
+type SubmitVoteResp struct {
+	Finalized int32
+}
+
+// QueryAccountReq defines a request of the QueryAccount RPC method.
+type QueryAccountReq struct {
+	Address proto2.AccountAddress
+}
+
+// QueryAccountResp defines a response of the QueryAccount RPC method, the balance is encoded as
+// a big-endian unsigned integer.
+type QueryAccountResp struct {
+	Balance  []byte
+	Nonce    uint64
+	Metadata map[string]string
+	Head     hash.Hash
+	Height   int32
+}
+
+// QueryDatabaseReq defines a request of the QueryDatabase RPC method.
+type QueryDatabaseReq struct {
+	DatabaseID string
+}
+
+// QueryDatabaseResp defines a response of the QueryDatabase RPC method, the balance is encoded as
+// a big-endian unsigned integer.
+type QueryDatabaseResp struct {
+	Owner        proto2.AccountAddress
+	Balance      []byte
+	BilledHeight int32
+	Replicas     []proto2.NodeID
+	Term         uint64
+	Head         hash.Hash
+	Height       int32
+}
+
+// QueryFinalizedReq defines a request of the QueryFinalized RPC method.
+type QueryFinalizedReq struct{}
+
+// QueryFinalizedResp defines a response of the QueryFinalized RPC method, which includes the votes
+// finalizing the block of the finalized height.
+type QueryFinalizedResp struct {
+	Height    int32
+	BlockHash hash.Hash
+	Votes     [][]byte
+}
+
+// AdviseNewBlockReq defines a request of the AdviseNewBlock RPC method.
+type AdviseNewBlockReq struct {
+	Block []byte
+}
+
+// AdviseNewBlockResp defines a response of the AdviseNewBlock RPC method.
+type AdviseNewBlockResp struct{}
+
+// FetchBlockReq defines a request of the FetchBlock RPC method, the block is fetched by height on
+// the best chain if ByHeight is set.
+type FetchBlockReq struct {
+	Hash     hash.Hash
+	Height   int32
+	ByHeight bool
+}
+
+// FetchBlockResp defines a response of the FetchBlock RPC method.
+type FetchBlockResp struct {
+	Block []byte
+}
+
+// FetchVotesReq defines a request of the FetchVotes RPC method.
+type FetchVotesReq struct {
+	BlockHash hash.Hash
+}
+
+// FetchVotesResp defines a response of the FetchVotes RPC method.
+type FetchVotesResp struct {
+	Votes [][]byte
+}
+
+// Caller calls the RPC methods of a remote node.
+type Caller interface {
+	CallNode(nodeID proto2.NodeID, method string, args, reply interface{}) error
+}
+
+// RPCCaller is a Caller implementation which dials the remote nodes with the rpc package.
+type RPCCaller struct{}
+
+// CallNode implements Caller.CallNode.
+func (c *RPCCaller) CallNode(nodeID proto2.NodeID, method string, args, reply interface{}) (
+	err error) {
+	conn, err := rpc.DailToNode(nodeID)
+
+	if err != nil {
+		return
+	}
+
+	client, err := rpc.InitClientConn(conn)
+
+	if err != nil {
+		return
+	}
+
+	defer client.Close()
+	return client.Call(method, args, reply)
+}
+
+// ChainRPCService is the server side RPC implementation of the main chain hosted by a block
+// producer.
+type ChainRPCService struct {
+	chain *Chain
+	pool  *TxPool
+}
+
+// NewChainRPCService returns a new ChainRPCService.
+func NewChainRPCService(chain *Chain, pool *TxPool) *ChainRPCService {
+	return &ChainRPCService{
+		chain: chain,
+		pool:  pool,
+	}
+}
+
+// SubmitTx RPC validates the tx and adds it to the tx pool waiting for inclusion.
+func (s *ChainRPCService) SubmitTx(req *SubmitTxReq, resp *SubmitTxResp) (err error) {
+	tx := &Tx{}
+
+	if err = tx.unmarshal(req.Tx); err != nil {
+		return
+	}
+
+	if err = s.pool.AddTx(tx); err != nil {
+		return
+	}
+
+	resp.TxHash = tx.TxHash
+	return
+}
+
+// SubmitVote RPC adds the vote of a validator on a block of the chain.
+func (s *ChainRPCService) SubmitVote(req *SubmitVoteReq, resp *SubmitVoteResp) (err error) {
+	vote := &Vote{}
+
+	if err = vote.unmarshal(req.Vote); err != nil {
+		return
+	}
+
+	resp.Finalized, err = s.chain.AddVote(vote)
+	return
+}
+
+// AdviseNewBlock RPC pushes the block produced by a validator to the chain.
+func (s *ChainRPCService) AdviseNewBlock(req *AdviseNewBlockReq, resp *AdviseNewBlockResp) (
+	err error) {
+	block := &Block{}
+
+	if err = block.unmarshal(req.Block); err != nil {
+		return
+	}
+
+	return pushBlock(s.chain, s.pool, block)
+}
+
+// FetchBlock RPC returns a block of the chain.
+func (s *ChainRPCService) FetchBlock(req *FetchBlockReq, resp *FetchBlockResp) (err error) {
+	var block *Block
+
+	if req.ByHeight {
+		block, err = s.chain.GetBlockByHeight(req.Height)
+	} else {
+		block, err = s.chain.GetBlock(&req.Hash)
+	}
+
+	if err != nil {
+		return
+	}
+
+	resp.Block, err = block.marshal()
+	return
+}
+
+// FetchVotes RPC returns the collected votes on an unfinalized block of the chain.
+func (s *ChainRPCService) FetchVotes(req *FetchVotesReq, resp *FetchVotesResp) (err error) {
+	resp.Votes, err = marshalVoteList(s.chain.Votes(req.BlockHash))
+	return
+}
+
+// QueryAccount RPC returns the account state on the head of the chain.
+func (s *ChainRPCService) QueryAccount(req *QueryAccountReq, resp *QueryAccountResp) (
+	err error) {
+	head := s.chain.Head()
+	account, err := s.chain.GetAccount(req.Address)
+
+	if err != nil {
+		return
+	}
+
+	resp.Balance = account.Balance.Bytes()
+	resp.Nonce = account.Nonce
+	resp.Metadata = account.Metadata
+	resp.Head = head.Head
+	resp.Height = head.Height
+	return
+}
+
+// QueryDatabase RPC returns the database state on the head of the chain.
+func (s *ChainRPCService) QueryDatabase(req *QueryDatabaseReq, resp *QueryDatabaseResp) (
+	err error) {
+	head := s.chain.Head()
+	database, err := s.chain.GetDatabase(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	resp.Owner = database.Owner
+	resp.Balance = database.Balance.Bytes()
+	resp.BilledHeight = database.BilledHeight
+	resp.Replicas = database.Replicas
+	resp.Term = database.Term
+	resp.Head = head.Head
+	resp.Height = head.Height
+	return
+}
+
+// QueryFinalized RPC returns the last finalized block and its votes, which are verified by the
+// syncing block producer with Chain.Finalize.
+func (s *ChainRPCService) QueryFinalized(req *QueryFinalizedReq, resp *QueryFinalizedResp) (
+	err error) {
+	state, votes, err := s.chain.FinalizedVotes()
+
+	if err != nil {
+		return
+	}
+
+	resp.Height = state.Finalized
+	resp.BlockHash = state.node.ancestor(state.Finalized).hash
+	resp.Votes, err = marshalVoteList(votes)
+	return
+}
+
+// marshalVoteList marshals the votes into separate buffers of an RPC response.
+func marshalVoteList(votes []*Vote) (list [][]byte, err error) {
+	list = make([][]byte, len(votes))
+
+	for i, v := range votes {
+		if list[i], err = v.marshal(); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// unmarshalVoteList unmarshals the votes of an RPC response.
+func unmarshalVoteList(list [][]byte) (votes []*Vote, err error) {
+	votes = make([]*Vote, len(list))
+
+	for i, b := range list {
+		votes[i] = &Vote{}
+
+		if err = votes[i].unmarshal(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
@@ -0,0 +1,922 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"sort"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/merkle"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/types"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+// Account is the state of an account on the main chain.
+type Account struct {
+	Address proto2.AccountAddress
+	Balance *big.Int
+	Nonce   uint64
+
+	// Metadata holds the extra attributes of the account.
+	Metadata map[string]string
+}
+
+func newAccount(addr proto2.AccountAddress) *Account {
+	return &Account{
+		Address: addr,
+		Balance: new(big.Int),
+	}
+}
+
+func (a *Account) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	keys := make([]string, 0, len(a.Metadata))
+
+	for k := range a.Metadata {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		string(a.Address),
+		a.Balance.Bytes(),
+		a.Nonce,
+		uint32(len(keys)),
+	); err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		if err := utils.WriteElements(buffer, binary.BigEndian, k, a.Metadata[k]); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (a *Account) unmarshal(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	var addr string
+	var balance []byte
+	var l uint32
+
+	if err = utils.ReadElements(reader, binary.BigEndian,
+		&addr,
+		&balance,
+		&a.Nonce,
+		&l,
+	); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return utils.ErrInsufficientBuffer
+	}
+
+	a.Address = proto2.AccountAddress(addr)
+	a.Balance = new(big.Int).SetBytes(balance)
+	a.Metadata = nil
+
+	for i := uint32(0); i < l; i++ {
+		var k, v string
+
+		if err = utils.ReadElements(reader, binary.BigEndian, &k, &v); err != nil {
+			return
+		}
+
+		if a.Metadata == nil {
+			a.Metadata = make(map[string]string, l)
+		}
+
+		a.Metadata[k] = v
+	}
+
+	return
+}
+
+// Hash implements merkle.Hashable.
+func (a *Account) Hash() *hash.Hash {
+	b, err := a.marshal()
+
+	if err != nil {
+		return &hash.Hash{}
+	}
+
+	h := hash.THashH(b)
+	return &h
+}
+
+// StateRoot computes the merkle root of the account states sorted by address, it returns an
+// empty hash if there is no account. It is the state root of a chain which has no database or
+// miner yet, such as the genesis state.
+func StateRoot(accounts []*Account) hash.Hash {
+	if len(accounts) == 0 {
+		return hash.Hash{}
+	}
+
+	sorted := append([]*Account{}, accounts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Address < sorted[j].Address
+	})
+
+	items := make([]*merkle.Hashable, len(sorted))
+
+	for i := range sorted {
+		item := merkle.Hashable(sorted[i])
+		items[i] = &item
+	}
+
+	return *merkle.NewMerkle(items).GetRoot()
+}
+
+// stateEntry is the hash of an encoded state entry in the state buckets.
+type stateEntry hash.Hash
+
+// Hash implements merkle.Hashable.
+func (e stateEntry) Hash() *hash.Hash {
+	h := hash.Hash(e)
+	return &h
+}
+
+// accountState applies txs to the state buckets of the chain database, which hold the accounts,
+// the databases and the miners, and records the undo data.
+type accountState struct {
+	meta *bolt.Bucket
+	undo *undoData
+	cfg  *Config
+}
+
+func (s *accountState) get(addr proto2.AccountAddress) (a *Account, err error) {
+	a = newAccount(addr)
+
+	if v := s.meta.Bucket(metaAccountBucket).Get([]byte(addr)); v != nil {
+		err = a.unmarshal(v)
+	}
+
+	return
+}
+
+func (s *accountState) put(a *Account) (err error) {
+	b, err := a.marshal()
+
+	if err != nil {
+		return
+	}
+
+	return s.putEntry(metaAccountBucket, []byte(a.Address), b)
+}
+
+func (s *accountState) getDatabase(id string) (d *Database, err error) {
+	v := s.meta.Bucket(metaDatabaseBucket).Get([]byte(id))
+
+	if v == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	d = &Database{}
+	err = d.unmarshal(v)
+	return
+}
+
+func (s *accountState) putDatabase(d *Database) (err error) {
+	b, err := d.marshal()
+
+	if err != nil {
+		return
+	}
+
+	return s.putEntry(metaDatabaseBucket, []byte(d.ID), b)
+}
+
+// adminDatabase returns the database if the user has its admin permission.
+func (s *accountState) adminDatabase(id string, user proto2.AccountAddress) (
+	d *Database, err error) {
+	if d, err = s.getDatabase(id); err != nil {
+		return
+	}
+
+	if !d.HasPermission(user, PermissionAdmin) {
+		return nil, ErrPermissionDenied
+	}
+
+	return
+}
+
+func (s *accountState) getMiner(id proto2.NodeID) (m *Miner, err error) {
+	v := s.meta.Bucket(metaMinerBucket).Get([]byte(id))
+
+	if v == nil {
+		return nil, ErrMinerNotFound
+	}
+
+	m = &Miner{}
+	err = m.unmarshal(v)
+	return
+}
+
+func (s *accountState) putMiner(m *Miner) (err error) {
+	b, err := m.marshal()
+
+	if err != nil {
+		return
+	}
+
+	return s.putEntry(metaMinerBucket, []byte(m.NodeID), b)
+}
+
+// putEntry puts the value into the state bucket, and records the original value at the first
+// change.
+func (s *accountState) putEntry(bucket, key, value []byte) error {
+	b := s.meta.Bucket(bucket)
+
+	if s.undo != nil && !s.undo.has(bucket, key) {
+		s.undo.add(bucket, key, b.Get(key))
+	}
+
+	return b.Put(key, value)
+}
+
+// credit adds the amount to the balance of the account.
+func (s *accountState) credit(addr proto2.AccountAddress, amount *big.Int) (err error) {
+	a, err := s.get(addr)
+
+	if err != nil {
+		return
+	}
+
+	a.Balance.Add(a.Balance, amount)
+	return s.put(a)
+}
+
+// applyTx charges the cost of the tx from the sender, applies the state transition of the tx type,
+// and transfers the fee to the producer.
+func (s *accountState) applyTx(producer proto2.AccountAddress, tx *Tx) (err error) {
+	data := &tx.TxData
+	payload, err := data.DecodePayload()
+
+	if err != nil {
+		return
+	}
+
+	sender, err := s.get(data.Sender())
+
+	if err != nil {
+		return
+	}
+
+	if sender.Nonce != data.AccountNonce {
+		return ErrNonceMismatch
+	}
+
+	cost := data.Cost()
+
+	if sender.Balance.Cmp(cost) < 0 {
+		return ErrInsufficientBalance
+	}
+
+	sender.Balance.Sub(sender.Balance, cost)
+	sender.Nonce++
+
+	if err = s.put(sender); err != nil {
+		return
+	}
+
+	switch p := payload.(type) {
+	case nil:
+		if data.Recipient == nil {
+			return ErrNilValue
+		}
+
+		err = s.credit(proto2.AccountAddress(data.Recipient.AccountAddress), data.Amount)
+	case *types.CreateDatabasePayload:
+		err = s.createDatabase(sender.Address, data, p)
+	case *types.DepositPayload:
+		err = s.deposit(data, p)
+	case *types.WithdrawPayload:
+		err = s.withdraw(sender.Address, data, p)
+	case *types.GrantPermissionPayload:
+		err = s.grantPermission(sender.Address, p)
+	case *types.RevokePermissionPayload:
+		err = s.revokePermission(sender.Address, p)
+	case *types.UpdateReplicaCountPayload:
+		err = s.updateReplicaCount(sender.Address, p)
+	case *types.RegisterMinerPayload:
+		err = s.registerMiner(sender.Address, data, p)
+	case *types.BillingPayload:
+		err = s.billing(sender.Address, p)
+	case *types.ReplaceReplicaPayload:
+		err = s.replaceReplica(sender.Address, p)
+	}
+
+	if err != nil {
+		return
+	}
+
+	return s.credit(producer, new(big.Int).SetUint64(data.Fee))
+}
+
+// createDatabase creates a database owned by the sender with the tx amount as its deposit, and
+// places its replicas on the miners.
+func (s *accountState) createDatabase(
+	sender proto2.AccountAddress, data *TxData, p *types.CreateDatabasePayload) (err error) {
+	id := DatabaseIDFromTx(sender, data.AccountNonce)
+
+	if _, err = s.getDatabase(id); err == nil {
+		return ErrDatabaseExists
+	} else if err != ErrDatabaseNotFound {
+		return
+	}
+
+	d := &Database{
+		ID:           id,
+		Owner:        sender,
+		ReplicaCount: p.ReplicaCount,
+		Balance:      new(big.Int).Set(data.Amount),
+		Permissions:  map[proto2.AccountAddress]uint32{sender: PermissionAll},
+		Term:         1,
+	}
+
+	if err = s.placeReplicas(d, nil); err != nil {
+		return
+	}
+
+	if len(d.Replicas) < int(d.ReplicaCount) {
+		return ErrInsufficientMiners
+	}
+
+	return s.putDatabase(d)
+}
+
+// deposit adds the tx amount to the deposit of the database.
+func (s *accountState) deposit(data *TxData, p *types.DepositPayload) (err error) {
+	d, err := s.getDatabase(p.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	d.Balance.Add(d.Balance, data.Amount)
+	return s.putDatabase(d)
+}
+
+// withdraw moves the tx amount from the deposit of the database to the sender.
+func (s *accountState) withdraw(
+	sender proto2.AccountAddress, data *TxData, p *types.WithdrawPayload) (err error) {
+	d, err := s.adminDatabase(p.DatabaseID, sender)
+
+	if err != nil {
+		return
+	}
+
+	if d.Balance.Cmp(data.Amount) < 0 {
+		return ErrInsufficientBalance
+	}
+
+	d.Balance.Sub(d.Balance, data.Amount)
+
+	if err = s.putDatabase(d); err != nil {
+		return
+	}
+
+	return s.credit(sender, data.Amount)
+}
+
+// grantPermission adds the permission bits to the user of the database.
+func (s *accountState) grantPermission(
+	sender proto2.AccountAddress, p *types.GrantPermissionPayload) (err error) {
+	d, err := s.adminDatabase(p.DatabaseID, sender)
+
+	if err != nil {
+		return
+	}
+
+	user := proto2.AccountAddress(p.User.AccountAddress)
+	d.Permissions[user] |= p.Permission
+	return s.putDatabase(d)
+}
+
+// revokePermission clears the permission bits from the user of the database, the permission of the
+// owner can't be revoked.
+func (s *accountState) revokePermission(
+	sender proto2.AccountAddress, p *types.RevokePermissionPayload) (err error) {
+	d, err := s.adminDatabase(p.DatabaseID, sender)
+
+	if err != nil {
+		return
+	}
+
+	user := proto2.AccountAddress(p.User.AccountAddress)
+
+	if user == d.Owner {
+		return ErrPermissionDenied
+	}
+
+	if perm := d.Permissions[user] &^ p.Permission; perm != 0 {
+		d.Permissions[user] = perm
+	} else {
+		delete(d.Permissions, user)
+	}
+
+	return s.putDatabase(d)
+}
+
+// updateReplicaCount changes the replica count of the database, the new replicas are placed on
+// the miners, or the last replicas are removed.
+func (s *accountState) updateReplicaCount(
+	sender proto2.AccountAddress, p *types.UpdateReplicaCountPayload) (err error) {
+	d, err := s.adminDatabase(p.DatabaseID, sender)
+
+	if err != nil {
+		return
+	}
+
+	d.ReplicaCount = p.ReplicaCount
+
+	for len(d.Replicas) > int(d.ReplicaCount) {
+		last := d.Replicas[len(d.Replicas)-1]
+		d.Replicas = d.Replicas[:len(d.Replicas)-1]
+
+		if err = s.releaseMiner(last, false); err != nil {
+			return
+		}
+	}
+
+	if err = s.placeReplicas(d, nil); err != nil {
+		return
+	}
+
+	if len(d.Replicas) < int(d.ReplicaCount) {
+		return ErrInsufficientMiners
+	}
+
+	d.Term++
+	return s.putDatabase(d)
+}
+
+// replaceReplica records a failure of the miner reported by the owner of another replica of the
+// database, and moves the replica to another miner. The report must be signed in the current term
+// by a majority of the other replicas, so that a single miner can't evict its peers. The database
+// keeps running on its remaining replicas if there is no eligible miner to take over.
+func (s *accountState) replaceReplica(
+	sender proto2.AccountAddress, p *types.ReplaceReplicaPayload) (err error) {
+	d, err := s.getDatabase(p.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	if p.Term != d.Term {
+		return ErrTermMismatch
+	}
+
+	failed := proto2.NodeID(p.Miner.NodeID)
+
+	if !d.HasReplica(failed) {
+		return ErrNotReplica
+	}
+
+	signers, err := replaceReplicaSigners(p)
+
+	if err != nil {
+		return
+	}
+
+	signed := make(map[proto2.NodeID]bool, len(signers))
+
+	for _, id := range signers {
+		if id == failed || !d.HasReplica(id) {
+			return ErrNotReplica
+		}
+
+		signed[id] = true
+	}
+
+	if 2*len(signed) <= len(d.Replicas)-1 {
+		return ErrInsufficientSignatures
+	}
+
+	reported := false
+
+	for _, id := range d.Replicas {
+		var m *Miner
+
+		if id == failed {
+			continue
+		}
+
+		if m, err = s.getMiner(id); err != nil {
+			return
+		}
+
+		reported = reported || m.Owner == sender
+	}
+
+	if !reported {
+		return ErrPermissionDenied
+	}
+
+	replicas := make([]proto2.NodeID, 0, len(d.Replicas))
+
+	for _, id := range d.Replicas {
+		if id != failed {
+			replicas = append(replicas, id)
+		}
+	}
+
+	d.Replicas = replicas
+
+	if err = s.releaseMiner(failed, true); err != nil {
+		return
+	}
+
+	if err = s.placeReplicas(d, map[proto2.NodeID]bool{failed: true}); err != nil {
+		return
+	}
+
+	d.Term++
+	return s.putDatabase(d)
+}
+
+// placeReplicas appends the eligible miners to the replicas of the database until it reaches
+// the replica count or there is no more candidate. A miner is eligible if it's not a replica or
+// excluded, hasn't failed too many times and has enough stake for one more replica. The
+// candidates with fewer failures come first, and then they are ordered by the hash of the
+// database id and the node id, so that every node derives the same placement from the state.
+func (s *accountState) placeReplicas(d *Database, exclude map[proto2.NodeID]bool) (err error) {
+	if len(d.Replicas) >= int(d.ReplicaCount) {
+		return
+	}
+
+	type candidate struct {
+		miner  *Miner
+		weight hash.Hash
+	}
+
+	var candidates []candidate
+
+	err = s.meta.Bucket(metaMinerBucket).ForEach(func(k, v []byte) (err error) {
+		m := &Miner{}
+
+		if err = m.unmarshal(v); err != nil {
+			return
+		}
+
+		if exclude[m.NodeID] || d.HasReplica(m.NodeID) || !s.isEligible(m) {
+			return
+		}
+
+		buffer := bytes.NewBuffer(nil)
+
+		if err = utils.WriteElements(buffer, binary.BigEndian,
+			d.ID,
+			string(m.NodeID),
+		); err != nil {
+			return
+		}
+
+		candidates = append(candidates, candidate{
+			miner:  m,
+			weight: hash.THashH(buffer.Bytes()),
+		})
+		return
+	})
+
+	if err != nil {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if fi, fj := candidates[i].miner.Failures, candidates[j].miner.Failures; fi != fj {
+			return fi < fj
+		}
+
+		return bytes.Compare(candidates[i].weight[:], candidates[j].weight[:]) < 0
+	})
+
+	for _, c := range candidates {
+		if len(d.Replicas) >= int(d.ReplicaCount) {
+			break
+		}
+
+		d.Replicas = append(d.Replicas, c.miner.NodeID)
+
+		if err = s.assignMiner(c.miner.NodeID); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// isEligible returns whether the miner can host one more replica.
+func (s *accountState) isEligible(m *Miner) bool {
+	if s.cfg == nil {
+		return true
+	}
+
+	if s.cfg.MaxFailures > 0 && m.Failures >= s.cfg.MaxFailures {
+		return false
+	}
+
+	if s.cfg.StakePerReplica == nil || s.cfg.StakePerReplica.Sign() <= 0 {
+		return true
+	}
+
+	capacity := new(big.Int).Div(m.Stake, s.cfg.StakePerReplica)
+	return capacity.Cmp(new(big.Int).SetUint64(uint64(m.Load))) > 0
+}
+
+// assignMiner adds a replica to the load of the miner.
+func (s *accountState) assignMiner(id proto2.NodeID) (err error) {
+	m, err := s.getMiner(id)
+
+	if err != nil {
+		return
+	}
+
+	m.Load++
+	return s.putMiner(m)
+}
+
+// releaseMiner removes a replica from the load of the miner, and records a failure of the miner if
+// failed is set.
+func (s *accountState) releaseMiner(id proto2.NodeID, failed bool) (err error) {
+	m, err := s.getMiner(id)
+
+	if err != nil {
+		return
+	}
+
+	if m.Load > 0 {
+		m.Load--
+	}
+
+	if failed {
+		m.Failures++
+	}
+
+	return s.putMiner(m)
+}
+
+// registerMiner registers the miner node owned by the sender with the tx amount as its stake.
+func (s *accountState) registerMiner(
+	sender proto2.AccountAddress, data *TxData, p *types.RegisterMinerPayload) (err error) {
+	id := proto2.NodeID(p.NodeID.NodeID)
+
+	if _, err = s.getMiner(id); err == nil {
+		return ErrMinerExists
+	} else if err != ErrMinerNotFound {
+		return
+	}
+
+	return s.putMiner(&Miner{
+		NodeID: id,
+		Owner:  sender,
+		Stake:  new(big.Int).Set(data.Amount),
+	})
+}
+
+// billing pays the usage of the database in the billed SQL chain height range from its deposit to
+// the owners of the miners. The billed miners must be the replicas of the database, the summary
+// must be signed by a majority of the replicas, and the sender must own one of the billed miners.
+func (s *accountState) billing(sender proto2.AccountAddress, p *types.BillingPayload) (err error) {
+	d, err := s.getDatabase(p.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	if p.StartHeight != d.BilledHeight {
+		return ErrBillingHeightMismatch
+	}
+
+	signers, err := billingSigners(p)
+
+	if err != nil {
+		return
+	}
+
+	signed := make(map[proto2.NodeID]bool, len(signers))
+
+	for _, id := range signers {
+		if !d.HasReplica(id) {
+			return ErrNotReplica
+		}
+
+		signed[id] = true
+	}
+
+	if 2*len(signed) <= len(d.Replicas) {
+		return ErrInsufficientSignatures
+	}
+
+	owners := make([]proto2.AccountAddress, len(p.Items))
+	rewards := make([]*big.Int, len(p.Items))
+	total := new(big.Int)
+	billed := false
+
+	for i, item := range p.Items {
+		var m *Miner
+
+		if !d.HasReplica(proto2.NodeID(item.Miner.NodeID)) {
+			return ErrNotReplica
+		}
+
+		if m, err = s.getMiner(proto2.NodeID(item.Miner.NodeID)); err != nil {
+			return
+		}
+
+		owners[i] = m.Owner
+		rewards[i] = new(big.Int).Mul(
+			new(big.Int).SetUint64(item.QueryCount), new(big.Int).SetUint64(s.cfg.QueryPrice))
+		total.Add(total, rewards[i])
+		billed = billed || m.Owner == sender
+	}
+
+	if !billed {
+		return ErrPermissionDenied
+	}
+
+	if d.Balance.Cmp(total) < 0 {
+		return ErrInsufficientBalance
+	}
+
+	d.Balance.Sub(d.Balance, total)
+	d.BilledHeight = p.EndHeight + 1
+
+	if err = s.putDatabase(d); err != nil {
+		return
+	}
+
+	for i := range owners {
+		if err = s.credit(owners[i], rewards[i]); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// applyBlock applies the txs of the block in order.
+func (s *accountState) applyBlock(block *Block) (err error) {
+	for _, tx := range block.Tx {
+		if err = s.applyTx(block.Header.Producer, tx); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// root computes the state root of all the accounts, followed by the databases and the miners.
+func (s *accountState) root() (root hash.Hash, err error) {
+	var items []*merkle.Hashable
+
+	for _, name := range stateBuckets {
+		err = s.meta.Bucket(name).ForEach(func(k, v []byte) error {
+			item := merkle.Hashable(stateEntry(hash.THashH(v)))
+			items = append(items, &item)
+			return nil
+		})
+
+		if err != nil {
+			return
+		}
+	}
+
+	if len(items) == 0 {
+		return
+	}
+
+	return *merkle.NewMerkle(items).GetRoot(), nil
+}
+
+// revert restores the state entries recorded in the undo data.
+func (s *accountState) revert(undo *undoData) (err error) {
+	for i, e := range undo.entries {
+		b := s.meta.Bucket([]byte(e.bucket))
+
+		if undo.states[i] == nil {
+			err = b.Delete([]byte(e.key))
+		} else {
+			err = b.Put([]byte(e.key), undo.states[i])
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// undoKey identifies a state entry by its bucket and key.
+type undoKey struct {
+	bucket string
+	key    string
+}
+
+// undoData records the state entries before a block is applied, so that the block can be
+// disconnected from the chain during a reorganization.
+type undoData struct {
+	entries []undoKey
+	states  [][]byte // The encoded states, nil if the entry didn't exist
+}
+
+func (u *undoData) has(bucket, key []byte) bool {
+	for _, v := range u.entries {
+		if v.bucket == string(bucket) && v.key == string(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (u *undoData) add(bucket, key, state []byte) {
+	u.entries = append(u.entries, undoKey{bucket: string(bucket), key: string(key)})
+
+	if state != nil {
+		state = append([]byte{}, state...)
+	}
+
+	u.states = append(u.states, state)
+}
+
+func (u *undoData) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian, uint32(len(u.entries))); err != nil {
+		return nil, err
+	}
+
+	for i, e := range u.entries {
+		if err := utils.WriteElements(buffer, binary.BigEndian,
+			e.bucket,
+			e.key,
+			u.states[i] != nil,
+			u.states[i],
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (u *undoData) unmarshal(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	var l uint32
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &l); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return utils.ErrInsufficientBuffer
+	}
+
+	u.entries = make([]undoKey, l)
+	u.states = make([][]byte, l)
+
+	for i := range u.entries {
+		var exists bool
+		var state []byte
+
+		if err = utils.ReadElements(reader, binary.BigEndian,
+			&u.entries[i].bucket,
+			&u.entries[i].key,
+			&exists,
+			&state,
+		); err != nil {
+			return
+		}
+
+		if exists {
+			u.states[i] = state
+		}
+	}
+
+	return
+}
@@ -0,0 +1,349 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"bytes"
+	"container/heap"
+	"math/big"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+)
+
+const (
+	// DefaultTxPoolSize is the default maximum number of txs in the tx pool.
+	DefaultTxPoolSize = 4096
+)
+
+// AccountState provides the confirmed nonces and balances of accounts to validate txs.
+type AccountState interface {
+	// Nonce returns the next nonce of the account.
+	Nonce(addr proto2.AccountAddress) (uint64, error)
+
+	// Balance returns the balance of the account.
+	Balance(addr proto2.AccountAddress) (*big.Int, error)
+}
+
+// TxPoolConfig represents a tx pool config.
+type TxPoolConfig struct {
+	// State is the confirmed account state which the txs are validated against.
+	State AccountState
+
+	// MaxSize is the maximum number of txs in the pool, DefaultTxPoolSize is used if it's not
+	// positive.
+	MaxSize int
+}
+
+// TxPool holds the validated txs waiting for inclusion in blocks. The pending txs of each
+// account are kept in nonce order and continuous from the next nonce of the account, so that
+// they can always be packed in order.
+type TxPool struct {
+	cfg *TxPoolConfig
+
+	mu       sync.Mutex
+	all      map[hash.Hash]*Tx
+	accounts map[proto2.AccountAddress][]*Tx
+}
+
+// NewTxPool returns a new TxPool.
+func NewTxPool(cfg *TxPoolConfig) (p *TxPool, err error) {
+	if cfg == nil || cfg.State == nil {
+		return nil, ErrInvalidTxPoolConfig
+	}
+
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultTxPoolSize
+	}
+
+	p = &TxPool{
+		cfg:      cfg,
+		all:      make(map[hash.Hash]*Tx),
+		accounts: make(map[proto2.AccountAddress][]*Tx),
+	}
+
+	return
+}
+
+// Size returns the number of txs in the pool.
+func (p *TxPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.all)
+}
+
+// GetTx returns the tx of the given hash in the pool, or nil if it's not found.
+func (p *TxPool) GetTx(h *hash.Hash) *Tx {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.all[*h]
+}
+
+// AddTx validates the tx and adds it to the pool. A pending tx with the same sender and nonce is
+// replaced if the new one pays a higher fee. If the pool is full, the tx with the lowest fee
+// among the last pending txs of other accounts is evicted to make room for a tx paying a higher
+// fee.
+func (p *TxPool) AddTx(tx *Tx) (err error) {
+	if err = tx.Verify(); err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.all[tx.TxHash]; ok {
+		return ErrTxExists
+	}
+
+	addr := tx.TxData.Sender()
+	nonce, err := p.cfg.State.Nonce(addr)
+
+	if err != nil {
+		return
+	}
+
+	balance, err := p.cfg.State.Balance(addr)
+
+	if err != nil {
+		return
+	}
+
+	// Check nonce ordering
+	pending := p.accounts[addr]
+
+	if tx.TxData.AccountNonce < nonce {
+		return ErrNonceTooLow
+	}
+
+	index := int(tx.TxData.AccountNonce - nonce)
+
+	if index > len(pending) {
+		return ErrNonceGap
+	}
+
+	replace := index < len(pending)
+
+	if replace && tx.TxData.Fee <= pending[index].TxData.Fee {
+		return ErrReplaceUnderpriced
+	}
+
+	// Check balance sufficiency of all the pending txs of the account
+	cost := tx.TxData.Cost()
+
+	for i, v := range pending {
+		if i != index {
+			cost.Add(cost, v.TxData.Cost())
+		}
+	}
+
+	if cost.Cmp(balance) > 0 {
+		return ErrInsufficientBalance
+	}
+
+	if replace {
+		delete(p.all, pending[index].TxHash)
+		pending[index] = tx
+		p.all[tx.TxHash] = tx
+		return
+	}
+
+	if len(p.all) >= p.cfg.MaxSize {
+		if err = p.evict(addr, tx.TxData.Fee); err != nil {
+			return
+		}
+	}
+
+	p.accounts[addr] = append(pending, tx)
+	p.all[tx.TxHash] = tx
+	return
+}
+
+// evict removes the tx with the lowest fee among the last pending txs of the accounts other
+// than the given one, the fee of the evicted tx must be lower than the given fee.
+func (p *TxPool) evict(except proto2.AccountAddress, fee uint64) error {
+	var victim proto2.AccountAddress
+	var lowest *Tx
+
+	for addr, pending := range p.accounts {
+		if addr == except {
+			continue
+		}
+
+		if last := pending[len(pending)-1]; lowest == nil || last.TxData.Fee < lowest.TxData.Fee {
+			victim, lowest = addr, last
+		}
+	}
+
+	if lowest == nil || lowest.TxData.Fee >= fee {
+		return ErrTxPoolFull
+	}
+
+	log.Debugf("evict tx %s from tx pool", lowest.TxHash.String())
+	p.removeLast(victim)
+	return nil
+}
+
+func (p *TxPool) removeLast(addr proto2.AccountAddress) {
+	pending := p.accounts[addr]
+	delete(p.all, pending[len(pending)-1].TxHash)
+
+	if pending = pending[:len(pending)-1]; len(pending) == 0 {
+		delete(p.accounts, addr)
+	} else {
+		p.accounts[addr] = pending
+	}
+}
+
+// txHeap is a max-heap of the next pending txs of accounts ordered by fee.
+type txHeap []*Tx
+
+func (h txHeap) Len() int { return len(h) }
+
+func (h txHeap) Less(i, j int) bool {
+	if h[i].TxData.Fee != h[j].TxData.Fee {
+		return h[i].TxData.Fee > h[j].TxData.Fee
+	}
+
+	// Break the tie by hash to make the order deterministic
+	return bytes.Compare(h[i].TxHash[:], h[j].TxHash[:]) < 0
+}
+
+func (h txHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *txHeap) Push(x interface{}) { *h = append(*h, x.(*Tx)) }
+
+func (h *txHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// PendingTxs returns at most limit txs for block assembly, the txs are ordered by fee while the
+// txs of the same account are kept in nonce order. All the pending txs are returned if limit is
+// not positive.
+func (p *TxPool) PendingTxs(limit int) (txs []*Tx) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if limit <= 0 || limit > len(p.all) {
+		limit = len(p.all)
+	}
+
+	txs = make([]*Tx, 0, limit)
+	next := make(map[proto2.AccountAddress]int, len(p.accounts))
+	heads := make(txHeap, 0, len(p.accounts))
+
+	for _, pending := range p.accounts {
+		heads = append(heads, pending[0])
+	}
+
+	heap.Init(&heads)
+
+	for len(txs) < limit && heads.Len() > 0 {
+		tx := heap.Pop(&heads).(*Tx)
+		txs = append(txs, tx)
+		addr := tx.TxData.Sender()
+		next[addr]++
+
+		if pending := p.accounts[addr]; next[addr] < len(pending) {
+			heap.Push(&heads, pending[next[addr]])
+		}
+	}
+
+	return
+}
+
+// Update removes the txs included in the block from the pool, and drops the pending txs which
+// are no longer valid against the account state updated by the block.
+func (p *TxPool) Update(block *Block) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, tx := range block.Tx {
+		delete(p.all, tx.TxHash)
+	}
+
+	for addr := range p.accounts {
+		if err = p.prune(addr); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// prune drops the pending txs of the account which are confirmed or no longer valid.
+func (p *TxPool) prune(addr proto2.AccountAddress) (err error) {
+	nonce, err := p.cfg.State.Nonce(addr)
+
+	if err != nil {
+		return
+	}
+
+	balance, err := p.cfg.State.Balance(addr)
+
+	if err != nil {
+		return
+	}
+
+	// Skip the txs which are confirmed or included in the block
+	pending := p.accounts[addr]
+	start := 0
+
+	for ; start < len(pending); start++ {
+		tx := pending[start]
+
+		if _, ok := p.all[tx.TxHash]; ok && tx.TxData.AccountNonce >= nonce {
+			break
+		}
+	}
+
+	// The rest txs must start from the next nonce of the account, and must be affordable
+	kept := pending[start:]
+
+	if len(kept) > 0 && kept[0].TxData.AccountNonce != nonce {
+		kept = nil
+	}
+
+	cost := new(big.Int)
+
+	for i, tx := range kept {
+		if cost.Add(cost, tx.TxData.Cost()).Cmp(balance) > 0 {
+			kept = kept[:i]
+			break
+		}
+	}
+
+	for _, tx := range pending[:start] {
+		delete(p.all, tx.TxHash)
+	}
+
+	for _, tx := range pending[start+len(kept):] {
+		delete(p.all, tx.TxHash)
+	}
+
+	if len(kept) == 0 {
+		delete(p.accounts, addr)
+	} else {
+		p.accounts[addr] = kept
+	}
+
+	return
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	proto2 "github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/types"
+)
+
+type testAccountState struct {
+	nonces   map[proto2.AccountAddress]uint64
+	balances map[proto2.AccountAddress]*big.Int
+}
+
+func newTestAccountState() *testAccountState {
+	return &testAccountState{
+		nonces:   make(map[proto2.AccountAddress]uint64),
+		balances: make(map[proto2.AccountAddress]*big.Int),
+	}
+}
+
+func (s *testAccountState) Nonce(addr proto2.AccountAddress) (uint64, error) {
+	return s.nonces[addr], nil
+}
+
+func (s *testAccountState) Balance(addr proto2.AccountAddress) (*big.Int, error) {
+	if b, ok := s.balances[addr]; ok {
+		return b, nil
+	}
+
+	return new(big.Int), nil
+}
+
+func createTestTx(t *testing.T, signer *asymmetric.PrivateKey, nonce uint64, amount int64,
+	fee uint64) (tx *Tx) {
+	tx = &Tx{
+		TxData: TxData{
+			AccountNonce: nonce,
+			Recipient:    &types.AccountAddress{AccountAddress: "recipient"},
+			Amount:       big.NewInt(amount),
+			Fee:          fee,
+		},
+	}
+
+	if err := tx.Sign(signer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+func createTestAccount(t *testing.T, state *testAccountState, balance int64) (
+	priv *asymmetric.PrivateKey, addr proto2.AccountAddress) {
+	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	addr = AccountAddressFromPublicKey(pub)
+	state.balances[addr] = big.NewInt(balance)
+	return
+}
+
+func TestTxPool(t *testing.T) {
+	if _, err := NewTxPool(&TxPoolConfig{}); err != ErrInvalidTxPoolConfig {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	state := newTestAccountState()
+	pool, err := NewTxPool(&TxPoolConfig{State: state, MaxSize: 6})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	alice, _ := createTestAccount(t, state, 100)
+	bob, bobAddr := createTestAccount(t, state, 100)
+	state.nonces[bobAddr] = 5
+
+	// Signature over tx data
+	tx := createTestTx(t, alice, 0, 10, 1)
+	tx.TxData.Amount = big.NewInt(20)
+
+	if err = pool.AddTx(tx); err != ErrSignVerification {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Nonce ordering
+	for _, c := range []struct {
+		tx  *Tx
+		err error
+	}{
+		{createTestTx(t, alice, 1, 10, 1), ErrNonceGap},
+		{createTestTx(t, alice, 0, 10, 1), nil},
+		{createTestTx(t, alice, 1, 10, 2), nil},
+		{createTestTx(t, bob, 4, 10, 3), ErrNonceTooLow},
+		{createTestTx(t, bob, 5, 10, 3), nil},
+	} {
+		if err = pool.AddTx(c.tx); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Dedupe and replacement
+	if err = pool.AddTx(createTestTx(t, alice, 1, 9, 2)); err != ErrReplaceUnderpriced {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	replaced := pool.PendingTxs(0)[0]
+	tx = createTestTx(t, bob, 5, 10, 4)
+
+	if err = pool.AddTx(tx); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = pool.AddTx(tx); err != ErrTxExists {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if pool.GetTx(&replaced.TxHash) != nil || pool.GetTx(&tx.TxHash) == nil || pool.Size() != 3 {
+		t.Fatalf("Unexpected tx pool size: %d", pool.Size())
+	}
+
+	// Balance sufficiency: alice has 100 - 11 - 12 left
+	if err = pool.AddTx(createTestTx(t, alice, 2, 70, 8)); err != ErrInsufficientBalance {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = pool.AddTx(createTestTx(t, alice, 2, 70, 7)); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Fee order while keeping the nonce order of each account
+	expected := []struct {
+		signer *asymmetric.PrivateKey
+		nonce  uint64
+	}{
+		{bob, 5}, {alice, 0}, {alice, 1}, {alice, 2},
+	}
+	txs := pool.PendingTxs(0)
+
+	if len(txs) != len(expected) {
+		t.Fatalf("Unexpected pending tx count: %d", len(txs))
+	}
+
+	for i, e := range expected {
+		if !txs[i].TxData.PublicKey.IsEqual(e.signer.PubKey()) ||
+			txs[i].TxData.AccountNonce != e.nonce {
+			t.Fatalf("Unexpected pending tx #%d: %+v", i, txs[i].TxData)
+		}
+	}
+
+	if txs = pool.PendingTxs(2); len(txs) != 2 {
+		t.Fatalf("Unexpected pending tx count: %d", len(txs))
+	}
+
+	// Eviction on size limit
+	carol, _ := createTestAccount(t, state, 100)
+
+	for i := uint64(0); i < 2; i++ {
+		if err = pool.AddTx(createTestTx(t, carol, i, 1, 5)); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	dave, _ := createTestAccount(t, state, 100)
+
+	if err = pool.AddTx(createTestTx(t, dave, 0, 1, 4)); err != ErrTxPoolFull {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = pool.AddTx(createTestTx(t, dave, 0, 1, 6)); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Bob's tx with the lowest fee is evicted
+	if pool.Size() != 6 {
+		t.Fatalf("Unexpected tx pool size: %d", pool.Size())
+	}
+
+	for _, tx := range pool.PendingTxs(0) {
+		if tx.TxData.PublicKey.IsEqual(bob.PubKey()) {
+			t.Fatal("Tx should be evicted")
+		}
+	}
+
+	// Update after block inclusion: alice's first tx is packed, and her balance drops so that
+	// her last tx is no longer affordable
+	block := &Block{}
+
+	for _, tx := range pool.PendingTxs(0) {
+		if tx.TxData.PublicKey.IsEqual(alice.PubKey()) {
+			block.Tx = []*Tx{tx}
+			break
+		}
+	}
+
+	aliceAddr := block.Tx[0].TxData.Sender()
+	state.nonces[aliceAddr] = 1
+	state.balances[aliceAddr] = big.NewInt(50)
+
+	if err = pool.Update(block); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if pool.Size() != 4 || pool.GetTx(&block.Tx[0].TxHash) != nil {
+		t.Fatalf("Unexpected tx pool size: %d", pool.Size())
+	}
+
+	// Submit tx by RPC
+	service := NewChainRPCService(nil, pool)
+	tx = createTestTx(t, carol, 2, 1, 5)
+	b, err := tx.marshal()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	resp := &SubmitTxResp{}
+
+	if err = service.SubmitTx(&SubmitTxReq{Tx: b}, resp); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !resp.TxHash.IsEqual(&tx.TxHash) || pool.GetTx(&tx.TxHash) == nil {
+		t.Fatalf("Unexpected response: %+v", resp)
+	}
+
+	if err = service.SubmitTx(&SubmitTxReq{Tx: b}, resp); err != ErrTxExists {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
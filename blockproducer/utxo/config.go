@@ -0,0 +1,32 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utxo
+
+// DefaultCoinbaseMaturity is the default number of blocks before a coinbase output can be spent.
+const DefaultCoinbaseMaturity = 100
+
+// Config represents a utxo set config.
+type Config struct {
+	DataDir string
+
+	// CoinbaseMaturity is the number of blocks before a coinbase output can be spent, the
+	// default maturity is used if it's zero.
+	CoinbaseMaturity uint32
+
+	// CoinbaseReward is the maximum total amount of the outputs of a coinbase tx.
+	CoinbaseReward uint64
+}
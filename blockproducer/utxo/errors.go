@@ -0,0 +1,66 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utxo
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNilValue indicates that an unexpected but not fatal nil value is detected, hence return
+	// it as an error.
+	ErrNilValue = errors.New("unexpected nil value")
+
+	// ErrUtxoNotFound indicates that the tx output doesn't exist or has been spent.
+	ErrUtxoNotFound = errors.New("utxo not found")
+
+	// ErrImmatureCoinbase indicates an attempt to spend a coinbase output before it matures.
+	ErrImmatureCoinbase = errors.New("coinbase output is not mature")
+
+	// ErrSigneeMismatch indicates that the signee of a tx input isn't the owner of the spent
+	// output.
+	ErrSigneeMismatch = errors.New("signee doesn't match the output owner")
+
+	// ErrSignVerification indicates that the signature of a tx input isn't signed by its signee
+	// over the signature digest of the tx.
+	ErrSignVerification = errors.New("signature verification failed")
+
+	// ErrInvalidCoinbase indicates a coinbase tx which isn't the first tx of its block.
+	ErrInvalidCoinbase = errors.New("coinbase tx is not the first tx of the block")
+
+	// ErrCoinbaseReward indicates that the outputs of a coinbase tx exceed the coinbase reward.
+	ErrCoinbaseReward = errors.New("coinbase outputs exceed the reward")
+
+	// ErrAmountOverflow indicates that the total amount of the inputs or the outputs of a tx
+	// overflows.
+	ErrAmountOverflow = errors.New("amount overflow")
+
+	// ErrInsufficientInputs indicates that the outputs of a tx exceed its inputs.
+	ErrInsufficientInputs = errors.New("outputs exceed inputs")
+
+	// ErrDuplicateTx indicates that a tx with the same hash still has unspent outputs.
+	ErrDuplicateTx = errors.New("duplicate tx")
+
+	// ErrHeightMismatch indicates that the connected block doesn't follow the tip of the set.
+	ErrHeightMismatch = errors.New("block height doesn't follow the tip")
+
+	// ErrTipMismatch indicates an attempt to disconnect a block which isn't the tip of the set.
+	ErrTipMismatch = errors.New("block is not the tip")
+
+	// ErrUndoNotFound indicates that the undo data of a block is missing from the database.
+	ErrUndoNotFound = errors.New("undo data not found")
+)
@@ -0,0 +1,538 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utxo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"sync"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/golang/protobuf/proto"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/types"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+var (
+	metaBucket      = [4]byte{0x0, 0x0, 0x0, 0x0}
+	metaStateKey    = []byte("thunderdb-utxo-state")
+	metaEntryBucket = []byte("thunderdb-utxo-entry-bucket")
+	metaUndoBucket  = []byte("thunderdb-utxo-undo-bucket")
+)
+
+// OutPoint identifies a tx output by the tx hash and the output index.
+type OutPoint struct {
+	TxHash hash.Hash
+	Index  uint32
+}
+
+// TxHash returns the hash of the tx.
+func TxHash(tx *types.Tx) (h hash.Hash, err error) {
+	b, err := proto.Marshal(tx)
+
+	if err != nil {
+		return
+	}
+
+	return hash.THashH(b), nil
+}
+
+// SigHash returns the digest of the tx signed by the owners of the spent outputs, which is the
+// hash of the tx without the signatures of its inputs.
+func SigHash(tx *types.Tx) (h hash.Hash, err error) {
+	unsigned := proto.Clone(tx).(*types.Tx)
+
+	for _, input := range unsigned.UtxoIn {
+		if input.UtxoHeader != nil {
+			input.UtxoHeader.Signature = nil
+		}
+	}
+
+	return TxHash(unsigned)
+}
+
+// SignInput signs the input of the tx at the index with the private key of the spent output
+// owner. The signees of all the inputs must be set before signing since they are covered by the
+// signatures.
+func SignInput(tx *types.Tx, index int, signer *asymmetric.PrivateKey) (err error) {
+	header := tx.UtxoIn[index].UtxoHeader
+
+	if header == nil || header.Signee == nil {
+		return ErrNilValue
+	}
+
+	if !bytes.Equal(header.Signee.PublicKey, signer.PubKey().Serialize()) {
+		return ErrSigneeMismatch
+	}
+
+	h, err := SigHash(tx)
+
+	if err != nil {
+		return
+	}
+
+	signature, err := signer.Sign(h[:])
+
+	if err != nil {
+		return
+	}
+
+	header.Signature = &types.Signature{
+		R: signature.R.String(),
+		S: signature.S.String(),
+	}
+	return
+}
+
+// verifyInput verifies the signature of the input header over the signature digest of its tx.
+func verifyInput(header *types.UtxoHeader, h hash.Hash) (err error) {
+	if header.Signee == nil || header.Signature == nil {
+		return ErrSignVerification
+	}
+
+	pub, err := asymmetric.ParsePubKey(header.Signee.PublicKey)
+
+	if err != nil {
+		return ErrSignVerification
+	}
+
+	r, rok := new(big.Int).SetString(header.Signature.R, 10)
+	ss, sok := new(big.Int).SetString(header.Signature.S, 10)
+
+	if !rok || !sok || !(&asymmetric.Signature{R: r, S: ss}).Verify(h[:], pub) {
+		return ErrSignVerification
+	}
+
+	return
+}
+
+// Set is the persistent set of the unspent tx outputs. The outputs of a tx are kept in a
+// types.UtxoEntry indexed by the tx hash, and spent outputs are pruned from its sparse outputs.
+type Set struct {
+	mu       sync.Mutex
+	cfg      *Config
+	db       *bolt.DB
+	maturity uint32
+
+	// tip is the last connected block, and next is the height of the next connected block.
+	tip  hash.Hash
+	next uint32
+}
+
+// NewSet opens the utxo set in the data directory of the config, the set is created if it
+// doesn't exist.
+func NewSet(cfg *Config) (s *Set, err error) {
+	db, err := bolt.Open(cfg.DataDir, 0600, nil)
+
+	if err != nil {
+		return
+	}
+
+	s = &Set{
+		cfg:      cfg,
+		db:       db,
+		maturity: cfg.CoinbaseMaturity,
+	}
+
+	if s.maturity == 0 {
+		s.maturity = DefaultCoinbaseMaturity
+	}
+
+	err = db.Update(func(tx *bolt.Tx) (err error) {
+		bucket, err := tx.CreateBucketIfNotExists(metaBucket[:])
+
+		if err != nil {
+			return
+		}
+
+		if _, err = bucket.CreateBucketIfNotExists(metaEntryBucket); err != nil {
+			return
+		}
+
+		if _, err = bucket.CreateBucketIfNotExists(metaUndoBucket); err != nil {
+			return
+		}
+
+		// Load the tip of an existing set
+		if v := bucket.Get(metaStateKey); v != nil {
+			err = utils.ReadElements(bytes.NewReader(v), binary.BigEndian, &s.tip, &s.next)
+		}
+
+		return
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// Close closes the database of the set.
+func (s *Set) Close() error {
+	return s.db.Close()
+}
+
+// Tip returns the hash of the last connected block and the height of the next block.
+func (s *Set) Tip() (tip hash.Hash, next uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tip, s.next
+}
+
+// LookupEntry returns the unspent outputs of the tx.
+func (s *Set) LookupEntry(h hash.Hash) (entry *types.UtxoEntry, err error) {
+	err = s.db.View(func(tx *bolt.Tx) (err error) {
+		entry, err = getEntry(tx.Bucket(metaBucket[:]).Bucket(metaEntryBucket), h)
+		return
+	})
+
+	return
+}
+
+// Lookup returns the unspent tx output of the out point.
+func (s *Set) Lookup(op OutPoint) (utxo *types.Utxo, err error) {
+	entry, err := s.LookupEntry(op.TxHash)
+
+	if err != nil {
+		return
+	}
+
+	if utxo = entry.SparseOutputs[op.Index]; utxo == nil {
+		return nil, ErrUtxoNotFound
+	}
+
+	return
+}
+
+// ConnectBlock applies the txs of the block at the given height in order: the outputs spent by
+// the inputs are removed and the outputs of the txs are added. A tx without any input is a
+// coinbase tx, which is only allowed as the first tx of the block and mints at most the coinbase
+// reward of the config. The spent outputs are recorded in the undo data of the block, so that the block can
+// be disconnected during a reorganization.
+func (s *Set) ConnectBlock(blockHash hash.Hash, height uint32, txs []*types.Tx) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if height != s.next {
+		return ErrHeightMismatch
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(metaBucket[:])
+		v := &view{
+			bucket:   bucket.Bucket(metaEntryBucket),
+			height:   height,
+			maturity: s.maturity,
+			reward:   s.cfg.CoinbaseReward,
+			created:  make(map[hash.Hash]bool),
+			undo:     &undoData{prevTip: s.tip},
+		}
+
+		for i, t := range txs {
+			if err = v.connectTx(t, i == 0); err != nil {
+				return
+			}
+		}
+
+		ub, err := v.undo.marshal()
+
+		if err != nil {
+			return
+		}
+
+		if err = bucket.Bucket(metaUndoBucket).Put(blockHash[:], ub); err != nil {
+			return
+		}
+
+		return putState(bucket, blockHash, height+1)
+	})
+
+	if err != nil {
+		return
+	}
+
+	s.tip = blockHash
+	s.next = height + 1
+	return
+}
+
+// DisconnectBlock reverts the tip block of the set with its undo data.
+func (s *Set) DisconnectBlock(blockHash hash.Hash) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == 0 || !blockHash.IsEqual(&s.tip) {
+		return ErrTipMismatch
+	}
+
+	undo := &undoData{}
+
+	err = s.db.Update(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(metaBucket[:])
+		ub := bucket.Bucket(metaUndoBucket)
+		eb := bucket.Bucket(metaEntryBucket)
+		v := ub.Get(blockHash[:])
+
+		if v == nil {
+			return ErrUndoNotFound
+		}
+
+		if err = undo.unmarshal(v); err != nil {
+			return
+		}
+
+		// Remove the outputs created by the block
+		for _, h := range undo.created {
+			if err = eb.Delete(h[:]); err != nil {
+				return
+			}
+		}
+
+		// Restore the outputs spent by the block
+		for i, h := range undo.spent {
+			var entry *types.UtxoEntry
+
+			if entry, err = getEntry(eb, h); err == ErrUtxoNotFound {
+				entry = &types.UtxoEntry{
+					IsCoinbase:    undo.entries[i].IsCoinbase,
+					FromMainChain: undo.entries[i].FromMainChain,
+					BlockHeight:   undo.entries[i].BlockHeight,
+					SparseOutputs: make(map[uint32]*types.Utxo),
+				}
+			} else if err != nil {
+				return
+			}
+
+			for index, utxo := range undo.entries[i].SparseOutputs {
+				utxo.Spent = false
+				entry.SparseOutputs[index] = utxo
+			}
+
+			if err = putEntry(eb, h, entry); err != nil {
+				return
+			}
+		}
+
+		if err = ub.Delete(blockHash[:]); err != nil {
+			return
+		}
+
+		return putState(bucket, undo.prevTip, s.next-1)
+	})
+
+	if err != nil {
+		return
+	}
+
+	s.tip = undo.prevTip
+	s.next--
+	return
+}
+
+func putState(bucket *bolt.Bucket, tip hash.Hash, next uint32) (err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = utils.WriteElements(buffer, binary.BigEndian, tip, next); err != nil {
+		return
+	}
+
+	return bucket.Put(metaStateKey, buffer.Bytes())
+}
+
+func getEntry(bucket *bolt.Bucket, h hash.Hash) (entry *types.UtxoEntry, err error) {
+	v := bucket.Get(h[:])
+
+	if v == nil {
+		return nil, ErrUtxoNotFound
+	}
+
+	entry = &types.UtxoEntry{}
+
+	if err = proto.Unmarshal(v, entry); err != nil {
+		return nil, err
+	}
+
+	if entry.SparseOutputs == nil {
+		entry.SparseOutputs = make(map[uint32]*types.Utxo)
+	}
+
+	return
+}
+
+func putEntry(bucket *bolt.Bucket, h hash.Hash, entry *types.UtxoEntry) (err error) {
+	b, err := proto.Marshal(entry)
+
+	if err != nil {
+		return
+	}
+
+	return bucket.Put(h[:], b)
+}
+
+// view applies the txs of a block to the entry bucket.
+type view struct {
+	bucket   *bolt.Bucket
+	height   uint32
+	maturity uint32
+	reward   uint64
+
+	// created records the txs of the block, their spent outputs don't need to be restored
+	created map[hash.Hash]bool
+	undo    *undoData
+}
+
+// connectTx spends the inputs of the tx and adds its outputs, first indicates whether the tx is
+// the first one of the block, which is the only tx allowed to be a coinbase.
+func (v *view) connectTx(tx *types.Tx, first bool) (err error) {
+	coinbase := len(tx.UtxoIn) == 0
+
+	if coinbase && !first {
+		return ErrInvalidCoinbase
+	}
+
+	h, err := TxHash(tx)
+
+	if err != nil {
+		return
+	}
+
+	sigHash, err := SigHash(tx)
+
+	if err != nil {
+		return
+	}
+
+	if _, err = getEntry(v.bucket, h); err == nil {
+		return ErrDuplicateTx
+	} else if err != ErrUtxoNotFound {
+		return
+	}
+
+	var in, out uint64
+
+	for _, input := range tx.UtxoIn {
+		if input.UtxoHeader == nil || input.UtxoHeader.PrevTxHash == nil {
+			return ErrNilValue
+		}
+
+		var prev *hash.Hash
+		var spent *types.Utxo
+
+		if prev, err = hash.NewHash(input.UtxoHeader.PrevTxHash.Hash); err != nil {
+			return
+		}
+
+		if spent, err = v.spend(OutPoint{
+			TxHash: *prev,
+			Index:  input.UtxoHeader.PrevOutputIndex,
+		}, input.UtxoHeader, sigHash); err != nil {
+			return
+		}
+
+		if in+spent.Amount < in {
+			return ErrAmountOverflow
+		}
+
+		in += spent.Amount
+	}
+
+	entry := &types.UtxoEntry{
+		IsCoinbase:    coinbase,
+		FromMainChain: true,
+		BlockHeight:   v.height,
+		SparseOutputs: make(map[uint32]*types.Utxo, len(tx.UtxoOut)),
+	}
+
+	for i, output := range tx.UtxoOut {
+		if output.UtxoHeader == nil || output.UtxoHeader.Signee == nil {
+			return ErrNilValue
+		}
+
+		if out+output.Amount < out {
+			return ErrAmountOverflow
+		}
+
+		out += output.Amount
+		utxo := proto.Clone(output).(*types.Utxo)
+		utxo.Spent = false
+		entry.SparseOutputs[uint32(i)] = utxo
+	}
+
+	if coinbase && out > v.reward {
+		return ErrCoinbaseReward
+	}
+
+	if !coinbase && out > in {
+		return ErrInsufficientInputs
+	}
+
+	if len(entry.SparseOutputs) == 0 {
+		return
+	}
+
+	v.created[h] = true
+	v.undo.created = append(v.undo.created, h)
+	return putEntry(v.bucket, h, entry)
+}
+
+// spend removes the output from the set and records it in the undo data, the signee of the input
+// must be the owner of the output and sign the signature digest of the spending tx.
+func (v *view) spend(op OutPoint, input *types.UtxoHeader, sigHash hash.Hash) (
+	utxo *types.Utxo, err error) {
+	entry, err := getEntry(v.bucket, op.TxHash)
+
+	if err != nil {
+		return
+	}
+
+	if utxo = entry.SparseOutputs[op.Index]; utxo == nil || utxo.Spent {
+		return nil, ErrUtxoNotFound
+	}
+
+	if entry.IsCoinbase && v.height-entry.BlockHeight < v.maturity {
+		return nil, ErrImmatureCoinbase
+	}
+
+	if input.Signee == nil || utxo.UtxoHeader == nil || utxo.UtxoHeader.Signee == nil ||
+		!bytes.Equal(input.Signee.PublicKey, utxo.UtxoHeader.Signee.PublicKey) {
+		return nil, ErrSigneeMismatch
+	}
+
+	if err = verifyInput(input, sigHash); err != nil {
+		return nil, err
+	}
+
+	delete(entry.SparseOutputs, op.Index)
+
+	// Outputs created in the same block are removed as a whole while disconnecting the block
+	if !v.created[op.TxHash] {
+		v.undo.add(op, entry, utxo)
+	}
+
+	if len(entry.SparseOutputs) == 0 {
+		err = v.bucket.Delete(op.TxHash[:])
+	} else {
+		err = putEntry(v.bucket, op.TxHash, entry)
+	}
+
+	return
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utxo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/types"
+)
+
+var testKeys = make(map[string]*asymmetric.PrivateKey)
+
+// testKey returns the private key of the named test account.
+func testKey(t *testing.T, name string) *asymmetric.PrivateKey {
+	if key, ok := testKeys[name]; ok {
+		return key
+	}
+
+	key, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	testKeys[name] = key
+	return key
+}
+
+// createTestTx creates a tx spending the inputs signed by the signee, a coinbase tx is created if
+// there is no input.
+func createTestTx(t *testing.T, signee string, inputs []OutPoint,
+	outputs map[string]uint64) (tx *types.Tx) {
+	tx = &types.Tx{Content: fmt.Sprintf("%s-%v", signee, inputs)}
+
+	for _, op := range inputs {
+		tx.UtxoIn = append(tx.UtxoIn, &types.Utxo{
+			UtxoHeader: &types.UtxoHeader{
+				PrevTxHash:      &types.Hash{Hash: op.TxHash[:]},
+				PrevOutputIndex: op.Index,
+				Signee: &types.PublicKey{
+					PublicKey: testKey(t, signee).PubKey().Serialize(),
+				},
+			},
+		})
+	}
+
+	for _, owner := range []string{"alice", "bob", "carol"} {
+		if amount, ok := outputs[owner]; ok {
+			tx.UtxoOut = append(tx.UtxoOut, &types.Utxo{
+				UtxoHeader: &types.UtxoHeader{
+					Signee: &types.PublicKey{
+						PublicKey: testKey(t, owner).PubKey().Serialize(),
+					},
+				},
+				Amount: amount,
+			})
+		}
+	}
+
+	for i := range tx.UtxoIn {
+		if err := SignInput(tx, i, testKey(t, signee)); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	return
+}
+
+func txOutPoint(t *testing.T, tx *types.Tx, index uint32) OutPoint {
+	h, err := TxHash(tx)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return OutPoint{TxHash: h, Index: index}
+}
+
+func checkUtxo(t *testing.T, s *Set, op OutPoint, owner string, amount uint64) {
+	utxo, err := s.Lookup(op)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if string(utxo.UtxoHeader.Signee.PublicKey) !=
+		string(testKey(t, owner).PubKey().Serialize()) || utxo.Amount != amount ||
+		utxo.Spent {
+		t.Fatalf("Unexpected utxo: %v", utxo)
+	}
+}
+
+func TestSet(t *testing.T) {
+	fl, err := ioutil.TempFile("", "utxo")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	cfg := &Config{DataDir: fl.Name(), CoinbaseMaturity: 2, CoinbaseReward: 110}
+	s, err := NewSet(cfg)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	blocks := make([]hash.Hash, 3)
+
+	for i := range blocks {
+		blocks[i] = hash.THashH([]byte(fmt.Sprintf("block%d", i)))
+	}
+
+	// Mint the coinbase outputs, which are capped by the reward
+	coinbase := createTestTx(t, "", nil, map[string]uint64{"alice": 100, "bob": 10})
+	excess := createTestTx(t, "", nil, map[string]uint64{"alice": 100, "bob": 11})
+
+	if err = s.ConnectBlock(blocks[0], 0, []*types.Tx{excess}); err != ErrCoinbaseReward {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = s.ConnectBlock(blocks[0], 0, []*types.Tx{coinbase}); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	cb0, cb1 := txOutPoint(t, coinbase, 0), txOutPoint(t, coinbase, 1)
+	checkUtxo(t, s, cb0, "alice", 100)
+	checkUtxo(t, s, cb1, "bob", 10)
+	entry, err := s.LookupEntry(cb0.TxHash)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !entry.IsCoinbase || !entry.FromMainChain || entry.BlockHeight != 0 {
+		t.Fatalf("Unexpected utxo entry: %v", entry)
+	}
+
+	// Coinbase outputs are not mature yet
+	tx1 := createTestTx(t, "alice", []OutPoint{cb0}, map[string]uint64{"alice": 30, "bob": 60})
+
+	if err = s.ConnectBlock(blocks[1], 1, []*types.Tx{tx1}); err != ErrImmatureCoinbase {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = s.ConnectBlock(blocks[1], 2, nil); err != ErrHeightMismatch {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = s.ConnectBlock(blocks[1], 1, nil); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// A tx with the correct signee but a forged or missing signature
+	forged := createTestTx(t, "alice", []OutPoint{cb0}, map[string]uint64{"bob": 100})
+
+	if err = SignInput(forged, 0, testKey(t, "bob")); err != ErrSigneeMismatch {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	signature, err := testKey(t, "bob").Sign(hash.THashB([]byte("forged")))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	forged.UtxoIn[0].UtxoHeader.Signature = &types.Signature{
+		R: signature.R.String(),
+		S: signature.S.String(),
+	}
+	missing := createTestTx(t, "alice", []OutPoint{cb0}, map[string]uint64{"bob": 100})
+	missing.UtxoIn[0].UtxoHeader.Signature = nil
+
+	// The signature of alice doesn't cover the tampered outputs
+	tampered := createTestTx(t, "alice", []OutPoint{cb0}, map[string]uint64{"bob": 100})
+	tampered.UtxoOut[0].UtxoHeader.Signee.PublicKey = testKey(t, "carol").PubKey().Serialize()
+
+	// Invalid txs
+	for _, c := range []struct {
+		tx  *types.Tx
+		err error
+	}{
+		{forged, ErrSignVerification},
+		{missing, ErrSignVerification},
+		{tampered, ErrSignVerification},
+		{createTestTx(t, "alice", []OutPoint{cb0},
+			map[string]uint64{"bob": math.MaxUint64, "carol": 2}), ErrAmountOverflow},
+		{createTestTx(t, "bob", []OutPoint{cb0}, map[string]uint64{"bob": 100}), ErrSigneeMismatch},
+		{createTestTx(t, "alice", []OutPoint{cb0}, map[string]uint64{"bob": 101}),
+			ErrInsufficientInputs},
+		{createTestTx(t, "alice", []OutPoint{{TxHash: cb0.TxHash, Index: 2}},
+			map[string]uint64{"bob": 1}), ErrUtxoNotFound},
+		{createTestTx(t, "alice", []OutPoint{cb0, cb0}, map[string]uint64{"bob": 1}),
+			ErrUtxoNotFound},
+	} {
+		if err = s.ConnectBlock(blocks[2], 2, []*types.Tx{c.tx}); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// A coinbase tx must be the first tx of the block
+	if err = s.ConnectBlock(blocks[2], 2, []*types.Tx{
+		createTestTx(t, "", nil, map[string]uint64{"carol": 1}),
+		createTestTx(t, "", nil, map[string]uint64{"carol": 2}),
+	}); err != ErrInvalidCoinbase {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Spend the coinbase output, and spend the new output in the same block
+	tx1o1 := txOutPoint(t, tx1, 1)
+	tx2 := createTestTx(t, "bob", []OutPoint{tx1o1}, map[string]uint64{"carol": 55})
+
+	if err = s.ConnectBlock(blocks[2], 2, []*types.Tx{tx1, tx2}); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = s.Lookup(cb0); err != ErrUtxoNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = s.Lookup(tx1o1); err != ErrUtxoNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	checkUtxo(t, s, cb1, "bob", 10)
+	checkUtxo(t, s, txOutPoint(t, tx1, 0), "alice", 30)
+	checkUtxo(t, s, txOutPoint(t, tx2, 0), "carol", 55)
+
+	// The set is persistent
+	if err = s.Close(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if s, err = NewSet(cfg); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer s.Close()
+
+	if tip, next := s.Tip(); !tip.IsEqual(&blocks[2]) || next != 3 {
+		t.Fatalf("Unexpected tip: %s, %d", tip.String(), next)
+	}
+
+	checkUtxo(t, s, txOutPoint(t, tx2, 0), "carol", 55)
+
+	// Disconnect the blocks and restore the spent outputs
+	if err = s.DisconnectBlock(blocks[1]); err != ErrTipMismatch {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = s.DisconnectBlock(blocks[2]); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	checkUtxo(t, s, cb0, "alice", 100)
+	checkUtxo(t, s, cb1, "bob", 10)
+
+	for _, tx := range []*types.Tx{tx1, tx2} {
+		if _, err = s.LookupEntry(txOutPoint(t, tx, 0).TxHash); err != ErrUtxoNotFound {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if tip, next := s.Tip(); !tip.IsEqual(&blocks[1]) || next != 2 {
+		t.Fatalf("Unexpected tip: %s, %d", tip.String(), next)
+	}
+
+	for _, b := range []hash.Hash{blocks[1], blocks[0]} {
+		if err = s.DisconnectBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	if _, err = s.LookupEntry(cb0.TxHash); err != ErrUtxoNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = s.DisconnectBlock(hash.Hash{}); err != ErrTipMismatch {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
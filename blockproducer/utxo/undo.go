@@ -0,0 +1,144 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utxo
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/types"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+// undoData records the changes of a connected block, so that the block can be disconnected from
+// the set during a reorganization.
+type undoData struct {
+	prevTip hash.Hash
+
+	// created are the hashes of the txs whose outputs are added by the block
+	created []hash.Hash
+
+	// spent are the hashes of the txs whose outputs are spent by the block, and entries keep the
+	// spent outputs with the metadata of the txs
+	spent   []hash.Hash
+	entries []*types.UtxoEntry
+}
+
+func (u *undoData) add(op OutPoint, entry *types.UtxoEntry, utxo *types.Utxo) {
+	spent := proto.Clone(utxo).(*types.Utxo)
+	spent.Spent = true
+
+	for i, h := range u.spent {
+		if h.IsEqual(&op.TxHash) {
+			u.entries[i].SparseOutputs[op.Index] = spent
+			return
+		}
+	}
+
+	u.spent = append(u.spent, op.TxHash)
+	u.entries = append(u.entries, &types.UtxoEntry{
+		IsCoinbase:    entry.IsCoinbase,
+		FromMainChain: entry.FromMainChain,
+		BlockHeight:   entry.BlockHeight,
+		SparseOutputs: map[uint32]*types.Utxo{op.Index: spent},
+	})
+}
+
+func (u *undoData) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		u.prevTip,
+		uint32(len(u.created)),
+	); err != nil {
+		return nil, err
+	}
+
+	for _, h := range u.created {
+		if err := utils.WriteElements(buffer, binary.BigEndian, h); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := utils.WriteElements(buffer, binary.BigEndian, uint32(len(u.spent))); err != nil {
+		return nil, err
+	}
+
+	for i, h := range u.spent {
+		b, err := proto.Marshal(u.entries[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = utils.WriteElements(buffer, binary.BigEndian, h, b); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (u *undoData) unmarshal(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	var l uint32
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &u.prevTip, &l); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return utils.ErrInsufficientBuffer
+	}
+
+	u.created = make([]hash.Hash, l)
+
+	for i := range u.created {
+		if err = utils.ReadElements(reader, binary.BigEndian, &u.created[i]); err != nil {
+			return
+		}
+	}
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &l); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return utils.ErrInsufficientBuffer
+	}
+
+	u.spent = make([]hash.Hash, l)
+	u.entries = make([]*types.UtxoEntry, l)
+
+	for i := range u.spent {
+		var eb []byte
+
+		if err = utils.ReadElements(reader, binary.BigEndian, &u.spent[i], &eb); err != nil {
+			return
+		}
+
+		u.entries[i] = &types.UtxoEntry{}
+
+		if err = proto.Unmarshal(eb, u.entries[i]); err != nil {
+			return
+		}
+	}
+
+	return
+}
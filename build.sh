@@ -16,6 +16,9 @@ version=`getversion`
 idminer_pkgpath="github.com/thunderdb/ThunderDB/cmd/idminer"
 go build -ldflags "-X main.version=${version}"  -o bin/idminer ${idminer_pkgpath}
 
+genesis_pkgpath="github.com/thunderdb/ThunderDB/cmd/genesis"
+go build -ldflags "-X main.version=${version}"  -o bin/genesis ${genesis_pkgpath}
+
 thunderdbd_pkgpath="github.com/thunderdb/ThunderDB/cmd/thunderdbd"
 go build -ldflags "-X main.version=${version} -X github.com/thunderdb/ThunderDB/conf.Role=B"  -o bin/thunderdbd ${thunderdbd_pkgpath}
 
@@ -0,0 +1,242 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/crypto/symmetric"
+)
+
+// EncryptionMode defines how the values of an encrypted column are encrypted.
+type EncryptionMode int
+
+const (
+	// Randomized mode uses a random iv for each value, so equal values produce different
+	// cipher data. This is the safest mode but the column can not be used in lookups.
+	Randomized EncryptionMode = iota
+
+	// Deterministic mode derives the iv from the value, so equal values produce equal
+	// cipher data and equality lookups can be done on the encrypted column.
+	Deterministic
+)
+
+var (
+	// ErrNotDeterministic indicates that an equality lookup is requested on a column which is
+	// not deterministically encrypted.
+	ErrNotDeterministic = errors.New("column is not deterministically encrypted")
+
+	// ErrColumnCount indicates that the number of columns and values don't match.
+	ErrColumnCount = errors.New("column count doesn't match value count")
+
+	// ErrValueType indicates that the value type is not supported.
+	ErrValueType = errors.New("unsupported value type")
+)
+
+// ColumnEncryptor encrypts the values of the selected columns on the client side, so that the
+// miners only store and replicate cipher data. Each column has its own key derived from the
+// master key.
+type ColumnEncryptor struct {
+	sync.RWMutex
+	key     []byte
+	columns map[string]EncryptionMode
+}
+
+// NewColumnEncryptor returns a new ColumnEncryptor with the given master key.
+func NewColumnEncryptor(key []byte) *ColumnEncryptor {
+	return &ColumnEncryptor{
+		key:     append([]byte(nil), key...),
+		columns: make(map[string]EncryptionMode),
+	}
+}
+
+func columnName(table, column string) string {
+	return strings.ToLower(table) + "." + strings.ToLower(column)
+}
+
+// AddColumn marks the column of table as encrypted with the given mode.
+func (e *ColumnEncryptor) AddColumn(table, column string, mode EncryptionMode) {
+	e.Lock()
+	defer e.Unlock()
+	e.columns[columnName(table, column)] = mode
+}
+
+// RemoveColumn unmarks the encrypted column.
+func (e *ColumnEncryptor) RemoveColumn(table, column string) {
+	e.Lock()
+	defer e.Unlock()
+	delete(e.columns, columnName(table, column))
+}
+
+// GetMode returns the encryption mode of the column, ok is false if the column is not
+// encrypted.
+func (e *ColumnEncryptor) GetMode(table, column string) (mode EncryptionMode, ok bool) {
+	e.RLock()
+	defer e.RUnlock()
+	mode, ok = e.columns[columnName(table, column)]
+	return
+}
+
+func (e *ColumnEncryptor) columnKey(table, column string) []byte {
+	return hash.DoubleHashB(append(append([]byte(nil), e.key...), columnName(table, column)...))
+}
+
+// Encrypt encrypts value if the column is encrypted, otherwise value is returned as is.
+func (e *ColumnEncryptor) Encrypt(table, column string, value []byte) ([]byte, error) {
+	mode, ok := e.GetMode(table, column)
+
+	if !ok {
+		return value, nil
+	}
+
+	if mode == Deterministic {
+		return symmetric.EncryptWithPasswordDeterministic(value, e.columnKey(table, column))
+	}
+
+	return symmetric.EncryptWithPassword(append([]byte(nil), value...), e.columnKey(table, column))
+}
+
+// Decrypt decrypts value if the column is encrypted, otherwise value is returned as is.
+func (e *ColumnEncryptor) Decrypt(table, column string, value []byte) ([]byte, error) {
+	mode, ok := e.GetMode(table, column)
+
+	if !ok {
+		return value, nil
+	}
+
+	if mode == Deterministic {
+		return symmetric.DecryptWithPasswordDeterministic(value, e.columnKey(table, column))
+	}
+
+	return symmetric.DecryptWithPassword(value, e.columnKey(table, column))
+}
+
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// Literal formats value as a SQL literal of the column, encrypted columns are formatted as
+// blob literals of the cipher data.
+func (e *ColumnEncryptor) Literal(table, column string, value interface{}) (string, error) {
+	if value == nil {
+		return "NULL", nil
+	}
+
+	var raw []byte
+
+	switch v := value.(type) {
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	case int:
+		raw = []byte(strconv.FormatInt(int64(v), 10))
+	case int64:
+		raw = []byte(strconv.FormatInt(v, 10))
+	case uint64:
+		raw = []byte(strconv.FormatUint(v, 10))
+	case float64:
+		raw = []byte(strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		raw = []byte(strconv.FormatBool(v))
+	default:
+		return "", ErrValueType
+	}
+
+	if _, ok := e.GetMode(table, column); ok {
+		enc, err := e.Encrypt(table, column, raw)
+
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("X'%s'", hex.EncodeToString(enc)), nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return quote(v), nil
+	case []byte:
+		return fmt.Sprintf("X'%s'", hex.EncodeToString(v)), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+
+		return "0", nil
+	default:
+		return string(raw), nil
+	}
+}
+
+// InsertQuery builds an INSERT statement with the values of the encrypted columns encrypted.
+func (e *ColumnEncryptor) InsertQuery(table string, columns []string, values []interface{}) (
+	string, error) {
+	if len(columns) != len(values) {
+		return "", ErrColumnCount
+	}
+
+	names := make([]string, len(columns))
+	literals := make([]string, len(values))
+
+	for i, c := range columns {
+		l, err := e.Literal(table, c, values[i])
+
+		if err != nil {
+			return "", err
+		}
+
+		names[i] = quoteIdent(c)
+		literals[i] = l
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(table), strings.Join(names, ", "), strings.Join(literals, ", ")), nil
+}
+
+// EqualPredicate builds a predicate which matches the column with value. It returns
+// ErrNotDeterministic if the column is encrypted in randomized mode.
+func (e *ColumnEncryptor) EqualPredicate(table, column string, value interface{}) (
+	string, error) {
+	if mode, ok := e.GetMode(table, column); ok && mode != Deterministic {
+		return "", ErrNotDeterministic
+	}
+
+	if value == nil {
+		return fmt.Sprintf("%s IS NULL", quoteIdent(column)), nil
+	}
+
+	l, err := e.Literal(table, column, value)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s = %s", quoteIdent(column), l), nil
+}
+
+// quoteIdent quotes the table or column name with backticks, the backticks in the name are
+// doubled so that the name can't end the quoted identifier.
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/sqlchain/storage"
+)
+
+func TestColumnEncryptor(t *testing.T) {
+	e := NewColumnEncryptor([]byte("master-key"))
+	e.AddColumn("users", "name", Deterministic)
+	e.AddColumn("users", "secret", Randomized)
+
+	// Deterministic column
+	enc1, err := e.Encrypt("users", "name", []byte("alice"))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	enc2, err := e.Encrypt("USERS", "Name", []byte("alice"))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !bytes.Equal(enc1, enc2) {
+		t.Fatal("Unexpected result: deterministic encryption produced different cipher data")
+	}
+
+	// Randomized column
+	enc3, err := e.Encrypt("users", "secret", []byte("alice"))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	enc4, err := e.Encrypt("users", "secret", []byte("alice"))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if bytes.Equal(enc3, enc4) {
+		t.Fatal("Unexpected result: randomized encryption produced same cipher data")
+	}
+
+	if dec, err := e.Decrypt("users", "secret", enc3); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	} else if string(dec) != "alice" {
+		t.Fatalf("Unexpected result: %s", dec)
+	}
+
+	// Columns use different keys
+	if dec, err := e.Decrypt("users", "secret", enc1); err == nil && string(dec) == "alice" {
+		t.Fatal("Unexpected result: columns share the same key")
+	}
+
+	// Plain column
+	if enc, err := e.Encrypt("users", "age", []byte("42")); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	} else if string(enc) != "42" {
+		t.Fatalf("Unexpected result: %s", enc)
+	}
+
+	if _, err = e.EqualPredicate("users", "secret", "alice"); err != ErrNotDeterministic {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	if _, err = e.InsertQuery("users", []string{"name"}, nil); err != ErrColumnCount {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	if _, err = e.Literal("users", "name", struct{}{}); err != ErrValueType {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	// Backticks in the names can't end the quoted identifiers
+	q, err := e.InsertQuery("a`b", []string{"c`d"}, []interface{}{1})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if q != "INSERT INTO `a``b` (`c``d`) VALUES (1)" {
+		t.Fatalf("Unexpected result: %s", q)
+	}
+
+	if q, err = e.EqualPredicate("users", "x` = 1 OR `y", nil); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if q != "`x`` = 1 OR ``y` IS NULL" {
+		t.Fatalf("Unexpected result: %s", q)
+	}
+}
+
+func TestEncryptedExecLog(t *testing.T) {
+	fl, err := ioutil.TempFile("", "sqlite3-")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	st, err := storage.New(fmt.Sprintf("file:%s", fl.Name()))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	e := NewColumnEncryptor([]byte("master-key"))
+	e.AddColumn("users", "name", Deterministic)
+	e.AddColumn("users", "secret", Randomized)
+
+	queries := []string{
+		"CREATE TABLE `users` (`id` INTEGER PRIMARY KEY, `name` BLOB, `secret` BLOB)",
+	}
+
+	for i, n := range []string{"alice", "bob", "o'neil"} {
+		q, err := e.InsertQuery("users", []string{"id", "name", "secret"},
+			[]interface{}{i, n, "secret of " + n})
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if bytes.Contains([]byte(q), []byte(n)) {
+			t.Fatalf("Unexpected result: plaintext found in query: %s", q)
+		}
+
+		queries = append(queries, q)
+	}
+
+	el := &storage.ExecLog{
+		ConnectionID: 1,
+		SeqNo:        1,
+		Timestamp:    uint64(time.Now().Unix()),
+		Queries:      queries,
+	}
+
+	if err = st.Prepare(context.Background(), el); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = st.Commit(context.Background(), el); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Lookup by the deterministic column.
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL", fl.Name()))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer db.Close()
+	p, err := e.EqualPredicate("users", "name", "o'neil")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	var id int
+	var secret []byte
+
+	if err = db.QueryRow("SELECT `id`, `secret` FROM `users` WHERE "+p).Scan(
+		&id, &secret); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if id != 2 {
+		t.Fatalf("Unexpected result: %d", id)
+	}
+
+	dec, err := e.Decrypt("users", "secret", secret)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if string(dec) != "secret of o'neil" {
+		t.Fatalf("Unexpected result: %s", dec)
+	}
+}
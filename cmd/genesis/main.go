@@ -0,0 +1,182 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/blockproducer"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	mine "github.com/thunderdb/ThunderDB/pow/cpuminer"
+	"github.com/thunderdb/ThunderDB/proto"
+)
+
+var (
+	version = "unknown"
+)
+
+var (
+	privateKeyPath string
+	templatePath   string
+	bpAddr         string
+	bpNonce        string
+	accounts       string
+	queryPrice     uint64
+	stake          string
+	maxFailures    uint
+	turnTimeout    string
+)
+
+const name = `genesis`
+const desc = `genesis generates and signs the genesis file of ThunderDB main chain`
+
+func init() {
+	flag.StringVar(&privateKeyPath, "private-key-path", "./private.key", "Path to private key file of the first block producer")
+	flag.StringVar(&templatePath, "template", "", "Path to an unsigned genesis file to sign, the block producer and account flags are ignored if set")
+	flag.StringVar(&bpAddr, "bp-addr", "127.0.0.1:2120", "Address of the block producer")
+	flag.StringVar(&bpNonce, "bp-nonce", "", `Nonce of the block producer mined by idminer, e.g. "A:B:C:D", a new one is mined if empty`)
+	flag.StringVar(&accounts, "accounts", "", `Initial account balances, e.g. "addr1=100,addr2=200"`)
+	flag.Uint64Var(&queryPrice, "query-price", 1, "Amount paid to the miners for each query")
+	flag.StringVar(&stake, "stake-per-replica", "", "Stake required for a miner to host each database replica, not limited if empty")
+	flag.UintVar(&maxFailures, "max-failures", 3, "Number of reported failures before a miner is no longer assigned any replica, not limited if 0")
+	flag.StringVar(&turnTimeout, "turn-timeout", "10s", "Duration before the turn to produce a block passes to the next block producer, never passes if empty")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n%s\n\n", desc)
+		fmt.Fprintf(os.Stderr, "Usage: %s [arguments] <genesis file>\n", name)
+		flag.PrintDefaults()
+	}
+}
+
+func parseNonce(s string) (nonce mine.Uint256, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 4 {
+		err = fmt.Errorf("invalid nonce: %s", s)
+		return
+	}
+	fields := []*uint64{&nonce.A, &nonce.B, &nonce.C, &nonce.D}
+	for i, p := range parts {
+		if *fields[i], err = strconv.ParseUint(p, 10, 64); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func parseAccounts(s string) (accounts []*blockproducer.GenesisAccount, err error) {
+	if s == "" {
+		return
+	}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			err = fmt.Errorf("invalid account: %s", item)
+			return
+		}
+		accounts = append(accounts, &blockproducer.GenesisAccount{
+			Address: proto.AccountAddress(parts[0]),
+			Balance: parts[1],
+		})
+	}
+	return
+}
+
+// newGenesis generates a genesis with a single block producer of the given public key
+func newGenesis(pubKey *asymmetric.PublicKey) (g *blockproducer.Genesis, err error) {
+	var nonce mine.Uint256
+	if bpNonce != "" {
+		if nonce, err = parseNonce(bpNonce); err != nil {
+			return
+		}
+	} else {
+		log.Info("mining nonce for the block producer public key")
+		nonce = asymmetric.GetPubKeyNonce(pubKey, proto.NewNodeIDDifficulty,
+			proto.NewNodeIDDifficultyTimeout, nil).Nonce
+	}
+
+	g = &blockproducer.Genesis{
+		Timestamp: time.Now().UTC(),
+		BlockProducers: []*blockproducer.GenesisBP{
+			{
+				NodeID:    proto.NodeID(mine.HashBlock(pubKey.Serialize(), nonce).String()),
+				PublicKey: hex.EncodeToString(pubKey.Serialize()),
+				Nonce:     nonce,
+				Addr:      bpAddr,
+			},
+		},
+		Params: blockproducer.GenesisParams{
+			QueryPrice:      queryPrice,
+			StakePerReplica: stake,
+			MaxFailures:     uint32(maxFailures),
+			TurnTimeout:     turnTimeout,
+		},
+	}
+	g.Accounts, err = parseAccounts(accounts)
+	return
+}
+
+func main() {
+	flag.Parse()
+	log.Infof("genesis build: %s", version)
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// read master key
+	fmt.Print("Type in Master key to continue: ")
+	masterKeyBytes, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		fmt.Printf("Failed to read Master Key: %v", err)
+	}
+	fmt.Println("")
+
+	privateKey, err := kms.LoadPrivateKey(privateKeyPath, masterKeyBytes)
+	if err != nil {
+		log.Fatalf("load private key failed: %s", err)
+	}
+
+	var g *blockproducer.Genesis
+	if templatePath != "" {
+		g, err = blockproducer.LoadGenesis(templatePath)
+	} else {
+		g, err = newGenesis(privateKey.PubKey())
+	}
+	if err != nil {
+		log.Fatalf("generate genesis failed: %s", err)
+	}
+
+	if err = g.Sign(privateKey); err != nil {
+		log.Fatalf("sign genesis failed: %s", err)
+	}
+	if err = g.Save(flag.Arg(0)); err != nil {
+		log.Fatalf("save genesis failed: %s", err)
+	}
+
+	log.Infof("genesis saved to %s, block producer: %s", flag.Arg(0), g.BlockProducers[0].NodeID)
+}
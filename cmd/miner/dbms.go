@@ -0,0 +1,389 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/route"
+	"github.com/thunderdb/ThunderDB/sqlchain"
+	"github.com/thunderdb/ThunderDB/sqlchain/storage"
+)
+
+const (
+	// dbmsServiceName is the name of the RPC service deploying databases to this miner.
+	dbmsServiceName = "DBS"
+
+	genesisFileName = "genesis"
+	peersFileName   = "peers"
+	chainFileName   = "chain.db"
+	storageFileName = "storage.db"
+
+	// twoPCTimeout bounds every phase of the replication of a log.
+	twoPCTimeout = 10 * time.Second
+)
+
+var (
+	// errNotBlockProducer indicates that a database is deployed by a node other than the block
+	// producer.
+	errNotBlockProducer = errors.New("databases can only be deployed by the block producer")
+	// errNotInPeers indicates that the miner is not a replica of the deployed database.
+	errNotInPeers = errors.New("miner is not a peer of the database")
+	// errDatabaseExists indicates that the database is already hosted by this miner.
+	errDatabaseExists = errors.New("database already exists")
+)
+
+// DeployReq is the request of the DBS.Deploy RPC method.
+type DeployReq struct {
+	proto.Envelope
+	DatabaseID string
+	Genesis    []byte
+	Peers      []byte
+}
+
+// DeployResp is the response of the DBS.Deploy RPC method.
+type DeployResp struct {
+	Msg string
+}
+
+// database is a database hosted by the miner: the replicated storage, its sql-chain, the block
+// producer packing the committed logs into the chain and the billing submitter settling the usage
+// of the chain onto the main chain.
+type database struct {
+	storage   *storage.Storage
+	runtime   *kayak.Runtime
+	transport *rpcTransport
+	producer  *sqlchain.Producer
+	billing   *sqlchain.BillingSubmitter
+}
+
+// dbms hosts the databases deployed to the miner, each one in its own directory under RootDir.
+type dbms struct {
+	rootDir     string
+	nodeID      proto.NodeID
+	blockPeriod time.Duration
+	billing     *billingConfig
+	chains      *sqlchain.ChainRPCService
+	kayak       *kayakService
+
+	mu  sync.Mutex
+	dbs map[string]*database
+}
+
+// billingConfig is the config shared by the billing submitters of the hosted databases.
+type billingConfig struct {
+	period time.Duration
+	fee    uint64
+}
+
+func newDBMS(rootDir string, nodeID proto.NodeID, blockPeriod time.Duration,
+	billing *billingConfig, chains *sqlchain.ChainRPCService, kayakService *kayakService) *dbms {
+	return &dbms{
+		rootDir:     rootDir,
+		nodeID:      nodeID,
+		blockPeriod: blockPeriod,
+		billing:     billing,
+		chains:      chains,
+		kayak:       kayakService,
+		dbs:         make(map[string]*database),
+	}
+}
+
+// load hosts the databases deployed before the miner restarted.
+func (d *dbms) load() (err error) {
+	if err = os.MkdirAll(d.rootDir, 0700); err != nil {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(d.rootDir)
+
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(d.rootDir, e.Name())
+		genesis, peers, err := readDeployment(dir)
+
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("read database %s: %v", e.Name(), err)
+		}
+
+		if err = d.host(e.Name(), genesis, peers); err != nil {
+			return fmt.Errorf("host database %s: %v", e.Name(), err)
+		}
+	}
+
+	return
+}
+
+// Deploy RPC hosts a new database on the miner, the deployment must be sent by the block producer
+// and list the miner in the peers of the database.
+func (d *dbms) Deploy(req *DeployReq, resp *DeployResp) (err error) {
+	if req.GetNodeID() == nil || !route.IsBPNodeID(req.GetNodeID()) {
+		return errNotBlockProducer
+	}
+
+	if req.DatabaseID == "" || filepath.Base(req.DatabaseID) != req.DatabaseID {
+		return errUnknownDatabase
+	}
+
+	d.mu.Lock()
+	_, exists := d.dbs[req.DatabaseID]
+	d.mu.Unlock()
+
+	if exists {
+		return errDatabaseExists
+	}
+
+	genesis := &sqlchain.Block{}
+
+	if err = genesis.UnmarshalBinary(req.Genesis); err != nil {
+		return
+	}
+
+	peers := &kayak.Peers{}
+
+	if err = peers.UnmarshalBinary(req.Peers); err != nil {
+		return
+	}
+
+	dir := filepath.Join(d.rootDir, req.DatabaseID)
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, genesisFileName), req.Genesis, 0600); err != nil {
+		return
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, peersFileName), req.Peers, 0600); err != nil {
+		return
+	}
+
+	return d.host(req.DatabaseID, genesis, peers)
+}
+
+// host opens the storage and the sql-chain of the database, and starts its kayak runtime, block
+// producer and billing submitter.
+func (d *dbms) host(id string, genesis *sqlchain.Block, peers *kayak.Peers) (err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.dbs[id]; ok {
+		return errDatabaseExists
+	}
+
+	inPeers := false
+
+	for _, s := range peers.Servers {
+		if s.ID == d.nodeID {
+			inPeers = true
+		}
+	}
+
+	if !inPeers {
+		return errNotInPeers
+	}
+
+	dir := filepath.Join(d.rootDir, id)
+
+	// The storage and the kayak logs are encrypted by keys only known to this miner
+	key, err := storage.DeriveLocalKey(id)
+
+	if err != nil {
+		return
+	}
+
+	db := &database{transport: newRPCTransport(id)}
+	db.storage, err = storage.NewEncrypted(
+		fmt.Sprintf("file:%s", filepath.Join(dir, storageFileName)), key)
+
+	if err != nil {
+		return
+	}
+
+	db.runtime, err = kayak.NewRuntime(&kayak.TwoPCConfig{
+		RuntimeConfig: kayak.RuntimeConfig{
+			RootDir:        dir,
+			LocalID:        d.nodeID,
+			Runner:         kayak.NewTwoPCRunner(),
+			Transport:      db.transport,
+			ProcessTimeout: twoPCTimeout,
+			EncryptionKey:  key,
+		},
+		LogCodec:        &storage.ExecLogCodec{},
+		Storage:         db.storage,
+		PrepareTimeout:  twoPCTimeout,
+		CommitTimeout:   twoPCTimeout,
+		RollbackTimeout: twoPCTimeout,
+	}, peers)
+
+	if err != nil {
+		db.storage.Close()
+		return
+	}
+
+	d.kayak.addTransport(db.transport)
+
+	if err = db.runtime.Init(); err != nil {
+		d.kayak.removeTransport(id)
+		db.storage.Close()
+		return
+	}
+
+	chain, err := openChain(&sqlchain.Config{
+		DataDir: filepath.Join(dir, chainFileName),
+		Genesis: genesis,
+	})
+
+	if err == nil {
+		db.producer, err = sqlchain.NewProducer(&sqlchain.ProducerConfig{
+			Chain:       chain,
+			LogStore:    db.runtime.LogStore(),
+			StableStore: db.runtime.LogStore(),
+			LogCodec:    &storage.ExecLogCodec{},
+			Broadcaster: &sqlchain.RPCBroadcaster{
+				DatabaseID: id,
+				LocalID:    d.nodeID,
+				Peers:      peers,
+			},
+			Leadership: db.runtime,
+			Period:     d.blockPeriod,
+		})
+	}
+
+	if err == nil {
+		db.billing, err = d.newBillingSubmitter(id, chain)
+	}
+
+	if err == nil {
+		err = db.producer.Start()
+	}
+
+	if err == nil {
+		if err = db.billing.Start(); err != nil {
+			db.producer.Stop()
+		}
+	}
+
+	if err != nil {
+		d.kayak.removeTransport(id)
+		db.runtime.Shutdown()
+		db.storage.Close()
+		return
+	}
+
+	d.chains.AddChain(id, chain, peers)
+	d.dbs[id] = db
+	log.Infof("hosting database %s", id)
+	return
+}
+
+// shutdown stops all the hosted databases.
+func (d *dbms) shutdown() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id, db := range d.dbs {
+		db.billing.Stop()
+		db.producer.Stop()
+		d.chains.RemoveChain(id)
+		d.kayak.removeTransport(id)
+		db.transport.close()
+
+		if err := db.runtime.Shutdown(); err != nil {
+			log.Errorf("shutdown kayak runtime of database %s: %v", id, err)
+		}
+
+		if err := db.storage.Close(); err != nil {
+			log.Errorf("close storage of database %s: %v", id, err)
+		}
+
+		delete(d.dbs, id)
+	}
+}
+
+// newBillingSubmitter creates the billing submitter of the hosted database, which submits the
+// billing txs to the block producer from the account of the local node key.
+func (d *dbms) newBillingSubmitter(id string, chain *sqlchain.Chain) (
+	*sqlchain.BillingSubmitter, error) {
+	priv, err := kms.GetLocalPrivateKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlchain.NewBillingSubmitter(&sqlchain.BillingConfig{
+		Chain:         chain,
+		DatabaseID:    id,
+		BlockProducer: proto.NodeID(kms.BPNodeID),
+		Account:       priv,
+		Fee:           d.billing.fee,
+		Period:        d.billing.period,
+	})
+}
+
+// openChain loads the sql-chain of the database, or creates it from the genesis block on the
+// first run.
+func openChain(cfg *sqlchain.Config) (*sqlchain.Chain, error) {
+	if _, err := os.Stat(cfg.DataDir); err == nil {
+		return sqlchain.LoadChain(cfg)
+	}
+
+	return sqlchain.NewChain(cfg)
+}
+
+// readDeployment reads the genesis block and peers persisted by Deploy.
+func readDeployment(dir string) (genesis *sqlchain.Block, peers *kayak.Peers, err error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, genesisFileName))
+
+	if err != nil {
+		return
+	}
+
+	genesis = &sqlchain.Block{}
+
+	if err = genesis.UnmarshalBinary(b); err != nil {
+		return
+	}
+
+	if b, err = ioutil.ReadFile(filepath.Join(dir, peersFileName)); err != nil {
+		return
+	}
+
+	peers = &kayak.Peers{}
+	err = peers.UnmarshalBinary(b)
+	return
+}
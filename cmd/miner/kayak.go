@@ -0,0 +1,230 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/rpc"
+)
+
+const (
+	// kayakServiceName is the name of the RPC service serving the kayak requests between the
+	// replicas of the hosted databases.
+	kayakServiceName = "Kayak"
+)
+
+var (
+	// errUnknownDatabase indicates that the database is not hosted by this miner.
+	errUnknownDatabase = errors.New("unknown database")
+	// errUnknownCaller indicates that the RPC caller is not authenticated by the crypto layer.
+	errUnknownCaller = errors.New("unknown caller")
+)
+
+// kayakReq is the request of the Kayak.Call RPC method. The payload is JSON encoded, which is
+// what the 2PC runner decodes the log and log index from.
+type kayakReq struct {
+	proto.Envelope
+	DatabaseID string
+	Method     string
+	Payload    []byte
+}
+
+// kayakResp is the response of the Kayak.Call RPC method.
+type kayakResp struct{}
+
+// kayakService dispatches the kayak requests to the transports of the hosted databases.
+type kayakService struct {
+	mu         sync.RWMutex
+	transports map[string]*rpcTransport
+}
+
+func newKayakService() *kayakService {
+	return &kayakService{
+		transports: make(map[string]*rpcTransport),
+	}
+}
+
+func (s *kayakService) addTransport(t *rpcTransport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transports[t.databaseID] = t
+}
+
+func (s *kayakService) removeTransport(databaseID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.transports, databaseID)
+}
+
+// Call hands off the request to the transport of the database and waits for the response of the
+// runner.
+func (s *kayakService) Call(req *kayakReq, resp *kayakResp) (err error) {
+	if req.GetNodeID() == nil {
+		return errUnknownCaller
+	}
+
+	s.mu.RLock()
+	t := s.transports[req.DatabaseID]
+	s.mu.RUnlock()
+
+	if t == nil {
+		return errUnknownDatabase
+	}
+
+	r := &rpcRequest{
+		nodeID: proto.NodeID(req.GetNodeID().Hash.String()),
+		method: req.Method,
+		respCh: make(chan error, 1),
+	}
+
+	if err = json.Unmarshal(req.Payload, &r.payload); err != nil {
+		return
+	}
+
+	select {
+	case t.queue <- r:
+	case <-t.stopCh:
+		return errUnknownDatabase
+	}
+
+	select {
+	case err = <-r.respCh:
+	case <-t.stopCh:
+		err = errUnknownDatabase
+	}
+
+	return
+}
+
+// rpcRequest implements kayak.Request for the requests received by kayakService.
+type rpcRequest struct {
+	nodeID  proto.NodeID
+	method  string
+	payload interface{}
+	once    sync.Once
+	respCh  chan error
+}
+
+// GetNodeID implements kayak.Request.GetNodeID.
+func (r *rpcRequest) GetNodeID() proto.NodeID {
+	return r.nodeID
+}
+
+// GetMethod implements kayak.Request.GetMethod.
+func (r *rpcRequest) GetMethod() string {
+	return r.method
+}
+
+// GetRequest implements kayak.Request.GetRequest.
+func (r *rpcRequest) GetRequest() interface{} {
+	return r.payload
+}
+
+// SendResponse implements kayak.Request.SendResponse, the response value is dropped since the
+// runner only reports errors to its callers.
+func (r *rpcRequest) SendResponse(resp interface{}, err error) error {
+	sent := false
+	r.once.Do(func() {
+		r.respCh <- err
+		sent = true
+	})
+
+	if !sent {
+		return kayak.ErrInvalidRequest
+	}
+
+	return nil
+}
+
+// rpcTransport implements kayak.Transport for a hosted database on the RPC server.
+type rpcTransport struct {
+	databaseID string
+	queue      chan kayak.Request
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+}
+
+func newRPCTransport(databaseID string) *rpcTransport {
+	return &rpcTransport{
+		databaseID: databaseID,
+		queue:      make(chan kayak.Request, 100),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Request implements kayak.Transport.Request.
+func (t *rpcTransport) Request(ctx context.Context, nodeID proto.NodeID, method string,
+	args interface{}) (interface{}, error) {
+	payload, err := json.Marshal(args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req := &kayakReq{
+		DatabaseID: t.databaseID,
+		Method:     method,
+		Payload:    payload,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- callNode(nodeID, kayakServiceName+".Call", req, &kayakResp{})
+	}()
+
+	select {
+	case err = <-errCh:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Process implements kayak.Transport.Process.
+func (t *rpcTransport) Process() <-chan kayak.Request {
+	return t.queue
+}
+
+func (t *rpcTransport) close() {
+	t.stopOnce.Do(func() {
+		close(t.stopCh)
+	})
+}
+
+// callNode calls the RPC method of the given node.
+func callNode(nodeID proto.NodeID, method string, args, reply interface{}) (err error) {
+	conn, err := rpc.DailToNode(nodeID)
+
+	if err != nil {
+		return
+	}
+
+	client, err := rpc.InitClientConn(conn)
+
+	if err != nil {
+		return
+	}
+
+	defer client.Close()
+	return client.Call(method, args, reply)
+}
@@ -17,17 +17,26 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"math/rand"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
+	"golang.org/x/crypto/ssh/terminal"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/thunderdb/ThunderDB/common"
 	"github.com/thunderdb/ThunderDB/conf"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	mine "github.com/thunderdb/ThunderDB/pow/cpuminer"
+	"github.com/thunderdb/ThunderDB/proto"
 	"github.com/thunderdb/ThunderDB/rpc"
+	"github.com/thunderdb/ThunderDB/sqlchain"
 	"github.com/thunderdb/ThunderDB/utils"
 )
 
@@ -81,6 +90,16 @@ var (
 	cpuProfile string
 	memProfile string
 
+	// key path
+	privateKeyPath     string
+	publicKeyStorePath string
+	nodeNonce          string
+
+	// sql-chain
+	blockPeriod   time.Duration
+	billingPeriod time.Duration
+	billingFee    uint64
+
 	// other
 	noLogo      bool
 	showVersion bool
@@ -115,6 +134,12 @@ func init() {
 	flag.StringVar(&cpuProfile, "cpu-profile", "", "Path to file for CPU profiling information")
 	flag.StringVar(&memProfile, "mem-profile", "", "Path to file for memory profiling information")
 	flag.StringVar(&initPeers, "init-peers", "", "Init peers to join")
+	flag.StringVar(&privateKeyPath, "private-key-path", "./private.key", "Path to private key file")
+	flag.StringVar(&publicKeyStorePath, "public-keystore-path", "./public.keystore", "Path to public keystore file")
+	flag.StringVar(&nodeNonce, "nonce", "", "Hex encoded nonce of the node id mined by idminer")
+	flag.DurationVar(&blockPeriod, "block-period", time.Minute, "Period to produce sql-chain blocks")
+	flag.DurationVar(&billingPeriod, "billing-period", time.Hour, "Period to bill the sql-chain usage")
+	flag.Uint64Var(&billingFee, "billing-fee", 0, "Fee of the billing txs")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "\n%s\n\n", desc)
 		fmt.Fprintf(os.Stderr, "Usage: %s [arguments] <data directory>\n", name)
@@ -138,7 +163,7 @@ func main() {
 	initLogs()
 
 	if showVersion {
-		log.Info("%s %s %s %s %s (commit %s, branch %s)",
+		log.Infof("%s %s %s %s %s (commit %s, branch %s)",
 			name, version, runtime.GOOS, runtime.GOARCH, runtime.Version(), commit, branch)
 		os.Exit(0)
 	}
@@ -168,5 +193,98 @@ func main() {
 	utils.StartProfile(cpuProfile, memProfile)
 	defer utils.StopProfile()
 
+	// read master key
+	fmt.Print("Type in Master key to continue: ")
+	masterKeyBytes, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		fmt.Printf("Failed to read Master Key: %v", err)
+	}
+	fmt.Println("")
+
+	// start RPC server
+	rpcServer = rpc.NewServer()
+
+	// if any error, log.Fatal will call os.Exit
+	err = rpcServer.InitRPCServer(fmt.Sprintf("%s:%d", bindAddr, minPort), privateKeyPath,
+		masterKeyBytes)
+	if err != nil {
+		log.Fatalf("rpcServer.InitRPCServer failed: %s", err)
+	}
+
+	nodeID, err := initNodeID(nodeNonce)
+	if err != nil {
+		log.Fatalf("init node id failed: %s", err)
+	}
+
+	err = kms.InitPublicKeyStore(publicKeyStorePath, nil)
+	if err != nil {
+		log.Fatalf("init public keystore failed: %s", err)
+	}
+
+	// The answers of the local node are billed by its key in the keystore
+	if err = registerLocalKey(nodeID); err != nil {
+		log.Fatalf("register local public key failed: %s", err)
+	}
+
+	// host the deployed databases and their sql-chains
+	chains := sqlchain.NewChainRPCService()
+	kayakService := newKayakService()
+	dbms := newDBMS(flag.Arg(0), nodeID, blockPeriod, &billingConfig{
+		period: billingPeriod,
+		fee:    billingFee,
+	}, chains, kayakService)
+
+	if err = dbms.load(); err != nil {
+		log.Fatalf("load databases failed: %s", err)
+	}
+	defer dbms.shutdown()
+
+	rpcServer.RegisterService(sqlchain.ChainRPCServiceName, chains)
+	rpcServer.RegisterService(kayakServiceName, kayakService)
+	rpcServer.RegisterService(dbmsServiceName, dbms)
+	go rpcServer.Serve()
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	<-signalCh
+
+	rpcServer.Stop()
 	log.Info("server stopped")
 }
+
+// initNodeID sets the local node id which is mined by idminer from the local public key and the
+// given nonce.
+func registerLocalKey(nodeID proto.NodeID) (err error) {
+	publicKey, err := kms.GetLocalPublicKey()
+	if err != nil {
+		return
+	}
+
+	nonce, err := kms.GetLocalNonce()
+	if err != nil {
+		return
+	}
+
+	return kms.SetPublicKey(nodeID, *nonce, publicKey)
+}
+
+func initNodeID(nonceHex string) (nodeID proto.NodeID, err error) {
+	b, err := hex.DecodeString(nonceHex)
+	if err != nil {
+		return
+	}
+
+	nonce, err := mine.FromBytes(b)
+	if err != nil {
+		return
+	}
+
+	publicKey, err := kms.GetLocalPublicKey()
+	if err != nil {
+		return
+	}
+
+	h := mine.HashBlock(publicKey.Serialize(), *nonce)
+	kms.SetLocalNodeIDNonce(h[:], nonce)
+	return proto.NodeID(h.String()), nil
+}
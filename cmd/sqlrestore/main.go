@@ -0,0 +1,101 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/sqlchain"
+	"github.com/thunderdb/ThunderDB/sqlchain/storage"
+)
+
+var (
+	version = "unknown"
+)
+
+var (
+	chainPath          string
+	publicKeyStorePath string
+	dsn                string
+	fromHeight         int
+	toHeight           int
+)
+
+const name = `sqlrestore`
+const desc = `sqlrestore rebuilds a database as of any block height by replaying its SQL chain`
+
+func init() {
+	flag.StringVar(&chainPath, "chain", "", "Path to the SQL chain database file")
+	flag.StringVar(&publicKeyStorePath, "public-keystore-path", "./public.keystore",
+		"Path to public keystore file")
+	flag.StringVar(&dsn, "dsn", "", "SQLite DSN of the target database")
+	flag.IntVar(&fromHeight, "from", 0,
+		"Height to replay from, the target database should hold the snapshot of height from-1")
+	flag.IntVar(&toHeight, "height", -1, "Height to restore to, -1 for the best chain head")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\n%s\n\n", desc)
+		fmt.Fprintf(os.Stderr, "Usage: %s [arguments]\n", name)
+		flag.PrintDefaults()
+	}
+}
+
+func main() {
+	flag.Parse()
+	log.Infof("%s build: %s", name, version)
+
+	if chainPath == "" || dsn == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := kms.InitPublicKeyStore(publicKeyStorePath, nil); err != nil {
+		log.Fatalf("error initializing public keystore: %s", err)
+	}
+
+	chain, err := sqlchain.LoadChain(&sqlchain.Config{DataDir: chainPath})
+
+	if err != nil {
+		log.Fatalf("error loading chain: %s", err)
+	}
+
+	if toHeight < 0 {
+		toHeight = int(chain.Head().Height)
+	}
+
+	if fromHeight == 0 {
+		err = chain.RestoreTo(dsn, int32(toHeight))
+	} else {
+		var st *storage.Storage
+
+		if st, err = storage.New(dsn); err != nil {
+			log.Fatalf("error opening database: %s", err)
+		}
+
+		err = chain.Restore(st, int32(fromHeight), int32(toHeight))
+		st.Close()
+	}
+
+	if err != nil {
+		log.Fatalf("error restoring database: %s", err)
+	}
+
+	log.Infof("database restored to height %d", toHeight)
+}
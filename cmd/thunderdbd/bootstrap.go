@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/thunderdb/ThunderDB/blockproducer"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/route"
+)
+
+const mainChainFile = "mainchain.db"
+
+var (
+	// errGenesisMismatch indicates the main chain in data directory is not created from the genesis
+	errGenesisMismatch = errors.New("main chain genesis block mismatch")
+)
+
+// initGenesis loads the genesis file and replaces the compiled-in Block Producer with the block
+// producers of it
+func initGenesis(path string) (genesis *blockproducer.Genesis, nodes []*proto.Node, err error) {
+	if genesis, err = blockproducer.LoadGenesis(path); err != nil {
+		return
+	}
+	if nodes, err = genesis.Nodes(); err != nil {
+		return
+	}
+	if err = kms.SetBPNode(nodes[0]); err != nil {
+		return
+	}
+
+	addrs := make([]string, len(nodes))
+	for i, node := range nodes {
+		addrs[i] = node.Addr
+	}
+	route.SetBPAddr(addrs)
+	return
+}
+
+// registerBPNodes saves the block producer nodes to the public key store, it should be called
+// after the public key store is initialized
+func registerBPNodes(nodes []*proto.Node) (err error) {
+	for _, node := range nodes {
+		if err = kms.SetNode(node); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// initMainChain opens the main chain in dataDir, it's created from the genesis on first start
+func initMainChain(dataDir string, genesis *blockproducer.Genesis) (
+	chain *blockproducer.Chain, err error) {
+	if err = os.MkdirAll(dataDir, 0755); err != nil {
+		return
+	}
+	cfg, err := genesis.Config(filepath.Join(dataDir, mainChainFile))
+	if err != nil {
+		return
+	}
+
+	if _, err = os.Stat(cfg.DataDir); os.IsNotExist(err) {
+		return blockproducer.NewChain(cfg)
+	}
+	if chain, err = blockproducer.LoadChain(cfg); err != nil {
+		return
+	}
+
+	block, err := chain.GetBlockByHeight(0)
+	if err == nil && !block.Header.BlockHash.IsEqual(&cfg.Genesis.Header.BlockHash) {
+		err = errGenesisMismatch
+	}
+	if err != nil {
+		chain.Stop()
+		chain = nil
+	}
+	return
+}
@@ -28,6 +28,7 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/blockproducer"
 	"github.com/thunderdb/ThunderDB/common"
 	"github.com/thunderdb/ThunderDB/conf"
 	"github.com/thunderdb/ThunderDB/route"
@@ -70,6 +71,13 @@ var (
 	privateKeyPath     string
 	publicKeyStorePath string
 
+	// genesis
+	genesisPath string
+
+	// main chain
+	blockPeriod time.Duration
+	maxBlockTxs int
+
 	// other
 	noLogo      bool
 	showVersion bool
@@ -95,6 +103,9 @@ func init() {
 	flag.DurationVar(&publishPeersDelay, "publish-peers-delay", time.Second, "Interval for peers publishing retry")
 	flag.StringVar(&privateKeyPath, "private-key-path", "./private.key", "Path to private key file")
 	flag.StringVar(&publicKeyStorePath, "public-keystore-path", "./public.keystore", "Path to public keystore file")
+	flag.StringVar(&genesisPath, "genesis", "./genesis.json", "Path to genesis file, SEE: cmd/genesis for more")
+	flag.DurationVar(&blockPeriod, "block-period", time.Second*10, "Interval for main chain block producing and syncing")
+	flag.IntVar(&maxBlockTxs, "max-block-txs", 1000, "Maximum number of txs in a main chain block, not limited if 0")
 	flag.StringVar(&cpuProfile, "cpu-profile", "", "Path to file for CPU profiling information")
 	flag.StringVar(&memProfile, "mem-profile", "", "Path to file for memory profiling information")
 	flag.StringVar(&initPeers, "init-peers", "", "Init peers to join")
@@ -150,6 +161,12 @@ func main() {
 	}
 	fmt.Println("")
 
+	// load genesis, the block producers of it replace the compiled-in one
+	genesis, bpNodes, err := initGenesis(genesisPath)
+	if err != nil {
+		log.Fatalf("load genesis failed: %s", err)
+	}
+
 	// start RPC server
 	rpcServer := rpc.NewServer()
 
@@ -165,6 +182,40 @@ func main() {
 		log.Fatalf("creating dht service failed: %s", err)
 	}
 	rpcServer.RegisterService("DHT", dht)
+
+	if err = registerBPNodes(bpNodes[1:]); err != nil {
+		log.Fatalf("register block producers failed: %s", err)
+	}
+
+	// open main chain
+	chain, err := initMainChain(flag.Arg(0), genesis)
+	if err != nil {
+		log.Fatalf("init main chain failed: %s", err)
+	}
+	defer chain.Stop()
+
+	pool, err := blockproducer.NewTxPool(&blockproducer.TxPoolConfig{State: chain})
+	if err != nil {
+		log.Fatalf("creating tx pool failed: %s", err)
+	}
+	rpcServer.RegisterService(blockproducer.ChainRPCServiceName,
+		blockproducer.NewChainRPCService(chain, pool))
+
+	// produce main chain blocks in the turns of the local block producer, and sync the blocks and
+	// votes of the others
+	producer, err := blockproducer.NewProducer(&blockproducer.ProducerConfig{
+		Chain:  chain,
+		Pool:   pool,
+		Period: blockPeriod,
+		MaxTxs: maxBlockTxs,
+	})
+	if err != nil {
+		log.Fatalf("creating block producer failed: %s", err)
+	}
+	if err = producer.Start(); err != nil {
+		log.Fatalf("starting block producer failed: %s", err)
+	}
+	defer producer.Stop()
 	rpcServer.Serve()
 
 	log.Info("server stopped")
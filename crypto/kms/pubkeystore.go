@@ -17,6 +17,7 @@
 package kms
 
 import (
+	"encoding/hex"
 	"errors"
 
 	"sync"
@@ -81,6 +82,29 @@ func init() {
 	}
 }
 
+// SetBPNode replaces the compiled-in Block Producer identity, e.g. with the first block producer
+// of the genesis file. It should be called before the public key store is initialized
+func SetBPNode(node *proto.Node) (err error) {
+	if node == nil || node.PublicKey == nil {
+		return ErrNilNode
+	}
+	keyHash := mine.HashBlock(node.PublicKey.Serialize(), node.Nonce)
+	var rawID proto.RawNodeID
+	if err = hash.Decode(&rawID.Hash, string(node.ID)); err != nil {
+		return ErrNotValidNodeID
+	}
+	if !keyHash.IsEqual(&rawID.Hash) {
+		return ErrNodeIDKeyNonceNotMatch
+	}
+
+	BPNodeID = string(node.ID)
+	BPRawNodeID = rawID
+	BPPublicKeyStr = hex.EncodeToString(node.PublicKey.Serialize())
+	BPPublicKey = node.PublicKey
+	BPNonce = node.Nonce
+	return
+}
+
 var (
 	// ErrBucketNotInitialized indicates bucket not initialized
 	ErrBucketNotInitialized = errors.New("bucket not initialized")
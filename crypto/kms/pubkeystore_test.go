@@ -196,3 +196,38 @@ func TestMarshalNode(t *testing.T) {
 		So(reflect.DeepEqual(nodeDec, nodeInfo), ShouldBeTrue)
 	})
 }
+
+func TestSetBPNode(t *testing.T) {
+	Convey("set block producer node", t, func() {
+		origNodeID, origRawNodeID, origPublicKeyStr, origPublicKey, origNonce :=
+			BPNodeID, BPRawNodeID, BPPublicKeyStr, BPPublicKey, BPNonce
+		defer func() {
+			BPNodeID, BPRawNodeID, BPPublicKeyStr, BPPublicKey, BPNonce =
+				origNodeID, origRawNodeID, origPublicKeyStr, origPublicKey, origNonce
+		}()
+
+		_, pubKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		nonce := cpuminer.Uint256{A: 1}
+		node := &proto.Node{
+			ID:        proto.NodeID(cpuminer.HashBlock(pubKey.Serialize(), nonce).String()),
+			PublicKey: pubKey,
+			Nonce:     nonce,
+		}
+
+		So(SetBPNode(nil), ShouldEqual, ErrNilNode)
+		So(SetBPNode(&proto.Node{ID: node.ID}), ShouldEqual, ErrNilNode)
+		So(SetBPNode(&proto.Node{ID: "xyz", PublicKey: pubKey}), ShouldEqual, ErrNotValidNodeID)
+		So(SetBPNode(&proto.Node{ID: node.ID, PublicKey: pubKey}), ShouldEqual,
+			ErrNodeIDKeyNonceNotMatch)
+		So(BPNodeID, ShouldEqual, origNodeID)
+
+		err = SetBPNode(node)
+		So(err, ShouldBeNil)
+		So(BPNodeID, ShouldEqual, string(node.ID))
+		So(BPRawNodeID.String(), ShouldEqual, string(node.ID))
+		So(BPPublicKeyStr, ShouldEqual, hex.EncodeToString(pubKey.Serialize()))
+		So(BPPublicKey.IsEqual(pubKey), ShouldBeTrue)
+		So(BPNonce, ShouldResemble, nonce)
+	})
+}
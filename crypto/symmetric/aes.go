@@ -20,7 +20,9 @@ package symmetric
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"io"
 
 	"errors"
@@ -31,6 +33,10 @@ import (
 
 const (
 	keySalt = "auxten-key-salt-auxten"
+
+	// labels of the subkeys used by the deterministic encryption
+	ivKeyLabel  = "iv"
+	encKeyLabel = "enc"
 )
 
 var (
@@ -44,6 +50,15 @@ func keyDerivation(password []byte) (out []byte) {
 	return hash.DoubleHashB(append(password, keySalt...))
 }
 
+// subKeyDerivation derives an independent subkey of the label from key, it's the
+// HKDF-Expand of RFC 5869 with key as the pseudorandom key and a single output block
+func subKeyDerivation(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	mac.Write([]byte{1})
+	return mac.Sum(nil)
+}
+
 // EncryptWithPassword encrypts data with given password, iv will be placed
 // at head of cipher data
 func EncryptWithPassword(in, password []byte) (out []byte, err error) {
@@ -70,9 +85,42 @@ func EncryptWithPassword(in, password []byte) (out []byte, err error) {
 	return out, nil
 }
 
+// EncryptWithPasswordDeterministic encrypts data with given password, the iv is
+// derived from HMAC-SHA256 of the data, so the same input always produces the
+// same cipher data. The iv and the data are keyed by independent subkeys of the
+// password, cipher data can be decrypted by DecryptWithPasswordDeterministic.
+func EncryptWithPasswordDeterministic(in, password []byte) (out []byte, err error) {
+	key := keyDerivation(password)
+	keyE := subKeyDerivation(key, encKeyLabel)
+	paddedIn := crypto.AddPKCSPadding(append([]byte(nil), in...))
+	// IV + padded cipher data
+	out = make([]byte, aes.BlockSize+len(paddedIn))
+
+	mac := hmac.New(sha256.New, subKeyDerivation(key, ivKeyLabel))
+	mac.Write(in)
+	iv := out[:aes.BlockSize]
+	copy(iv, mac.Sum(nil))
+
+	block, _ := aes.NewCipher(keyE)
+
+	mode := cipher.NewCBCEncrypter(block, iv)
+	mode.CryptBlocks(out[aes.BlockSize:], paddedIn)
+
+	return out, nil
+}
+
 // DecryptWithPassword decrypts data with given password
 func DecryptWithPassword(in, password []byte) (out []byte, err error) {
-	keyE := keyDerivation(password)
+	return decrypt(in, keyDerivation(password))
+}
+
+// DecryptWithPasswordDeterministic decrypts data encrypted by
+// EncryptWithPasswordDeterministic with given password
+func DecryptWithPasswordDeterministic(in, password []byte) (out []byte, err error) {
+	return decrypt(in, subKeyDerivation(keyDerivation(password), encKeyLabel))
+}
+
+func decrypt(in, keyE []byte) (out []byte, err error) {
 	// IV + padded cipher data == (n + 1 + 1) * aes.BlockSize
 	if len(in)%aes.BlockSize != 0 || len(in)/aes.BlockSize < 2 {
 		return nil, ErrInputSize
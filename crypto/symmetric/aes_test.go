@@ -59,3 +59,26 @@ func TestEncryptDecryptWithPassword(t *testing.T) {
 		So(err, ShouldEqual, ErrInputSize)
 	})
 }
+
+func TestEncryptWithPasswordDeterministic(t *testing.T) {
+	Convey("encrypt same data twice", t, func() {
+		in := []byte("deterministic")
+		enc1, err := EncryptWithPasswordDeterministic(in, []byte(password))
+		So(err, ShouldBeNil)
+		enc2, err := EncryptWithPasswordDeterministic(in, []byte(password))
+		So(err, ShouldBeNil)
+		So(enc1, ShouldResemble, enc2)
+
+		enc3, err := EncryptWithPasswordDeterministic([]byte("other"), []byte(password))
+		So(err, ShouldBeNil)
+		So(enc3, ShouldNotResemble, enc1)
+
+		dec, err := DecryptWithPasswordDeterministic(enc1, []byte(password))
+		So(err, ShouldBeNil)
+		So(dec, ShouldResemble, in)
+
+		// the data is not encrypted by the key deriving the iv
+		dec, err = DecryptWithPassword(enc1, []byte(password))
+		So(dec, ShouldNotResemble, in)
+	})
+}
@@ -17,9 +17,12 @@
 package kayak
 
 import (
+	"bytes"
 	"errors"
 
 	"github.com/coreos/bbolt"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/crypto/symmetric"
 )
 
 const (
@@ -35,6 +38,9 @@ var (
 
 	// ErrKeyNotFound is an error indicating a given key does not exist
 	ErrKeyNotFound = errors.New("not found")
+
+	// ErrLogCorrupted is an error indicating a stored log cannot be decrypted
+	ErrLogCorrupted = errors.New("log corrupted")
 )
 
 // BoltStore provides access to BoltDB for Raft to store and retrieve
@@ -46,6 +52,9 @@ type BoltStore struct {
 
 	// The path to the Bolt database file
 	path string
+
+	// key is used to encrypt log entries at rest, nil disables encryption
+	key []byte
 }
 
 // Options contains all the configuraiton used to open the BoltDB
@@ -61,6 +70,10 @@ type Options struct {
 	// write to the log. This is unsafe, so it should be used
 	// with caution.
 	NoSync bool
+
+	// EncryptionKey enables encryption of the log entries stored on disk
+	// if set. The same key must be provided to reopen the store.
+	EncryptionKey []byte
 }
 
 // readOnly returns true if the contained bolt options say to open
@@ -88,6 +101,7 @@ func New(options Options) (*BoltStore, error) {
 	store := &BoltStore{
 		conn: handle,
 		path: options.Path,
+		key:  options.EncryptionKey,
 	}
 
 	// If the store was opened read-only, don't try and create buckets
@@ -170,6 +184,11 @@ func (b *BoltStore) GetLog(idx uint64, log *Log) error {
 	if val == nil {
 		return ErrKeyNotFound
 	}
+	if b.key != nil {
+		if val, err = b.decrypt(val); err != nil {
+			return err
+		}
+	}
 	return decodeMsgPack(val, log)
 }
 
@@ -192,8 +211,14 @@ func (b *BoltStore) StoreLogs(logs []*Log) error {
 		if err != nil {
 			return err
 		}
+		data := val.Bytes()
+		if b.key != nil {
+			if data, err = b.encrypt(data); err != nil {
+				return err
+			}
+		}
 		bucket := tx.Bucket(dbLogs)
-		if err := bucket.Put(key, val.Bytes()); err != nil {
+		if err := bucket.Put(key, data); err != nil {
 			return err
 		}
 	}
@@ -227,6 +252,23 @@ func (b *BoltStore) DeleteRange(min, max uint64) error {
 	return tx.Commit()
 }
 
+// encrypt seals the encoded log along with its hash using the store key.
+func (b *BoltStore) encrypt(data []byte) ([]byte, error) {
+	return symmetric.EncryptWithPassword(append(hash.DoubleHashB(data), data...), b.key)
+}
+
+// decrypt opens the sealed log and verifies its hash.
+func (b *BoltStore) decrypt(data []byte) ([]byte, error) {
+	dec, err := symmetric.DecryptWithPassword(data, b.key)
+	if err != nil || len(dec) < hash.HashBSize {
+		return nil, ErrLogCorrupted
+	}
+	if !bytes.Equal(hash.DoubleHashB(dec[hash.HashBSize:]), dec[:hash.HashBSize]) {
+		return nil, ErrLogCorrupted
+	}
+	return dec[hash.HashBSize:], nil
+}
+
 // Set is used to set a key/value set outside of the raft log
 func (b *BoltStore) Set(k, v []byte) error {
 	tx, err := b.conn.Begin(true)
@@ -17,6 +17,7 @@
 package kayak
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -373,3 +374,47 @@ func TestBoltStore_SetUint64_GetUint64(t *testing.T) {
 		So(val, ShouldEqual, v)
 	})
 }
+
+func TestBoltStore_EncryptedLog(t *testing.T) {
+	Convey("Encrypted log", t, func() {
+		fh, err := ioutil.TempFile("", "bolt")
+		So(err, ShouldBeNil)
+		os.Remove(fh.Name())
+		defer os.Remove(fh.Name())
+
+		key := []byte("log-key")
+		store, err := New(Options{
+			Path:          fh.Name(),
+			EncryptionKey: key,
+		})
+		So(err, ShouldBeNil)
+
+		// Set a mock raft log
+		log := testLog(1, "secret-query")
+		err = store.StoreLog(log)
+		So(err, ShouldBeNil)
+
+		// Read back the log
+		result := new(Log)
+		err = store.GetLog(1, result)
+		So(err, ShouldBeNil)
+		So(result, ShouldResemble, log)
+		err = store.Close()
+		So(err, ShouldBeNil)
+
+		// Ensure the log is not stored in plaintext
+		content, err := ioutil.ReadFile(fh.Name())
+		So(err, ShouldBeNil)
+		So(bytes.Contains(content, []byte("secret-query")), ShouldBeFalse)
+
+		// Reopen the store with a wrong key
+		store, err = New(Options{
+			Path:          fh.Name(),
+			EncryptionKey: []byte("wrong-key"),
+		})
+		So(err, ShouldBeNil)
+		defer store.Close()
+		err = store.GetLog(1, result)
+		So(err, ShouldEqual, ErrLogCorrupted)
+	})
+}
@@ -84,7 +84,10 @@ func NewRuntime(config Config, peers *Peers) (*Runtime, error) {
 // Init defines the common init logic.
 func (r *Runtime) Init() error {
 	// init log store
-	logStore, err := NewBoltStore(filepath.Join(r.config.RootDir, FileStorePath))
+	logStore, err := New(Options{
+		Path:          filepath.Join(r.config.RootDir, FileStorePath),
+		EncryptionKey: r.config.EncryptionKey,
+	})
 	if err != nil {
 		return fmt.Errorf("new bolt store: %s", err.Error())
 	}
@@ -166,3 +169,13 @@ func (r *Runtime) UpdatePeers(peers *Peers) error {
 
 	return nil
 }
+
+// IsLeader returns whether the local server is the leader of the peers.
+func (r *Runtime) IsLeader() bool {
+	return r.isLeader
+}
+
+// LogStore returns the log store of the runtime, it's nil before Init or after Shutdown.
+func (r *Runtime) LogStore() *BoltStore {
+	return r.logStore
+}
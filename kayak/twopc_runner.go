@@ -124,6 +124,16 @@ func NewTwoPCRunner() *TwoPCRunner {
 	}
 }
 
+// GetCommittedIndex returns the index of the last committed log recorded in the stable store,
+// or 0 if nothing is committed yet.
+func GetCommittedIndex(stable StableStore) (index uint64, err error) {
+	if index, err = stable.GetUint64(keyCommittedIndex); err == ErrKeyNotFound {
+		return 0, nil
+	}
+
+	return
+}
+
 // GetRuntimeConfig implements Config.GetRuntimeConfig
 func (tpc *TwoPCConfig) GetRuntimeConfig() *RuntimeConfig {
 	return &tpc.RuntimeConfig
@@ -28,6 +28,7 @@ import (
 	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
 	"github.com/thunderdb/ThunderDB/crypto/hash"
 	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/utils"
 )
 
 // Log entries are replicated to all members of the Raft cluster
@@ -233,6 +234,90 @@ func (c *Peers) String() string {
 		base64.StdEncoding.EncodeToString(c.Signature.Serialize()))
 }
 
+// MarshalBinary encodes the peers configuration, the leader is encoded as its index in the
+// servers.
+func (c *Peers) MarshalBinary() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	leader := int32(-1)
+
+	for i, s := range c.Servers {
+		if s == c.Leader {
+			leader = int32(i)
+		}
+	}
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		c.Term,
+		leader,
+		uint32(len(c.Servers)),
+	); err != nil {
+		return nil, err
+	}
+
+	for _, s := range c.Servers {
+		if err := utils.WriteElements(buffer, binary.BigEndian,
+			int32(s.Role),
+			s.ID,
+			s.PubKey,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		c.PubKey,
+		c.Signature,
+	); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalBinary decodes the peers configuration encoded by MarshalBinary.
+func (c *Peers) UnmarshalBinary(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	var (
+		leader int32
+		l      uint32
+	)
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &c.Term, &leader, &l); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return utils.ErrInsufficientBuffer
+	}
+
+	c.Servers = make([]*Server, l)
+
+	for i := range c.Servers {
+		var (
+			role   int32
+			id     proto.NodeID
+			pubKey *asymmetric.PublicKey
+		)
+
+		if err = utils.ReadElements(reader, binary.BigEndian, &role, &id, &pubKey); err != nil {
+			return
+		}
+
+		c.Servers[i] = &Server{
+			Role:   ServerRole(role),
+			ID:     id,
+			PubKey: pubKey,
+		}
+	}
+
+	if leader < 0 || int(leader) >= len(c.Servers) {
+		return ErrInvalidConfig
+	}
+
+	c.Leader = c.Servers[leader]
+	return utils.ReadElements(reader, binary.BigEndian, &c.PubKey, &c.Signature)
+}
+
 // RuntimeConfig defines minimal configuration fields for consensus runner.
 type RuntimeConfig struct {
 	// RootDir is the root dir for runtime
@@ -253,6 +338,9 @@ type RuntimeConfig struct {
 	// AutoBanCount defines how many times a nodes will be banned from execution
 	AutoBanCount uint32
 
+	// EncryptionKey encrypts the logs stored by the runtime if set, SEE: Options.EncryptionKey
+	EncryptionKey []byte
+
 	// Logger is the logger
 	Logger *log.Logger
 }
@@ -198,6 +198,56 @@ func TestPeers_Sign(t *testing.T) {
 	})
 }
 
+func TestPeers_MarshalBinary(t *testing.T) {
+	testPriv := []byte{
+		0xea, 0xf0, 0x2c, 0xa3, 0x48, 0xc5, 0x24, 0xe6,
+		0x39, 0x26, 0x55, 0xba, 0x4d, 0x29, 0x60, 0x3c,
+		0xd1, 0xa7, 0x34, 0x7d, 0x9d, 0x65, 0xcf, 0xe9,
+		0x3c, 0xe1, 0xeb, 0xff, 0xdc, 0xa2, 0x26, 0x94,
+	}
+	privKey, pubKey := asymmetric.PrivKeyFromBytes(testPriv)
+	servers := []*Server{
+		{
+			Role:   Follower,
+			ID:     "sad",
+			PubKey: pubKey,
+		},
+		{
+			Role:   Leader,
+			ID:     "happy",
+			PubKey: pubKey,
+		},
+	}
+	peers := &Peers{
+		Term:    1,
+		Leader:  servers[1],
+		Servers: servers,
+		PubKey:  pubKey,
+	}
+
+	if err := peers.Sign(privKey); err != nil {
+		t.Fatalf("sign peer conf failed: %v", err.Error())
+	}
+	Convey("decode encoded peers", t, func() {
+		b, err := peers.MarshalBinary()
+		So(err, ShouldBeNil)
+
+		decoded := &Peers{}
+		So(decoded.UnmarshalBinary(b), ShouldBeNil)
+		So(decoded.Term, ShouldEqual, peers.Term)
+		So(decoded.Leader, ShouldEqual, decoded.Servers[1])
+		So(decoded.Leader.ID, ShouldEqual, "happy")
+		So(decoded.Servers[0].Role, ShouldEqual, Follower)
+		So(decoded.Verify(), ShouldBeTrue)
+	})
+	Convey("decode peers without leader", t, func() {
+		peers.Leader = &Server{ID: "nobody"}
+		b, err := peers.MarshalBinary()
+		So(err, ShouldBeNil)
+		So((&Peers{}).UnmarshalBinary(b), ShouldEqual, ErrInvalidConfig)
+	})
+}
+
 func TestToString(t *testing.T) {
 	Convey("ServerRole", t, func() {
 		So(fmt.Sprint(Leader), ShouldEqual, "Leader")
@@ -18,9 +18,14 @@ package merkle
 
 import (
 	"github.com/thunderdb/ThunderDB/crypto/hash"
-	"github.com/thunderdb/ThunderDB/sqlchain"
 )
 
+// Hashable is an interface whitch make struct hashable
+type Hashable interface {
+	// Hash return the hash of a hashable value
+	Hash() *hash.Hash
+}
+
 // Merkle is a merkle tree implementation (https://en.wikipedia.org/wiki/Merkle_tree)
 type Merkle struct {
 	tree []*hash.Hash
@@ -42,7 +47,7 @@ func upperPowOfTwo(n int) int {
 
 // NewMerkle generate a merkle tree according
 // to some hashable values like transactions or blocks
-func NewMerkle(items []*sqlchain.Hashable) *Merkle {
+func NewMerkle(items []*Hashable) *Merkle {
 	// the max number of merkle tree node = len(items) * 2 + 2
 	upperPoT := upperPowOfTwo(len(items))
 	maxMerkleSize := upperPoT*2 - 1
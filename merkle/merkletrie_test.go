@@ -11,7 +11,6 @@ import (
 
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/thunderdb/ThunderDB/crypto/hash"
-	"github.com/thunderdb/ThunderDB/sqlchain"
 )
 
 func TestMergeTwoHash(t *testing.T) {
@@ -69,10 +68,10 @@ func TestNewMerkle(t *testing.T) {
 	}
 	Convey("Two root hashes should be the same", t, func() {
 		for i := range tests {
-			hashableTx := make([]*sqlchain.Hashable, len(tests[i].t))
+			hashableTx := make([]*Hashable, len(tests[i].t))
 			for j := range tests[i].t {
 				tests[i].t[j].Hash()
-				hashable := sqlchain.Hashable(&(tests[i].t[j]))
+				hashable := Hashable(&(tests[i].t[j]))
 				hashableTx[j] = &hashable
 			}
 			merkle := NewMerkle(hashableTx)
@@ -104,7 +103,7 @@ func nextPowerOfTwo(n int) int {
 	return 1 << exponent // 2^exponent
 }
 
-func buildMerkleTreeStore(transactions []*sqlchain.Hashable) []*hash.Hash {
+func buildMerkleTreeStore(transactions []*Hashable) []*hash.Hash {
 	// Calculate how many entries are required to hold the binary merkle
 	// tree as a linear array and create an array of that size.
 	nextPoT := nextPowerOfTwo(len(transactions))
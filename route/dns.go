@@ -34,6 +34,10 @@ var (
 	// resolver hold the singleton instance
 	resolver     *Resolver
 	resolverOnce sync.Once
+
+	// bpAddrs hold the BlockProducer addresses, defaults to the local BlockProducer
+	bpAddrs     = []string{"127.0.0.1:2120", "127.0.0.1:2120"}
+	bpAddrsLock sync.RWMutex
 )
 
 var (
@@ -102,8 +106,16 @@ func SetNodeAddr(id *proto.RawNodeID, addr string) (err error) {
 	return
 }
 
+// SetBPAddr sets BlockProducer addresses array, e.g. loaded from the genesis file
+func SetBPAddr(addrs []string) {
+	bpAddrsLock.Lock()
+	defer bpAddrsLock.Unlock()
+	bpAddrs = append([]string{}, addrs...)
+}
+
 // GetBPAddr return BlockProducer addresses array
 func GetBPAddr() []string {
-	//TODO(auxten): implement that
-	return []string{"127.0.0.1:2120", "127.0.0.1:2120"}
+	bpAddrsLock.RLock()
+	defer bpAddrsLock.RUnlock()
+	return append([]string{}, bpAddrs...)
 }
@@ -58,5 +58,12 @@ func TestResolver(t *testing.T) {
 		So(IsBPNodeID(nodeA), ShouldBeFalse)
 
 		So(GetBPAddr(), ShouldNotBeNil)
+
+		origBPAddr := GetBPAddr()
+		defer SetBPAddr(origBPAddr)
+		bpAddr := []string{"10.0.0.1:2120", "10.0.0.2:2120"}
+		SetBPAddr(bpAddr)
+		bpAddr[0] = ""
+		So(GetBPAddr(), ShouldResemble, []string{"10.0.0.1:2120", "10.0.0.2:2120"})
 	})
 }
@@ -0,0 +1,369 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/blockproducer"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/pow/cpuminer"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/types"
+)
+
+// Billing summarizes the answered queries in the blocks of the best chain in height range
+// [from, to] by the replicas which signed their responses, a response signed by a node other
+// than the replicas of its block is not billed. The summary is signed by the replicas and
+// submitted to the main chain in a billing tx of the database.
+func (c *Chain) Billing(databaseID string, from, to int32) (
+	payload *types.BillingPayload, err error) {
+	counts := make(map[proto.NodeID]uint64)
+	keys := make(map[proto.NodeID]*asymmetric.PublicKey)
+	var end hash.Hash
+
+	err = c.ForEachBlock(from, to, true, func(block *Block) error {
+		for _, q := range block.Queries {
+			if q.Response == nil {
+				continue
+			}
+
+			if id, ok := responder(block.SignedHeader.Replicas, q.Response.Signee, keys); ok {
+				counts[id]++
+			}
+		}
+
+		end = block.SignedHeader.BlockHash
+		return nil
+	})
+
+	if err != nil {
+		return
+	}
+
+	payload = &types.BillingPayload{
+		DatabaseID:   databaseID,
+		StartHeight:  from,
+		EndHeight:    to,
+		Items:        make([]*types.BillingItem, 0, len(counts)),
+		EndBlockHash: &types.Hash{Hash: end[:]},
+	}
+
+	for id, count := range counts {
+		payload.Items = append(payload.Items, &types.BillingItem{
+			Miner:      &types.NodeID{NodeID: string(id)},
+			QueryCount: count,
+		})
+	}
+
+	// Sort the items to produce a deterministic payload
+	sort.Slice(payload.Items, func(i, j int) bool {
+		return payload.Items[i].Miner.NodeID < payload.Items[j].Miner.NodeID
+	})
+
+	return
+}
+
+// responder returns the replica whose public key signed the response, the keys of the replicas
+// are looked up in kms and cached.
+func responder(replicas []proto.NodeID, signee *asymmetric.PublicKey,
+	keys map[proto.NodeID]*asymmetric.PublicKey) (id proto.NodeID, ok bool) {
+	if signee == nil {
+		return
+	}
+
+	for _, r := range replicas {
+		key, cached := keys[r]
+
+		if !cached {
+			// A replica with an unknown key is cached as nil
+			key, _ = kms.GetPublicKey(r)
+			keys[r] = key
+		}
+
+		if key != nil && key.IsEqual(signee) {
+			return r, true
+		}
+	}
+
+	return
+}
+
+// BillingConfig represents a billing submitter config.
+type BillingConfig struct {
+	// Chain is the local sql-chain whose answered queries are billed.
+	Chain *Chain
+
+	// DatabaseID is the id of the database on the main chain.
+	DatabaseID string
+
+	// BlockProducer is the block producer which the database is queried from and the billing
+	// txs are submitted to.
+	BlockProducer proto.NodeID
+
+	// Account is the private key of the account owning the local miner, which sends the billing
+	// txs and pays their fees.
+	Account *asymmetric.PrivateKey
+
+	// Fee is the fee of a billing tx.
+	Fee uint64
+
+	// Period is the billing period.
+	Period time.Duration
+
+	// Caller calls the remote nodes, the rpc package is used if it's nil.
+	Caller Caller
+}
+
+// BillingSubmitter periodically bills the blocks of a hosted database since its last billed
+// height: the summary is signed by the local node and the other replicas, and submitted to the
+// main chain in a billing tx.
+type BillingSubmitter struct {
+	cfg    *BillingConfig
+	caller Caller
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+
+	// submitLock serializes the submitting and protects the last submitted tx, which is not
+	// submitted again while it's waiting for inclusion
+	submitLock sync.Mutex
+	submitted  bool
+	lastHeight int32
+	lastNonce  uint64
+}
+
+// NewBillingSubmitter creates a new billing submitter.
+func NewBillingSubmitter(cfg *BillingConfig) (s *BillingSubmitter, err error) {
+	if cfg.Chain == nil || cfg.DatabaseID == "" || cfg.BlockProducer == "" ||
+		cfg.Account == nil || cfg.Period <= 0 {
+		return nil, ErrInvalidBillingConfig
+	}
+
+	caller := cfg.Caller
+
+	if caller == nil {
+		caller = &RPCCaller{}
+	}
+
+	return &BillingSubmitter{
+		cfg:    cfg,
+		caller: caller,
+	}, nil
+}
+
+// Start starts the billing loop in a new goroutine.
+func (s *BillingSubmitter) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopCh != nil {
+		return ErrBillingSubmitterStarted
+	}
+
+	s.stopCh = make(chan struct{})
+	s.doneCh = make(chan struct{})
+	go s.run(s.stopCh, s.doneCh)
+	return nil
+}
+
+// Stop stops the billing loop and waits for it to exit.
+func (s *BillingSubmitter) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopCh == nil {
+		return
+	}
+
+	close(s.stopCh)
+	<-s.doneCh
+	s.stopCh = nil
+	s.doneCh = nil
+}
+
+func (s *BillingSubmitter) run(stopCh <-chan struct{}, doneCh chan<- struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(s.cfg.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if _, err := s.Submit(); err != nil {
+				log.Errorf("failed to submit billing: %v", err)
+			}
+		}
+	}
+}
+
+// Submit bills the blocks of the local best chain since the billed height of the database on the
+// main chain, and submits the billing tx signed by a majority of the replicas. It returns a nil
+// tx if there is nothing to bill or the last submitted tx is still waiting for inclusion.
+func (s *BillingSubmitter) Submit() (tx *blockproducer.Tx, err error) {
+	s.submitLock.Lock()
+	defer s.submitLock.Unlock()
+	database := &blockproducer.QueryDatabaseResp{}
+
+	if err = s.caller.CallNode(s.cfg.BlockProducer,
+		blockproducer.ChainRPCServiceName+".QueryDatabase",
+		&blockproducer.QueryDatabaseReq{DatabaseID: s.cfg.DatabaseID}, database); err != nil {
+		return
+	}
+
+	to := s.cfg.Chain.Head().Height
+
+	if database.BilledHeight > to {
+		return
+	}
+
+	account := &blockproducer.QueryAccountResp{}
+
+	if err = s.caller.CallNode(s.cfg.BlockProducer,
+		blockproducer.ChainRPCServiceName+".QueryAccount", &blockproducer.QueryAccountReq{
+			Address: blockproducer.AccountAddressFromPublicKey(s.cfg.Account.PubKey()),
+		}, account); err != nil {
+		return
+	}
+
+	// Neither the billed height nor the account nonce is changed, the last tx is still pending
+	if s.submitted && s.lastHeight == database.BilledHeight && s.lastNonce == account.Nonce {
+		return
+	}
+
+	payload, err := s.cfg.Chain.Billing(s.cfg.DatabaseID, database.BilledHeight, to)
+
+	if err != nil || len(payload.Items) == 0 {
+		return
+	}
+
+	if err = s.collectSignatures(payload, database.Replicas); err != nil {
+		return
+	}
+
+	tx = &blockproducer.Tx{
+		TxData: blockproducer.TxData{
+			AccountNonce: account.Nonce,
+			Amount:       new(big.Int),
+			Fee:          s.cfg.Fee,
+		},
+	}
+
+	if err = tx.TxData.SetPayload(types.BPTxType_BILLING, payload); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Sign(s.cfg.Account); err != nil {
+		return nil, err
+	}
+
+	buffer, err := tx.MarshalBinary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.caller.CallNode(s.cfg.BlockProducer,
+		blockproducer.ChainRPCServiceName+".SubmitTx", &blockproducer.SubmitTxReq{Tx: buffer},
+		&blockproducer.SubmitTxResp{}); err != nil {
+		return nil, err
+	}
+
+	s.submitted = true
+	s.lastHeight = database.BilledHeight
+	s.lastNonce = account.Nonce
+	return
+}
+
+// collectSignatures signs the billing summary with the local node key if the local node is one of
+// the replicas, and collects the signatures of the other replicas. The summary must be signed by
+// a majority of the replicas, the unreachable replicas and the invalid signatures are skipped.
+func (s *BillingSubmitter) collectSignatures(payload *types.BillingPayload,
+	replicas []proto.NodeID) (err error) {
+	buffer, err := pb.Marshal(payload)
+
+	if err != nil {
+		return
+	}
+
+	priv, err := kms.GetLocalPrivateKey()
+
+	if err != nil {
+		return
+	}
+
+	nonce, err := kms.GetLocalNonce()
+
+	if err != nil {
+		return
+	}
+
+	// The node id is checked by the main chain in the same way
+	local := proto.NodeID(cpuminer.HashBlock(priv.PubKey().Serialize(), *nonce).String())
+	req := &SignBillingReq{
+		DatabaseID: s.cfg.DatabaseID,
+		Payload:    buffer,
+	}
+
+	for _, id := range replicas {
+		if id == local {
+			if err = blockproducer.SignBilling(payload, priv, *nonce); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		resp := &SignBillingResp{}
+
+		if cerr := s.caller.CallNode(id, ChainRPCServiceName+".SignBilling", req,
+			resp); cerr != nil {
+			log.Warnf("failed to collect billing signature from %s: %v", id, cerr)
+			continue
+		}
+
+		signature := &types.BillingSignature{}
+
+		if err = pb.Unmarshal(resp.Signature, signature); err != nil {
+			return
+		}
+
+		if signer, verr := blockproducer.VerifyBillingSignature(payload,
+			signature); verr != nil || signer != id {
+			log.Warnf("invalid billing signature from %s", id)
+			continue
+		}
+
+		payload.Signatures = append(payload.Signatures, signature)
+	}
+
+	if 2*len(payload.Signatures) <= len(replicas) {
+		return ErrInsufficientSignatures
+	}
+
+	return
+}
@@ -0,0 +1,757 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/thunderdb/ThunderDB/blockproducer"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/pow/cpuminer"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/types"
+)
+
+// createTestReplicas creates the replica nodes whose public keys are registered in kms.
+func createTestReplicas(t *testing.T, n int) (keys []*asymmetric.PrivateKey,
+	ids []proto.NodeID) {
+	for i := 0; i < n; i++ {
+		priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		nonce := cpuminer.Uint256{A: uint64(i)}
+		id := proto.NodeID(cpuminer.HashBlock(pub.Serialize(), nonce).String())
+
+		if err = kms.SetPublicKey(id, nonce, pub); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		keys = append(keys, priv)
+		ids = append(ids, id)
+	}
+
+	return
+}
+
+func createTestAnsweredQuery(t *testing.T, miner *asymmetric.PrivateKey) (q *Query) {
+	q = createRandomQuery()
+	client, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = q.Sign(client); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	result := make([]byte, 64)
+	rand.Read(result)
+
+	if _, err = q.Respond(result, miner); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+func TestChainBilling(t *testing.T) {
+	fl, err := ioutil.TempFile("", "chain")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain, err := NewChain(&Config{
+		DataDir: fl.Name(),
+		Genesis: genesis,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	keys, ids := createTestReplicas(t, 2)
+	outsider, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Count the answered queries of each replica, the responses of the other nodes are not billed
+	expected := make(map[string]uint64)
+	parent := genesis.SignedHeader.BlockHash
+
+	for i := 0; i < 4; i++ {
+		queries := Queries{createRandomQuery(), createTestAnsweredQuery(t, outsider)}
+
+		for j := 0; j <= i; j++ {
+			queries = append(queries, createTestAnsweredQuery(t, keys[j%len(keys)]))
+			expected[string(ids[j%len(ids)])]++
+		}
+
+		block, err := createRandomBlock(parent, false, withQueries(queries), withReplicas(ids))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(block); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		parent = block.SignedHeader.BlockHash
+	}
+
+	payload, err := chain.Billing("db", 1, 4)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if payload.DatabaseID != "db" || payload.StartHeight != 1 || payload.EndHeight != 4 ||
+		len(payload.Items) != len(expected) ||
+		string(payload.EndBlockHash.Hash) != string(parent[:]) {
+		t.Fatalf("Unexpected billing payload: %v", payload)
+	}
+
+	for i, item := range payload.Items {
+		if i > 0 && payload.Items[i-1].Miner.NodeID >= item.Miner.NodeID {
+			t.Fatalf("Unsorted billing items: %v", payload.Items)
+		}
+
+		if expected[item.Miner.NodeID] != item.QueryCount {
+			t.Fatalf("Unexpected query count of %s: %d", item.Miner.NodeID, item.QueryCount)
+		}
+	}
+
+	if _, err = chain.Billing("db", 3, 2); err != ErrInvalidRange {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = chain.Billing("db", 1, 5); err == nil {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	// The local replica signs the summary matching its chain
+	service := NewChainRPCService()
+	service.AddChain("db", chain, nil)
+	buffer, err := pb.Marshal(payload)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = service.SignBilling(&SignBillingReq{DatabaseID: "xxx", Payload: buffer},
+		&SignBillingResp{}); err != ErrUnknownDatabase {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	resp := &SignBillingResp{}
+
+	if err = service.SignBilling(&SignBillingReq{DatabaseID: "db", Payload: buffer},
+		resp); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	signature := &types.BillingSignature{}
+
+	if err = pb.Unmarshal(resp.Signature, signature); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	pub, err := kms.GetLocalPublicKey()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	h, err := blockproducer.BillingHash(payload)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	r, _ := new(big.Int).SetString(signature.Signature.R, 10)
+	s, _ := new(big.Int).SetString(signature.Signature.S, 10)
+
+	if string(signature.Signee.PublicKey) != string(pub.Serialize()) ||
+		!(&asymmetric.Signature{R: r, S: s}).Verify(h[:], pub) {
+		t.Fatalf("Unexpected billing signature: %v", signature)
+	}
+
+	// A summary which doesn't match the local chain is not signed
+	payload.Items[0].QueryCount++
+
+	if buffer, err = pb.Marshal(payload); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = service.SignBilling(&SignBillingReq{DatabaseID: "db", Payload: buffer},
+		&SignBillingResp{}); err != ErrBillingMismatch {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// testBillingCaller serves the main chain RPCs from a fake database state, and signs the billing
+// summaries with the keys of the remote replicas.
+type testBillingCaller struct {
+	sync.Mutex
+	database  blockproducer.QueryDatabaseResp
+	nonce     uint64
+	keys      map[proto.NodeID]*asymmetric.PrivateKey
+	nonces    map[proto.NodeID]cpuminer.Uint256
+	down      map[proto.NodeID]bool
+	submitted []*blockproducer.Tx
+}
+
+func (c *testBillingCaller) CallNode(nodeID proto.NodeID, method string, args,
+	reply interface{}) (err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	switch method {
+	case blockproducer.ChainRPCServiceName + ".QueryDatabase":
+		*reply.(*blockproducer.QueryDatabaseResp) = c.database
+	case blockproducer.ChainRPCServiceName + ".QueryAccount":
+		reply.(*blockproducer.QueryAccountResp).Nonce = c.nonce
+	case blockproducer.ChainRPCServiceName + ".SubmitTx":
+		tx := &blockproducer.Tx{}
+
+		if err = tx.UnmarshalBinary(args.(*blockproducer.SubmitTxReq).Tx); err != nil {
+			return
+		}
+
+		c.submitted = append(c.submitted, tx)
+	case ChainRPCServiceName + ".SignBilling":
+		if c.down[nodeID] {
+			return errors.New("node is down")
+		}
+
+		payload := &types.BillingPayload{}
+
+		if err = pb.Unmarshal(args.(*SignBillingReq).Payload, payload); err != nil {
+			return
+		}
+
+		if err = blockproducer.SignBilling(payload, c.keys[nodeID],
+			c.nonces[nodeID]); err != nil {
+			return
+		}
+
+		reply.(*SignBillingResp).Signature, err = pb.Marshal(payload.Signatures[0])
+	default:
+		return errors.New("unknown method")
+	}
+
+	return
+}
+
+func TestBillingSubmitter(t *testing.T) {
+	fl, err := ioutil.TempFile("", "chain")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain, err := NewChain(&Config{
+		DataDir: fl.Name(),
+		Genesis: genesis,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// The local node and 2 remote replicas
+	keys, ids := createTestReplicas(t, 2)
+	priv, err := kms.GetLocalPrivateKey()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	nonce, err := kms.GetLocalNonce()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	local := proto.NodeID(cpuminer.HashBlock(priv.PubKey().Serialize(), *nonce).String())
+	replicas := append([]proto.NodeID{local}, ids...)
+	parent := genesis.SignedHeader.BlockHash
+
+	for i := 0; i < 3; i++ {
+		block, err := createRandomBlock(parent, false, withQueries(Queries{
+			createTestAnsweredQuery(t, keys[0]),
+			createTestAnsweredQuery(t, keys[1]),
+		}), withReplicas(replicas))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(block); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		parent = block.SignedHeader.BlockHash
+	}
+
+	caller := &testBillingCaller{
+		database: blockproducer.QueryDatabaseResp{
+			BilledHeight: 1,
+			Replicas:     replicas,
+		},
+		nonce: 5,
+		keys:  map[proto.NodeID]*asymmetric.PrivateKey{ids[0]: keys[0], ids[1]: keys[1]},
+		nonces: map[proto.NodeID]cpuminer.Uint256{
+			ids[0]: {A: 0},
+			ids[1]: {A: 1},
+		},
+		down: map[proto.NodeID]bool{ids[1]: true},
+	}
+	account, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	for _, cfg := range []*BillingConfig{
+		{},
+		{Chain: chain, DatabaseID: "db", BlockProducer: "bp", Account: account},
+		{Chain: chain, DatabaseID: "db", Account: account, Period: time.Second},
+	} {
+		if _, err = NewBillingSubmitter(cfg); err != ErrInvalidBillingConfig {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	submitter, err := NewBillingSubmitter(&BillingConfig{
+		Chain:         chain,
+		DatabaseID:    "db",
+		BlockProducer: "bp",
+		Account:       account,
+		Fee:           1,
+		Period:        100 * time.Millisecond,
+		Caller:        caller,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Signed by the local node and the reachable replica
+	tx, err := submitter.Submit()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if tx == nil || len(caller.submitted) != 1 {
+		t.Fatal("Unexpected result: billing tx is not submitted")
+	}
+
+	submitted := caller.submitted[0]
+
+	if err = submitted.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	decoded, err := submitted.TxData.DecodePayload()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	payload := decoded.(*types.BillingPayload)
+
+	if submitted.TxData.AccountNonce != 5 || submitted.TxData.Fee != 1 ||
+		submitted.TxData.Type != types.BPTxType_BILLING || payload.StartHeight != 1 ||
+		payload.EndHeight != 3 || len(payload.Items) != 2 || len(payload.Signatures) != 2 {
+		t.Fatalf("Unexpected billing tx: %v", payload)
+	}
+
+	for i, expected := range []proto.NodeID{local, ids[0]} {
+		if id, err := blockproducer.VerifyBillingSignature(payload,
+			payload.Signatures[i]); err != nil || id != expected {
+			t.Fatalf("Unexpected signer: id = %s, err = %v", id, err)
+		}
+	}
+
+	// The last tx is still pending
+	if tx, err = submitter.Submit(); err != nil || tx != nil {
+		t.Fatalf("Unexpected result: tx = %v, err = %v", tx, err)
+	}
+
+	// The account nonce is consumed by another tx, the billing is submitted again
+	caller.nonce = 6
+
+	if tx, err = submitter.Submit(); err != nil || tx == nil ||
+		tx.TxData.AccountNonce != 6 {
+		t.Fatalf("Unexpected result: tx = %v, err = %v", tx, err)
+	}
+
+	// Nothing to bill
+	caller.database.BilledHeight = 4
+
+	if tx, err = submitter.Submit(); err != nil || tx != nil {
+		t.Fatalf("Unexpected result: tx = %v, err = %v", tx, err)
+	}
+
+	// A replica signing with a key other than its own is not counted
+	caller.database.BilledHeight = 2
+	caller.keys[ids[0]] = keys[1]
+
+	if _, err = submitter.Submit(); err != ErrInsufficientSignatures {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	caller.keys[ids[0]] = keys[0]
+	caller.down[ids[0]] = true
+
+	if _, err = submitter.Submit(); err != ErrInsufficientSignatures {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Submit periodically
+	caller.down[ids[0]] = false
+
+	if err = submitter.Start(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = submitter.Start(); err != ErrBillingSubmitterStarted {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	submitter.Stop()
+	caller.Lock()
+	defer caller.Unlock()
+
+	if len(caller.submitted) != 3 {
+		t.Fatalf("Unexpected submitted tx count: %d", len(caller.submitted))
+	}
+}
+
+// testMainChainCaller serves the main chain RPCs from a real main chain, and the billing signing
+// RPCs of the remote replicas in the same way as testBillingCaller.
+type testMainChainCaller struct {
+	*testBillingCaller
+	service *blockproducer.ChainRPCService
+}
+
+func (c *testMainChainCaller) CallNode(nodeID proto.NodeID, method string, args,
+	reply interface{}) error {
+	switch method {
+	case blockproducer.ChainRPCServiceName + ".QueryDatabase":
+		return c.service.QueryDatabase(args.(*blockproducer.QueryDatabaseReq),
+			reply.(*blockproducer.QueryDatabaseResp))
+	case blockproducer.ChainRPCServiceName + ".QueryAccount":
+		return c.service.QueryAccount(args.(*blockproducer.QueryAccountReq),
+			reply.(*blockproducer.QueryAccountResp))
+	case blockproducer.ChainRPCServiceName + ".SubmitTx":
+		return c.service.SubmitTx(args.(*blockproducer.SubmitTxReq),
+			reply.(*blockproducer.SubmitTxResp))
+	default:
+		return c.testBillingCaller.CallNode(nodeID, method, args, reply)
+	}
+}
+
+func createTestMainChainTx(t *testing.T, signer *asymmetric.PrivateKey, nonce uint64,
+	typ types.BPTxType, payload pb.Message, amount int64) (tx *blockproducer.Tx) {
+	tx = &blockproducer.Tx{
+		TxData: blockproducer.TxData{
+			AccountNonce: nonce,
+			Amount:       big.NewInt(amount),
+			Fee:          1,
+		},
+	}
+
+	if err := tx.TxData.SetPayload(typ, payload); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err := tx.Sign(signer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+func TestBillingSubmitterMainChain(t *testing.T) {
+	// The local node produces the main chain blocks and owns the local miner, the remote
+	// replicas own the other miners and the client owns the database
+	keys, ids := createTestReplicas(t, 2)
+	priv, err := kms.GetLocalPrivateKey()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	nonce, err := kms.GetLocalNonce()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	client, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	local := proto.NodeID(cpuminer.HashBlock(priv.PubKey().Serialize(), *nonce).String())
+
+	if err = kms.SetPublicKey(local, *nonce, priv.PubKey()); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	owners := []*asymmetric.PrivateKey{priv, keys[0], keys[1]}
+	miners := []proto.NodeID{local, ids[0], ids[1]}
+	var accounts []*blockproducer.Account
+
+	for _, k := range append(owners, client) {
+		accounts = append(accounts, &blockproducer.Account{
+			Address: blockproducer.AccountAddressFromPublicKey(k.PubKey()),
+			Balance: big.NewInt(100),
+		})
+	}
+
+	fl, err := ioutil.TempFile("", "mainchain")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	mainGenesis := &blockproducer.Block{
+		Header: &blockproducer.SignedHeader{
+			Header: blockproducer.Header{
+				Version:   0x01000000,
+				Producer:  accounts[0].Address,
+				Root:      blockproducer.StateRoot(accounts),
+				Timestamp: time.Now().UTC(),
+			},
+		},
+	}
+
+	if err = mainGenesis.SignHeader(priv); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	mainChain, err := blockproducer.NewChain(&blockproducer.Config{
+		DataDir:    fl.Name(),
+		Genesis:    mainGenesis,
+		Accounts:   accounts,
+		QueryPrice: 1,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	pool, err := blockproducer.NewTxPool(&blockproducer.TxPoolConfig{State: mainChain})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	producer, err := blockproducer.NewProducer(&blockproducer.ProducerConfig{
+		Chain:  mainChain,
+		Pool:   pool,
+		Period: time.Second,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Register the miners and place the database on them
+	for i, k := range owners {
+		if err = pool.AddTx(createTestMainChainTx(t, k, 0, types.BPTxType_REGISTER_MINER,
+			&types.RegisterMinerPayload{NodeID: &types.NodeID{NodeID: string(miners[i])}},
+			10)); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	if _, err = producer.ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = pool.AddTx(createTestMainChainTx(t, client, 0, types.BPTxType_CREATE_DATABASE,
+		&types.CreateDatabasePayload{ReplicaCount: 3}, 50)); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = producer.ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	dbID := blockproducer.DatabaseIDFromTx(accounts[3].Address, 0)
+	database, err := mainChain.GetDatabase(dbID)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Every replica answers a query in each block
+	chainFile, err := ioutil.TempFile("", "chain")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chainFile.Close()
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain, err := NewChain(&Config{
+		DataDir: chainFile.Name(),
+		Genesis: genesis,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	parent := genesis.SignedHeader.BlockHash
+
+	for i := 0; i < 3; i++ {
+		block, err := createRandomBlock(parent, false, withQueries(Queries{
+			createTestAnsweredQuery(t, priv),
+			createTestAnsweredQuery(t, keys[0]),
+			createTestAnsweredQuery(t, keys[1]),
+		}), withReplicas(database.Replicas))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(block); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		parent = block.SignedHeader.BlockHash
+	}
+
+	balances := make([]*big.Int, len(owners))
+
+	for i := range owners {
+		if balances[i], err = mainChain.Balance(accounts[i].Address); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	submitter, err := NewBillingSubmitter(&BillingConfig{
+		Chain:         chain,
+		DatabaseID:    dbID,
+		BlockProducer: "bp",
+		Account:       priv,
+		Fee:           1,
+		Period:        time.Second,
+		Caller: &testMainChainCaller{
+			testBillingCaller: &testBillingCaller{
+				keys: map[proto.NodeID]*asymmetric.PrivateKey{
+					ids[0]: keys[0],
+					ids[1]: keys[1],
+				},
+				nonces: map[proto.NodeID]cpuminer.Uint256{
+					ids[0]: {A: 0},
+					ids[1]: {A: 1},
+				},
+			},
+			service: blockproducer.NewChainRPCService(mainChain, pool),
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if tx, err := submitter.Submit(); err != nil || tx == nil {
+		t.Fatalf("Unexpected result: tx = %v, err = %v", tx, err)
+	}
+
+	block, err := producer.ProduceBlock()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(block.Tx) != 1 || block.Tx[0].TxData.Type != types.BPTxType_BILLING {
+		t.Fatalf("Unexpected block txs: %v", block.Tx)
+	}
+
+	// The blocks are billed, 3 queries are paid to the owner of each replica. The local node
+	// pays the fee of the billing tx to itself as the block producer.
+	if database, err = mainChain.GetDatabase(dbID); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if database.BilledHeight != 4 || database.Balance.Cmp(big.NewInt(41)) != 0 {
+		t.Fatalf("Unexpected database: billed height = %d, balance = %v",
+			database.BilledHeight, database.Balance)
+	}
+
+	for i := range owners {
+		balance, err := mainChain.Balance(accounts[i].Address)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if expected := new(big.Int).Add(balances[i], big.NewInt(3)); balance.Cmp(expected) != 0 {
+			t.Fatalf("Unexpected balance of owner %d: %v, expected %v", i, balance, expected)
+		}
+	}
+
+	// Nothing left to bill
+	if tx, err := submitter.Submit(); err != nil || tx != nil {
+		t.Fatalf("Unexpected result: tx = %v, err = %v", tx, err)
+	}
+}
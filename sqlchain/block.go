@@ -19,6 +19,7 @@ package sqlchain
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 	"reflect"
 	"time"
 
@@ -37,7 +38,55 @@ type Header struct {
 	RootHash   hash.Hash
 	ParentHash hash.Hash
 	MerkleRoot hash.Hash
+	ProofRoot  hash.Hash
 	Timestamp  time.Time
+
+	// LogIndex is the index of the last kayak log packed into the chain up to this block.
+	LogIndex uint64
+
+	// Replicas is the replica set of the database when the block is produced, the storage
+	// proof round of the block is taken by these nodes.
+	Replicas []proto.NodeID
+}
+
+// writeNodeIDs writes the length prefixed node id list to the writer.
+func writeNodeIDs(w io.Writer, ids []proto.NodeID) (err error) {
+	if err = utils.WriteElements(w, binary.BigEndian, uint32(len(ids))); err != nil {
+		return
+	}
+
+	for _, id := range ids {
+		if err = utils.WriteElements(w, binary.BigEndian, id); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// readNodeIDs reads a length prefixed node id list from the reader.
+func readNodeIDs(r *bytes.Reader) (ids []proto.NodeID, err error) {
+	var l uint32
+
+	if err = utils.ReadElements(r, binary.BigEndian, &l); err != nil {
+		return
+	}
+
+	if int(l) > r.Len() {
+		return nil, utils.ErrInsufficientBuffer
+	}
+
+	for i := uint32(0); i < l; i++ {
+		var id proto.NodeID
+
+		if err = utils.ReadElements(r, binary.BigEndian, &id); err != nil {
+			return
+		}
+
+		ids = append(ids, id)
+	}
+
+	return
 }
 
 func (h *Header) marshal() ([]byte, error) {
@@ -49,14 +98,31 @@ func (h *Header) marshal() ([]byte, error) {
 		&h.RootHash,
 		&h.ParentHash,
 		&h.MerkleRoot,
+		&h.ProofRoot,
 		h.Timestamp,
+		h.LogIndex,
 	); err != nil {
 		return nil, err
 	}
 
+	if err := writeNodeIDs(buffer, h.Replicas); err != nil {
+		return nil, err
+	}
+
 	return buffer.Bytes(), nil
 }
 
+// HasReplica returns whether the node is in the replica set of the block.
+func (h *Header) HasReplica(id proto.NodeID) bool {
+	for _, r := range h.Replicas {
+		if r == id {
+			return true
+		}
+	}
+
+	return false
+}
+
 // SignedHeader is block header along with its producer signature.
 type SignedHeader struct {
 	Header
@@ -75,7 +141,18 @@ func (s *SignedHeader) marshal() ([]byte, error) {
 		&s.RootHash,
 		&s.ParentHash,
 		&s.MerkleRoot,
+		&s.ProofRoot,
 		s.Timestamp,
+		s.LogIndex,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := writeNodeIDs(buffer, s.Replicas); err != nil {
+		return nil, err
+	}
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
 		&s.BlockHash,
 		s.Signee,
 		s.Signature,
@@ -86,21 +163,43 @@ func (s *SignedHeader) marshal() ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-func (s *SignedHeader) unmarshal(b []byte) error {
+func (s *SignedHeader) unmarshal(b []byte) (err error) {
 	reader := bytes.NewReader(b)
-	return utils.ReadElements(reader, binary.BigEndian,
+
+	if err = utils.ReadElements(reader, binary.BigEndian,
 		&s.Version,
 		&s.Producer,
 		&s.RootHash,
 		&s.ParentHash,
 		&s.MerkleRoot,
+		&s.ProofRoot,
 		&s.Timestamp,
+		&s.LogIndex,
+	); err != nil {
+		return
+	}
+
+	if s.Replicas, err = readNodeIDs(reader); err != nil {
+		return
+	}
+
+	return utils.ReadElements(reader, binary.BigEndian,
 		&s.BlockHash,
 		&s.Signee,
 		&s.Signature,
 	)
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (s *SignedHeader) MarshalBinary() ([]byte, error) {
+	return s.marshal()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (s *SignedHeader) UnmarshalBinary(b []byte) error {
+	return s.unmarshal(b)
+}
+
 // Verify verifies the signature of the signed header.
 func (s *SignedHeader) Verify() error {
 	if !s.Signature.Verify(s.BlockHash[:], s.Signee) {
@@ -138,11 +237,91 @@ func (s *SignedHeader) VerifyAsGenesis() (err error) {
 // Block is a node of blockchain.
 type Block struct {
 	SignedHeader *SignedHeader
-	Queries      []*Query
+	Queries      Queries
+
+	// ProofResults are the results of the storage proof rounds checked by their verifiers.
+	ProofResults ProofResults
+}
+
+func (b *Block) marshal() ([]byte, error) {
+	if b.SignedHeader == nil {
+		return nil, ErrNilValue
+	}
+
+	header, err := b.SignedHeader.marshal()
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := b.ProofResults.marshal()
+
+	if err != nil {
+		return nil, err
+	}
+
+	queries, err := b.Queries.marshal()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Queries are appended as the rest of the buffer, since they may exceed the length limit
+	// of a single element
+	buffer := bytes.NewBuffer(nil)
+
+	if err = utils.WriteElements(buffer, binary.BigEndian, header, results); err != nil {
+		return nil, err
+	}
+
+	buffer.Write(queries)
+	return buffer.Bytes(), nil
+}
+
+func (b *Block) unmarshal(buffer []byte) (err error) {
+	var header, results []byte
+	reader := bytes.NewReader(buffer)
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &header, &results); err != nil {
+		return
+	}
+
+	queries := buffer[len(buffer)-reader.Len():]
+
+	b.SignedHeader = &SignedHeader{}
+
+	if err = b.SignedHeader.unmarshal(header); err != nil {
+		return
+	}
+
+	if err = b.ProofResults.unmarshal(results); err != nil {
+		return
+	}
+
+	return b.Queries.unmarshal(queries)
 }
 
-// SignHeader generates the signature for the Block from the given PrivateKey.
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (b *Block) MarshalBinary() ([]byte, error) {
+	return b.marshal()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (b *Block) UnmarshalBinary(buffer []byte) error {
+	return b.unmarshal(buffer)
+}
+
+// SignHeader sets the merkle root of the queries and the hash of the proof results, and
+// generates the signature for the Block from the given PrivateKey.
 func (b *Block) SignHeader(signer *asymmetric.PrivateKey) (err error) {
+	if b.SignedHeader.MerkleRoot, err = b.Queries.MerkleRoot(); err != nil {
+		return
+	}
+
+	if b.SignedHeader.ProofRoot, err = b.ProofResults.Hash(); err != nil {
+		return
+	}
+
 	buffer, err := b.SignedHeader.Header.marshal()
 
 	if err != nil {
@@ -155,10 +334,52 @@ func (b *Block) SignHeader(signer *asymmetric.PrivateKey) (err error) {
 	return
 }
 
-// Verify verifies the merkle root and header signature of the block.
+// Verify verifies the merkle root, proof results and header signature of the block.
 func (b *Block) Verify() (err error) {
-	// TODO(leventeliu): verify merkle root of queries
-	// ...
+	if b.SignedHeader == nil {
+		return ErrNilValue
+	}
+
+	// Verify merkle root of queries
+	mr, err := b.Queries.MerkleRoot()
+
+	if err != nil {
+		return
+	}
+
+	if !mr.IsEqual(&b.SignedHeader.MerkleRoot) {
+		return ErrMerkleRootVerification
+	}
+
+	// Verify the proof results and their signatures
+	pr, err := b.ProofResults.Hash()
+
+	if err != nil {
+		return
+	}
+
+	if !pr.IsEqual(&b.SignedHeader.ProofRoot) {
+		return ErrProofRootVerification
+	}
+
+	for _, r := range b.ProofResults {
+		if err = r.Verify(); err != nil {
+			return
+		}
+	}
+
+	// Verify the signatures of signed queries, the unsigned ones are collected from the
+	// replicated logs by the producer. A response is only valid for a signed request, and
+	// must answer the very request it's attached to
+	for _, q := range b.Queries {
+		if q.Signature == nil && q.Response == nil {
+			continue
+		}
+
+		if err = q.Verify(); err != nil {
+			return
+		}
+	}
 
 	// Verify block hash
 	buffer, err := b.SignedHeader.Header.marshal()
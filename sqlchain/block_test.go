@@ -80,6 +80,47 @@ func TestSerialization(t *testing.T) {
 	}
 }
 
+func TestBlockSerialization(t *testing.T) {
+	block, err := createRandomBlock(rootHash, false)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	buffer, err := block.marshal()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	rBlock := &Block{}
+
+	if err = rBlock.unmarshal(buffer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(block.SignedHeader.Header, rBlock.SignedHeader.Header) {
+		t.Fatalf("Values don't match:\n\tv1 = %+v\n\tv2 = %+v",
+			block.SignedHeader.Header, rBlock.SignedHeader.Header)
+	}
+
+	if err = rBlock.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = rBlock.unmarshal(buffer[:len(buffer)/2]); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	if _, err = (&Block{}).marshal(); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+}
+
 func TestGenesis(t *testing.T) {
 	genesis, err := createRandomBlock(rootHash, true)
 
@@ -17,42 +17,68 @@
 package sqlchain
 
 import (
+	"bytes"
 	"encoding/binary"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/thunderdb/ThunderDB/crypto/hash"
 )
 
+const (
+	// medianTimeBlocks is the number of previous blocks used to compute the median time past.
+	medianTimeBlocks = 11
+)
+
 type blockNode struct {
-	parent *blockNode
-	hash   hash.Hash
-	height int32
+	parent    *blockNode
+	skip      *blockNode // Skip pointer to an ancestor to speed up ancestor lookups
+	hash      hash.Hash
+	height    int32
+	timestamp time.Time
+	logIndex  uint64
 }
 
 func newBlockNode(header *SignedHeader, parent *blockNode) (node *blockNode) {
-	node = &blockNode{
-		hash:   header.BlockHash,
-		parent: nil,
-		height: 0,
-	}
-
-	if parent != nil {
-		node.parent = parent
-		node.height = parent.height + 1
-	}
-
+	node = &blockNode{}
+	node.initBlockNode(header, parent)
 	return node
 }
 
 func (bn *blockNode) initBlockNode(head *SignedHeader, parent *blockNode) {
 	bn.hash = head.BlockHash
+	bn.timestamp = head.Timestamp
 	bn.parent = nil
+	bn.skip = nil
 	bn.height = 0
+	bn.logIndex = head.LogIndex
 
 	if parent != nil {
 		bn.parent = parent
 		bn.height = parent.height + 1
+		bn.skip = parent.ancestor(skipHeight(bn.height))
+	}
+}
+
+// invertLowestOne turns the lowest 1 bit of n to 0.
+func invertLowestOne(n int32) int32 {
+	return n & (n - 1)
+}
+
+// skipHeight returns the height which the skip pointer of the node at the given height points
+// to. Any height is reachable within O(log(n)) steps following the skip pointers, see also
+// GetSkipHeight in bitcoin core.
+func skipHeight(height int32) int32 {
+	if height < 2 {
+		return 0
 	}
+
+	if height&1 != 0 {
+		return invertLowestOne(invertLowestOne(height-1)) + 1
+	}
+
+	return invertLowestOne(height)
 }
 
 func (bn *blockNode) ancestor(height int32) (ancestor *blockNode) {
@@ -62,31 +88,93 @@ func (bn *blockNode) ancestor(height int32) (ancestor *blockNode) {
 
 	ancestor = bn
 
-	for ancestor != nil && ancestor.height != height {
-		ancestor = ancestor.parent
+	for ancestor != nil && ancestor.height > height {
+		// Only follow the skip pointer if it doesn't overshoot the target, and the skip pointer
+		// of the parent doesn't get closer to the target
+		hs, hp := skipHeight(ancestor.height), skipHeight(ancestor.height-1)
+
+		if ancestor.skip != nil &&
+			(hs == height || (hs > height && !(hp < hs-2 && hp >= height))) {
+			ancestor = ancestor.skip
+		} else {
+			ancestor = ancestor.parent
+		}
 	}
 
 	return ancestor
 }
 
+// medianTimePast returns the median timestamp of the node and its previous ancestors.
+func (bn *blockNode) medianTimePast() time.Time {
+	timestamps := make([]time.Time, 0, medianTimeBlocks)
+
+	for node := bn; node != nil && len(timestamps) < medianTimeBlocks; node = node.parent {
+		timestamps = append(timestamps, node.timestamp)
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+
+	return timestamps[len(timestamps)/2]
+}
+
 func (bn *blockNode) indexKey() []byte {
 	indexKey := make([]byte, hash.HashSize+4)
 	binary.BigEndian.PutUint32(indexKey[0:4], uint32(bn.height))
-	copy(indexKey[4:hash.HashSize], bn.hash[:])
+	copy(indexKey[4:], bn.hash[:])
 	return indexKey
 }
 
+// isBetterThan reports whether the branch ended with bn is preferred to the one ended with other:
+// the higher branch is preferred, and the one with the smaller tip hash is preferred if they have
+// the same height, so that all the replicas choose the same best chain.
+func (bn *blockNode) isBetterThan(other *blockNode) bool {
+	if bn.height != other.height {
+		return bn.height > other.height
+	}
+
+	return bytes.Compare(bn.hash[:], other.hash[:]) < 0
+}
+
+// lastCommonAncestor returns the last common ancestor of the two nodes, or nil if they are not
+// on the same tree.
+func lastCommonAncestor(a, b *blockNode) *blockNode {
+	if a == nil || b == nil {
+		return nil
+	}
+
+	if a.height > b.height {
+		a = a.ancestor(b.height)
+	} else if b.height > a.height {
+		b = b.ancestor(a.height)
+	}
+
+	for a != nil && b != nil && a != b {
+		a = a.parent
+		b = b.parent
+	}
+
+	if a == nil || b == nil {
+		return nil
+	}
+
+	return a
+}
+
 type blockIndex struct {
 	cfg *Config
 
 	mu    sync.RWMutex
 	index map[hash.Hash]*blockNode
+	tips  map[hash.Hash]*blockNode
 }
 
 func newBlockIndex(cfg *Config) (index *blockIndex) {
 	index = &blockIndex{
 		cfg:   cfg,
 		index: make(map[hash.Hash]*blockNode),
+		tips:  make(map[hash.Hash]*blockNode),
 	}
 
 	return index
@@ -96,6 +184,27 @@ func (bi *blockIndex) AddBlock(newBlock *blockNode) {
 	bi.mu.Lock()
 	defer bi.mu.Unlock()
 	bi.index[newBlock.hash] = newBlock
+
+	// Blocks are always added after their parents, so the new block is a tip and the parent is
+	// no longer a tip
+	if newBlock.parent != nil {
+		delete(bi.tips, newBlock.parent.hash)
+	}
+
+	bi.tips[newBlock.hash] = newBlock
+}
+
+// Tips returns the tip nodes of all the branches.
+func (bi *blockIndex) Tips() (tips []*blockNode) {
+	bi.mu.RLock()
+	defer bi.mu.RUnlock()
+	tips = make([]*blockNode, 0, len(bi.tips))
+
+	for _, v := range bi.tips {
+		tips = append(tips, v)
+	}
+
+	return
 }
 
 func (bi *blockIndex) HasBlock(hash *hash.Hash) (hasBlock bool) {
@@ -17,7 +17,9 @@
 package sqlchain
 
 import (
+	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/thunderdb/ThunderDB/crypto/hash"
 )
@@ -43,6 +45,35 @@ func generateTestBlocks() (err error) {
 	return
 }
 
+// createTestNodes creates a chain of block nodes without signing real blocks.
+func createTestNodes(n int) (nodes []*blockNode) {
+	nodes = make([]*blockNode, n)
+	parent := (*blockNode)(nil)
+	t := time.Now().UTC()
+
+	for i := range nodes {
+		header := &SignedHeader{Header: Header{Timestamp: t.Add(time.Duration(i) * time.Second)}}
+		rand.Read(header.BlockHash[:])
+		nodes[i] = newBlockNode(header, parent)
+		parent = nodes[i]
+	}
+
+	return
+}
+
+// linearAncestor walks the parent pointers one by one to find the ancestor.
+func linearAncestor(bn *blockNode, height int32) *blockNode {
+	if height < 0 || height > bn.height {
+		return nil
+	}
+
+	for bn != nil && bn.height != height {
+		bn = bn.parent
+	}
+
+	return bn
+}
+
 func init() {
 	err := generateTestBlocks()
 
@@ -166,3 +197,94 @@ func TestIndex(t *testing.T) {
 		}
 	}
 }
+
+func TestSkipHeight(t *testing.T) {
+	for h := int32(0); h < 10000; h++ {
+		if sh := skipHeight(h); sh < 0 || (h > 0 && sh >= h) {
+			t.Fatalf("Unexpected skip height: height = %d, skip = %d", h, sh)
+		}
+	}
+}
+
+func TestSkipAncestor(t *testing.T) {
+	nodes := createTestNodes(10000)
+
+	for i, n := range nodes {
+		if n.height != int32(i) {
+			t.Fatalf("Unexpected height: got %d while expecting %d", n.height, i)
+		}
+
+		if i > 0 && (n.skip == nil || n.skip.height != skipHeight(n.height)) {
+			t.Fatalf("Unexpected skip pointer: %v", n.skip)
+		}
+	}
+
+	for i := 0; i < 10000; i++ {
+		n := nodes[rand.Intn(len(nodes))]
+		h := rand.Int31n(n.height+3) - 1
+
+		if a, e := n.ancestor(h), linearAncestor(n, h); a != e {
+			t.Fatalf("Unexpected ancestor: height = %d, got %v while expecting %v", h, a, e)
+		}
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	nodes := createTestNodes(100)
+
+	// Timestamps are increasing by 1 second
+	if mtp := nodes[0].medianTimePast(); !mtp.Equal(nodes[0].timestamp) {
+		t.Fatalf("Unexpected median time past: %v", mtp)
+	}
+
+	if mtp := nodes[4].medianTimePast(); !mtp.Equal(nodes[2].timestamp) {
+		t.Fatalf("Unexpected median time past: %v", mtp)
+	}
+
+	if mtp := nodes[99].medianTimePast(); !mtp.Equal(nodes[94].timestamp) {
+		t.Fatalf("Unexpected median time past: %v", mtp)
+	}
+
+	// Out of order timestamps
+	nodes[98].timestamp = nodes[0].timestamp.Add(-time.Hour)
+
+	if mtp := nodes[99].medianTimePast(); !mtp.Equal(nodes[93].timestamp) {
+		t.Fatalf("Unexpected median time past: %v", mtp)
+	}
+}
+
+func benchmarkAncestor(b *testing.B, n int, ancestor func(*blockNode, int32) *blockNode) {
+	nodes := createTestNodes(n)
+	tip := nodes[len(nodes)-1]
+	heights := make([]int32, 1024)
+
+	for i := range heights {
+		heights[i] = rand.Int31n(tip.height + 1)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ancestor(tip, heights[i%len(heights)])
+	}
+}
+
+func BenchmarkAncestor1K(b *testing.B) {
+	benchmarkAncestor(b, 1000, (*blockNode).ancestor)
+}
+
+func BenchmarkAncestor100K(b *testing.B) {
+	benchmarkAncestor(b, 100000, (*blockNode).ancestor)
+}
+
+func BenchmarkAncestor1M(b *testing.B) {
+	benchmarkAncestor(b, 1000000, (*blockNode).ancestor)
+}
+
+func BenchmarkLinearAncestor1K(b *testing.B) {
+	benchmarkAncestor(b, 1000, linearAncestor)
+}
+
+func BenchmarkLinearAncestor100K(b *testing.B) {
+	benchmarkAncestor(b, 100000, linearAncestor)
+}
@@ -19,8 +19,11 @@ package sqlchain
 import (
 	"bytes"
 	"encoding/binary"
+	"sync"
+	"time"
 
 	bolt "github.com/coreos/bbolt"
+	log "github.com/sirupsen/logrus"
 	"github.com/thunderdb/ThunderDB/crypto/hash"
 	"github.com/thunderdb/ThunderDB/utils"
 )
@@ -29,6 +32,10 @@ var (
 	metaBucket           = [4]byte{0x0, 0x0, 0x0, 0x0}
 	metaStateKey         = []byte("thunderdb-state")
 	metaBlockIndexBucket = []byte("thunderdb-block-index-bucket")
+	metaBlockBodyBucket  = []byte("thunderdb-block-body-bucket")
+	metaBlockProofBucket = []byte("thunderdb-block-proof-bucket")
+	metaProofBucket      = []byte("thunderdb-storage-proof-bucket")
+	metaPenaltyBucket    = []byte("thunderdb-penalty-bucket")
 )
 
 // State represents a snapshot of current best chain.
@@ -61,6 +68,7 @@ func (s *State) unmarshal(b []byte) (err error) {
 
 // Chain represents a sql-chain.
 type Chain struct {
+	mu           sync.Mutex
 	cfg          *Config
 	db           *bolt.DB
 	index        *blockIndex
@@ -91,7 +99,23 @@ func NewChain(cfg *Config) (chain *Chain, err error) {
 			return
 		}
 
-		_, err = bucket.CreateBucketIfNotExists(metaBlockIndexBucket)
+		if _, err = bucket.CreateBucketIfNotExists(metaBlockIndexBucket); err != nil {
+			return
+		}
+
+		if _, err = bucket.CreateBucketIfNotExists(metaBlockBodyBucket); err != nil {
+			return
+		}
+
+		if _, err = bucket.CreateBucketIfNotExists(metaBlockProofBucket); err != nil {
+			return
+		}
+
+		if _, err = bucket.CreateBucketIfNotExists(metaProofBucket); err != nil {
+			return
+		}
+
+		_, err = bucket.CreateBucketIfNotExists(metaPenaltyBucket)
 		return
 	})
 
@@ -112,7 +136,7 @@ func NewChain(cfg *Config) (chain *Chain, err error) {
 		},
 	}
 
-	err = chain.PushBlock(cfg.Genesis.SignedHeader)
+	err = chain.PushBlock(cfg.Genesis)
 
 	if err != nil {
 		return nil, err
@@ -121,13 +145,6 @@ func NewChain(cfg *Config) (chain *Chain, err error) {
 	return
 }
 
-func blockIndexKey(blockHash *hash.Hash, height uint32) []byte {
-	indexKey := make([]byte, hash.HashSize+4)
-	binary.BigEndian.PutUint32(indexKey[0:4], height)
-	copy(indexKey[4:hash.HashSize], blockHash[:])
-	return indexKey
-}
-
 // LoadChain loads the chain state from the specified database and rebuilds a memory index.
 func LoadChain(cfg *Config) (chain *Chain, err error) {
 	// Open DB file
@@ -155,19 +172,17 @@ func LoadChain(cfg *Config) (chain *Chain, err error) {
 			return err
 		}
 
-		// Rebuild memory index
-		blockCount := int32(0)
+		// Rebuild memory index, blocks are sorted by height so that parents are always added
+		// before their children
 		bi := bucket.Bucket(metaBlockIndexBucket)
-		cursor := bi.Cursor()
+		bb := bucket.Bucket(metaBlockBodyBucket)
+		bp := bucket.Bucket(metaBlockProofBucket)
 
-		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
-			blockCount++
+		if bb == nil || bp == nil {
+			return ErrBlockBodyNotFound
 		}
 
-		lastNode := (*blockNode)(nil)
-		index := int32(0)
-		nodes := make([]blockNode, blockCount)
-		cursor = bi.Cursor()
+		cursor := bi.Cursor()
 
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
 			header := &SignedHeader{}
@@ -177,29 +192,66 @@ func LoadChain(cfg *Config) (chain *Chain, err error) {
 				return err
 			}
 
-			parent := (*blockNode)(nil)
+			// Verify block body
+			body := bb.Get(k)
 
-			if lastNode == nil {
-				if err = header.VerifyAsGenesis(); err != nil {
+			if body == nil {
+				return ErrBlockBodyNotFound
+			}
+
+			var (
+				queries Queries
+				results ProofResults
+				mr, pr  hash.Hash
+			)
+
+			if err = queries.unmarshal(body); err != nil {
+				return
+			}
+
+			if mr, err = queries.MerkleRoot(); err != nil {
+				return
+			}
+
+			if !mr.IsEqual(&header.MerkleRoot) {
+				return ErrMerkleRootVerification
+			}
+
+			if v := bp.Get(k); v != nil {
+				if err = results.unmarshal(v); err != nil {
 					return
 				}
-			} else if header.ParentHash == lastNode.hash {
-				if err = header.Verify(); err != nil {
+			}
+
+			if pr, err = results.Hash(); err != nil {
+				return
+			}
+
+			if !pr.IsEqual(&header.ProofRoot) {
+				return ErrProofRootVerification
+			}
+
+			parent := (*blockNode)(nil)
+
+			if binary.BigEndian.Uint32(k[:4]) == 0 {
+				if err = header.VerifyAsGenesis(); err != nil {
 					return
 				}
-
-				parent = lastNode
 			} else {
-				parent = chain.index.LookupNode(&header.ParentHash)
-
-				if parent == nil {
+				if parent = chain.index.LookupNode(&header.ParentHash); parent == nil {
 					return ErrParentNotFound
 				}
+
+				if err = header.Verify(); err != nil {
+					return
+				}
 			}
 
-			nodes[index].initBlockNode(header, parent)
-			lastNode = &nodes[index]
-			index++
+			chain.index.AddBlock(newBlockNode(header, parent))
+		}
+
+		if chain.state.node = chain.index.LookupNode(&chain.state.Head); chain.state.node == nil {
+			return ErrParentNotFound
 		}
 
 		return nil
@@ -212,43 +264,373 @@ func LoadChain(cfg *Config) (chain *Chain, err error) {
 	return
 }
 
-// PushBlock pushes the signed block header to extend the current main chain.
-func (c *Chain) PushBlock(block *SignedHeader) (err error) {
-	// Pushed block must extend the best chain
-	if block.Header.ParentHash != hash.Hash(c.state.Head) {
+// PushBlock pushes the block to extend the current main chain, the block header, queries and
+// proof results are stored in the chain database.
+func (c *Chain) PushBlock(block *Block) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if block.SignedHeader == nil {
+		return ErrNilValue
+	}
+
+	if c.index.HasBlock(&block.SignedHeader.BlockHash) {
+		return ErrBlockExists
+	}
+
+	// Pushed block must be a genesis block of an empty chain, or extend any known block
+	parent := (*blockNode)(nil)
+
+	if c.state.node == nil {
+		if block.SignedHeader.ParentHash != c.state.Head {
+			return ErrInvalidBlock
+		}
+	} else if parent = c.index.LookupNode(&block.SignedHeader.ParentHash); parent == nil {
+		return ErrParentNotFound
+	}
+
+	// Packed logs are never unpacked
+	if parent != nil && block.SignedHeader.LogIndex < parent.logIndex {
 		return ErrInvalidBlock
 	}
 
-	// Update best state
-	c.state.node = newBlockNode(block, c.state.node)
-	c.state.Head = [32]byte(block.BlockHash)
-	c.state.Height++
+	if err = block.Verify(); err != nil {
+		return
+	}
 
-	// Update index
-	c.index.AddBlock(c.state.node)
+	if err = c.checkProofResults(block, parent); err != nil {
+		return
+	}
+
+	// Switch to the new branch if it's better than the current best chain
+	node := newBlockNode(block.SignedHeader, parent)
+	state := c.state
+
+	if c.state.node == nil || node.isBetterThan(c.state.node) {
+		state = &State{
+			node:   node,
+			Head:   node.hash,
+			Height: node.height,
+		}
+	}
 
 	// Write to db
-	return c.db.Update(func(tx *bolt.Tx) (err error) {
-		buffer, err := block.marshal()
+	err = c.db.Update(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(metaBucket[:])
+		buffer, err := block.SignedHeader.marshal()
+
+		if err != nil {
+			return err
+		}
+
+		key := node.indexKey()
+		err = bucket.Bucket(metaBlockIndexBucket).Put(key, buffer)
+
+		if err != nil {
+			return err
+		}
+
+		buffer, err = block.Queries.marshal()
 
 		if err != nil {
 			return err
 		}
 
-		key := c.state.node.indexKey()
-		err = tx.Bucket(metaBucket[:]).Bucket(metaBlockIndexBucket).Put(key, buffer)
+		err = bucket.Bucket(metaBlockBodyBucket).Put(key, buffer)
 
 		if err != nil {
 			return err
 		}
 
-		buffer, err = c.state.marshal()
+		if len(block.ProofResults) > 0 {
+			if buffer, err = block.ProofResults.marshal(); err != nil {
+				return err
+			}
+
+			if err = bucket.Bucket(metaBlockProofBucket).Put(key, buffer); err != nil {
+				return err
+			}
+		}
+
+		// The proof results are indexed along the best chain only
+		if state != c.state {
+			if err = switchProofResults(bucket, c.state.node, node); err != nil {
+				return err
+			}
+		}
+
+		buffer, err = state.marshal()
 
 		if err != nil {
 			return err
 		}
 
-		err = tx.Bucket(metaBucket[:]).Put(metaStateKey, buffer)
+		return bucket.Put(metaStateKey, buffer)
+	})
+
+	if err != nil {
+		return
+	}
+
+	// Update best state and index
+	if old := c.state.node; old != nil && state.node != old && parent != old {
+		fork := lastCommonAncestor(old, node)
+		log.Infof("reorganize sql-chain: fork = %s, old = %s@%d, new = %s@%d",
+			fork.hash, old.hash, old.height, node.hash, node.height)
+	}
+
+	c.state = state
+	c.index.AddBlock(node)
+	return
+}
+
+// Tips returns the states of the tips of all the known branches, including the best one.
+func (c *Chain) Tips() (tips []State) {
+	nodes := c.index.Tips()
+	tips = make([]State, len(nodes))
+
+	for i, v := range nodes {
+		tips[i] = State{
+			node:   v,
+			Head:   v.hash,
+			Height: v.height,
+		}
+	}
+
+	return
+}
+
+// head returns the hash and height of the current best block.
+func (c *Chain) head() (h hash.Hash, height int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Head, c.state.Height
+}
+
+// Head returns the state of the current best chain.
+func (c *Chain) Head() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.state
+}
+
+// MedianTimePast returns the median timestamp of the last blocks of the best chain.
+func (c *Chain) MedianTimePast() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.node.medianTimePast()
+}
+
+// lookupByHeight returns the node at the given height on the best chain.
+func (c *Chain) lookupByHeight(height int32) (node *blockNode, err error) {
+	c.mu.Lock()
+	head := c.state.node
+	c.mu.Unlock()
+
+	if node = head.ancestor(height); node == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	return
+}
+
+// lookupByHash returns the node of the given block hash.
+func (c *Chain) lookupByHash(h *hash.Hash) (node *blockNode, err error) {
+	if node = c.index.LookupNode(h); node == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	return
+}
+
+// fetchHeader reads the header of the node from the chain database.
+func (c *Chain) fetchHeader(node *blockNode) (header *SignedHeader, err error) {
+	err = c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(metaBucket[:]).Bucket(metaBlockIndexBucket).Get(node.indexKey())
+
+		if v == nil {
+			return ErrBlockNotFound
+		}
+
+		header = &SignedHeader{}
+		return header.unmarshal(v)
+	})
+
+	return
+}
+
+// fetchBlock reads the header, queries and proof results of the node from the chain database.
+func (c *Chain) fetchBlock(node *blockNode) (block *Block, err error) {
+	err = c.db.View(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(metaBucket[:])
+		key := node.indexKey()
+		v := bucket.Bucket(metaBlockIndexBucket).Get(key)
+
+		if v == nil {
+			return ErrBlockNotFound
+		}
+
+		block = &Block{SignedHeader: &SignedHeader{}}
+
+		if err = block.SignedHeader.unmarshal(v); err != nil {
+			return
+		}
+
+		if v = bucket.Bucket(metaBlockBodyBucket).Get(key); v == nil {
+			return ErrBlockBodyNotFound
+		}
+
+		if err = block.Queries.unmarshal(v); err != nil {
+			return
+		}
+
+		block.ProofResults, err = readProofResults(bucket.Bucket(metaBlockProofBucket), node)
+		return
+	})
+
+	return
+}
+
+// GetHeader returns the header of the given block hash, the block may be on any branch.
+func (c *Chain) GetHeader(h *hash.Hash) (header *SignedHeader, err error) {
+	node, err := c.lookupByHash(h)
+
+	if err != nil {
+		return
+	}
+
+	return c.fetchHeader(node)
+}
+
+// GetBlock returns the block of the given block hash, the block may be on any branch.
+func (c *Chain) GetBlock(h *hash.Hash) (block *Block, err error) {
+	node, err := c.lookupByHash(h)
+
+	if err != nil {
+		return
+	}
+
+	return c.fetchBlock(node)
+}
+
+// GetHeaderByHeight returns the header at the given height on the best chain.
+func (c *Chain) GetHeaderByHeight(height int32) (header *SignedHeader, err error) {
+	node, err := c.lookupByHeight(height)
+
+	if err != nil {
+		return
+	}
+
+	return c.fetchHeader(node)
+}
+
+// GetBlockByHeight returns the block at the given height on the best chain.
+func (c *Chain) GetBlockByHeight(height int32) (block *Block, err error) {
+	node, err := c.lookupByHeight(height)
+
+	if err != nil {
+		return
+	}
+
+	return c.fetchBlock(node)
+}
+
+// GetAncestor returns the header of the ancestor at the given height of the given block.
+func (c *Chain) GetAncestor(h *hash.Hash, height int32) (header *SignedHeader, err error) {
+	node, err := c.lookupByHash(h)
+
+	if err != nil {
+		return
+	}
+
+	if node = node.ancestor(height); node == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	return c.fetchHeader(node)
+}
+
+// GetHeaders returns the headers of the best chain in height range [from, to].
+func (c *Chain) GetHeaders(from, to int32) (headers []*SignedHeader, err error) {
+	err = c.ForEachBlock(from, to, false, func(block *Block) error {
+		headers = append(headers, block.SignedHeader)
+		return nil
+	})
+
+	return
+}
+
+// GetBlocks returns the blocks of the best chain in height range [from, to].
+func (c *Chain) GetBlocks(from, to int32) (blocks Blocks, err error) {
+	err = c.ForEachBlock(from, to, true, func(block *Block) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+
+	return
+}
+
+// ForEachBlock calls fn with the blocks of the best chain in height range [from, to] in height
+// order, the queries and proof results are not read if withQueries is false. It stops at the
+// first error returned by fn. Note that fn is called in a read transaction of the chain database,
+// so it must not push blocks to the chain.
+func (c *Chain) ForEachBlock(from, to int32, withQueries bool, fn func(*Block) error) (
+	err error) {
+	if from < 0 || from > to {
+		return ErrInvalidRange
+	}
+
+	last, err := c.lookupByHeight(to)
+
+	if err != nil {
+		return
+	}
+
+	// Collect the nodes backwards from the end of the range
+	nodes := make([]*blockNode, to-from+1)
+
+	for i, node := len(nodes)-1, last; i >= 0; i, node = i-1, node.parent {
+		nodes[i] = node
+	}
+
+	return c.db.View(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(metaBucket[:])
+		bi := bucket.Bucket(metaBlockIndexBucket)
+		bb := bucket.Bucket(metaBlockBodyBucket)
+		bp := bucket.Bucket(metaBlockProofBucket)
+
+		for _, node := range nodes {
+			key := node.indexKey()
+			v := bi.Get(key)
+
+			if v == nil {
+				return ErrBlockNotFound
+			}
+
+			block := &Block{SignedHeader: &SignedHeader{}}
+
+			if err = block.SignedHeader.unmarshal(v); err != nil {
+				return
+			}
+
+			if withQueries {
+				if v = bb.Get(key); v == nil {
+					return ErrBlockBodyNotFound
+				}
+
+				if err = block.Queries.unmarshal(v); err != nil {
+					return
+				}
+
+				if block.ProofResults, err = readProofResults(bp, node); err != nil {
+					return
+				}
+			}
+
+			if err = fn(block); err != nil {
+				return
+			}
+		}
 
 		return
 	})
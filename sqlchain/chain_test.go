@@ -145,7 +145,7 @@ func TestChain(t *testing.T) {
 	for block, err := createRandomBlock(
 		genesis.SignedHeader.BlockHash, false,
 	); err == nil; block, err = createRandomBlock(block.SignedHeader.BlockHash, false) {
-		err = chain.PushBlock(block.SignedHeader)
+		err = chain.PushBlock(block)
 
 		if err != nil {
 			t.Fatalf("Error occurred: %v", err)
@@ -173,3 +173,371 @@ func TestChain(t *testing.T) {
 		t.Fatalf("Error occurred: %v", err)
 	}
 }
+
+func TestChainFork(t *testing.T) {
+	fl, err := ioutil.TempFile("", "chain")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain, err := NewChain(&Config{
+		DataDir: fl.Name(),
+		Genesis: genesis,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	createBranch := func(parent hash.Hash, length int) (blocks []*Block) {
+		blocks = make([]*Block, length)
+
+		for i := range blocks {
+			b, err := createRandomBlock(parent, false)
+
+			if err != nil {
+				t.Fatalf("Error occurred: %v", err)
+			}
+
+			blocks[i] = b
+			parent = b.SignedHeader.BlockHash
+		}
+
+		return
+	}
+
+	// genesis <- a0 <- a1 <- a2
+	//             ^--- b0 <- b1 <- b2
+	branchA := createBranch(genesis.SignedHeader.BlockHash, 3)
+	branchB := createBranch(branchA[0].SignedHeader.BlockHash, 3)
+
+	for _, b := range branchA {
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	for i, b := range branchB {
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		// b1 has the same height as a2, the smaller hash wins
+		if i == 1 {
+			expected := branchA[2]
+
+			if bytes.Compare(b.SignedHeader.BlockHash[:], expected.SignedHeader.BlockHash[:]) < 0 {
+				expected = b
+			}
+
+			if chain.state.Head != expected.SignedHeader.BlockHash {
+				t.Fatalf("Unexpected head: %s", chain.state.Head)
+			}
+		}
+	}
+
+	if chain.state.Head != branchB[2].SignedHeader.BlockHash || chain.state.Height != 4 {
+		t.Fatalf("Unexpected state: head = %s, height = %d", chain.state.Head, chain.state.Height)
+	}
+
+	if tips := chain.Tips(); len(tips) != 2 {
+		t.Fatalf("Unexpected tips: %v", tips)
+	}
+
+	fork := lastCommonAncestor(
+		chain.index.LookupNode(&branchA[2].SignedHeader.BlockHash), chain.state.node)
+
+	if fork == nil || fork.hash != branchA[0].SignedHeader.BlockHash {
+		t.Fatalf("Unexpected fork point: %v", fork)
+	}
+
+	// Extend the shorter branch to switch back
+	branchA = append(branchA, createBranch(branchA[2].SignedHeader.BlockHash, 3)...)
+
+	for _, b := range branchA[3:] {
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	if chain.state.Head != branchA[5].SignedHeader.BlockHash || chain.state.Height != 6 {
+		t.Fatalf("Unexpected state: head = %s, height = %d", chain.state.Head, chain.state.Height)
+	}
+
+	if err = chain.PushBlock(branchB[0]); err == ErrBlockExists {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	orphan := createBranch(hash.Hash{}, 1)[0]
+
+	if err = chain.PushBlock(orphan); err == ErrParentNotFound {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Reload chain and check the branches
+	chain.db.Close()
+	chain, err = LoadChain(&Config{DataDir: fl.Name()})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if chain.state.Head != branchA[5].SignedHeader.BlockHash || chain.state.Height != 6 ||
+		chain.state.node == nil {
+		t.Fatalf("Unexpected state: head = %s, height = %d", chain.state.Head, chain.state.Height)
+	}
+
+	if tips := chain.Tips(); len(tips) != 2 {
+		t.Fatalf("Unexpected tips: %v", tips)
+	}
+
+	if err = chain.PushBlock(createBranch(branchB[2].SignedHeader.BlockHash, 1)[0]); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+}
+
+func TestChainQuery(t *testing.T) {
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain := createTestChain(t, genesis)
+	blocks := Blocks{genesis}
+
+	for i := 0; i < 10; i++ {
+		b, err := createRandomBlock(blocks[len(blocks)-1].SignedHeader.BlockHash, false)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		blocks = append(blocks, b)
+	}
+
+	// A side branch from height 5
+	side, err := createRandomBlock(blocks[5].SignedHeader.BlockHash, false)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(side); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if head := chain.Head(); head.Head != blocks[10].SignedHeader.BlockHash || head.Height != 10 {
+		t.Fatalf("Unexpected head: head = %s, height = %d", head.Head, head.Height)
+	}
+
+	if mtp := chain.MedianTimePast(); !mtp.Equal(blocks[5].SignedHeader.Timestamp) {
+		t.Fatalf("Unexpected median time past: %v", mtp)
+	}
+
+	for i, b := range blocks {
+		block, err := chain.GetBlockByHeight(int32(i))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if !reflect.DeepEqual(block.SignedHeader.Header, b.SignedHeader.Header) ||
+			len(block.Queries) != len(b.Queries) {
+			t.Fatalf("Values don't match:\n\tv1 = %+v\n\tv2 = %+v", block, b)
+		}
+
+		if err = block.Verify(); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		header, err := chain.GetHeader(&b.SignedHeader.BlockHash)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if !reflect.DeepEqual(header.Header, b.SignedHeader.Header) {
+			t.Fatalf("Values don't match:\n\tv1 = %+v\n\tv2 = %+v", header, b.SignedHeader)
+		}
+	}
+
+	// Blocks on side branch can be fetched by hash but not by height
+	if block, err := chain.GetBlock(&side.SignedHeader.BlockHash); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	} else if block.SignedHeader.BlockHash != side.SignedHeader.BlockHash {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+
+	if header, err := chain.GetHeaderByHeight(6); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	} else if header.BlockHash != blocks[6].SignedHeader.BlockHash {
+		t.Fatalf("Unexpected header: %v", header)
+	}
+
+	if header, err := chain.GetAncestor(&side.SignedHeader.BlockHash, 3); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	} else if header.BlockHash != blocks[3].SignedHeader.BlockHash {
+		t.Fatalf("Unexpected header: %v", header)
+	}
+
+	headers, err := chain.GetHeaders(2, 8)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(headers) != 7 {
+		t.Fatalf("Unexpected header count: %d", len(headers))
+	}
+
+	for i, h := range headers {
+		if h.BlockHash != blocks[i+2].SignedHeader.BlockHash {
+			t.Fatalf("Unexpected header: %v", h)
+		}
+	}
+
+	rBlocks, err := chain.GetBlocks(9, 10)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(rBlocks) != 2 || len(rBlocks[1].Queries) != len(blocks[10].Queries) {
+		t.Fatalf("Unexpected blocks: %v", rBlocks)
+	}
+
+	// Invalid queries
+	h := hash.Hash{}
+
+	if _, err = chain.GetBlock(&h); err == ErrBlockNotFound {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = chain.GetBlockByHeight(11); err == ErrBlockNotFound {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = chain.GetAncestor(&blocks[3].SignedHeader.BlockHash, 4); err == ErrBlockNotFound {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = chain.GetHeaders(5, 4); err == ErrInvalidRange {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err = chain.GetHeaders(5, 11); err == ErrBlockNotFound {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestChainForkLogIndex(t *testing.T) {
+	fl, err := ioutil.TempFile("", "chain")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain, err := NewChain(&Config{
+		DataDir: fl.Name(),
+		Genesis: genesis,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	pushBlock := func(parent hash.Hash, index uint64) *Block {
+		b, err := createRandomBlock(parent, false, withLogIndex(index))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		return b
+	}
+
+	// genesis <- a0(2) <- a1(5)
+	//              ^----- b0(3) <- b1(3) <- b2(4)
+	a0 := pushBlock(genesis.SignedHeader.BlockHash, 2)
+	pushBlock(a0.SignedHeader.BlockHash, 5)
+
+	if index := chain.logIndex(); index != 5 {
+		t.Fatalf("Unexpected log index: %d", index)
+	}
+
+	b0 := pushBlock(a0.SignedHeader.BlockHash, 3)
+	b1 := pushBlock(b0.SignedHeader.BlockHash, 3)
+	b2 := pushBlock(b1.SignedHeader.BlockHash, 4)
+
+	// The logs packed by the detached branch are unpacked by the reorganization
+	if chain.state.Head != b2.SignedHeader.BlockHash {
+		t.Fatalf("Unexpected head: %s", chain.state.Head)
+	}
+
+	if index := chain.logIndex(); index != 4 {
+		t.Fatalf("Unexpected log index: %d", index)
+	}
+
+	// Blocks still can't rewind the logs packed by their own parents
+	b, err := createRandomBlock(b2.SignedHeader.BlockHash, false, withLogIndex(2))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(b); err == ErrInvalidBlock {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Reload chain and check the log index of the best chain
+	chain.db.Close()
+	chain, err = LoadChain(&Config{DataDir: fl.Name()})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if index := chain.logIndex(); index != 4 {
+		t.Fatalf("Unexpected log index: %d", index)
+	}
+}
@@ -44,10 +44,73 @@ var (
 	// it as an error.
 	ErrNilValue = errors.New("unexpected nil value")
 
-	// ErrParentNotFound indicates an error failing to find parent node during a chain reloading.
+	// ErrParentNotFound indicates an error failing to find parent node during a chain reloading or
+	// a block pushing.
 	ErrParentNotFound = errors.New("could not find parent node")
 
-	// ErrInvalidBlock indicates an invalid block which does not extend the best chain while
-	// pushing new blocks.
+	// ErrMerkleRootVerification indicates a failed merkle root verificaiton.
+	ErrMerkleRootVerification = errors.New("merkle root verification failed")
+
+	// ErrBlockBodyNotFound indicates that the queries of a block are missing from the chain
+	// database.
+	ErrBlockBodyNotFound = errors.New("block body not found")
+
+	// ErrInvalidBlock indicates an invalid block which does not extend the root of an empty
+	// chain, or rewinds the logs packed by its parent while pushing new blocks.
 	ErrInvalidBlock = errors.New("invalid block")
+
+	// ErrQueryTooLarge indicates that the statement or an argument of a query is too long to be
+	// serialized into a block.
+	ErrQueryTooLarge = errors.New("query too large")
+
+	// ErrInvalidProducerConfig indicates that some required fields of the producer config are
+	// missing.
+	ErrInvalidProducerConfig = errors.New("invalid producer config")
+
+	// ErrProducerStarted indicates that the producer is already started.
+	ErrProducerStarted = errors.New("producer already started")
+
+	// ErrNotLeader indicates that the local node isn't the leader of the database, which is the
+	// only producer of its blocks.
+	ErrNotLeader = errors.New("not leader of the database")
+
+	// ErrInvalidLogData indicates that the log data is not decoded as an execution log.
+	ErrInvalidLogData = errors.New("invalid log data")
+
+	// ErrUnknownDatabase indicates that the database is not hosted by the node.
+	ErrUnknownDatabase = errors.New("unknown database")
+
+	// ErrInvalidBlockProducer indicates that an advised block isn't produced and signed by one of
+	// the peers of the database.
+	ErrInvalidBlockProducer = errors.New("block is not produced by a peer of the database")
+
+	// ErrBlockExists indicates that the block is already in the chain.
+	ErrBlockExists = errors.New("block already exists")
+
+	// ErrBlockNotFound indicates that the requested block is not found in the chain.
+	ErrBlockNotFound = errors.New("block not found")
+
+	// ErrInvalidRange indicates an invalid height range.
+	ErrInvalidRange = errors.New("invalid height range")
+
+	// ErrQueryArgsNotSupported indicates that a query with arguments can not be replayed into a
+	// storage, which only executes plain statements.
+	ErrQueryArgsNotSupported = errors.New("query arguments not supported")
+
+	// ErrDatabaseExists indicates that the target database of a restoring is not empty.
+	ErrDatabaseExists = errors.New("database already exists")
+
+	// ErrBillingMismatch indicates that a billing summary to sign doesn't match the local chain.
+	ErrBillingMismatch = errors.New("billing doesn't match the local chain")
+
+	// ErrInvalidBillingConfig indicates that some required fields of the billing submitter config
+	// are missing.
+	ErrInvalidBillingConfig = errors.New("invalid billing config")
+
+	// ErrBillingSubmitterStarted indicates that the billing submitter is already started.
+	ErrBillingSubmitterStarted = errors.New("billing submitter already started")
+
+	// ErrInsufficientSignatures indicates that a billing summary isn't signed by a majority of the
+	// replicas.
+	ErrInsufficientSignatures = errors.New("billing not signed by a majority of the replicas")
 )
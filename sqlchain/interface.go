@@ -1,9 +1,6 @@
 package sqlchain
 
-import "github.com/thunderdb/ThunderDB/crypto/hash"
+import "github.com/thunderdb/ThunderDB/merkle"
 
 // Hashable is an interface whitch make struct hashable
-type Hashable interface {
-	// Hash return the hash of a hashable value
-	Hash() *hash.Hash
-}
+type Hashable = merkle.Hashable
@@ -0,0 +1,373 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/sqlchain/storage"
+)
+
+// Broadcaster broadcasts the newly produced blocks to the other replicas of the database.
+type Broadcaster interface {
+	BroadcastBlock(block *Block) error
+}
+
+// Leadership tells whether the local node is the leader of the hosted database, it's implemented
+// by kayak.Runtime.
+type Leadership interface {
+	IsLeader() bool
+}
+
+// ProducerConfig represents a block producer config.
+type ProducerConfig struct {
+	// Chain is the local sql-chain which the produced blocks are pushed to.
+	Chain *Chain
+
+	// LogStore and StableStore are the kayak stores of the hosted database, queries are
+	// collected from the committed logs.
+	LogStore    kayak.LogStore
+	StableStore kayak.StableStore
+
+	// LogCodec decodes the log data into *storage.ExecLog.
+	LogCodec kayak.TwoPCLogCodec
+
+	// Broadcaster broadcasts the produced blocks, no broadcasting if it's nil.
+	Broadcaster Broadcaster
+
+	// Leadership tells whether the local node leads the database. Only the kayak leader
+	// produces blocks, so that the replicas don't fork at every height.
+	Leadership Leadership
+
+	// Period is the block producing period.
+	Period time.Duration
+
+	// ProduceEmptyBlock indicates whether to produce a block while there is no new committed
+	// log in a period.
+	ProduceEmptyBlock bool
+
+	// Replicas is the replica set recorded in the produced blocks, the replica set of the
+	// genesis block is used if it's empty.
+	Replicas []proto.NodeID
+}
+
+// Producer periodically packs the committed queries of a hosted database into blocks.
+type Producer struct {
+	cfg *ProducerConfig
+
+	mu     sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+
+	// produceLock serializes the block producing
+	produceLock sync.Mutex
+
+	// Signed queries and storage proof results to be packed into the next block
+	pendingLock    sync.Mutex
+	pending        Queries
+	pendingResults ProofResults
+}
+
+// NewProducer creates a new block producer.
+func NewProducer(cfg *ProducerConfig) (p *Producer, err error) {
+	if cfg.Chain == nil || cfg.LogStore == nil || cfg.StableStore == nil ||
+		cfg.LogCodec == nil || cfg.Leadership == nil || cfg.Period <= 0 {
+		return nil, ErrInvalidProducerConfig
+	}
+
+	return &Producer{cfg: cfg}, nil
+}
+
+// Start starts the producing loop in a new goroutine.
+func (p *Producer) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopCh != nil {
+		return ErrProducerStarted
+	}
+
+	p.stopCh = make(chan struct{})
+	p.doneCh = make(chan struct{})
+	go p.run(p.stopCh, p.doneCh)
+	return nil
+}
+
+// Stop stops the producing loop and waits for it to exit.
+func (p *Producer) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopCh == nil {
+		return
+	}
+
+	close(p.stopCh)
+	<-p.doneCh
+	p.stopCh = nil
+	p.doneCh = nil
+}
+
+func (p *Producer) run(stopCh <-chan struct{}, doneCh chan<- struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(p.cfg.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if _, err := p.ProduceBlock(); err != nil && err != ErrNotLeader {
+				log.Errorf("failed to produce block: %v", err)
+			}
+		}
+	}
+}
+
+// AddQuery adds a query signed by its issuer and answered by the miner to the next block, so that
+// both sides can prove what was asked and what was returned later. The query is an audit record
+// only: writes are also packed from the execution log, and Restore skips answered queries.
+func (p *Producer) AddQuery(q *Query) (err error) {
+	if q.Response == nil {
+		return ErrNilValue
+	}
+
+	if err = q.Verify(); err != nil {
+		return
+	}
+
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+	p.pending = append(p.pending, q)
+	return
+}
+
+// AddProofResult adds a storage proof result signed by the verifier of the round to the next
+// block, the nodes in the result are penalized once the block is pushed to the chain.
+func (p *Producer) AddProofResult(r *ProofResult) (err error) {
+	if err = r.Verify(); err != nil {
+		return
+	}
+
+	if err = p.cfg.Chain.checkProofResult(r); err != nil {
+		return
+	}
+
+	p.pendingLock.Lock()
+	defer p.pendingLock.Unlock()
+
+	for _, v := range p.pendingResults {
+		if v.PreviousBlockID == r.PreviousBlockID {
+			return ErrProofExists
+		}
+	}
+
+	p.pendingResults = append(p.pendingResults, r)
+	return
+}
+
+// ProduceBlock packs the queries committed since the last block and the pending proof results
+// into a new block, signs it with the local private key, pushes it to the chain and broadcasts
+// it. It returns a nil block if there is nothing to pack and empty blocks are not allowed, or
+// ErrNotLeader if the local node isn't the leader of the database.
+func (p *Producer) ProduceBlock() (block *Block, err error) {
+	if !p.cfg.Leadership.IsLeader() {
+		return nil, ErrNotLeader
+	}
+
+	p.produceLock.Lock()
+	defer p.produceLock.Unlock()
+
+	// The log index recorded by the best block, which follows the blocks of the former leaders
+	last := p.cfg.Chain.logIndex()
+	committed, err := kayak.GetCommittedIndex(p.cfg.StableStore)
+
+	if err != nil {
+		return
+	}
+
+	p.pendingLock.Lock()
+	pending, pendingResults := p.pending, p.pendingResults
+	p.pendingLock.Unlock()
+
+	// The rounds may be recorded by the blocks of a former leader meanwhile
+	results := make(ProofResults, 0, len(pendingResults))
+
+	for _, r := range pendingResults {
+		if p.cfg.Chain.checkProofResult(r) == nil {
+			results = append(results, r)
+		}
+	}
+
+	if committed <= last && len(pending) == 0 && len(results) == 0 &&
+		!p.cfg.ProduceEmptyBlock {
+		return nil, nil
+	}
+
+	queries := Queries{}
+
+	for i := last + 1; i <= committed; i++ {
+		var q Queries
+
+		if q, err = p.readQueries(i); err != nil {
+			return
+		}
+
+		queries = append(queries, q...)
+	}
+
+	queries = append(queries, pending...)
+
+	if committed < last {
+		committed = last
+	}
+
+	if block, err = p.newBlock(queries, results, committed); err != nil {
+		return
+	}
+
+	if err = p.cfg.Chain.PushBlock(block); err != nil {
+		return nil, err
+	}
+
+	// Queries may be added while producing
+	p.pendingLock.Lock()
+	p.pending = p.pending[len(pending):]
+	p.pendingResults = p.pendingResults[len(pendingResults):]
+	p.pendingLock.Unlock()
+
+	if p.cfg.Broadcaster != nil {
+		// The block is already accepted locally, the other replicas may catch up later
+		if berr := p.cfg.Broadcaster.BroadcastBlock(block); berr != nil {
+			log.Warnf("failed to broadcast block %s: %v", block.SignedHeader.BlockHash, berr)
+		}
+	}
+
+	return
+}
+
+// readQueries reads the queries of the execution log at the given index.
+func (p *Producer) readQueries(index uint64) (queries Queries, err error) {
+	var l kayak.Log
+
+	if err = p.cfg.LogStore.GetLog(index, &l); err != nil {
+		return
+	}
+
+	var decoded interface{}
+
+	if err = p.cfg.LogCodec.Decode(l.Data, &decoded); err != nil {
+		return
+	}
+
+	el, ok := decoded.(*storage.ExecLog)
+
+	if !ok {
+		return nil, ErrInvalidLogData
+	}
+
+	queries = make(Queries, len(el.Queries))
+
+	for i := range el.Queries {
+		queries[i] = &Query{
+			Type:      WriteQuery,
+			Statement: el.Queries[i],
+			Timestamp: time.Unix(0, int64(el.Timestamp)).UTC(),
+		}
+	}
+
+	return
+}
+
+// newBlock builds a new block extending the current best block and signs it with the local
+// private key.
+func (p *Producer) newBlock(queries Queries, results ProofResults, logIndex uint64) (
+	block *Block, err error) {
+	priv, err := kms.GetLocalPrivateKey()
+
+	if err != nil {
+		return
+	}
+
+	pub, err := kms.GetLocalPublicKey()
+
+	if err != nil {
+		return
+	}
+
+	rawID, err := kms.GetLocalNodeID()
+
+	if err != nil {
+		return
+	}
+
+	nodeID, err := hash.NewHash(rawID)
+
+	if err != nil {
+		return
+	}
+
+	genesis := p.cfg.Chain.cfg.Genesis.SignedHeader
+	head, _ := p.cfg.Chain.head()
+	replicas := p.cfg.Replicas
+
+	if len(replicas) == 0 {
+		replicas = genesis.Replicas
+	}
+
+	block = &Block{
+		SignedHeader: &SignedHeader{
+			Header: Header{
+				Version:    genesis.Version,
+				Producer:   proto.NodeID(nodeID.String()),
+				RootHash:   genesis.RootHash,
+				ParentHash: head,
+				Timestamp:  time.Now().UTC(),
+				LogIndex:   logIndex,
+				Replicas:   replicas,
+			},
+			Signee: pub,
+		},
+		Queries:      queries,
+		ProofResults: results,
+	}
+
+	if err = block.SignHeader(priv); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// logIndex returns the index of the last kayak log packed into the best chain.
+func (c *Chain) logIndex() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.node == nil {
+		return 0
+	}
+
+	return c.state.node.logIndex
+}
@@ -0,0 +1,419 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/sqlchain/storage"
+)
+
+// localBroadcaster advises blocks to the chain RPC services directly.
+type localBroadcaster struct {
+	sync.Mutex
+	databaseID string
+	services   []*ChainRPCService
+	blocks     []*Block
+}
+
+func (b *localBroadcaster) BroadcastBlock(block *Block) (err error) {
+	b.Lock()
+	defer b.Unlock()
+	b.blocks = append(b.blocks, block)
+	buffer, err := block.marshal()
+
+	if err != nil {
+		return
+	}
+
+	for _, s := range b.services {
+		if err = s.AdviseNewBlock(&AdviseNewBlockReq{
+			DatabaseID: b.databaseID,
+			Block:      buffer,
+		}, &AdviseNewBlockResp{}); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (b *localBroadcaster) count() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.blocks)
+}
+
+func createTestChain(t *testing.T, genesis *Block) (chain *Chain) {
+	fl, err := ioutil.TempFile("", "chain")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	chain, err = NewChain(&Config{
+		DataDir: fl.Name(),
+		Genesis: genesis,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+// createLocalPeers returns the peers with the local node as the only server.
+func createLocalPeers(t *testing.T) *kayak.Peers {
+	pub, err := kms.GetLocalPublicKey()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	rawID, err := kms.GetLocalNodeID()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	nodeID, err := hash.NewHash(rawID)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	server := &kayak.Server{
+		Role:   kayak.Leader,
+		ID:     proto.NodeID(nodeID.String()),
+		PubKey: pub,
+	}
+
+	return &kayak.Peers{
+		Leader:  server,
+		Servers: []*kayak.Server{server},
+	}
+}
+
+func appendTestLog(t *testing.T, store *kayak.BoltStore, el *storage.ExecLog) {
+	codec := &storage.ExecLogCodec{}
+	data, err := codec.Encode(el)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	index, err := store.LastIndex()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = store.StoreLog(&kayak.Log{
+		Index: index + 1,
+		Data:  data,
+	}); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Same key as the one used by the kayak twopc runner
+	if err = store.SetUint64([]byte("CommittedIndex"), index+1); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+}
+
+// testLeadership is a Leadership implementation switched by the tests.
+type testLeadership struct {
+	leader bool
+}
+
+func (l *testLeadership) IsLeader() bool {
+	return l.leader
+}
+
+func TestProducer(t *testing.T) {
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain := createTestChain(t, genesis)
+	replica := createTestChain(t, genesis)
+	service := NewChainRPCService()
+	service.AddChain("db", replica, createLocalPeers(t))
+	broadcaster := &localBroadcaster{
+		databaseID: "db",
+		services:   []*ChainRPCService{service},
+	}
+
+	fl, err := ioutil.TempFile("", "kayak")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	defer os.Remove(fl.Name())
+	store, err := kayak.NewBoltStore(fl.Name())
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer store.Close()
+	leadership := &testLeadership{leader: true}
+	cfg := &ProducerConfig{
+		Chain:       chain,
+		LogStore:    store,
+		StableStore: store,
+		LogCodec:    &storage.ExecLogCodec{},
+		Broadcaster: broadcaster,
+		Leadership:  leadership,
+		Period:      10 * time.Millisecond,
+	}
+
+	if _, err = NewProducer(&ProducerConfig{}); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	producer, err := NewProducer(cfg)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Nothing to pack
+	block, err := producer.ProduceBlock()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block != nil {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+
+	appendTestLog(t, store, &storage.ExecLog{
+		ConnectionID: 1,
+		SeqNo:        1,
+		Timestamp:    uint64(time.Now().UnixNano()),
+		Queries:      []string{"CREATE TABLE t (k INT, v INT)", "INSERT INTO t VALUES (1, 1)"},
+	})
+	appendTestLog(t, store, &storage.ExecLog{
+		ConnectionID: 1,
+		SeqNo:        2,
+		Timestamp:    uint64(time.Now().UnixNano()),
+		Queries:      []string{"INSERT INTO t VALUES (2, 2)"},
+	})
+
+	if block, err = producer.ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block == nil || len(block.Queries) != 3 {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+
+	if block.Queries[2].Statement != "INSERT INTO t VALUES (2, 2)" {
+		t.Fatalf("Unexpected query: %v", block.Queries[2])
+	}
+
+	if head, height := chain.head(); head != block.SignedHeader.BlockHash || height != 1 {
+		t.Fatalf("Unexpected chain head: head = %s, height = %d", head, height)
+	}
+
+	if head, _ := replica.head(); head != block.SignedHeader.BlockHash {
+		t.Fatalf("Block is not broadcasted: head = %s", head)
+	}
+
+	// The replica follows the log index of the block
+	if index := chain.logIndex(); index != 2 {
+		t.Fatalf("Unexpected log index: %d", index)
+	}
+
+	if index := replica.logIndex(); index != 2 {
+		t.Fatalf("Unexpected log index: %d", index)
+	}
+
+	// Packed logs are not packed again
+	if block, err = producer.ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block != nil {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+
+	// Signed queries answered by the miner
+	if err = producer.AddQuery(createRandomQuery()); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	q, err := createRandomSignedQuery()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = producer.AddQuery(q); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block, err = producer.ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block == nil || len(block.Queries) != 1 || block.Queries[0] != q {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+
+	if block, err = producer.ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block != nil {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+
+	// Empty block policy
+	cfg.ProduceEmptyBlock = true
+
+	if block, err = producer.ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block == nil || len(block.Queries) != 0 {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+
+	// Producing loop
+	if err = producer.Start(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = producer.Start(); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); broadcaster.count() < 5; {
+		if time.Now().After(deadline) {
+			t.Fatal("Producer didn't produce blocks in time")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	producer.Stop()
+	producer.Stop()
+	head, height := chain.head()
+
+	if rHead, rHeight := replica.head(); rHead != head || rHeight != height {
+		t.Fatalf("Values don't match: v1 = %s@%d, v2 = %s@%d", head, height, rHead, rHeight)
+	}
+
+	// Only the leader produces blocks
+	leadership.leader = false
+
+	if _, err = producer.ProduceBlock(); err != ErrNotLeader {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The replica taking over packs the logs following its best block only
+	appendTestLog(t, store, &storage.ExecLog{
+		ConnectionID: 1,
+		SeqNo:        3,
+		Timestamp:    uint64(time.Now().UnixNano()),
+		Queries:      []string{"INSERT INTO t VALUES (3, 3)"},
+	})
+	follower, err := NewProducer(&ProducerConfig{
+		Chain:       replica,
+		LogStore:    store,
+		StableStore: store,
+		LogCodec:    &storage.ExecLogCodec{},
+		Leadership:  &testLeadership{leader: true},
+		Period:      time.Second,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block, err = follower.ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block == nil || len(block.Queries) != 1 || block.SignedHeader.LogIndex != 3 {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+
+	head, _ = replica.head()
+
+	// The log index never goes backwards
+	rewound, err := createRandomBlock(head, false, func(b *Block) {
+		b.SignedHeader.LogIndex = 2
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = replica.PushBlock(rewound); err != ErrInvalidBlock {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Blocks not produced by the peers
+	other, err := createRandomBlock(head, false)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = broadcaster.BroadcastBlock(other); err != ErrInvalidBlockProducer {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	service.SetPeers("db", nil)
+
+	if err = broadcaster.BroadcastBlock(block); err != ErrInvalidBlockProducer {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Unknown database
+	service.RemoveChain("db")
+
+	if err = broadcaster.BroadcastBlock(block); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+}
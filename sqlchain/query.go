@@ -17,7 +17,16 @@
 package sqlchain
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+
 	"github.com/thunderdb/ThunderDB/common"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/merkle"
+	"github.com/thunderdb/ThunderDB/utils"
 )
 
 // QueryType enumerates the basic types of SQL query, i.e., ReadQuery or WriteQuery.
@@ -31,10 +40,387 @@ const (
 	WriteQuery
 )
 
+// maxQueryStringLength is the maximum length of the statement and each argument of a query, it
+// matches the longest string the serializer reads back.
+const maxQueryStringLength = 1 << 20
+
 // Query represents a SQL query log
 type Query struct {
-	TxnID common.UUID
+	TxnID     common.UUID
+	Type      QueryType
+	Statement string
+	Args      []string
+	Timestamp time.Time
+
+	// Issuer is the public key of the client who issued the query, and Signature is its
+	// signature of the request hash.
+	Issuer    *asymmetric.PublicKey
+	Signature *asymmetric.Signature
+
+	// Response is the response signed by the miner, it's nil if the query isn't answered.
+	Response *Response
+}
+
+// Response represents a query response signed by the responding miner.
+type Response struct {
+	// RequestHash is the hash of the answered query request.
+	RequestHash hash.Hash
+
+	// ResultHash is the hash of the query result.
+	ResultHash hash.Hash
+	Timestamp  time.Time
+
+	Signee    *asymmetric.PublicKey
+	Signature *asymmetric.Signature
+}
+
+// serializeRequest writes the request fields of the query, which are covered by the issuer
+// signature.
+func (q *Query) serializeRequest(w io.Writer) (err error) {
+	if len(q.Statement) > maxQueryStringLength {
+		return ErrQueryTooLarge
+	}
+
+	for i := range q.Args {
+		if len(q.Args[i]) > maxQueryStringLength {
+			return ErrQueryTooLarge
+		}
+	}
+
+	if err = utils.WriteElements(w, binary.BigEndian,
+		q.TxnID,
+		int32(q.Type),
+		q.Statement,
+		uint32(len(q.Args)),
+	); err != nil {
+		return
+	}
+
+	for i := range q.Args {
+		if err = utils.WriteElements(w, binary.BigEndian, &q.Args[i]); err != nil {
+			return
+		}
+	}
+
+	return utils.WriteElements(w, binary.BigEndian,
+		q.Timestamp,
+		q.Issuer,
+	)
+}
+
+func (q *Query) serialize(w io.Writer) (err error) {
+	if err = q.serializeRequest(w); err != nil {
+		return
+	}
+
+	if err = utils.WriteElements(w, binary.BigEndian,
+		q.Signature,
+		q.Response != nil,
+	); err != nil || q.Response == nil {
+		return
+	}
+
+	return q.Response.serialize(w)
+}
+
+func (q *Query) deserialize(r io.Reader) (err error) {
+	var qt int32
+	var l uint32
+
+	if err = utils.ReadElements(r, binary.BigEndian,
+		&q.TxnID,
+		&qt,
+		&q.Statement,
+		&l,
+	); err != nil {
+		return
+	}
+
+	q.Type = QueryType(qt)
+	q.Args = nil
+
+	if l > 0 {
+		q.Args = make([]string, 0, l)
+	}
+
+	for i := uint32(0); i < l; i++ {
+		var arg string
+
+		if err = utils.ReadElements(r, binary.BigEndian, &arg); err != nil {
+			return
+		}
+
+		q.Args = append(q.Args, arg)
+	}
+
+	var hasResponse bool
+
+	if err = utils.ReadElements(r, binary.BigEndian,
+		&q.Timestamp,
+		&q.Issuer,
+		&q.Signature,
+		&hasResponse,
+	); err != nil {
+		return
+	}
+
+	q.Response = nil
+
+	if hasResponse {
+		q.Response = &Response{}
+		return q.Response.deserialize(r)
+	}
+
+	return
+}
+
+// RequestHash returns the hash of the request fields of the query, including the issuer public
+// key.
+func (q *Query) RequestHash() (h hash.Hash, err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = q.serializeRequest(buffer); err != nil {
+		return
+	}
+
+	return hash.THashH(buffer.Bytes()), nil
+}
+
+// Sign sets the issuer of the query and signs the request with the given private key.
+func (q *Query) Sign(signer *asymmetric.PrivateKey) (err error) {
+	q.Issuer = signer.PubKey()
+	h, err := q.RequestHash()
+
+	if err != nil {
+		return
+	}
+
+	q.Signature, err = signer.Sign(h[:])
+	return
+}
+
+// Verify verifies the issuer signature of the query, and the response signature if the query is
+// answered.
+func (q *Query) Verify() (err error) {
+	if q.Issuer == nil || q.Signature == nil {
+		return ErrNilValue
+	}
+
+	h, err := q.RequestHash()
+
+	if err != nil {
+		return
+	}
+
+	if !q.Signature.Verify(h[:], q.Issuer) {
+		return ErrSignVerification
+	}
+
+	if q.Response != nil {
+		if q.Response.RequestHash != h {
+			return ErrHashVerification
+		}
+
+		return q.Response.Verify()
+	}
+
+	return
+}
+
+// Respond generates the response of the query from the query result, and signs it with the given
+// private key. The response is attached to the query and returned.
+func (q *Query) Respond(result []byte, signer *asymmetric.PrivateKey) (r *Response, err error) {
+	h, err := q.RequestHash()
+
+	if err != nil {
+		return
+	}
+
+	r = &Response{
+		RequestHash: h,
+		ResultHash:  hash.THashH(result),
+		Timestamp:   time.Now().UTC(),
+	}
+
+	if err = r.Sign(signer); err != nil {
+		return nil, err
+	}
+
+	q.Response = r
+	return
+}
+
+func (r *Response) serializeHeader(w io.Writer) error {
+	return utils.WriteElements(w, binary.BigEndian,
+		&r.RequestHash,
+		&r.ResultHash,
+		r.Timestamp,
+		r.Signee,
+	)
+}
+
+func (r *Response) serialize(w io.Writer) (err error) {
+	if err = r.serializeHeader(w); err != nil {
+		return
+	}
+
+	return utils.WriteElements(w, binary.BigEndian, r.Signature)
+}
+
+func (r *Response) deserialize(reader io.Reader) error {
+	return utils.ReadElements(reader, binary.BigEndian,
+		&r.RequestHash,
+		&r.ResultHash,
+		&r.Timestamp,
+		&r.Signee,
+		&r.Signature,
+	)
+}
+
+func (r *Response) headerHash() (h hash.Hash, err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = r.serializeHeader(buffer); err != nil {
+		return
+	}
+
+	return hash.THashH(buffer.Bytes()), nil
+}
+
+// Sign sets the signee of the response and signs it with the given private key.
+func (r *Response) Sign(signer *asymmetric.PrivateKey) (err error) {
+	r.Signee = signer.PubKey()
+	h, err := r.headerHash()
+
+	if err != nil {
+		return
+	}
+
+	r.Signature, err = signer.Sign(h[:])
+	return
+}
+
+// Verify verifies the signature of the response.
+func (r *Response) Verify() (err error) {
+	if r.Signee == nil || r.Signature == nil {
+		return ErrNilValue
+	}
+
+	h, err := r.headerHash()
+
+	if err != nil {
+		return
+	}
+
+	if !r.Signature.Verify(h[:], r.Signee) {
+		return ErrSignVerification
+	}
+
+	return
+}
+
+// VerifyResult reports whether the result matches the result hash of the response.
+func (r *Response) VerifyResult(result []byte) bool {
+	h := hash.THashH(result)
+	return h.IsEqual(&r.ResultHash)
+}
+
+func (q *Query) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := q.serialize(buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (q *Query) unmarshal(b []byte) error {
+	return q.deserialize(bytes.NewReader(b))
+}
+
+// Hash returns the hash of the query log, which is used as a leaf of the block merkle tree.
+func (q *Query) Hash() (h hash.Hash, err error) {
+	buffer, err := q.marshal()
+
+	if err != nil {
+		return
+	}
+
+	return hash.THashH(buffer), nil
+}
+
+// merkleLeaf is a precomputed leaf hash of the merkle tree.
+type merkleLeaf hash.Hash
+
+// Hash implements merkle.Hashable.Hash.
+func (l *merkleLeaf) Hash() *hash.Hash {
+	return (*hash.Hash)(l)
 }
 
 // Queries is a Query (reference) array
 type Queries []*Query
+
+func (qs Queries) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian, uint32(len(qs))); err != nil {
+		return nil, err
+	}
+
+	for _, q := range qs {
+		if err := q.serialize(buffer); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (qs *Queries) unmarshal(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	var l uint32
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &l); err != nil {
+		return
+	}
+
+	if int64(l) > int64(reader.Len()) {
+		return utils.ErrInsufficientBuffer
+	}
+
+	*qs = make(Queries, l)
+
+	for i := range *qs {
+		(*qs)[i] = &Query{}
+
+		if err = (*qs)[i].deserialize(reader); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// MerkleRoot computes the merkle root of the queries, it returns an empty hash if there is no
+// query, or an error if any query can't be serialized.
+func (qs Queries) MerkleRoot() (root hash.Hash, err error) {
+	if len(qs) == 0 {
+		return
+	}
+
+	items := make([]*merkle.Hashable, len(qs))
+
+	for i := range qs {
+		h, err := qs[i].Hash()
+
+		if err != nil {
+			return root, err
+		}
+
+		item := merkle.Hashable((*merkleLeaf)(&h))
+		items[i] = &item
+	}
+
+	return *merkle.NewMerkle(items).GetRoot(), nil
+}
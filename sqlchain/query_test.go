@@ -0,0 +1,293 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+)
+
+func TestQuerySerialization(t *testing.T) {
+	block, err := createRandomBlock(rootHash, false)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	buffer, err := block.Queries.marshal()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	var queries Queries
+
+	if err = queries.unmarshal(buffer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(block.Queries, queries) {
+		t.Fatalf("Values don't match:\n\tv1 = %+v\n\tv2 = %+v", block.Queries, queries)
+	}
+
+	var truncated Queries
+
+	if err = truncated.unmarshal(buffer[:len(buffer)-1]); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	mr, err := queries.MerkleRoot()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !mr.IsEqual(&block.SignedHeader.MerkleRoot) {
+		t.Fatalf("Values don't match: v1 = %s, v2 = %s",
+			mr.String(), block.SignedHeader.MerkleRoot.String())
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	block, err := createRandomBlock(rootHash, false)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = block.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Tamper a query
+	block.Queries[0].Statement = "DROP TABLE `t`"
+
+	if err = block.Verify(); err == ErrMerkleRootVerification {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Empty block
+	if block, err = createRandomBlock(rootHash, false, withQueries(nil)); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if root, err := block.Queries.MerkleRoot(); err != nil || !root.IsEqual(&hash.Hash{}) {
+		t.Fatalf("Unexpected merkle root of empty block: %s", root.String())
+	}
+
+	if err = block.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// A query which can't be serialized fails the merkle root instead of hashing to zero
+	q := createRandomQuery()
+	q.Statement = string(make([]byte, maxQueryStringLength+1))
+	block.Queries = Queries{q}
+
+	if err = block.SignHeader(priv); err != ErrQueryTooLarge {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = block.Verify(); err != ErrQueryTooLarge {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestSignedQuery(t *testing.T) {
+	client, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	miner, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	q := createRandomQuery()
+
+	if err = q.Verify(); err == ErrNilValue {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = q.Sign(client); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = q.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Tamper the request
+	q.Statement = "DROP TABLE `t`"
+
+	if err = q.Verify(); err == ErrSignVerification {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = q.Sign(client); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	result := []byte("query result")
+	resp, err := q.Respond(result, miner)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = q.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !resp.VerifyResult(result) || resp.VerifyResult([]byte("another result")) {
+		t.Fatal("Unexpected result verification")
+	}
+
+	if !reflect.DeepEqual(resp.Signee, miner.PubKey()) {
+		t.Fatalf("Values don't match: v1 = %v, v2 = %v", resp.Signee, miner.PubKey())
+	}
+
+	// Serialization keeps both signatures
+	buffer, err := q.marshal()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	rq := &Query{}
+
+	if err = rq.unmarshal(buffer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(q, rq) {
+		t.Fatalf("Values don't match:\n\tv1 = %+v\n\tv2 = %+v", q, rq)
+	}
+
+	if err = rq.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Tamper the response
+	rq.Response.ResultHash = hash.Hash{}
+
+	if err = rq.Verify(); err == ErrSignVerification {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Response to another request
+	another := createRandomQuery()
+
+	if err = another.Sign(client); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	another.Response = q.Response
+
+	if err = another.Verify(); err == ErrHashVerification {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestBlockWithSignedQueries(t *testing.T) {
+	block, err := createRandomBlock(rootHash, false)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = block.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	resign := func() {
+		if err = block.SignHeader(priv); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	block.SignedHeader.Signee = priv.PubKey()
+
+	// A response must answer the request it's attached to
+	response := block.Queries[1].Response
+	block.Queries[1].Response = block.Queries[3].Response
+	resign()
+
+	if err = block.Verify(); err == ErrHashVerification {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A response can't be attached to an unsigned request
+	block.Queries[1].Response = response
+	block.Queries[0].Response = response
+	resign()
+
+	if err = block.Verify(); err == ErrNilValue {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	block.Queries[0].Response = nil
+	resign()
+
+	if err = block.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Re-signing the header doesn't help a forged query
+	block.Queries[1].Response.ResultHash = hash.Hash{}
+	resign()
+
+	if err = block.Verify(); err == ErrSignVerification {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"context"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thunderdb/ThunderDB/sqlchain/storage"
+)
+
+// Restore replays the write queries of the best chain in height range [from, to] into the
+// storage, the queries of each block are committed in a single transaction. The storage should
+// hold the state as of height from-1, i.e., it's a fresh database if from is 0, or a snapshot
+// otherwise. Answered queries are audit records of writes already packed from the execution log,
+// so they are not replayed again.
+func (c *Chain) Restore(st *storage.Storage, from, to int32) (err error) {
+	height := from
+
+	return c.ForEachBlock(from, to, true, func(block *Block) (err error) {
+		el := &storage.ExecLog{
+			SeqNo:     uint64(height),
+			Timestamp: uint64(block.SignedHeader.Timestamp.UnixNano()),
+		}
+
+		for _, q := range block.Queries {
+			if q.Type != WriteQuery || q.Response != nil {
+				continue
+			}
+
+			// Storage only executes plain statements
+			if len(q.Args) > 0 {
+				return ErrQueryArgsNotSupported
+			}
+
+			el.Queries = append(el.Queries, q.Statement)
+		}
+
+		height++
+
+		if len(el.Queries) == 0 {
+			return
+		}
+
+		ctx := context.Background()
+
+		if err = st.Prepare(ctx, el); err != nil {
+			return
+		}
+
+		if err = st.Commit(ctx, el); err != nil {
+			log.Errorf("failed to restore block %s at height %d: %v",
+				block.SignedHeader.BlockHash, height-1, err)
+		}
+
+		return
+	})
+}
+
+// RestoreTo rebuilds the database state as of the given height of the best chain into a fresh
+// database connected by dsn, by replaying the write queries from the genesis block.
+func (c *Chain) RestoreTo(dsn string, height int32) (err error) {
+	d, err := storage.NewDSN(dsn)
+
+	if err != nil {
+		return
+	}
+
+	if fi, err := os.Stat(d.GetFileName()); err == nil && fi.Size() > 0 {
+		return ErrDatabaseExists
+	}
+
+	st, err := storage.New(dsn)
+
+	if err != nil {
+		return
+	}
+
+	defer st.Close()
+	return c.Restore(st, 0, height)
+}
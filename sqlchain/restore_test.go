@@ -0,0 +1,178 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/sqlchain/storage"
+)
+
+func createQueryBlock(parent hash.Hash, isGenesis bool, statements ...string) (
+	b *Block, err error) {
+	priv, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		return
+	}
+
+	queries := make(Queries, 2*len(statements)+1)
+
+	for i, s := range statements {
+		queries[i] = &Query{
+			Type:      WriteQuery,
+			Statement: s,
+			Timestamp: time.Now().UTC(),
+		}
+
+		// The answered copy of the write is an audit record, which is not replayed
+		signed := &Query{
+			Type:      WriteQuery,
+			Statement: s,
+			Timestamp: time.Now().UTC(),
+		}
+
+		if err = signed.Sign(priv); err != nil {
+			return
+		}
+
+		if _, err = signed.Respond(nil, priv); err != nil {
+			return
+		}
+
+		queries[len(statements)+i] = signed
+	}
+
+	// A read query is not replayed
+	queries[2*len(statements)] = &Query{
+		Type:      ReadQuery,
+		Statement: "SELECT * FROM `t`",
+		Timestamp: time.Now().UTC(),
+	}
+
+	return createRandomBlock(parent, isGenesis, withQueries(queries))
+}
+
+func countRows(t *testing.T, fn string) (count int) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", fn))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer db.Close()
+
+	if err = db.QueryRow("SELECT COUNT(*) FROM `t`").Scan(&count); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return
+}
+
+func TestRestore(t *testing.T) {
+	genesis, err := createQueryBlock(rootHash, true, "CREATE TABLE `t` (`v` INT)")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain := createTestChain(t, genesis)
+	parent := genesis.SignedHeader.BlockHash
+
+	// The genesis block creates the table, and each of the following blocks inserts a row
+	for i := 0; i < 10; i++ {
+		block, err := createQueryBlock(parent, false,
+			fmt.Sprintf("INSERT INTO `t` VALUES (%d)", i))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(block); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		parent = block.SignedHeader.BlockHash
+	}
+
+	dir, err := ioutil.TempDir("", "restore")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// Restore to height 5
+	fn := fmt.Sprintf("%s/db5", dir)
+
+	if err = chain.RestoreTo(fmt.Sprintf("file:%s", fn), 5); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if count := countRows(t, fn); count != 5 {
+		t.Fatalf("Unexpected row count: %d", count)
+	}
+
+	// Target database must be fresh
+	if err = chain.RestoreTo(fmt.Sprintf("file:%s", fn), 10); err == ErrDatabaseExists {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Continue from the snapshot
+	st, err := storage.New(fmt.Sprintf("file:%s", fn))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.Restore(st, 6, 10); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	st.Close()
+
+	if count := countRows(t, fn); count != 10 {
+		t.Fatalf("Unexpected row count: %d", count)
+	}
+
+	// Queries with arguments are not supported
+	block, err := createRandomBlock(parent, false)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(block); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.RestoreTo(fmt.Sprintf("file:%s/db11", dir), 11); err == ErrQueryArgsNotSupported {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,541 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"fmt"
+	"sync"
+
+	pb "github.com/golang/protobuf/proto"
+	"github.com/thunderdb/ThunderDB/blockproducer"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/rpc"
+	"github.com/thunderdb/ThunderDB/types"
+)
+
+const (
+	// ChainRPCServiceName is the name of the sql-chain RPC service.
+	ChainRPCServiceName = "SQLC"
+
+	// MaxFetchHeaders is the maximum number of headers returned by a single FetchHeaders call.
+	MaxFetchHeaders = 2000
+
+	// MaxFetchBlocks is the maximum number of blocks returned by a single FetchBlocks call.
+	MaxFetchBlocks = 100
+)
+
+// AdviseNewBlockReq defines a request of the AdviseNewBlock RPC method.
+type AdviseNewBlockReq struct {
+	DatabaseID string
+	Block      []byte
+}
+
+// AdviseNewBlockResp defines a response of the AdviseNewBlock RPC method.
+type AdviseNewBlockResp struct {
+	Msg string
+}
+
+// GetHeadReq defines a request of the GetHead RPC method.
+type GetHeadReq struct {
+	DatabaseID string
+}
+
+// GetHeadResp defines a response of the GetHead RPC method.
+type GetHeadResp struct {
+	Head   hash.Hash
+	Height int32
+}
+
+// FetchBlockReq defines a request of the FetchBlock RPC method, the block is located by Height
+// on the best chain if ByHeight is set, otherwise by Hash.
+type FetchBlockReq struct {
+	DatabaseID string
+	Hash       hash.Hash
+	Height     int32
+	ByHeight   bool
+}
+
+// FetchBlockResp defines a response of the FetchBlock RPC method.
+type FetchBlockResp struct {
+	Block []byte
+}
+
+// GetAncestorReq defines a request of the GetAncestor RPC method.
+type GetAncestorReq struct {
+	DatabaseID string
+	Hash       hash.Hash
+	Height     int32
+}
+
+// GetAncestorResp defines a response of the GetAncestor RPC method.
+type GetAncestorResp struct {
+	Header []byte
+}
+
+// FetchRangeReq defines a request of the FetchHeaders and FetchBlocks RPC methods, the range is
+// truncated to the best chain height and the method count limit.
+type FetchRangeReq struct {
+	DatabaseID string
+	From       int32
+	To         int32
+}
+
+// FetchHeadersResp defines a response of the FetchHeaders RPC method.
+type FetchHeadersResp struct {
+	Headers [][]byte
+}
+
+// FetchBlocksResp defines a response of the FetchBlocks RPC method.
+type FetchBlocksResp struct {
+	Blocks [][]byte
+}
+
+// FetchHeadersByLocatorReq defines a request of the FetchHeadersByLocator RPC method.
+type FetchHeadersByLocatorReq struct {
+	DatabaseID string
+	Locator    []hash.Hash
+}
+
+// FetchBlocksByHashReq defines a request of the FetchBlocksByHash RPC method.
+type FetchBlocksByHashReq struct {
+	DatabaseID string
+	Hashes     []hash.Hash
+}
+
+// SignBillingReq defines a request of the SignBilling RPC method, Payload is the encoded billing
+// payload built by the requesting replica.
+type SignBillingReq struct {
+	DatabaseID string
+	Payload    []byte
+}
+
+// SignBillingResp defines a response of the SignBilling RPC method, Signature is the encoded
+// billing signature of the local replica.
+type SignBillingResp struct {
+	Signature []byte
+}
+
+// ChainRPCService is the server side RPC implementation of the sql-chains hosted by a miner.
+type ChainRPCService struct {
+	mu     sync.RWMutex
+	chains map[string]*Chain
+
+	// peers are the replicas of the databases, which are the only nodes allowed to advise new
+	// blocks
+	peers map[string]*kayak.Peers
+}
+
+// NewChainRPCService returns a new ChainRPCService.
+func NewChainRPCService() *ChainRPCService {
+	return &ChainRPCService{
+		chains: make(map[string]*Chain),
+		peers:  make(map[string]*kayak.Peers),
+	}
+}
+
+// AddChain registers the chain of the database and its peers to the service, advised blocks
+// are rejected if peers is nil.
+func (s *ChainRPCService) AddChain(databaseID string, chain *Chain, peers *kayak.Peers) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chains[databaseID] = chain
+	s.peers[databaseID] = peers
+}
+
+// SetPeers updates the peers of the database, e.g. when the replicas are placed on other miners.
+func (s *ChainRPCService) SetPeers(databaseID string, peers *kayak.Peers) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers[databaseID] = peers
+}
+
+// RemoveChain unregisters the chain of the database from the service.
+func (s *ChainRPCService) RemoveChain(databaseID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.chains, databaseID)
+	delete(s.peers, databaseID)
+}
+
+func (s *ChainRPCService) getChain(databaseID string) (chain *Chain, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if chain = s.chains[databaseID]; chain == nil {
+		return nil, ErrUnknownDatabase
+	}
+
+	return
+}
+
+// AdviseNewBlock RPC pushes a block produced by the other replica to the local chain, the block
+// must be produced and signed by one of the peers of the database.
+func (s *ChainRPCService) AdviseNewBlock(req *AdviseNewBlockReq, resp *AdviseNewBlockResp) (
+	err error) {
+	chain, err := s.getChain(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	block := &Block{}
+
+	if err = block.unmarshal(req.Block); err != nil {
+		return
+	}
+
+	s.mu.RLock()
+	peers := s.peers[req.DatabaseID]
+	s.mu.RUnlock()
+
+	if !isPeerBlock(peers, block.SignedHeader) {
+		return ErrInvalidBlockProducer
+	}
+
+	return chain.PushBlock(block)
+}
+
+// isPeerBlock reports whether the block is produced by a peer and signed by its key.
+func isPeerBlock(peers *kayak.Peers, header *SignedHeader) bool {
+	if peers == nil || header == nil || header.Signee == nil {
+		return false
+	}
+
+	for _, s := range peers.Servers {
+		if s.ID == header.Producer && s.PubKey != nil && s.PubKey.IsEqual(header.Signee) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SignBilling RPC signs the billing summary of the database with the local node key if it
+// matches the summary of the same height range on the local chain.
+func (s *ChainRPCService) SignBilling(req *SignBillingReq, resp *SignBillingResp) (err error) {
+	chain, err := s.getChain(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	payload := &types.BillingPayload{}
+
+	if err = pb.Unmarshal(req.Payload, payload); err != nil {
+		return
+	}
+
+	local, err := chain.Billing(req.DatabaseID, payload.StartHeight, payload.EndHeight)
+
+	if err != nil {
+		return
+	}
+
+	expected, err := blockproducer.BillingHash(payload)
+
+	if err != nil {
+		return
+	}
+
+	if h, err := blockproducer.BillingHash(local); err != nil {
+		return err
+	} else if !h.IsEqual(&expected) {
+		return ErrBillingMismatch
+	}
+
+	priv, err := kms.GetLocalPrivateKey()
+
+	if err != nil {
+		return
+	}
+
+	nonce, err := kms.GetLocalNonce()
+
+	if err != nil {
+		return
+	}
+
+	if err = blockproducer.SignBilling(local, priv, *nonce); err != nil {
+		return
+	}
+
+	resp.Signature, err = pb.Marshal(local.Signatures[0])
+	return
+}
+
+// GetHead RPC returns the head of the best chain.
+func (s *ChainRPCService) GetHead(req *GetHeadReq, resp *GetHeadResp) (err error) {
+	chain, err := s.getChain(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	head := chain.Head()
+	resp.Head = head.Head
+	resp.Height = head.Height
+	return
+}
+
+// FetchBlock RPC returns the block located by hash or height.
+func (s *ChainRPCService) FetchBlock(req *FetchBlockReq, resp *FetchBlockResp) (err error) {
+	chain, err := s.getChain(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	var block *Block
+
+	if req.ByHeight {
+		block, err = chain.GetBlockByHeight(req.Height)
+	} else {
+		block, err = chain.GetBlock(&req.Hash)
+	}
+
+	if err != nil {
+		return
+	}
+
+	resp.Block, err = block.marshal()
+	return
+}
+
+// GetAncestor RPC returns the header of the ancestor at the given height of the given block.
+func (s *ChainRPCService) GetAncestor(req *GetAncestorReq, resp *GetAncestorResp) (err error) {
+	chain, err := s.getChain(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	header, err := chain.GetAncestor(&req.Hash, req.Height)
+
+	if err != nil {
+		return
+	}
+
+	resp.Header, err = header.marshal()
+	return
+}
+
+// FetchHeaders RPC returns the headers of the best chain in the height range.
+func (s *ChainRPCService) FetchHeaders(req *FetchRangeReq, resp *FetchHeadersResp) (err error) {
+	chain, err := s.getChain(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	to := truncateRange(chain, req, MaxFetchHeaders)
+
+	if req.From >= 0 && to < req.From {
+		// Nothing beyond the best chain
+		return
+	}
+
+	return chain.ForEachBlock(req.From, to, false, func(block *Block) (err error) {
+		buffer, err := block.SignedHeader.marshal()
+
+		if err == nil {
+			resp.Headers = append(resp.Headers, buffer)
+		}
+
+		return
+	})
+}
+
+// FetchBlocks RPC returns the blocks of the best chain in the height range.
+func (s *ChainRPCService) FetchBlocks(req *FetchRangeReq, resp *FetchBlocksResp) (err error) {
+	chain, err := s.getChain(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	to := truncateRange(chain, req, MaxFetchBlocks)
+
+	if req.From >= 0 && to < req.From {
+		// Nothing beyond the best chain
+		return
+	}
+
+	return chain.ForEachBlock(req.From, to, true, func(block *Block) (err error) {
+		buffer, err := block.marshal()
+
+		if err == nil {
+			resp.Blocks = append(resp.Blocks, buffer)
+		}
+
+		return
+	})
+}
+
+// FetchHeadersByLocator RPC returns the headers of the best chain following the first block in
+// the locator which is also on the best chain, at most MaxFetchHeaders headers are returned.
+func (s *ChainRPCService) FetchHeadersByLocator(
+	req *FetchHeadersByLocatorReq, resp *FetchHeadersResp) (err error) {
+	chain, err := s.getChain(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	headers, err := chain.locateHeaders(req.Locator, MaxFetchHeaders)
+
+	if err != nil {
+		return
+	}
+
+	resp.Headers = make([][]byte, len(headers))
+
+	for i, h := range headers {
+		if resp.Headers[i], err = h.marshal(); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// FetchBlocksByHash RPC returns the blocks of the given hashes, at most MaxFetchBlocks blocks are
+// returned.
+func (s *ChainRPCService) FetchBlocksByHash(req *FetchBlocksByHashReq, resp *FetchBlocksResp) (
+	err error) {
+	chain, err := s.getChain(req.DatabaseID)
+
+	if err != nil {
+		return
+	}
+
+	hashes := req.Hashes
+
+	if len(hashes) > MaxFetchBlocks {
+		hashes = hashes[:MaxFetchBlocks]
+	}
+
+	resp.Blocks = make([][]byte, len(hashes))
+
+	for i := range hashes {
+		var block *Block
+
+		if block, err = chain.GetBlock(&hashes[i]); err != nil {
+			return
+		}
+
+		if resp.Blocks[i], err = block.marshal(); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// truncateRange returns the end of the requested range truncated to the best chain height and
+// the count limit.
+func truncateRange(chain *Chain, req *FetchRangeReq, limit int32) (to int32) {
+	to = req.To
+
+	if height := chain.Head().Height; to > height {
+		to = height
+	}
+
+	if req.From >= 0 && to-req.From >= limit {
+		to = req.From + limit - 1
+	}
+
+	return
+}
+
+// RPCBroadcaster is a Broadcaster implementation which advises the blocks to the other peers of
+// the database through RPC.
+type RPCBroadcaster struct {
+	DatabaseID string
+	LocalID    proto.NodeID
+	Peers      *kayak.Peers
+
+	// Caller calls the remote nodes, the rpc package is used if it's nil.
+	Caller Caller
+}
+
+// BroadcastBlock implements Broadcaster.BroadcastBlock.
+func (b *RPCBroadcaster) BroadcastBlock(block *Block) (err error) {
+	buffer, err := block.marshal()
+
+	if err != nil {
+		return
+	}
+
+	req := &AdviseNewBlockReq{
+		DatabaseID: b.DatabaseID,
+		Block:      buffer,
+	}
+
+	var failed []proto.NodeID
+	caller := b.Caller
+
+	if caller == nil {
+		caller = &RPCCaller{}
+	}
+
+	for _, s := range b.Peers.Servers {
+		if s.ID == b.LocalID {
+			continue
+		}
+
+		if cerr := caller.CallNode(s.ID, ChainRPCServiceName+".AdviseNewBlock", req,
+			&AdviseNewBlockResp{}); cerr != nil {
+			failed = append(failed, s.ID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to advise block to nodes: %v", failed)
+	}
+
+	return
+}
+
+// Caller calls the RPC methods of remote nodes.
+type Caller interface {
+	CallNode(nodeID proto.NodeID, method string, args, reply interface{}) error
+}
+
+// RPCCaller is a Caller implementation which dials the remote nodes with the rpc package.
+type RPCCaller struct{}
+
+// CallNode implements Caller.CallNode.
+func (c *RPCCaller) CallNode(nodeID proto.NodeID, method string, args, reply interface{}) (
+	err error) {
+	conn, err := rpc.DailToNode(nodeID)
+
+	if err != nil {
+		return
+	}
+
+	client, err := rpc.InitClientConn(conn)
+
+	if err != nil {
+		return
+	}
+
+	defer client.Close()
+	return client.Call(method, args, reply)
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"testing"
+)
+
+func TestChainRPCService(t *testing.T) {
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain := createTestChain(t, genesis)
+	blocks := Blocks{genesis}
+
+	for i := 0; i < 10; i++ {
+		b, err := createRandomBlock(blocks[len(blocks)-1].SignedHeader.BlockHash, false)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		blocks = append(blocks, b)
+	}
+
+	service := NewChainRPCService()
+	service.AddChain("db", chain, nil)
+
+	if err = service.GetHead(&GetHeadReq{DatabaseID: "xxx"}, &GetHeadResp{}); err == nil {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	headResp := &GetHeadResp{}
+
+	if err = service.GetHead(&GetHeadReq{DatabaseID: "db"}, headResp); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if headResp.Head != blocks[10].SignedHeader.BlockHash || headResp.Height != 10 {
+		t.Fatalf("Unexpected head: %v", headResp)
+	}
+
+	// Fetch block by hash and by height
+	for _, req := range []*FetchBlockReq{
+		{DatabaseID: "db", Hash: blocks[4].SignedHeader.BlockHash},
+		{DatabaseID: "db", Height: 4, ByHeight: true},
+	} {
+		resp := &FetchBlockResp{}
+
+		if err = service.FetchBlock(req, resp); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		block := &Block{}
+
+		if err = block.UnmarshalBinary(resp.Block); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if block.SignedHeader.BlockHash != blocks[4].SignedHeader.BlockHash {
+			t.Fatalf("Unexpected block: %v", block)
+		}
+	}
+
+	ancestorResp := &GetAncestorResp{}
+
+	if err = service.GetAncestor(&GetAncestorReq{
+		DatabaseID: "db",
+		Hash:       blocks[8].SignedHeader.BlockHash,
+		Height:     2,
+	}, ancestorResp); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	header := &SignedHeader{}
+
+	if err = header.UnmarshalBinary(ancestorResp.Header); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if header.BlockHash != blocks[2].SignedHeader.BlockHash {
+		t.Fatalf("Unexpected header: %v", header)
+	}
+
+	// Ranges are truncated to the best chain
+	headersResp := &FetchHeadersResp{}
+
+	if err = service.FetchHeaders(&FetchRangeReq{
+		DatabaseID: "db",
+		From:       3,
+		To:         100,
+	}, headersResp); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(headersResp.Headers) != 8 {
+		t.Fatalf("Unexpected header count: %d", len(headersResp.Headers))
+	}
+
+	blocksResp := &FetchBlocksResp{}
+
+	if err = service.FetchBlocks(&FetchRangeReq{
+		DatabaseID: "db",
+		From:       0,
+		To:         10,
+	}, blocksResp); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(blocksResp.Blocks) != 11 {
+		t.Fatalf("Unexpected block count: %d", len(blocksResp.Blocks))
+	}
+
+	blocksResp = &FetchBlocksResp{}
+
+	if err = service.FetchBlocks(&FetchRangeReq{
+		DatabaseID: "db",
+		From:       11,
+		To:         20,
+	}, blocksResp); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(blocksResp.Blocks) != 0 {
+		t.Fatalf("Unexpected block count: %d", len(blocksResp.Blocks))
+	}
+}
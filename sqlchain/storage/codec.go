@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"errors"
+)
+
+var (
+	// ErrUnsupportedLogType indicates that the log codec is given a value which is not an
+	// execution log.
+	ErrUnsupportedLogType = errors.New("unsupported log type")
+)
+
+// ExecLogCodec encodes and decodes execution logs, it implements the kayak.TwoPCLogCodec
+// interface so that execution logs can be replicated by kayak.
+type ExecLogCodec struct{}
+
+// Encode implements kayak.TwoPCLogCodec.Encode.
+func (c *ExecLogCodec) Encode(v interface{}) ([]byte, error) {
+	switch el := v.(type) {
+	case *ExecLog:
+		return el.marshal()
+	case ExecLog:
+		return el.marshal()
+	default:
+		return nil, ErrUnsupportedLogType
+	}
+}
+
+// Decode implements kayak.TwoPCLogCodec.Decode, v should be either a *ExecLog or a
+// *interface{} which is set to a new *ExecLog.
+func (c *ExecLogCodec) Decode(b []byte, v interface{}) (err error) {
+	switch p := v.(type) {
+	case *ExecLog:
+		return p.unmarshal(b)
+	case *interface{}:
+		el := &ExecLog{}
+
+		if err = el.unmarshal(b); err != nil {
+			return
+		}
+
+		*p = el
+		return
+	default:
+		return ErrUnsupportedLogType
+	}
+}
@@ -0,0 +1,440 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/crypto/symmetric"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+const (
+	// Permissions to use on the journal file.
+	journalFileMode = 0600
+
+	// maxJournalRecordSize is the maximum size of a single encrypted journal record.
+	maxJournalRecordSize = 1 << 30
+)
+
+var (
+	// ErrCorruptedJournal indicates that the encrypted journal cannot be decoded, which is
+	// usually caused by a wrong key or a truncated file.
+	ErrCorruptedJournal = errors.New("corrupted database journal")
+)
+
+func (el *ExecLog) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian,
+		el.ConnectionID,
+		el.SeqNo,
+		el.Timestamp,
+		uint32(len(el.Queries)),
+	); err != nil {
+		return nil, err
+	}
+
+	for i := range el.Queries {
+		if err := utils.WriteElements(buffer, binary.BigEndian, &el.Queries[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (el *ExecLog) unmarshal(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	var l uint32
+
+	if err = utils.ReadElements(reader, binary.BigEndian,
+		&el.ConnectionID,
+		&el.SeqNo,
+		&el.Timestamp,
+		&l,
+	); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return utils.ErrInsufficientBuffer
+	}
+
+	el.Queries = make([]string, l)
+
+	for i := range el.Queries {
+		if err = utils.ReadElements(reader, binary.BigEndian, &el.Queries[i]); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// DeriveKey derives the encryption key of the named database from the given private key.
+func DeriveKey(key *asymmetric.PrivateKey, name string) []byte {
+	return hash.DoubleHashB(append(key.Serialize(), name...))
+}
+
+// DeriveLocalKey derives the encryption key of the named database from the local private key
+// in kms, so that only the hosting node can open the encrypted files.
+func DeriveLocalKey(name string) ([]byte, error) {
+	key, err := kms.GetLocalPrivateKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return DeriveKey(key, name), nil
+}
+
+// journal is an append-only file of encrypted execution logs. Encrypted databases keep their
+// working set in memory and only persist the journal, so that nothing is written to disk in
+// plaintext. The journal starts with a snapshot of the database once it's compacted, so that
+// reopening a database replays the snapshot and the logs committed after it only.
+type journal struct {
+	sync.Mutex
+	file *os.File
+	key  []byte
+}
+
+func openJournal(fn string, key []byte) (*journal, error) {
+	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, journalFileMode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &journal{
+		file: f,
+		key:  key,
+	}, nil
+}
+
+// replay decrypts all the records from the beginning of the journal and calls fn with each
+// of them in order.
+func (j *journal) replay(fn func(*ExecLog) error) (err error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if _, err = j.file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	for {
+		var l uint32
+
+		if err = binary.Read(j.file, binary.BigEndian, &l); err == io.EOF {
+			break
+		} else if err != nil {
+			return ErrCorruptedJournal
+		}
+
+		if l > maxJournalRecordSize {
+			return ErrCorruptedJournal
+		}
+
+		enc := make([]byte, l)
+
+		if _, err = io.ReadFull(j.file, enc); err != nil {
+			return ErrCorruptedJournal
+		}
+
+		var dec []byte
+
+		if dec, err = symmetric.DecryptWithPassword(enc, j.key); err != nil {
+			return ErrCorruptedJournal
+		}
+
+		// sha256 + record
+		if len(dec) < hash.HashBSize ||
+			!bytes.Equal(hash.DoubleHashB(dec[hash.HashBSize:]), dec[:hash.HashBSize]) {
+			return ErrCorruptedJournal
+		}
+
+		el := &ExecLog{}
+
+		if err = el.unmarshal(dec[hash.HashBSize:]); err != nil {
+			return ErrCorruptedJournal
+		}
+
+		if err = fn(el); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encode encrypts the execution log into a length-prefixed journal record.
+func (j *journal) encode(el *ExecLog) (record []byte, err error) {
+	b, err := el.marshal()
+
+	if err != nil {
+		return
+	}
+
+	enc, err := symmetric.EncryptWithPassword(append(hash.DoubleHashB(b), b...), j.key)
+
+	if err != nil {
+		return
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, 4+len(enc)))
+	binary.Write(buffer, binary.BigEndian, uint32(len(enc)))
+	buffer.Write(enc)
+	return buffer.Bytes(), nil
+}
+
+// append encrypts the execution log and appends it to the end of the journal, it returns the
+// previous size of the journal so that the record can be truncated if the tx fails to commit.
+func (j *journal) append(el *ExecLog) (offset int64, err error) {
+	record, err := j.encode(el)
+
+	if err != nil {
+		return
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if offset, err = j.file.Seek(0, io.SeekEnd); err != nil {
+		return
+	}
+
+	if _, err = j.file.Write(record); err != nil {
+		j.file.Truncate(offset)
+		return
+	}
+
+	err = j.file.Sync()
+	return
+}
+
+// truncate drops the records appended after offset.
+func (j *journal) truncate(offset int64) (err error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if err = j.file.Truncate(offset); err != nil {
+		return
+	}
+
+	return j.file.Sync()
+}
+
+// compact replaces all the records of the journal with the snapshot. The new journal is written
+// aside and renamed over the old one, so that a crash in between leaves either of them intact.
+func (j *journal) compact(snapshot *ExecLog) (err error) {
+	record, err := j.encode(snapshot)
+
+	if err != nil {
+		return
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	fn := j.file.Name()
+	tmp := fn + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, journalFileMode)
+
+	if err != nil {
+		return
+	}
+
+	if _, err = f.Write(record); err == nil {
+		err = f.Sync()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, fn)
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return
+	}
+
+	j.file.Close()
+	j.file = f
+	return
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
+
+// quoteIdent quotes an sqlite identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// snapshot dumps the schema and the rows of the database as the queries to rebuild it. Tables
+// are created and filled before the indexes, views and triggers, so that no trigger fires
+// while the rows are restored.
+func snapshot(db *sql.DB) (el *ExecLog, err error) {
+	tx, err := db.Begin()
+
+	if err != nil {
+		return
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT "type", "name", "sql" FROM "sqlite_master" ` +
+		`WHERE "sql" IS NOT NULL OR "name" = 'sqlite_sequence' ` +
+		`ORDER BY CASE "type" WHEN 'table' THEN 0 ELSE 1 END, "rowid"`)
+
+	if err != nil {
+		return
+	}
+
+	var schema, tables, others []string
+	sequence := false
+
+	for rows.Next() {
+		var typ, name string
+		var stmt sql.NullString
+
+		if err = rows.Scan(&typ, &name, &stmt); err != nil {
+			rows.Close()
+			return
+		}
+
+		switch {
+		case name == "sqlite_sequence":
+			// Created along with the first AUTOINCREMENT table, only its rows are restored
+			sequence = true
+		case strings.HasPrefix(name, "sqlite_"):
+			// Internal tables and automatic indexes
+		case typ == "table":
+			schema = append(schema, stmt.String)
+			tables = append(tables, name)
+		default:
+			others = append(others, stmt.String)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return
+	}
+
+	rows.Close()
+	el = &ExecLog{Queries: schema}
+
+	if sequence {
+		// Restored last to replace the sequences counted while the rows are restored
+		tables = append(tables, "sqlite_sequence")
+	}
+
+	for _, t := range tables {
+		var inserts []string
+
+		if t == "sqlite_sequence" {
+			el.Queries = append(el.Queries, `DELETE FROM "sqlite_sequence"`)
+		}
+
+		if inserts, err = dumpTable(tx, t); err != nil {
+			return nil, err
+		}
+
+		el.Queries = append(el.Queries, inserts...)
+	}
+
+	el.Queries = append(el.Queries, others...)
+	return
+}
+
+// dumpTable dumps the rows of the table as insert statements. Reals are printed with enough
+// digits to be restored exactly, and the other values are quoted by sqlite.
+func dumpTable(tx *sql.Tx, table string) (inserts []string, err error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(table)))
+
+	if err != nil {
+		return
+	}
+
+	var columns []string
+
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			typ     string
+			notNull bool
+			value   interface{}
+			pk      int
+		)
+
+		if err = rows.Scan(&cid, &name, &typ, &notNull, &value, &pk); err != nil {
+			rows.Close()
+			return
+		}
+
+		c := quoteIdent(name)
+		columns = append(columns, fmt.Sprintf(
+			"CASE typeof(%s) WHEN 'real' THEN printf('%%!.17g', %s) ELSE quote(%s) END",
+			c, c, c))
+	}
+
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return
+	}
+
+	rows.Close()
+
+	if rows, err = tx.Query(fmt.Sprintf("SELECT %s FROM %s",
+		strings.Join(columns, ", "), quoteIdent(table))); err != nil {
+		return
+	}
+
+	defer rows.Close()
+	values := make([]string, len(columns))
+	dest := make([]interface{}, len(columns))
+
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+
+		inserts = append(inserts, fmt.Sprintf("INSERT INTO %s VALUES(%s)",
+			quoteIdent(table), strings.Join(values, ", ")))
+	}
+
+	err = rows.Err()
+	return
+}
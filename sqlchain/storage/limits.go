@@ -0,0 +1,117 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrStorageClosed indicates that the storage is already closed.
+	ErrStorageClosed = errors.New("storage is closed")
+
+	// ErrDBSizeExceeded indicates that the database size would exceed the limit after the
+	// execution.
+	ErrDBSizeExceeded = errors.New("database size limit exceeded")
+
+	// ErrStatementTimeout indicates that a statement runs longer than the limit.
+	ErrStatementTimeout = errors.New("statement runtime limit exceeded")
+
+	// ErrRowsAffectedExceeded indicates that the execution log touches more rows than the
+	// limit.
+	ErrRowsAffectedExceeded = errors.New("rows affected limit exceeded")
+)
+
+// Limits represents the resource quotas of a database, zero value of each field means
+// unlimited.
+type Limits struct {
+	// MaxDBSize is the maximum size of the database in bytes, counted in sqlite pages. The
+	// working set of an encrypted database is kept in memory, so it also bounds the memory used.
+	MaxDBSize int64
+
+	// MaxStatementTime is the maximum runtime of a single statement.
+	MaxStatementTime time.Duration
+
+	// MaxRowsAffected is the maximum number of rows touched by a single execution log.
+	MaxRowsAffected int64
+
+	// MaxOpenConns is the maximum number of open connections to the database.
+	MaxOpenConns int
+}
+
+// execQueries executes the queries of current tx with the limits enforced.
+func (s *Storage) execQueries(ctx context.Context) (err error) {
+	var rows int64
+
+	for _, q := range s.queries {
+		if err = s.execQuery(ctx, q, &rows); err != nil {
+			return
+		}
+	}
+
+	if s.limits.MaxDBSize > 0 {
+		var count, size int64
+
+		if err = s.tx.QueryRowContext(ctx, "PRAGMA page_count").Scan(&count); err != nil {
+			return
+		}
+
+		if err = s.tx.QueryRowContext(ctx, "PRAGMA page_size").Scan(&size); err != nil {
+			return
+		}
+
+		if count*size > s.limits.MaxDBSize {
+			return ErrDBSizeExceeded
+		}
+	}
+
+	return
+}
+
+func (s *Storage) execQuery(ctx context.Context, q string, rows *int64) (err error) {
+	if s.limits.MaxStatementTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.limits.MaxStatementTime)
+		defer cancel()
+	}
+
+	res, err := s.tx.ExecContext(ctx, q)
+
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrStatementTimeout
+		}
+
+		return
+	}
+
+	if s.limits.MaxRowsAffected > 0 {
+		var n int64
+
+		if n, err = res.RowsAffected(); err != nil {
+			return
+		}
+
+		if *rows += n; *rows > s.limits.MaxRowsAffected {
+			return ErrRowsAffectedExceeded
+		}
+	}
+
+	return
+}
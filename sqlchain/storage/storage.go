@@ -23,6 +23,8 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+
 	// Register go-sqlite3 engine.
 	_ "github.com/mattn/go-sqlite3"
 
@@ -32,12 +34,31 @@ import (
 var (
 	index = struct {
 		sync.Mutex
-		db map[string]*sql.DB
+		db map[string]*dbEntry
 	}{
-		db: make(map[string]*sql.DB),
+		db: make(map[string]*dbEntry),
 	}
 )
 
+// dbEntry is a database shared by all the storages opened on the same file.
+type dbEntry struct {
+	db      *sql.DB
+	journal *journal // Encrypted journal, nil if the database is not encrypted
+	refs    int
+}
+
+func (e *dbEntry) close() (err error) {
+	err = e.db.Close()
+
+	if e.journal != nil {
+		if jerr := e.journal.close(); err == nil {
+			err = jerr
+		}
+	}
+
+	return
+}
+
 // ExecLog represents the execution log of sqlite.
 type ExecLog struct {
 	ConnectionID uint64
@@ -46,12 +67,14 @@ type ExecLog struct {
 	Queries      []string
 }
 
-func openDB(dsn string) (db *sql.DB, err error) {
+// openDB opens the database of dsn, it returns an empty filename if the database is private and
+// not tracked by the index.
+func openDB(dsn string) (db *sql.DB, filename string, err error) {
 	// Rebuild DSN.
 	d, err := NewDSN(dsn)
 
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	d.AddParam("_journal_mode", "WAL")
@@ -69,22 +92,179 @@ func openDB(dsn string) (db *sql.DB, err error) {
 	}
 
 	index.Lock()
-	db, ok := index.db[d.filename]
-	index.Unlock()
+	defer index.Unlock()
 
-	if !ok {
-		db, err = sql.Open("sqlite3", fdsn)
+	if e, ok := index.db[fn]; ok {
+		if e.journal != nil {
+			return nil, "", fmt.Errorf("database %s is already opened with encryption", fn)
+		}
+
+		e.refs++
+		return e.db, fn, nil
+	}
+
+	if db, err = sql.Open("sqlite3", fdsn); err != nil {
+		return nil, "", err
+	}
+
+	index.db[fn] = &dbEntry{
+		db:   db,
+		refs: 1,
+	}
+
+	return db, fn, nil
+}
+
+// openEncryptedDB opens a database whose contents only exist in memory and in an encrypted
+// journal on disk. The journal is replayed into the in-memory database on first open, and then
+// compacted into a snapshot of it.
+func openEncryptedDB(dsn string, key []byte) (db *sql.DB, j *journal, filename string,
+	err error) {
+	d, err := NewDSN(dsn)
+
+	if err != nil {
+		return
+	}
+
+	fn := d.GetFileName()
+
+	if fn == ":memory:" {
+		err = errors.New("encryption is not supported on in-memory database")
+		return
+	}
+
+	index.Lock()
+	defer index.Unlock()
+
+	if e, ok := index.db[fn]; ok {
+		if e.journal == nil {
+			err = fmt.Errorf("database %s is already opened without encryption", fn)
+			return
+		}
+
+		e.refs++
+		return e.db, e.journal, fn, nil
+	}
+
+	// Use a private shared-cache memory database named after the journal file, so that all
+	// the connections in the pool see the same data.
+	name := hash.THashH([]byte(fn))
+	mdsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", name.String())
+
+	if db, err = sql.Open("sqlite3", mdsn); err != nil {
+		return
+	}
+
+	if j, err = openJournal(fn, key); err != nil {
+		db.Close()
+		return nil, nil, "", err
+	}
+
+	records := 0
+
+	if err = j.replay(func(el *ExecLog) (err error) {
+		records++
+		tx, err := db.Begin()
+
+		if err != nil {
+			return
+		}
+
+		for _, q := range el.Queries {
+			if _, err = tx.Exec(q); err != nil {
+				tx.Rollback()
+				return
+			}
+		}
+
+		return tx.Commit()
+	}); err != nil {
+		j.close()
+		db.Close()
+		return nil, nil, "", err
+	}
+
+	// Fold the replayed logs into a snapshot, so that the next open starts from it
+	if records > 1 {
+		var el *ExecLog
+
+		if el, err = snapshot(db); err == nil {
+			err = j.compact(el)
+		}
 
 		if err != nil {
-			return nil, err
+			j.close()
+			db.Close()
+			return nil, nil, "", err
 		}
+	}
 
-		index.Lock()
-		index.db[d.filename] = db
-		index.Unlock()
+	index.db[fn] = &dbEntry{
+		db:      db,
+		journal: j,
+		refs:    1,
 	}
 
-	return
+	return db, j, fn, nil
+}
+
+// releaseDB decreases the reference count of the database and closes it if it's no longer
+// referenced.
+func releaseDB(filename string, db *sql.DB) error {
+	index.Lock()
+	defer index.Unlock()
+
+	e, ok := index.db[filename]
+
+	if !ok || e.db != db {
+		// Already evicted.
+		return nil
+	}
+
+	if e.refs--; e.refs > 0 {
+		return nil
+	}
+
+	delete(index.db, filename)
+	var err error
+
+	// Snapshot the encrypted database before it's dropped from memory, so that the next open
+	// doesn't replay the logs committed since this one
+	if e.journal != nil {
+		var el *ExecLog
+
+		if el, err = snapshot(e.db); err == nil {
+			err = e.journal.compact(el)
+		}
+	}
+
+	if cerr := e.close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Evict closes the database of dsn and removes it from the index, regardless of whether it's
+// still referenced by any storage. It should be called when the database is no longer hosted
+// on this node, further operations on the storages of the database will fail.
+func Evict(dsn string) error {
+	d, err := NewDSN(dsn)
+
+	if err != nil {
+		return err
+	}
+
+	index.Lock()
+	e, ok := index.db[d.GetFileName()]
+	delete(index.db, d.GetFileName())
+	index.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return e.close()
 }
 
 // TxID represents a transaction ID.
@@ -101,27 +281,84 @@ func equalTxID(x, y *TxID) bool {
 // Storage represents a underlying storage implementation based on sqlite3.
 type Storage struct {
 	sync.Mutex
-	dsn     string
-	db      *sql.DB
-	tx      *sql.Tx // Current tx
-	id      TxID
-	queries []string
+	dsn      string
+	filename string // Index key of the shared database, empty if the database is private
+	db       *sql.DB
+	tx       *sql.Tx // Current tx
+	id       TxID
+	queries  []string
+	journal  *journal // Encrypted journal, nil if the storage is not encrypted
+	limits   Limits
+	closed   bool
 }
 
 // New returns a new storage connected by dsn.
 func New(dsn string) (st *Storage, err error) {
-	db, err := openDB(dsn)
+	db, fn, err := openDB(dsn)
+
+	if err != nil {
+		return
+	}
+
+	return &Storage{
+		dsn:      dsn,
+		filename: fn,
+		db:       db,
+	}, nil
+}
+
+// NewEncrypted returns a new storage connected by dsn, whose contents are encrypted at rest by
+// the given key. The database file named by dsn holds an encrypted snapshot and journal of the
+// committed execution logs instead of a plain sqlite database.
+func NewEncrypted(dsn string, key []byte) (st *Storage, err error) {
+	db, j, fn, err := openEncryptedDB(dsn, key)
 
 	if err != nil {
 		return
 	}
 
 	return &Storage{
-		dsn: dsn,
-		db:  db,
+		dsn:      dsn,
+		filename: fn,
+		db:       db,
+		journal:  j,
 	}, nil
 }
 
+// SetLimits sets the resource limits of the storage. The maximum open connections limit applies
+// to the underlying database and is shared by all the storages opened on the same file.
+func (s *Storage) SetLimits(l Limits) {
+	s.Lock()
+	defer s.Unlock()
+	s.limits = l
+	s.db.SetMaxOpenConns(l.MaxOpenConns)
+}
+
+// Close rolls back the current tx and releases the underlying database, the database is closed
+// once no storage references it.
+func (s *Storage) Close() (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	if s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
+		s.queries = nil
+	}
+
+	s.closed = true
+
+	if s.filename == "" {
+		return s.db.Close()
+	}
+
+	return releaseDB(s.filename, s.db)
+}
+
 // Prepare implements prepare method of two-phase commit worker.
 func (s *Storage) Prepare(ctx context.Context, wb twopc.WriteBatch) (err error) {
 	el, ok := wb.(*ExecLog)
@@ -133,6 +370,10 @@ func (s *Storage) Prepare(ctx context.Context, wb twopc.WriteBatch) (err error)
 	s.Lock()
 	defer s.Unlock()
 
+	if s.closed {
+		return ErrStorageClosed
+	}
+
 	if s.tx != nil {
 		if equalTxID(&s.id, &TxID{el.ConnectionID, el.SeqNo, el.Timestamp}) {
 			s.queries = el.Queries
@@ -166,12 +407,30 @@ func (s *Storage) Commit(ctx context.Context, wb twopc.WriteBatch) (err error) {
 	s.Lock()
 	defer s.Unlock()
 
+	if s.closed {
+		return ErrStorageClosed
+	}
+
 	if s.tx != nil {
 		if equalTxID(&s.id, &TxID{el.ConnectionID, el.SeqNo, el.Timestamp}) {
-			for _, q := range s.queries {
-				_, err = s.tx.ExecContext(ctx, q)
+			if err = s.execQueries(ctx); err != nil {
+				s.tx.Rollback()
+				s.tx = nil
+				s.queries = nil
+				return
+			}
 
-				if err != nil {
+			// The journal record is written ahead of the commit, and dropped if the commit
+			// fails so that it's never replayed
+			var offset int64
+
+			if s.journal != nil {
+				if offset, err = s.journal.append(&ExecLog{
+					ConnectionID: s.id.ConnectionID,
+					SeqNo:        s.id.SeqNo,
+					Timestamp:    s.id.Timestamp,
+					Queries:      s.queries,
+				}); err != nil {
 					s.tx.Rollback()
 					s.tx = nil
 					s.queries = nil
@@ -179,7 +438,14 @@ func (s *Storage) Commit(ctx context.Context, wb twopc.WriteBatch) (err error) {
 				}
 			}
 
-			return nil
+			if err = s.tx.Commit(); err != nil && s.journal != nil {
+				s.journal.truncate(offset)
+			}
+
+			s.tx = nil
+			s.queries = nil
+
+			return
 		}
 
 		return fmt.Errorf("twopc: inconsistent state, currently in tx: "+
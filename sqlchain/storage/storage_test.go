@@ -17,9 +17,12 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -122,3 +125,496 @@ func TestStorage(t *testing.T) {
 		t.Fatalf("Error occurred: %v", err)
 	}
 }
+
+func TestEncryptedStorage(t *testing.T) {
+	fl, err := ioutil.TempFile("", "sqlite3-enc-")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	key := []byte("database-key")
+	st, err := NewEncrypted(fmt.Sprintf("file:%s", fl.Name()), key)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	el := &ExecLog{
+		ConnectionID: 1,
+		SeqNo:        1,
+		Timestamp:    uint64(time.Now().Unix()),
+		Queries: []string{
+			"CREATE TABLE IF NOT EXISTS `kv` (`key` TEXT PRIMARY KEY, `value` BLOB)",
+			"INSERT OR REPLACE INTO `kv` VALUES ('secret-key', 'secret-value')",
+		},
+	}
+
+	if err = st.Prepare(context.Background(), el); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = st.Commit(context.Background(), el); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Nothing should be stored in plaintext.
+	content, err := ioutil.ReadFile(fl.Name())
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if bytes.Contains(content, []byte("secret-value")) {
+		t.Fatal("Unexpected result: plaintext found in database file")
+	}
+
+	// Replay the journal into a fresh database.
+	j, err := openJournal(fl.Name(), key)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer j.close()
+	var replayed []*ExecLog
+
+	if err = j.replay(func(el *ExecLog) error {
+		replayed = append(replayed, el)
+		return nil
+	}); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(replayed, []*ExecLog{el}) {
+		t.Fatalf("Unexpected result: %v", replayed)
+	}
+
+	// A record dropped after a failed commit is not replayed.
+	offset, err := j.append(&ExecLog{ConnectionID: 1, SeqNo: 2, Queries: []string{"SELECT 1"}})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = j.truncate(offset); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	replayed = nil
+
+	if err = j.replay(func(el *ExecLog) error {
+		replayed = append(replayed, el)
+		return nil
+	}); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(replayed, []*ExecLog{el}) {
+		t.Fatalf("Unexpected result: %v", replayed)
+	}
+
+	// Replay with a wrong key.
+	wj, err := openJournal(fl.Name(), []byte("wrong-key"))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer wj.close()
+
+	if err = wj.replay(func(*ExecLog) error { return nil }); err == nil {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	} else {
+		t.Logf("Error occurred as expected: %v", err)
+	}
+
+	// Reopening the same file returns the cached database.
+	st2, err := NewEncrypted(fmt.Sprintf("file:%s", fl.Name()), key)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	var value string
+
+	if err = st2.db.QueryRow("SELECT `value` FROM `kv` WHERE `key`='secret-key'").Scan(
+		&value); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if value != "secret-value" {
+		t.Fatalf("Unexpected result: %s", value)
+	}
+}
+
+func TestEncryptedSnapshot(t *testing.T) {
+	fl, err := ioutil.TempFile("", "sqlite3-snap-")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	key := []byte("database-key")
+	j, err := openJournal(fl.Name(), key)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	for i, q := range [][]string{
+		{
+			"CREATE TABLE `t` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `r` REAL, `b` BLOB, " +
+				"`s` TEXT)",
+			"CREATE TABLE `audit` (`id` INTEGER)",
+			"CREATE INDEX `t_s` ON `t` (`s`)",
+			"CREATE TRIGGER `t_audit` AFTER INSERT ON `t` " +
+				"BEGIN INSERT INTO `audit` VALUES (NEW.`id`); END",
+		},
+		{
+			"INSERT INTO `t` (`r`, `b`, `s`) VALUES (0.1, X'00ff', 'it''s')",
+			"INSERT INTO `t` (`r`, `b`, `s`) VALUES (1e300, NULL, NULL)",
+		},
+		{
+			"DELETE FROM `t` WHERE `id` = 2",
+		},
+	} {
+		if _, err = j.append(&ExecLog{ConnectionID: 1, SeqNo: uint64(i), Queries: q}); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	j.close()
+
+	// Opening the database compacts the journal into a single snapshot.
+	st, err := NewEncrypted(fmt.Sprintf("file:%s", fl.Name()), key)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if j, err = openJournal(fl.Name(), key); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer j.close()
+	var replayed []*ExecLog
+
+	if err = j.replay(func(el *ExecLog) error {
+		replayed = append(replayed, el)
+		return nil
+	}); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(replayed) != 1 {
+		t.Fatalf("Unexpected result: %v", replayed)
+	}
+
+	// Rebuild a database from the snapshot.
+	db, err := sql.Open("sqlite3", ":memory:")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for _, q := range replayed[0].Queries {
+		if _, err = db.Exec(q); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	for _, d := range []*sql.DB{st.db, db} {
+		var (
+			r     float64
+			b     []byte
+			s     string
+			count int
+			seq   int
+		)
+
+		if err = d.QueryRow("SELECT `r`, `b`, `s` FROM `t`").Scan(&r, &b, &s); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if r != 0.1 || !bytes.Equal(b, []byte{0, 0xff}) || s != "it's" {
+			t.Fatalf("Unexpected result: %v %v %v", r, b, s)
+		}
+
+		if err = d.QueryRow("SELECT count(*) FROM `audit`").Scan(&count); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if count != 2 {
+			t.Fatalf("Unexpected result: %d", count)
+		}
+
+		if err = d.QueryRow(
+			"SELECT `seq` FROM `sqlite_sequence` WHERE `name` = 't'").Scan(&seq); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if seq != 2 {
+			t.Fatalf("Unexpected result: %d", seq)
+		}
+	}
+
+	// Releasing the database compacts the logs committed since it was opened.
+	el := &ExecLog{
+		ConnectionID: 2,
+		SeqNo:        1,
+		Queries:      []string{"INSERT INTO `t` (`r`) VALUES (2.5)"},
+	}
+
+	if err = st.Prepare(context.Background(), el); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = st.Commit(context.Background(), el); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = st.Close(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if st, err = NewEncrypted(fmt.Sprintf("file:%s", fl.Name()), key); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer st.Close()
+	var count int
+
+	if err = st.db.QueryRow("SELECT count(*) FROM `t`").Scan(&count); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if count != 2 {
+		t.Fatalf("Unexpected result: %d", count)
+	}
+
+	wj, err := openJournal(fl.Name(), key)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer wj.close()
+	records := 0
+
+	if err = wj.replay(func(*ExecLog) error {
+		records++
+		return nil
+	}); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if records != 1 {
+		t.Fatalf("Unexpected result: %d", records)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	fl, err := ioutil.TempFile("", "sqlite3-")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	st, err := New(fmt.Sprintf("file:%s", fl.Name()))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer st.Close()
+
+	var seq uint64
+	exec := func(queries ...string) error {
+		seq++
+		el := &ExecLog{
+			ConnectionID: 1,
+			SeqNo:        seq,
+			Timestamp:    uint64(time.Now().Unix()),
+			Queries:      queries,
+		}
+
+		if err := st.Prepare(context.Background(), el); err != nil {
+			return err
+		}
+
+		return st.Commit(context.Background(), el)
+	}
+
+	if err = exec(
+		"CREATE TABLE IF NOT EXISTS `kv` (`key` TEXT PRIMARY KEY, `value` BLOB)",
+		"INSERT INTO `kv` VALUES ('k1', 'v1')",
+		"INSERT INTO `kv` VALUES ('k2', 'v2')",
+		"INSERT INTO `kv` VALUES ('k3', 'v3')",
+	); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Rows affected limit
+	st.SetLimits(Limits{MaxRowsAffected: 2})
+
+	if err = exec("UPDATE `kv` SET `value` = 'v'"); err != ErrRowsAffectedExceeded {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	if err = exec("UPDATE `kv` SET `value` = 'v' WHERE `key` = 'k1'"); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Statement runtime limit
+	st.SetLimits(Limits{MaxStatementTime: 10 * time.Millisecond})
+
+	if err = exec("WITH RECURSIVE `c`(`x`) AS (SELECT 1 UNION ALL SELECT `x` + 1 FROM `c` " +
+		"WHERE `x` < 1000000000) SELECT COUNT(*) FROM `c`"); err != ErrStatementTimeout {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	// Database size limit
+	st.SetLimits(Limits{MaxDBSize: 64 * 1024})
+
+	if err = exec("INSERT INTO `kv` VALUES ('k4', zeroblob(1048576))"); err != ErrDBSizeExceeded {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	if err = exec("INSERT INTO `kv` VALUES ('k4', 'v4')"); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	fl, err := ioutil.TempFile("", "sqlite3-")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	dsn := fmt.Sprintf("file:%s", fl.Name())
+	st1, err := New(dsn)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	st2, err := New(dsn)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if st1.db != st2.db {
+		t.Fatal("Unexpected result: database is not shared")
+	}
+
+	if err = st1.Close(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	el := &ExecLog{
+		ConnectionID: 1,
+		SeqNo:        1,
+		Timestamp:    uint64(time.Now().Unix()),
+		Queries:      []string{"CREATE TABLE IF NOT EXISTS `t` (`k` TEXT)"},
+	}
+
+	if err = st1.Prepare(context.Background(), el); err != ErrStorageClosed {
+		t.Fatalf("Unexpected result: %v", err)
+	}
+
+	// The database is still referenced by st2.
+	if err = st2.Prepare(context.Background(), el); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = st2.Commit(context.Background(), el); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = st2.Close(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	index.Lock()
+	_, ok := index.db[fl.Name()]
+	index.Unlock()
+
+	if ok {
+		t.Fatal("Unexpected result: database is not released")
+	}
+
+	// Evict a referenced database.
+	st3, err := New(dsn)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = Evict(dsn); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = st3.Prepare(context.Background(), el); err == nil {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	} else {
+		t.Logf("Error occurred as expected: %v", err)
+	}
+
+	if err = st3.Close(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+}
+
+func TestExecLogCodec(t *testing.T) {
+	codec := &ExecLogCodec{}
+	el := &ExecLog{
+		ConnectionID: 1,
+		SeqNo:        2,
+		Timestamp:    uint64(time.Now().UnixNano()),
+		Queries:      []string{"CREATE TABLE t (k INT)", "INSERT INTO t VALUES (1)"},
+	}
+
+	buffer, err := codec.Encode(el)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	var decoded interface{}
+
+	if err = codec.Decode(buffer, &decoded); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(el, decoded) {
+		t.Fatalf("Values don't match: v1 = %v, v2 = %v", el, decoded)
+	}
+
+	rel := ExecLog{}
+
+	if err = codec.Decode(buffer, &rel); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(*el, rel) {
+		t.Fatalf("Values don't match: v1 = %v, v2 = %v", *el, rel)
+	}
+
+	if _, err = codec.Encode("xxx"); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	if err = codec.Decode(buffer, new(string)); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+}
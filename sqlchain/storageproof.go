@@ -17,24 +17,75 @@
 package sqlchain
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"time"
 
+	bolt "github.com/coreos/bbolt"
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
 	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
 	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/utils"
+)
+
+const (
+	// MissingAnswerPenalty is the penalty of a node which doesn't submit its answer in a storage
+	// proof round.
+	MissingAnswerPenalty uint64 = 1
+
+	// WrongAnswerPenalty is the penalty of a node which submits a wrong answer in a storage proof
+	// round.
+	WrongAnswerPenalty uint64 = 3
+)
+
+var (
+	// ErrInvalidAnswers indicates that the answers of the previous round are missing, or
+	// inconsistent with each other.
+	ErrInvalidAnswers = errors.New("invalid storage proof answers")
+
+	// ErrInvalidProofBlock indicates that the storage proof block has no header or no replica,
+	// or that the blocks of the round are not linked.
+	ErrInvalidProofBlock = errors.New("invalid storage proof block")
+
+	// ErrInvalidProofNode indicates that the node is not a replica of the block.
+	ErrInvalidProofNode = errors.New("invalid storage proof node")
+
+	// ErrNotVerifier indicates that the local node is not the verifier of the round.
+	ErrNotVerifier = errors.New("not the verifier of the round")
+
+	// ErrProofExists indicates that the result of the storage proof round is already recorded.
+	ErrProofExists = errors.New("storage proof result already exists")
+
+	// ErrInvalidProofResult indicates that the proof result accounts for a node more than once,
+	// accounts for the verifier itself or a non-replica, or leaves a replica out.
+	ErrInvalidProofResult = errors.New("invalid storage proof result")
+
+	// ErrProofRootVerification indicates that the proof results of a block don't match the proof
+	// root of its header.
+	ErrProofRootVerification = errors.New("proof root verification failed")
 )
 
 // Answer is responded by node to confirm other nodes that the node stores data correctly
 type Answer struct {
-	// The block id that the question belongs to
-	PreviousBlockID BlockID
+	// The block hash that the question belongs to
+	PreviousBlockID hash.Hash
 	// The node id that provides this answer
 	NodeID proto.NodeID
 	// The answer for the question
 	Answer hash.Hash
+
+	// The signature of the answer by the node
+	Signee    *asymmetric.PublicKey
+	Signature *asymmetric.Signature
 }
 
 // NewAnswer generates an answer for storage proof
-func NewAnswer(previousBlockID BlockID, nodeID proto.NodeID, answer hash.Hash) *Answer {
+func NewAnswer(previousBlockID hash.Hash, nodeID proto.NodeID, answer hash.Hash) *Answer {
 	return &Answer{
 		PreviousBlockID: previousBlockID,
 		NodeID:          nodeID,
@@ -42,74 +93,1027 @@ func NewAnswer(previousBlockID BlockID, nodeID proto.NodeID, answer hash.Hash) *
 	}
 }
 
+func (a *Answer) serializeHeader(w io.Writer) error {
+	return utils.WriteElements(w, binary.BigEndian,
+		&a.PreviousBlockID,
+		a.NodeID,
+		&a.Answer,
+		a.Signee,
+	)
+}
+
+func (a *Answer) serialize(w io.Writer) (err error) {
+	if err = a.serializeHeader(w); err != nil {
+		return
+	}
+
+	return utils.WriteElements(w, binary.BigEndian, a.Signature)
+}
+
+func (a *Answer) deserialize(r io.Reader) (err error) {
+	return utils.ReadElements(r, binary.BigEndian,
+		&a.PreviousBlockID,
+		&a.NodeID,
+		&a.Answer,
+		&a.Signee,
+		&a.Signature,
+	)
+}
+
+func (a *Answer) headerHash() (h hash.Hash, err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = a.serializeHeader(buffer); err != nil {
+		return
+	}
+
+	return hash.THashH(buffer.Bytes()), nil
+}
+
+// Sign sets the signee of the answer and signs it with the given private key.
+func (a *Answer) Sign(signer *asymmetric.PrivateKey) (err error) {
+	a.Signee = signer.PubKey()
+	h, err := a.headerHash()
+
+	if err != nil {
+		return
+	}
+
+	a.Signature, err = signer.Sign(h[:])
+	return
+}
+
+// Verify verifies the signature of the answer.
+func (a *Answer) Verify() (err error) {
+	if a.Signee == nil || a.Signature == nil {
+		return ErrNilValue
+	}
+
+	h, err := a.headerHash()
+
+	if err != nil {
+		return
+	}
+
+	if !a.Signature.Verify(h[:], a.Signee) {
+		return ErrSignVerification
+	}
+
+	return
+}
+
 // getNextPuzzle generate new puzzle which ask other nodes to get a specified record in database.
-// The index of next SQL (puzzle) is determined by the previous answer and previous block hash
-func getNextPuzzle(answers []Answer, previousBlock StorageProofBlock) (int32, error) {
-	var totalRecordsInSQLChain int32 = 10
-	var sum int32
+// The puzzle is determined by the previous answers and previous block hash, and the record is
+// selected by the puzzle modulo the total number of records. The puzzle of a round without any
+// previous answer, such as the first round or the round after one which nobody answered right, is
+// determined by the previous block hash only.
+func getNextPuzzle(answers []Answer, previousBlock *Block) (uint32, error) {
+	// check if block is valid
+	if previousBlock == nil || previousBlock.SignedHeader == nil {
+		return 0, ErrInvalidProofBlock
+	}
+
+	sum := hash.FNVHash32uint(previousBlock.SignedHeader.BlockHash[:])
+
+	if len(answers) == 0 {
+		return sum, nil
+	}
+
 	if !CheckValid(answers) {
-		return -1, errors.New("some nodes have not submitted its answer")
+		return 0, ErrInvalidAnswers
 	}
+
 	for _, answer := range answers {
-		// check if answer is valid
-		if len(answer.Answer) != hash.HashSize {
-			return -1, errors.New("invalid answer format")
-		}
-		sum += int32(hash.FNVHash32uint(answer.Answer[:]))
+		sum += hash.FNVHash32uint(answer.Answer[:])
 	}
-	// check if block is valid
-	if len(previousBlock.ID) <= 0 {
-		return -1, errors.New("invalid block format")
+
+	return sum, nil
+}
+
+// checkRound checks that the blocks belong to the same storage proof round.
+func checkRound(previousBlock, currentBlock *Block) error {
+	if previousBlock == nil || previousBlock.SignedHeader == nil || currentBlock == nil ||
+		currentBlock.SignedHeader == nil || len(currentBlock.SignedHeader.Replicas) == 0 {
+		return ErrInvalidProofBlock
 	}
-	sum += int32(hash.FNVHash32uint([]byte(previousBlock.ID)))
 
-	nextPuzzle := sum % totalRecordsInSQLChain
-	return nextPuzzle, nil
+	if !currentBlock.SignedHeader.ParentHash.IsEqual(&previousBlock.SignedHeader.BlockHash) {
+		return ErrInvalidProofBlock
+	}
+
+	return nil
 }
 
-// getNExtVerifier returns the id of next verifier.
-// ID is determined by the hash of previous block.
-func getNextVerifier(previousBlock, currentBlock StorageProofBlock) (int32, error) {
-	// check if block is valid
-	if len(previousBlock.ID) <= 0 {
-		return -1, errors.New("invalid previous block")
+// getNextVerifier returns the id of next verifier.
+// ID is determined by the hash of previous block and the replica set of the current block.
+func getNextVerifier(previousBlock, currentBlock *Block) (proto.NodeID, error) {
+	if err := checkRound(previousBlock, currentBlock); err != nil {
+		return "", err
 	}
-	if len(currentBlock.Nodes) <= 0 {
-		return -1, errors.New("invalid current block")
+
+	id := previousBlock.SignedHeader.BlockHash
+	replicas := currentBlock.SignedHeader.Replicas
+	return replicas[hash.FNVHash32uint(id[:])%uint32(len(replicas))], nil
+}
+
+// quoteIdentifier quotes a sqlite identifier.
+func quoteIdentifier(id string) string {
+	return "\"" + string(bytes.Replace([]byte(id), []byte("\""), []byte("\"\""), -1)) + "\""
+}
+
+// selectRecord returns the encoded nth record of all the user tables in the database, tables are
+// sorted by name and rows are sorted by rowid in each table, so that the same record is selected
+// by all the replicas. It returns an empty record if the database has no row.
+func selectRecord(db *sql.DB, n uint32) (record []byte, err error) {
+	rows, err := db.Query("SELECT `name` FROM `sqlite_master` WHERE `type` = 'table' " +
+		"AND `name` NOT LIKE 'sqlite_%' ORDER BY `name`")
+
+	if err != nil {
+		return
 	}
-	verifier := int32(hash.FNVHash32uint([]byte(previousBlock.ID))) % int32(len(currentBlock.Nodes))
 
-	return verifier, nil
+	var tables []string
+
+	for rows.Next() {
+		var name string
+
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return
+		}
+
+		tables = append(tables, name)
+	}
+
+	if err = rows.Close(); err != nil {
+		return
+	}
+
+	counts := make([]int64, len(tables))
+	var total int64
+
+	for i, t := range tables {
+		if err = db.QueryRow("SELECT COUNT(*) FROM " + quoteIdentifier(t)).Scan(
+			&counts[i]); err != nil {
+			return
+		}
+
+		total += counts[i]
+	}
+
+	if total == 0 {
+		return []byte{}, nil
+	}
+
+	offset := int64(n) % total
+	i := 0
+
+	for ; offset >= counts[i]; i++ {
+		offset -= counts[i]
+	}
+
+	return encodeRecord(db, tables[i], offset)
 }
 
-// selectRecord returns nth record in the table from the database
-func selectRecord(n int32) string {
-	return "hello world"
+// encodeRecord encodes the row at the given offset of the table.
+func encodeRecord(db *sql.DB, table string, offset int64) (record []byte, err error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s ORDER BY `rowid` LIMIT 1 OFFSET %d",
+		quoteIdentifier(table), offset))
+
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return
+	}
+
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+
+		return
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	if err = rows.Scan(dest...); err != nil {
+		return
+	}
+
+	// Encode the table name followed by typed values
+	buffer := bytes.NewBuffer(nil)
+
+	if err = utils.WriteElements(buffer, binary.BigEndian, table); err != nil {
+		return
+	}
+
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			err = utils.WriteElements(buffer, binary.BigEndian, uint8(0))
+		case int64:
+			err = utils.WriteElements(buffer, binary.BigEndian, uint8(1), v)
+		case float64:
+			err = utils.WriteElements(buffer, binary.BigEndian, uint8(2), v)
+		case bool:
+			err = utils.WriteElements(buffer, binary.BigEndian, uint8(3), v)
+		case []byte:
+			err = utils.WriteElements(buffer, binary.BigEndian, uint8(4), v)
+		case string:
+			err = utils.WriteElements(buffer, binary.BigEndian, uint8(5), v)
+		case time.Time:
+			err = utils.WriteElements(buffer, binary.BigEndian, uint8(6), v)
+		default:
+			err = utils.WriteElements(buffer, binary.BigEndian, uint8(7), fmt.Sprint(v))
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return buffer.Bytes(), nil
 }
 
 // CheckValid returns whether answers is valid
 // Checkvalid checks answers as follows:
-// 1. len(answers) == len(nodes) - 1
-// 2. answers[i].nodeID's answer is the same as the hash of verifier
+// 1. answers are not empty and all of them belong to the same block
+// 2. each node answers at most once
+// 3. each answer is signed by the node
 func CheckValid(answers []Answer) bool {
-	return len(answers) > 0
+	if len(answers) == 0 {
+		return false
+	}
+
+	nodes := make(map[proto.NodeID]bool, len(answers))
+
+	for i := range answers {
+		if answers[i].PreviousBlockID != answers[0].PreviousBlockID || nodes[answers[i].NodeID] {
+			return false
+		}
+
+		if answers[i].Verify() != nil {
+			return false
+		}
+
+		nodes[answers[i].NodeID] = true
+	}
+
+	return true
 }
 
-// GenerateAnswer will select specified record for proving.
+// computeAnswer returns the answer hash of the node for the record.
 // In order to generate a unique answer which is different with other nodes' answer,
 // we hash(record + nodeID) as the answer
-func GenerateAnswer(answers []Answer, previousBlock StorageProofBlock, node proto.Node) (*Answer, error) {
-	sqlIndex, err := getNextPuzzle(answers, previousBlock)
-	if err != nil {
+func computeAnswer(record []byte, nodeID proto.NodeID) hash.Hash {
+	answer := make([]byte, 0, len(record)+len(nodeID))
+	answer = append(answer, record...)
+	answer = append(answer, nodeID...)
+	return hash.HashH(answer)
+}
+
+// GenerateAnswer will select specified record from the database for proving, and sign the answer
+// with the given private key.
+func GenerateAnswer(db *sql.DB, answers []Answer, previousBlock, currentBlock *Block,
+	nodeID proto.NodeID, signer *asymmetric.PrivateKey) (*Answer, error) {
+	if err := checkRound(previousBlock, currentBlock); err != nil {
 		return nil, err
 	}
-	record := []byte(selectRecord(sqlIndex))
 	// check if node is valid
-	if len(node.ID) <= 0 {
-		return nil, errors.New("invalid node format")
+	if !currentBlock.SignedHeader.HasReplica(nodeID) {
+		return nil, ErrInvalidProofNode
+	}
+	puzzle, err := getNextPuzzle(answers, previousBlock)
+	if err != nil {
+		return nil, err
+	}
+	record, err := selectRecord(db, puzzle)
+	if err != nil {
+		return nil, err
+	}
+	answer := NewAnswer(previousBlock.SignedHeader.BlockHash, nodeID,
+		computeAnswer(record, nodeID))
+	if err = answer.Sign(signer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// ProofResult is the result of a storage proof round checked by the verifier.
+type ProofResult struct {
+	// The block hash that the question belongs to
+	PreviousBlockID hash.Hash
+	// The puzzle of the round
+	Puzzle uint32
+	// The verifier of the round
+	Verifier proto.NodeID
+	// The correct answers
+	Answers []Answer
+	// The nodes which don't answer or answer wrong
+	Missing []proto.NodeID
+	Wrong   []proto.NodeID
+
+	// The signature of the result by the verifier
+	Signee    *asymmetric.PublicKey
+	Signature *asymmetric.Signature
+}
+
+func (r *ProofResult) serializeHeader(w io.Writer) (err error) {
+	if err = utils.WriteElements(w, binary.BigEndian,
+		&r.PreviousBlockID,
+		r.Puzzle,
+		r.Verifier,
+		uint32(len(r.Answers)),
+	); err != nil {
+		return
+	}
+
+	for i := range r.Answers {
+		if err = r.Answers[i].serialize(w); err != nil {
+			return
+		}
+	}
+
+	for _, ids := range [][]proto.NodeID{r.Missing, r.Wrong} {
+		if err = writeNodeIDs(w, ids); err != nil {
+			return
+		}
+	}
+
+	return utils.WriteElements(w, binary.BigEndian, r.Signee)
+}
+
+func (r *ProofResult) serialize(w io.Writer) (err error) {
+	if err = r.serializeHeader(w); err != nil {
+		return
+	}
+
+	return utils.WriteElements(w, binary.BigEndian, r.Signature)
+}
+
+func (r *ProofResult) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := r.serialize(buffer); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (r *ProofResult) unmarshal(b []byte) (err error) {
+	return r.deserialize(bytes.NewReader(b))
+}
+
+func (r *ProofResult) deserialize(reader *bytes.Reader) (err error) {
+	var l uint32
+
+	if err = utils.ReadElements(reader, binary.BigEndian,
+		&r.PreviousBlockID,
+		&r.Puzzle,
+		&r.Verifier,
+		&l,
+	); err != nil {
+		return
+	}
+
+	if int(l) > reader.Len() {
+		return utils.ErrInsufficientBuffer
+	}
+
+	r.Answers = nil
+
+	if l > 0 {
+		r.Answers = make([]Answer, l)
+	}
+
+	for i := range r.Answers {
+		if err = r.Answers[i].deserialize(reader); err != nil {
+			return
+		}
+	}
+
+	for _, ids := range []*[]proto.NodeID{&r.Missing, &r.Wrong} {
+		if *ids, err = readNodeIDs(reader); err != nil {
+			return
+		}
+	}
+
+	return utils.ReadElements(reader, binary.BigEndian,
+		&r.Signee,
+		&r.Signature,
+	)
+}
+
+func (r *ProofResult) headerHash() (h hash.Hash, err error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err = r.serializeHeader(buffer); err != nil {
+		return
+	}
+
+	return hash.THashH(buffer.Bytes()), nil
+}
+
+// Sign sets the signee of the result and signs it with the private key of the verifier.
+func (r *ProofResult) Sign(signer *asymmetric.PrivateKey) (err error) {
+	r.Signee = signer.PubKey()
+	h, err := r.headerHash()
+
+	if err != nil {
+		return
+	}
+
+	r.Signature, err = signer.Sign(h[:])
+	return
+}
+
+// nodes returns the nodes accounted for by the result. Each node which is checked by the verifier
+// either answers right, or is missing or wrong, so the sets must be disjoint.
+func (r *ProofResult) nodes() (nodes map[proto.NodeID]bool, err error) {
+	nodes = make(map[proto.NodeID]bool, len(r.Answers)+len(r.Missing)+len(r.Wrong))
+	add := func(id proto.NodeID) error {
+		if id == r.Verifier || nodes[id] {
+			return ErrInvalidProofResult
+		}
+
+		nodes[id] = true
+		return nil
+	}
+
+	for i := range r.Answers {
+		if err = add(r.Answers[i].NodeID); err != nil {
+			return
+		}
+	}
+
+	for _, ids := range [][]proto.NodeID{r.Missing, r.Wrong} {
+		for _, id := range ids {
+			if err = add(id); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// Verify verifies the signature of the result and the accepted answers of the round, and checks
+// that each node is accounted for at most once.
+func (r *ProofResult) Verify() (err error) {
+	if r.Signee == nil || r.Signature == nil {
+		return ErrNilValue
+	}
+
+	if _, err = r.nodes(); err != nil {
+		return
+	}
+
+	for i := range r.Answers {
+		if !r.Answers[i].PreviousBlockID.IsEqual(&r.PreviousBlockID) {
+			return ErrInvalidAnswers
+		}
+
+		if err = r.Answers[i].Verify(); err != nil {
+			return
+		}
+	}
+
+	h, err := r.headerHash()
+
+	if err != nil {
+		return
+	}
+
+	if !r.Signature.Verify(h[:], r.Signee) {
+		return ErrSignVerification
+	}
+
+	return
+}
+
+// ProofResults is the storage proof results recorded in a block.
+type ProofResults []*ProofResult
+
+func (rs ProofResults) marshal() ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	if err := utils.WriteElements(buffer, binary.BigEndian, uint32(len(rs))); err != nil {
+		return nil, err
+	}
+
+	for _, r := range rs {
+		if err := r.serialize(buffer); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (rs *ProofResults) unmarshal(b []byte) (err error) {
+	reader := bytes.NewReader(b)
+	var l uint32
+
+	if err = utils.ReadElements(reader, binary.BigEndian, &l); err != nil {
+		return
+	}
+
+	if int64(l) > int64(reader.Len()) {
+		return utils.ErrInsufficientBuffer
+	}
+
+	*rs = nil
+
+	for i := uint32(0); i < l; i++ {
+		r := &ProofResult{}
+
+		if err = r.deserialize(reader); err != nil {
+			return
+		}
+
+		*rs = append(*rs, r)
+	}
+
+	return
+}
+
+// Hash returns the hash of the results, it returns an empty hash if there is no result.
+func (rs ProofResults) Hash() (h hash.Hash, err error) {
+	if len(rs) == 0 {
+		return
+	}
+
+	buffer, err := rs.marshal()
+
+	if err != nil {
+		return
+	}
+
+	return hash.THashH(buffer), nil
+}
+
+// VerifyAnswers checks the answers of the current round by the verifier. The verifier selects
+// the record of the puzzle from its own database, and checks the answer and signature of each
+// replica recorded in the current block. The replicas which don't answer or answer wrong are
+// recorded in the result, which should be signed by the verifier and packed into a block by
+// Producer.AddProofResult, the nodes are penalized when the block is pushed to the chain.
+func VerifyAnswers(db *sql.DB, previousAnswers []Answer, previousBlock, currentBlock *Block,
+	verifier proto.NodeID, answers []Answer) (result *ProofResult, err error) {
+	expected, err := getNextVerifier(previousBlock, currentBlock)
+
+	if err != nil {
+		return
+	}
+
+	if expected != verifier {
+		return nil, ErrNotVerifier
+	}
+
+	puzzle, err := getNextPuzzle(previousAnswers, previousBlock)
+
+	if err != nil {
+		return
+	}
+
+	record, err := selectRecord(db, puzzle)
+
+	if err != nil {
+		return
+	}
+
+	id := previousBlock.SignedHeader.BlockHash
+	result = &ProofResult{
+		PreviousBlockID: id,
+		Puzzle:          puzzle,
+		Verifier:        verifier,
+	}
+
+	// Index the answers by node, only the first answer of each node is accepted
+	submitted := make(map[proto.NodeID]*Answer, len(answers))
+
+	for i := range answers {
+		if _, ok := submitted[answers[i].NodeID]; !ok {
+			submitted[answers[i].NodeID] = &answers[i]
+		}
+	}
+
+	for _, node := range currentBlock.SignedHeader.Replicas {
+		if node == verifier {
+			continue
+		}
+
+		a, ok := submitted[node]
+
+		if !ok {
+			result.Missing = append(result.Missing, node)
+			continue
+		}
+
+		// The answer must be signed by the node itself
+		if pk, err := kms.GetPublicKey(node); err != nil || !pk.IsEqual(a.Signee) ||
+			!a.PreviousBlockID.IsEqual(&id) || a.Verify() != nil ||
+			a.Answer != computeAnswer(record, node) {
+			result.Wrong = append(result.Wrong, node)
+			continue
+		}
+
+		result.Answers = append(result.Answers, *a)
+	}
+
+	return
+}
+
+// proofRound returns the blocks of the storage proof round of the given block, which are the
+// parent of the block and the block itself.
+func (c *Chain) proofRound(h *hash.Hash) (previousBlock, currentBlock *Block, err error) {
+	if currentBlock, err = c.GetBlock(h); err != nil {
+		return
+	}
+
+	previousBlock, err = c.GetBlock(&currentBlock.SignedHeader.ParentHash)
+	return
+}
+
+// previousAnswers returns the accepted answers of the storage proof round of the given block,
+// which are read from the result recorded in the chain. It returns nil if the block is the genesis
+// block or the result of its round is not recorded by the best chain, the next puzzle is derived
+// from the block hash only then.
+func (c *Chain) previousAnswers(previousBlock *Block) (answers []Answer, err error) {
+	if previousBlock.SignedHeader.ParentHash == previousBlock.SignedHeader.RootHash {
+		return
+	}
+
+	r, err := c.GetProofResult(previousBlock.SignedHeader.ParentHash)
+
+	if err == ErrBlockNotFound {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+
+	return r.Answers, nil
+}
+
+// NextVerifier returns the verifier of the storage proof round of the given block.
+func (c *Chain) NextVerifier(h *hash.Hash) (verifier proto.NodeID, err error) {
+	previousBlock, currentBlock, err := c.proofRound(h)
+
+	if err != nil {
+		return
+	}
+
+	return getNextVerifier(previousBlock, currentBlock)
+}
+
+// NextPuzzle returns the puzzle of the storage proof round of the given block, which is derived
+// from the answers recorded in the result of the previous round.
+func (c *Chain) NextPuzzle(h *hash.Hash) (puzzle uint32, err error) {
+	previousBlock, currentBlock, err := c.proofRound(h)
+
+	if err != nil {
+		return
+	}
+
+	if err = checkRound(previousBlock, currentBlock); err != nil {
+		return
+	}
+
+	answers, err := c.previousAnswers(previousBlock)
+
+	if err != nil {
+		return
+	}
+
+	return getNextPuzzle(answers, previousBlock)
+}
+
+// GenerateAnswer generates the answer of the node for the storage proof round of the given
+// block.
+func (c *Chain) GenerateAnswer(db *sql.DB, h *hash.Hash, nodeID proto.NodeID,
+	signer *asymmetric.PrivateKey) (answer *Answer, err error) {
+	previousBlock, currentBlock, err := c.proofRound(h)
+
+	if err != nil {
+		return
+	}
+
+	answers, err := c.previousAnswers(previousBlock)
+
+	if err != nil {
+		return
+	}
+
+	return GenerateAnswer(db, answers, previousBlock, currentBlock, nodeID, signer)
+}
+
+// VerifyAnswers verifies the answers of the storage proof round of the given block by the
+// verifier.
+func (c *Chain) VerifyAnswers(db *sql.DB, h *hash.Hash, verifier proto.NodeID,
+	answers []Answer) (result *ProofResult, err error) {
+	previousBlock, currentBlock, err := c.proofRound(h)
+
+	if err != nil {
+		return
+	}
+
+	previousAnswers, err := c.previousAnswers(previousBlock)
+
+	if err != nil {
+		return
+	}
+
+	return VerifyAnswers(db, previousAnswers, previousBlock, currentBlock, verifier, answers)
+}
+
+// checkProofResults checks that each proof result of the block is signed by the verifier of its
+// round, that it accounts for every other replica of the round exactly once, and that the round is
+// taken by the ancestors of the block and not recorded yet by them.
+func (c *Chain) checkProofResults(block *Block, parent *blockNode) (err error) {
+	rounds := make(map[hash.Hash]bool, len(block.ProofResults))
+
+	for _, r := range block.ProofResults {
+		if rounds[r.PreviousBlockID] {
+			return ErrProofExists
+		}
+
+		rounds[r.PreviousBlockID] = true
+		previous := c.index.LookupNode(&r.PreviousBlockID)
+
+		if previous == nil || parent == nil {
+			return ErrInvalidProofBlock
+		}
+
+		current := parent.ancestor(previous.height + 1)
+
+		if current == nil || current.parent != previous {
+			return ErrInvalidProofBlock
+		}
+
+		previousHeader, err := c.fetchHeader(previous)
+
+		if err != nil {
+			return err
+		}
+
+		currentHeader, err := c.fetchHeader(current)
+
+		if err != nil {
+			return err
+		}
+
+		verifier, err := getNextVerifier(&Block{SignedHeader: previousHeader},
+			&Block{SignedHeader: currentHeader})
+
+		if err != nil {
+			return err
+		}
+
+		if verifier != r.Verifier {
+			return ErrNotVerifier
+		}
+
+		pk, err := kms.GetPublicKey(r.Verifier)
+
+		if err != nil {
+			return err
+		}
+
+		if !pk.IsEqual(r.Signee) {
+			return ErrInvalidProofNode
+		}
+
+		// The accepted answers, missing and wrong nodes partition the other replicas
+		nodes, err := r.nodes()
+
+		if err != nil {
+			return err
+		}
+
+		if len(nodes) != len(currentHeader.Replicas)-1 {
+			return ErrInvalidProofResult
+		}
+
+		for id := range nodes {
+			if !currentHeader.HasReplica(id) {
+				return ErrInvalidProofResult
+			}
+		}
+	}
+
+	if len(rounds) == 0 {
+		return
+	}
+
+	return c.db.View(func(tx *bolt.Tx) (err error) {
+		bucket := tx.Bucket(metaBucket[:])
+		bp := bucket.Bucket(metaBlockProofBucket)
+		best := c.state.node
+		node := parent
+
+		// Scan the blocks of the branch which are not on the best chain
+		for node != nil && (best == nil || best.ancestor(node.height) != node) {
+			var results ProofResults
+
+			if results, err = readProofResults(bp, node); err != nil {
+				return
+			}
+
+			for _, r := range results {
+				if rounds[r.PreviousBlockID] {
+					return ErrProofExists
+				}
+			}
+
+			node = node.parent
+		}
+
+		if node == nil {
+			return
+		}
+
+		// The rest of the branch is the best chain up to node, which is indexed by round
+		pb := bucket.Bucket(metaProofBucket)
+
+		for id := range rounds {
+			if v := pb.Get(id[:]); v != nil {
+				var h hash.Hash
+				copy(h[:], v)
+
+				if n := c.index.LookupNode(&h); n != nil && n.height <= node.height {
+					return ErrProofExists
+				}
+			}
+		}
+
+		return
+	})
+}
+
+// checkProofResult checks that the result can be recorded in the next block of the best chain.
+func (c *Chain) checkProofResult(r *ProofResult) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checkProofResults(&Block{ProofResults: ProofResults{r}}, c.state.node)
+}
+
+// readProofResults reads the proof results recorded by the block of the node.
+func readProofResults(bp *bolt.Bucket, node *blockNode) (results ProofResults, err error) {
+	if v := bp.Get(node.indexKey()); v != nil {
+		err = results.unmarshal(v)
+	}
+
+	return
+}
+
+// switchProofResults moves the round index and the penalties from the old best branch to the new
+// one, which is derived state of the best chain: the results of the old blocks above the fork
+// point are reverted, and the ones of the new blocks are applied in height order.
+func switchProofResults(bucket *bolt.Bucket, old, new *blockNode) (err error) {
+	fork := lastCommonAncestor(old, new)
+
+	for node := old; node != fork; node = node.parent {
+		if err = applyProofResults(bucket, node, true); err != nil {
+			return
+		}
 	}
-	answer := append(record, []byte(node.ID)...)
 
-	answerHash := hash.HashH(answer)
-	return NewAnswer(previousBlock.ID, node.ID, answerHash), nil
+	var nodes []*blockNode
+
+	for node := new; node != fork; node = node.parent {
+		nodes = append(nodes, node)
+	}
+
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if err = applyProofResults(bucket, nodes[i], false); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// applyProofResults indexes the proof results of the block by round, and penalizes the nodes
+// which don't answer or answer wrong. It takes them back instead if revert is true.
+func applyProofResults(bucket *bolt.Bucket, node *blockNode, revert bool) (err error) {
+	results, err := readProofResults(bucket.Bucket(metaBlockProofBucket), node)
+
+	if err != nil {
+		return
+	}
+
+	pb := bucket.Bucket(metaProofBucket)
+	nb := bucket.Bucket(metaPenaltyBucket)
+
+	for _, r := range results {
+		key := r.PreviousBlockID[:]
+
+		if revert {
+			err = pb.Delete(key)
+		} else if pb.Get(key) != nil {
+			err = ErrProofExists
+		} else {
+			err = pb.Put(key, node.hash[:])
+		}
+
+		if err != nil {
+			return
+		}
+
+		for _, p := range []struct {
+			nodes   []proto.NodeID
+			penalty uint64
+		}{
+			{r.Missing, MissingAnswerPenalty},
+			{r.Wrong, WrongAnswerPenalty},
+		} {
+			for _, id := range p.nodes {
+				var v [8]byte
+				key := []byte(id)
+
+				if b := nb.Get(key); b != nil {
+					copy(v[:], b)
+				}
+
+				penalty := binary.BigEndian.Uint64(v[:])
+
+				if revert {
+					penalty -= p.penalty
+				} else {
+					penalty += p.penalty
+				}
+
+				if penalty == 0 {
+					err = nb.Delete(key)
+				} else {
+					binary.BigEndian.PutUint64(v[:], penalty)
+					err = nb.Put(key, v[:])
+				}
+
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// GetProofResult returns the result of the storage proof round of the given block recorded by
+// the best chain, which is read from the block recording it.
+func (c *Chain) GetProofResult(id hash.Hash) (r *ProofResult, err error) {
+	var h hash.Hash
+
+	if err = c.db.View(func(tx *bolt.Tx) (err error) {
+		v := tx.Bucket(metaBucket[:]).Bucket(metaProofBucket).Get(id[:])
+
+		if v == nil {
+			return ErrBlockNotFound
+		}
+
+		copy(h[:], v)
+		return
+	}); err != nil {
+		return
+	}
+
+	block, err := c.GetBlock(&h)
+
+	if err != nil {
+		return
+	}
+
+	for _, r = range block.ProofResults {
+		if r.PreviousBlockID == id {
+			return
+		}
+	}
+
+	return nil, ErrBlockNotFound
+}
+
+// Penalty returns the storage proof penalty of the node accumulated by the best chain.
+func (c *Chain) Penalty(id proto.NodeID) (penalty uint64, err error) {
+	err = c.db.View(func(tx *bolt.Tx) (err error) {
+		if nb := tx.Bucket(metaBucket[:]).Bucket(metaPenaltyBucket); nb != nil {
+			if v := nb.Get([]byte(id)); len(v) == 8 {
+				penalty = binary.BigEndian.Uint64(v)
+			}
+		}
+
+		return
+	})
+
+	return
 }
@@ -17,66 +17,280 @@
 package sqlchain
 
 import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"reflect"
-	"strings"
 	"testing"
+	"time"
 
+	"github.com/thunderdb/ThunderDB/crypto/asymmetric"
 	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/crypto/kms"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/pow/cpuminer"
 	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/sqlchain/storage"
 )
 
 var (
-	currentNode   proto.Node
-	voidNode      proto.Node
+	currentNode   proto.NodeID
+	voidNode      proto.NodeID
 	answers       []Answer
 	voidAnswer    []Answer
-	previousBlock StorageProofBlock
-	currentBlock  StorageProofBlock
-	voidBlock     StorageProofBlock
+	previousBlock *Block
+	currentBlock  *Block
+	voidBlock     *Block
+	nodeKeys      map[proto.NodeID]*asymmetric.PrivateKey
+	replicas      = []proto.NodeID{"a", "b", "c", "d", "e"}
 )
 
+func createTestProofDB(t *testing.T, rows int) (db *sql.DB) {
+	fl, err := ioutil.TempFile("", "proof")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+
+	if db, err = sql.Open("sqlite3", fl.Name()); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	for _, stmt := range []string{
+		"CREATE TABLE `a` (`k` INTEGER PRIMARY KEY, `v` TEXT)",
+		"CREATE TABLE `b` (`k` INTEGER PRIMARY KEY, `v` BLOB, `f` REAL)",
+	} {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	for i := 0; i < rows; i++ {
+		if _, err = db.Exec("INSERT INTO `a` VALUES (?, ?)", i, fmt.Sprintf("row%d", i)); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if _, err = db.Exec("INSERT INTO `b` VALUES (?, ?, ?)", i, []byte{byte(i)},
+			float64(i)/2); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	return
+}
+
+func createCorruptedProofDB(t *testing.T) (db *sql.DB) {
+	db = createTestProofDB(t, 10)
+
+	for _, stmt := range []string{
+		"UPDATE `a` SET `v` = 'lost'",
+		"UPDATE `b` SET `f` = -1",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	return
+}
+
+func createSignedAnswer(t *testing.T, id hash.Hash, node proto.NodeID, answer hash.Hash) Answer {
+	a := NewAnswer(id, node, answer)
+
+	if err := a.Sign(nodeKeys[node]); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return *a
+}
+
+func createSignedResult(t *testing.T, id hash.Hash, verifier proto.NodeID, missing,
+	wrong []proto.NodeID) *ProofResult {
+	r := &ProofResult{
+		PreviousBlockID: id,
+		Verifier:        verifier,
+		Missing:         missing,
+		Wrong:           wrong,
+	}
+
+	if err := r.Sign(nodeKeys[verifier]); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	return r
+}
+
+func registerTestReplicas(t *testing.T) {
+	kms.Unittest = true
+	defer func() { kms.Unittest = false }()
+
+	for id, priv := range nodeKeys {
+		if err := kms.SetPublicKey(id, cpuminer.Uint256{}, priv.PubKey()); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+}
+
 func TestNewAnswer(t *testing.T) {
 	wantedAnswer := Answer{
-		PreviousBlockID: "aaa",
+		PreviousBlockID: hash.HashH([]byte("aaa")),
 		NodeID:          "bbb",
 		Answer:          hash.HashH([]byte{1, 2, 3, 4, 5}),
 	}
-	answer := NewAnswer("aaa", "bbb", hash.HashH([]byte{1, 2, 3, 4, 5}))
+	answer := NewAnswer(hash.HashH([]byte("aaa")), "bbb", hash.HashH([]byte{1, 2, 3, 4, 5}))
 
 	if !reflect.DeepEqual(*answer, wantedAnswer) {
 		t.Errorf("The answer is %+v, should be %+v", answer, wantedAnswer)
 	}
 }
 
+func TestAnswerSign(t *testing.T) {
+	id := previousBlock.SignedHeader.BlockHash
+	a := createSignedAnswer(t, id, "a", hash.HashH([]byte{1}))
+
+	if err := a.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Serialization round trip
+	r := &ProofResult{
+		PreviousBlockID: id,
+		Puzzle:          42,
+		Verifier:        "b",
+		Answers:         []Answer{a},
+		Missing:         []proto.NodeID{"c"},
+		Wrong:           []proto.NodeID{"d", "e"},
+	}
+
+	if err := r.Verify(); err != ErrNilValue {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := r.Sign(nodeKeys["b"]); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	b, err := r.marshal()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	rr := &ProofResult{}
+
+	if err = rr.unmarshal(b); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(r, rr) {
+		t.Fatalf("Values don't match: v1 = %+v, v2 = %+v", r, rr)
+	}
+
+	if err = rr.Verify(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Tamper the result
+	rr.Missing = nil
+
+	if err = rr.Verify(); err != ErrSignVerification {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Each node is accounted for at most once, and the verifier is not accounted for
+	for _, c := range []struct {
+		missing, wrong []proto.NodeID
+	}{
+		{[]proto.NodeID{"c", "c"}, nil},
+		{[]proto.NodeID{"c"}, []proto.NodeID{"c"}},
+		{[]proto.NodeID{"a"}, nil},
+		{nil, []proto.NodeID{"b"}},
+	} {
+		rr.Missing, rr.Wrong = c.missing, c.wrong
+
+		if err = rr.Sign(nodeKeys["b"]); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = rr.Verify(); err != ErrInvalidProofResult {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	rr.Missing, rr.Wrong = nil, nil
+
+	rr.Answers[0].PreviousBlockID = hash.HashH([]byte("other"))
+
+	if err = rr.Verify(); err != ErrInvalidAnswers {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Tamper the answer
+	a.Answer = hash.HashH([]byte{2})
+
+	if err = a.Verify(); err != ErrSignVerification {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	a.Signature = nil
+
+	if err = a.Verify(); err != ErrNilValue {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
 func TestGetNextPuzzle(t *testing.T) {
-	var totalRecordsInSQLChain int32 = 10
 	index, err := getNextPuzzle(answers, previousBlock)
 	if err != nil {
 		t.Error(err)
 	}
-	var wantedIndex int32
+	var wantedIndex uint32
 	for _, answer := range answers {
-		wantedIndex += int32(hash.FNVHash32uint(answer.Answer[:]))
+		wantedIndex += hash.FNVHash32uint(answer.Answer[:])
 	}
-	wantedIndex += int32(hash.FNVHash32uint([]byte(previousBlock.ID)))
-	wantedIndex %= totalRecordsInSQLChain
+	wantedIndex += hash.FNVHash32uint(previousBlock.SignedHeader.BlockHash[:])
 	if index != wantedIndex {
 		t.Errorf("The next sql index is %+v, should be %+v. "+
 			"Answers are %+v, and the previous block is %+v",
 			index, wantedIndex, answers, previousBlock)
 	}
 
-	// void answer
+	// void answer, the puzzle is derived from the block only
 	index, err = getNextPuzzle(voidAnswer, previousBlock)
-	if err == nil {
+	if err != nil {
+		t.Error(err)
+	}
+	if index != hash.FNVHash32uint(previousBlock.SignedHeader.BlockHash[:]) {
+		t.Errorf("Unexpected index of void answer: %d", index)
+	}
+
+	// invalid answers
+	index, err = getNextPuzzle(append(answers[:1:1], answers[0]), previousBlock)
+	if err != ErrInvalidAnswers {
 		t.Errorf("Index is %d, but should be failed", index)
 	}
 
 	// void block
 	index, err = getNextPuzzle(answers, voidBlock)
-	if err == nil {
+	if err != ErrInvalidProofBlock {
 		t.Errorf("Index is %d, but should be failed", index)
 	}
+
+	// genesis block has no previous answer
+	genesis, err := createRandomBlock(rootHash, false, withReplicas(replicas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err = getNextPuzzle(voidAnswer, genesis)
+	if err != nil {
+		t.Error(err)
+	}
+	if index != hash.FNVHash32uint(genesis.SignedHeader.BlockHash[:]) {
+		t.Errorf("Unexpected index of genesis block: %d", index)
+	}
 }
 
 func TestGetNextVerifier(t *testing.T) {
@@ -84,27 +298,96 @@ func TestGetNextVerifier(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	wantedVerifier := int32(hash.FNVHash32uint([]byte(previousBlock.ID))) % int32(len(currentBlock.Nodes))
+	wantedVerifier := replicas[hash.FNVHash32uint(previousBlock.SignedHeader.BlockHash[:])%
+		uint32(len(replicas))]
 	if verifier != wantedVerifier {
-		t.Errorf("The next verifier is %d, should be %d", verifier, wantedVerifier)
+		t.Errorf("The next verifier is %s, should be %s", verifier, wantedVerifier)
 	}
 
 	// void previousBlock
 	verifier, err = getNextVerifier(voidBlock, currentBlock)
 	if err == nil {
-		t.Errorf("Verifier is %d, but should be failed", verifier)
+		t.Errorf("Verifier is %s, but should be failed", verifier)
 	}
 
 	// void currentBlock
 	verifier, err = getNextVerifier(previousBlock, voidBlock)
 	if err == nil {
-		t.Errorf("Verifier is %d, but should be failed", verifier)
+		t.Errorf("Verifier is %s, but should be failed", verifier)
+	}
+
+	// blocks are not linked
+	verifier, err = getNextVerifier(currentBlock, previousBlock)
+	if err != ErrInvalidProofBlock {
+		t.Errorf("Verifier is %s, but should be failed", verifier)
 	}
 }
 
 func TestSelectRecord(t *testing.T) {
-	if strings.Compare(selectRecord(0), "hello world") != 0 {
-		t.Errorf("It should be hello world")
+	// Empty database
+	db := createTestProofDB(t, 0)
+	defer db.Close()
+	record, err := selectRecord(db, 7)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(record) != 0 {
+		t.Fatalf("Unexpected record: %v", record)
+	}
+
+	// Replicas with the same data select the same record
+	db1 := createTestProofDB(t, 10)
+	defer db1.Close()
+	db2 := createTestProofDB(t, 10)
+	defer db2.Close()
+	seen := make(map[string]bool)
+
+	for n := uint32(0); n < 40; n++ {
+		r1, err := selectRecord(db1, n)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		r2, err := selectRecord(db2, n)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if !reflect.DeepEqual(r1, r2) {
+			t.Fatalf("Values don't match: v1 = %v, v2 = %v", r1, r2)
+		}
+
+		seen[string(r1)] = true
+	}
+
+	// 2 tables * 10 rows
+	if len(seen) != 20 {
+		t.Fatalf("Unexpected record count: %d", len(seen))
+	}
+
+	// A modified row changes the record
+	r1, err := selectRecord(db1, 3)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = db2.Exec("UPDATE `a` SET `v` = 'changed' WHERE `k` = 3"); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	r2, err := selectRecord(db2, 3)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if reflect.DeepEqual(r1, r2) {
+		t.Fatal("Record should be changed")
 	}
 }
 
@@ -112,80 +395,733 @@ func TestCheckValid(t *testing.T) {
 	if !CheckValid(answers) {
 		t.Errorf("It should be true")
 	}
+
+	if CheckValid(voidAnswer) {
+		t.Errorf("It should be false")
+	}
+
+	// Duplicated node
+	if CheckValid(append(answers[:1:1], answers[0])) {
+		t.Errorf("It should be false")
+	}
+
+	// Different block
+	other := createSignedAnswer(t, currentBlock.SignedHeader.BlockHash, "e", hash.HashH([]byte{5}))
+
+	if CheckValid(append(answers[:1:1], other)) {
+		t.Errorf("It should be false")
+	}
+
+	// Unsigned answer
+	if CheckValid([]Answer{*NewAnswer(previousBlock.SignedHeader.BlockHash, "a",
+		hash.HashH([]byte{1}))}) {
+		t.Errorf("It should be false")
+	}
 }
 
 func TestGenerateAnswer(t *testing.T) {
-	answer, err := GenerateAnswer(answers, previousBlock, currentNode)
+	db := createTestProofDB(t, 10)
+	defer db.Close()
+	answer, err := GenerateAnswer(db, answers, previousBlock, currentBlock, currentNode,
+		nodeKeys[currentNode])
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	sqlIndex, err := getNextPuzzle(answers, previousBlock)
 	if err != nil {
 		t.Error(err)
 	}
-	record := []byte(selectRecord(sqlIndex))
-	answerHash := hash.HashH(append(record, []byte(currentNode.ID)...))
-	wantedAnswer := NewAnswer(previousBlock.ID, currentNode.ID, answerHash)
-	if !reflect.DeepEqual(*answer, *wantedAnswer) {
-		t.Errorf("Answer is %s, should be %s", *answer, *wantedAnswer)
+	record, err := selectRecord(db, sqlIndex)
+	if err != nil {
+		t.Error(err)
+	}
+	answerHash := hash.HashH(append(record, []byte(currentNode)...))
+	if answer.Answer != answerHash || answer.NodeID != currentNode ||
+		answer.PreviousBlockID != previousBlock.SignedHeader.BlockHash {
+		t.Errorf("Answer is %+v, should be %s", *answer, answerHash)
+	}
+	if err = answer.Verify(); err != nil {
+		t.Error(err)
 	}
 
 	// void answers
-	answer, err = GenerateAnswer(voidAnswer, previousBlock, currentNode)
-	if err == nil {
-		t.Errorf("Answer is %+v, should be failed", answer)
+	answer, err = GenerateAnswer(db, voidAnswer, previousBlock, currentBlock, currentNode,
+		nodeKeys[currentNode])
+	if err != nil {
+		t.Error(err)
+	}
+	if record, err = selectRecord(db,
+		hash.FNVHash32uint(previousBlock.SignedHeader.BlockHash[:])); err != nil {
+		t.Error(err)
+	}
+	if answerHash = computeAnswer(record, currentNode); answer.Answer != answerHash {
+		t.Errorf("Answer is %+v, should be %s", *answer, answerHash)
 	}
 
 	// void block
-	answer, err = GenerateAnswer(answers, voidBlock, currentNode)
+	answer, err = GenerateAnswer(db, answers, voidBlock, currentBlock, currentNode,
+		nodeKeys[currentNode])
 	if err == nil {
 		t.Errorf("Answer is %+v, should be failed", answer)
 	}
 
 	// void node
-	answer, err = GenerateAnswer(answers, previousBlock, voidNode)
-	if err == nil {
+	answer, err = GenerateAnswer(db, answers, previousBlock, currentBlock, voidNode,
+		nodeKeys[currentNode])
+	if err != ErrInvalidProofNode {
 		t.Errorf("Answer is %+v, should be failed", answer)
 	}
+}
+
+func TestVerifyAnswers(t *testing.T) {
+	registerTestReplicas(t)
+	verifier, err := getNextVerifier(previousBlock, currentBlock)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	var nonVerifier proto.NodeID
+
+	for _, id := range replicas {
+		if id != verifier {
+			nonVerifier = id
+			break
+		}
+	}
+
+	// All replicas store the same data except the one which answers wrong
+	db := createTestProofDB(t, 10)
+	defer db.Close()
+	bad := createCorruptedProofDB(t)
+	defer bad.Close()
+	var submitted []Answer
+	var missing, wrong proto.NodeID
+
+	for _, id := range replicas {
+		var src *sql.DB
 
+		switch {
+		case id == verifier:
+			continue
+		case missing == "":
+			missing = id
+			continue
+		case wrong == "":
+			wrong = id
+			src = bad
+		default:
+			src = db
+		}
+
+		a, err := GenerateAnswer(src, answers, previousBlock, currentBlock, id, nodeKeys[id])
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		submitted = append(submitted, *a)
+	}
+
+	// An answer signed by another key is wrong even if the answer itself is right
+	forged := submitted[len(submitted)-1]
+
+	if err = forged.Sign(nodeKeys[verifier]); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	result, err := VerifyAnswers(db, answers, previousBlock, currentBlock, verifier,
+		append(submitted[:len(submitted)-1:len(submitted)-1], forged))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(result.Wrong, []proto.NodeID{wrong, forged.NodeID}) {
+		t.Fatalf("Unexpected wrong nodes: %v", result.Wrong)
+	}
+
+	result, err = VerifyAnswers(db, answers, previousBlock, currentBlock, verifier, submitted)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(result.Missing, []proto.NodeID{missing}) {
+		t.Fatalf("Unexpected missing nodes: %v", result.Missing)
+	}
+
+	if !reflect.DeepEqual(result.Wrong, []proto.NodeID{wrong}) {
+		t.Fatalf("Unexpected wrong nodes: %v", result.Wrong)
+	}
+
+	if len(result.Answers) != len(replicas)-3 {
+		t.Fatalf("Unexpected answer count: %d", len(result.Answers))
+	}
+
+	// Only the verifier of the round can verify
+	if _, err = VerifyAnswers(db, answers, previousBlock, currentBlock, nonVerifier,
+		submitted); err != ErrNotVerifier {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 }
 
-func init() {
-	currentNode = proto.Node{ID: "123456"}
-	currentBlock = StorageProofBlock{
-		ID:    "def",
-		Nodes: []proto.Node{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}, {ID: "e"}},
+func TestChainStorageProof(t *testing.T) {
+	registerTestReplicas(t)
+	genesis, err := createRandomBlock(rootHash, true, withReplicas(replicas))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
 	}
-	previousBlock = StorageProofBlock{
-		ID:    "abc",
-		Nodes: []proto.Node{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}},
+
+	chain := createTestChain(t, genesis)
+	db := createTestProofDB(t, 10)
+	defer db.Close()
+	bad := createCorruptedProofDB(t)
+	defer bad.Close()
+
+	// The round of the genesis block
+	if _, err = chain.NextVerifier(&genesis.SignedHeader.BlockHash); err != ErrBlockNotFound {
+		t.Fatalf("Unexpected error: %v", err)
 	}
-	voidBlock = StorageProofBlock{
-		ID:    "",
-		Nodes: nil,
+
+	parentBlock := genesis
+	var previous []Answer
+	var results ProofResults
+
+	for i := 0; i < 3; i++ {
+		// The result of the previous round is recorded in the next block
+		b, err := createRandomBlock(parentBlock.SignedHeader.BlockHash, false,
+			withReplicas(replicas), withProofResults(results))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if len(results) > 0 {
+			stored, err := chain.GetProofResult(parentBlock.SignedHeader.ParentHash)
+
+			if err != nil {
+				t.Fatalf("Error occurred: %v", err)
+			}
+
+			if !reflect.DeepEqual(results[0], stored) {
+				t.Fatalf("Values don't match: v1 = %+v, v2 = %+v", results[0], stored)
+			}
+		}
+
+		h := &b.SignedHeader.BlockHash
+		verifier, err := chain.NextVerifier(h)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		puzzle, err := chain.NextPuzzle(h)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		expected, err := getNextPuzzle(previous, parentBlock)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if puzzle != expected {
+			t.Fatalf("Unexpected puzzle: %d, should be %d", puzzle, expected)
+		}
+
+		// The first non-verifier replica answers with the wrong data
+		var submitted []Answer
+		var wrong proto.NodeID
+
+		for _, id := range replicas {
+			if id == verifier {
+				continue
+			}
+
+			src := db
+
+			if wrong == "" {
+				wrong, src = id, bad
+			}
+
+			a, err := chain.GenerateAnswer(src, h, id, nodeKeys[id])
+
+			if err != nil {
+				t.Fatalf("Error occurred: %v", err)
+			}
+
+			submitted = append(submitted, *a)
+		}
+
+		result, err := chain.VerifyAnswers(db, h, verifier, submitted)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if !reflect.DeepEqual(result.Wrong, []proto.NodeID{wrong}) {
+			t.Fatalf("Unexpected wrong nodes: %v", result.Wrong)
+		}
+
+		if err = result.Sign(nodeKeys[verifier]); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		results = ProofResults{result}
+		previous = result.Answers
+		parentBlock = b
 	}
-	answers = []Answer{
+
+	// The round of the head block is not recorded yet
+	round := parentBlock.SignedHeader.ParentHash
+
+	if _, err = chain.GetProofResult(round); err != ErrBlockNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Invalid results are rejected
+	result := results[0]
+	verifier := result.Verifier
+	var other proto.NodeID
+
+	for _, id := range replicas {
+		if id != verifier {
+			other = id
+			break
+		}
+	}
+
+	for _, c := range []struct {
+		fn  func(r *ProofResult) error
+		err error
+	}{
+		{
+			fn: func(r *ProofResult) error {
+				return r.Sign(nodeKeys[other])
+			},
+			err: ErrInvalidProofNode,
+		},
+		{
+			fn: func(r *ProofResult) error {
+				// The other replica answered wrong in the round, swap it with the verifier
+				r.Verifier, r.Wrong = other, []proto.NodeID{verifier}
+				return r.Sign(nodeKeys[other])
+			},
+			err: ErrNotVerifier,
+		},
 		{
-			PreviousBlockID: previousBlock.ID,
-			NodeID:          currentNode.ID,
-			Answer:          hash.HashH([]byte{1}),
+			fn: func(r *ProofResult) error {
+				r.PreviousBlockID = parentBlock.SignedHeader.BlockHash
+				r.Answers = nil
+				return r.Sign(nodeKeys[verifier])
+			},
+			err: ErrInvalidProofBlock,
 		},
 		{
-			PreviousBlockID: previousBlock.ID,
-			NodeID:          currentNode.ID,
-			Answer:          hash.HashH([]byte{2}),
+			fn: func(r *ProofResult) error {
+				r.Wrong = nil
+				return nil
+			},
+			err: ErrSignVerification,
 		},
 		{
-			PreviousBlockID: previousBlock.ID,
-			NodeID:          currentNode.ID,
-			Answer:          hash.HashH([]byte{3}),
+			fn: func(r *ProofResult) error {
+				r.Wrong = nil
+				return r.Sign(nodeKeys[verifier])
+			},
+			err: ErrInvalidProofResult,
 		},
 		{
-			PreviousBlockID: previousBlock.ID,
-			NodeID:          currentNode.ID,
-			Answer:          hash.HashH([]byte{4}),
+			fn: func(r *ProofResult) error {
+				r.Missing = append(r.Missing, "x")
+				return r.Sign(nodeKeys[verifier])
+			},
+			err: ErrInvalidProofResult,
 		},
+		{
+			fn: func(r *ProofResult) error {
+				r.Missing = append(r.Missing, r.Wrong...)
+				return r.Sign(nodeKeys[verifier])
+			},
+			err: ErrInvalidProofResult,
+		},
+	} {
+		r := &ProofResult{}
+		buffer, err := result.marshal()
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = r.unmarshal(buffer); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = c.fn(r); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		b, err := createRandomBlock(parentBlock.SignedHeader.BlockHash, false,
+			withReplicas(replicas), withProofResults(ProofResults{r}))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(b); err != c.err {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// The results must match the proof root of the block
+	block, err := createRandomBlock(parentBlock.SignedHeader.BlockHash, false,
+		withReplicas(replicas))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	block.ProofResults = results
+
+	if err = chain.PushBlock(block); err != ErrProofRootVerification {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Record the result of the last round by the producer
+	fl, err := ioutil.TempFile("", "kayak")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	fl.Close()
+	defer os.Remove(fl.Name())
+	store, err := kayak.NewBoltStore(fl.Name())
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	defer store.Close()
+	producer, err := NewProducer(&ProducerConfig{
+		Chain:       chain,
+		LogStore:    store,
+		StableStore: store,
+		LogCodec:    &storage.ExecLogCodec{},
+		Leadership:  &testLeadership{leader: true},
+		Period:      time.Second,
+	})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = producer.AddProofResult(&ProofResult{PreviousBlockID: round}); err != ErrNilValue {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = producer.AddProofResult(result); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = producer.AddProofResult(result); err != ErrProofExists {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if block, err = producer.ProduceBlock(); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block == nil || !reflect.DeepEqual(block.ProofResults, results) {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+
+	if err = producer.AddProofResult(result); err != ErrProofExists {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if stored, err := chain.GetProofResult(round); err != nil ||
+		!reflect.DeepEqual(result, stored) {
+		t.Fatalf("Unexpected result: %+v, err = %v", stored, err)
+	}
+
+	// The results are stored and serialized along with the block
+	stored, err := chain.GetBlock(&block.SignedHeader.BlockHash)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	buffer, err := stored.marshal()
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	decoded := &Block{}
+
+	if err = decoded.unmarshal(buffer); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded.ProofResults, results) {
+		t.Fatalf("Values don't match: v1 = %+v, v2 = %+v", decoded.ProofResults, results)
+	}
+
+	// A round is recorded only once by a branch, but a side branch may record it as well
+	sibling, err := createRandomBlock(parentBlock.SignedHeader.BlockHash, false,
+		withReplicas(replicas), withProofResults(results))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(sibling); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if block, err = createRandomBlock(block.SignedHeader.BlockHash, false,
+		withReplicas(replicas), withProofResults(results)); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(block); err != ErrProofExists {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Penalties are accumulated over the rounds
+	var total uint64
+
+	for _, id := range replicas {
+		penalty, err := chain.Penalty(id)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		total += penalty
+	}
+
+	if total != 3*WrongAnswerPenalty {
+		t.Fatalf("Unexpected total penalty: %d", total)
+	}
+
+	// A node which is not in the replica set can't answer
+	if _, err = chain.GenerateAnswer(db, &parentBlock.SignedHeader.BlockHash, "x",
+		nodeKeys[currentNode]); err != ErrInvalidProofNode {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestChainStorageProofFork(t *testing.T) {
+	registerTestReplicas(t)
+	genesis, err := createRandomBlock(rootHash, true, withReplicas(replicas))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain := createTestChain(t, genesis)
+	b1, err := createRandomBlock(genesis.SignedHeader.BlockHash, false, withReplicas(replicas))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if err = chain.PushBlock(b1); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	// Both branches record the round of b1 with different results
+	round := genesis.SignedHeader.BlockHash
+	verifier, err := chain.NextVerifier(&b1.SignedHeader.BlockHash)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	var others []proto.NodeID
+
+	for _, id := range replicas {
+		if id != verifier {
+			others = append(others, id)
+		}
+	}
+
+	mainResult := createSignedResult(t, round, verifier, others, nil)
+	sideResult := createSignedResult(t, round, verifier, nil, others)
+	extend := func(parent *Block, n int, results ProofResults) (tip *Block) {
+		tip = parent
+
+		for i := 0; i < n; i++ {
+			b, err := createRandomBlock(tip.SignedHeader.BlockHash, false,
+				withReplicas(replicas), withProofResults(results))
+
+			if err != nil {
+				t.Fatalf("Error occurred: %v", err)
+			}
+
+			if err = chain.PushBlock(b); err != nil {
+				t.Fatalf("Error occurred: %v", err)
+			}
+
+			tip, results = b, nil
+		}
+
+		return
+	}
+	check := func(tip *Block, expected *ProofResult, penalty uint64) {
+		if head := chain.Head(); head.Head != tip.SignedHeader.BlockHash {
+			t.Fatalf("Unexpected head: %s", head.Head)
+		}
+
+		if stored, err := chain.GetProofResult(round); err != nil ||
+			!reflect.DeepEqual(expected, stored) {
+			t.Fatalf("Unexpected result: %+v, err = %v", stored, err)
+		}
+
+		for _, id := range others {
+			if v, err := chain.Penalty(id); err != nil || v != penalty {
+				t.Fatalf("Unexpected penalty of node %s: %d, err = %v", id, v, err)
+			}
+		}
+
+		if v, err := chain.Penalty(verifier); err != nil || v != 0 {
+			t.Fatalf("Unexpected penalty of verifier: %d, err = %v", v, err)
+		}
+	}
+
+	mainTip := extend(b1, 2, ProofResults{mainResult})
+	check(mainTip, mainResult, MissingAnswerPenalty)
+
+	// The side branch takes over the penalties once it becomes the best chain
+	sideTip := extend(b1, 3, ProofResults{sideResult})
+	check(sideTip, sideResult, WrongAnswerPenalty)
+
+	// And gives them back after the main branch catches up
+	mainTip = extend(mainTip, 2, nil)
+	check(mainTip, mainResult, MissingAnswerPenalty)
+
+	// Reload the chain and check the derived state of the best chain
+	chain.db.Close()
+
+	if chain, err = LoadChain(&Config{DataDir: chain.cfg.DataDir}); err != nil {
+		t.Fatalf("Error occurred: %v", err)
 	}
+
+	check(mainTip, mainResult, MissingAnswerPenalty)
+}
+
+func TestSingleReplicaStorageProof(t *testing.T) {
+	registerTestReplicas(t)
+	single := []proto.NodeID{"a"}
+	genesis, err := createRandomBlock(rootHash, true, withReplicas(single))
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain := createTestChain(t, genesis)
+	db := createTestProofDB(t, 10)
+	defer db.Close()
+	parent := genesis
+	var results ProofResults
+
+	// The only replica verifies every round, so no round has any answer, and the puzzles are
+	// derived from the blocks only
+	for i := 0; i < 4; i++ {
+		b, err := createRandomBlock(parent.SignedHeader.BlockHash, false, withReplicas(single),
+			withProofResults(results))
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		h := &b.SignedHeader.BlockHash
+
+		if verifier, err := chain.NextVerifier(h); err != nil || verifier != "a" {
+			t.Fatalf("Unexpected verifier: %s, err = %v", verifier, err)
+		}
+
+		puzzle, err := chain.NextPuzzle(h)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if puzzle != hash.FNVHash32uint(parent.SignedHeader.BlockHash[:]) {
+			t.Fatalf("Unexpected puzzle: %d", puzzle)
+		}
+
+		result, err := chain.VerifyAnswers(db, h, "a", nil)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = result.Sign(nodeKeys["a"]); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		// Skip a round, the next puzzle is derived from the block as well
+		if results = (ProofResults{result}); i == 1 {
+			results = nil
+		}
+
+		parent = b
+	}
+
+	if penalty, err := chain.Penalty("a"); err != nil || penalty != 0 {
+		t.Fatalf("Unexpected penalty: %d, err = %v", penalty, err)
+	}
+}
+
+func init() {
+	var err error
+	currentNode = "e"
+	nodeKeys = make(map[proto.NodeID]*asymmetric.PrivateKey)
+
+	for _, id := range replicas {
+		if nodeKeys[id], _, err = asymmetric.GenSecp256k1KeyPair(); err != nil {
+			panic(err)
+		}
+	}
+
+	if previousBlock, err = createRandomBlock(hash.HashH([]byte("parent")), false,
+		withReplicas(replicas[:4])); err != nil {
+		panic(err)
+	}
+
+	if currentBlock, err = createRandomBlock(previousBlock.SignedHeader.BlockHash, false,
+		withReplicas(replicas)); err != nil {
+		panic(err)
+	}
+
+	voidBlock = &Block{}
+	answers = nil
+
+	for i, id := range replicas[:4] {
+		a := NewAnswer(previousBlock.SignedHeader.BlockHash, id, hash.HashH([]byte{byte(i + 1)}))
+
+		if err = a.Sign(nodeKeys[id]); err != nil {
+			panic(err)
+		}
+
+		answers = append(answers, *a)
+	}
+
 	voidAnswer = nil
-	voidNode = proto.Node{}
+	voidNode = ""
 }
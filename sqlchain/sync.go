@@ -0,0 +1,236 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/proto"
+)
+
+const (
+	// locatorDenseBlocks is the number of the latest blocks which are all included in a block
+	// locator, the step doubles for each of the following blocks.
+	locatorDenseBlocks = 10
+)
+
+// blockLocator returns the hashes of the best chain from the tip back to the genesis block, the
+// latest blocks are included densely and the older ones exponentially sparsely, so that the peer
+// can find the fork point with O(log(n)) hashes.
+func (c *Chain) blockLocator() (locator []hash.Hash) {
+	node := c.Head().node
+	step := int32(1)
+
+	for node != nil {
+		locator = append(locator, node.hash)
+
+		if node.height == 0 {
+			break
+		}
+
+		if len(locator) >= locatorDenseBlocks {
+			step *= 2
+		}
+
+		height := node.height - step
+
+		if height < 0 {
+			height = 0
+		}
+
+		node = node.ancestor(height)
+	}
+
+	return
+}
+
+// locateHeaders returns at most max headers of the best chain following the first block in the
+// locator which is also on the best chain, or following the genesis block if there is none.
+func (c *Chain) locateHeaders(locator []hash.Hash, max int32) (headers []*SignedHeader,
+	err error) {
+	head := c.Head()
+	from := int32(0)
+
+	for i := range locator {
+		if node := c.index.LookupNode(&locator[i]); node != nil &&
+			head.node.ancestor(node.height) == node {
+			from = node.height + 1
+			break
+		}
+	}
+
+	to := from + max - 1
+
+	if to > head.Height {
+		to = head.Height
+	}
+
+	if from > to {
+		return
+	}
+
+	return c.GetHeaders(from, to)
+}
+
+// Syncer fetches the missing blocks of a chain from the other replicas.
+type Syncer struct {
+	chain      *Chain
+	databaseID string
+	caller     Caller
+}
+
+// NewSyncer returns a new Syncer of the chain, the rpc package is used if caller is nil.
+func NewSyncer(chain *Chain, databaseID string, caller Caller) *Syncer {
+	if caller == nil {
+		caller = &RPCCaller{}
+	}
+
+	return &Syncer{
+		chain:      chain,
+		databaseID: databaseID,
+		caller:     caller,
+	}
+}
+
+// Sync fetches the best chain of the peer, headers are fetched and verified first and then the
+// missing blocks are fetched in batches and pushed to the local chain. It returns the number of
+// pushed blocks.
+func (s *Syncer) Sync(peer proto.NodeID) (count int, err error) {
+	locator := s.chain.blockLocator()
+
+	for {
+		var headers []*SignedHeader
+
+		if headers, err = s.fetchHeaders(peer, locator); err != nil {
+			return
+		}
+
+		// Fetch the missing blocks
+		var missing []hash.Hash
+
+		for _, h := range headers {
+			if !s.chain.index.HasBlock(&h.BlockHash) {
+				missing = append(missing, h.BlockHash)
+			}
+		}
+
+		for len(missing) > 0 {
+			n := len(missing)
+
+			if n > MaxFetchBlocks {
+				n = MaxFetchBlocks
+			}
+
+			var pushed int
+			pushed, err = s.fetchBlocks(peer, missing[:n])
+			count += pushed
+
+			if err != nil {
+				return
+			}
+
+			missing = missing[n:]
+		}
+
+		if len(headers) < MaxFetchHeaders {
+			return
+		}
+
+		// Continue with the last fetched header
+		locator = append([]hash.Hash{headers[len(headers)-1].BlockHash}, locator...)
+	}
+}
+
+// fetchHeaders fetches the headers following the locator from the peer and verifies them.
+func (s *Syncer) fetchHeaders(peer proto.NodeID, locator []hash.Hash) (
+	headers []*SignedHeader, err error) {
+	resp := &FetchHeadersResp{}
+
+	if err = s.caller.CallNode(peer, ChainRPCServiceName+".FetchHeadersByLocator",
+		&FetchHeadersByLocatorReq{
+			DatabaseID: s.databaseID,
+			Locator:    locator,
+		}, resp); err != nil {
+		return
+	}
+
+	headers = make([]*SignedHeader, len(resp.Headers))
+
+	for i := range resp.Headers {
+		headers[i] = &SignedHeader{}
+
+		if err = headers[i].unmarshal(resp.Headers[i]); err != nil {
+			return nil, err
+		}
+
+		if err = headers[i].Verify(); err != nil {
+			return nil, err
+		}
+
+		// Headers must be continuous and connect to the local chain
+		if i == 0 {
+			if !s.chain.index.HasBlock(&headers[i].ParentHash) {
+				return nil, ErrParentNotFound
+			}
+		} else if headers[i].ParentHash != headers[i-1].BlockHash {
+			return nil, ErrInvalidBlock
+		}
+	}
+
+	return
+}
+
+// fetchBlocks fetches the blocks of the given hashes from the peer and pushes them to the local
+// chain.
+func (s *Syncer) fetchBlocks(peer proto.NodeID, hashes []hash.Hash) (count int, err error) {
+	resp := &FetchBlocksResp{}
+
+	if err = s.caller.CallNode(peer, ChainRPCServiceName+".FetchBlocksByHash",
+		&FetchBlocksByHashReq{
+			DatabaseID: s.databaseID,
+			Hashes:     hashes,
+		}, resp); err != nil {
+		return
+	}
+
+	if len(resp.Blocks) != len(hashes) {
+		return 0, ErrBlockNotFound
+	}
+
+	for i := range resp.Blocks {
+		block := &Block{}
+
+		if err = block.unmarshal(resp.Blocks[i]); err != nil {
+			return
+		}
+
+		if block.SignedHeader.BlockHash != hashes[i] {
+			return count, ErrHashVerification
+		}
+
+		if err = s.chain.PushBlock(block); err == ErrBlockExists {
+			// Pushed by the producer of the peer meanwhile
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+
+		count++
+	}
+
+	return
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the “License”);
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an “AS IS” BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"net"
+	"testing"
+
+	"github.com/thunderdb/ThunderDB/crypto/hash"
+	"github.com/thunderdb/ThunderDB/kayak"
+	"github.com/thunderdb/ThunderDB/proto"
+	"github.com/thunderdb/ThunderDB/rpc"
+)
+
+// addrCaller calls the nodes by their plain tcp addresses.
+type addrCaller map[proto.NodeID]string
+
+func (c addrCaller) CallNode(nodeID proto.NodeID, method string, args, reply interface{}) (
+	err error) {
+	client, err := rpc.InitClient(c[nodeID])
+
+	if err != nil {
+		return
+	}
+
+	defer client.Close()
+	return client.Call(method, args, reply)
+}
+
+func startTestServer(t *testing.T, service *ChainRPCService) (addr string) {
+	server, err := rpc.NewServerWithService(rpc.ServiceMap{ChainRPCServiceName: service})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	server.SetListener(l)
+	go server.Serve()
+	return l.Addr().String()
+}
+
+func extendTestChain(t *testing.T, chain *Chain, parent hash.Hash, n int) (blocks Blocks) {
+	for i := 0; i < n; i++ {
+		b, err := createRandomBlock(parent, false)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		if err = chain.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+
+		blocks = append(blocks, b)
+		parent = b.SignedHeader.BlockHash
+	}
+
+	return
+}
+
+func TestBlockLocator(t *testing.T) {
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	chain := createTestChain(t, genesis)
+	blocks := append(Blocks{genesis}, extendTestChain(t, chain, genesis.SignedHeader.BlockHash,
+		100)...)
+	locator := chain.blockLocator()
+
+	if locator[0] != blocks[100].SignedHeader.BlockHash ||
+		locator[len(locator)-1] != genesis.SignedHeader.BlockHash {
+		t.Fatalf("Unexpected locator: %v", locator)
+	}
+
+	for i := 0; i < locatorDenseBlocks; i++ {
+		if locator[i] != blocks[100-i].SignedHeader.BlockHash {
+			t.Fatalf("Unexpected locator: %v", locator)
+		}
+	}
+
+	if len(locator) > locatorDenseBlocks+8 {
+		t.Fatalf("Unexpected locator length: %d", len(locator))
+	}
+
+	// Locate headers following a side branch
+	side := extendTestChain(t, chain, blocks[50].SignedHeader.BlockHash, 1)
+	headers, err := chain.locateHeaders([]hash.Hash{
+		side[0].SignedHeader.BlockHash,
+		blocks[50].SignedHeader.BlockHash,
+	}, 10)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(headers) != 10 || headers[0].BlockHash != blocks[51].SignedHeader.BlockHash {
+		t.Fatalf("Unexpected headers: %v", headers)
+	}
+
+	if headers, err = chain.locateHeaders([]hash.Hash{
+		blocks[100].SignedHeader.BlockHash}, 10); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if len(headers) != 0 {
+		t.Fatalf("Unexpected headers: %v", headers)
+	}
+}
+
+func TestSync(t *testing.T) {
+	genesis, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	source := createTestChain(t, genesis)
+	replica := createTestChain(t, genesis)
+	service := NewChainRPCService()
+	service.AddChain("db", source, nil)
+	caller := addrCaller{"source": startTestServer(t, service)}
+
+	// The replica shares the first 10 blocks and has a shorter side branch
+	blocks := extendTestChain(t, source, genesis.SignedHeader.BlockHash, 10)
+
+	for _, b := range blocks {
+		if err = replica.PushBlock(b); err != nil {
+			t.Fatalf("Error occurred: %v", err)
+		}
+	}
+
+	extendTestChain(t, replica, blocks[9].SignedHeader.BlockHash, 5)
+	blocks = append(blocks, extendTestChain(t, source, blocks[9].SignedHeader.BlockHash,
+		MaxFetchBlocks+50)...)
+	syncer := NewSyncer(replica, "db", caller)
+	count, err := syncer.Sync("source")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if count != MaxFetchBlocks+50 {
+		t.Fatalf("Unexpected synced block count: %d", count)
+	}
+
+	if h1, h2 := source.Head(), replica.Head(); h1.Head != h2.Head || h1.Height != h2.Height {
+		t.Fatalf("Values don't match: v1 = %s@%d, v2 = %s@%d", h1.Head, h1.Height, h2.Head,
+			h2.Height)
+	}
+
+	// Nothing to sync
+	if count, err = syncer.Sync("source"); err != nil || count != 0 {
+		t.Fatalf("Unexpected result: count = %d, err = %v", count, err)
+	}
+
+	// Unknown database
+	if _, err = NewSyncer(replica, "xxx", caller).Sync("source"); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	// Chain of another genesis block
+	other, err := createRandomBlock(rootHash, true)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if _, err = NewSyncer(createTestChain(t, other), "db", caller).Sync("source"); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	// Advise new block through rpc
+	broadcaster := &RPCBroadcaster{
+		DatabaseID: "db",
+		LocalID:    "replica",
+		Peers: &kayak.Peers{
+			Servers: []*kayak.Server{{ID: "replica"}, {ID: "source"}},
+		},
+		Caller: caller,
+	}
+	block := extendTestChain(t, replica, replica.Head().Head, 1)[0]
+
+	// Not produced by a peer of the database
+	if err = broadcaster.BroadcastBlock(block); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+
+	service.SetPeers("db", &kayak.Peers{
+		Servers: []*kayak.Server{{
+			ID:     block.SignedHeader.Producer,
+			PubKey: block.SignedHeader.Signee,
+		}},
+	})
+
+	if err = broadcaster.BroadcastBlock(block); err != nil {
+		t.Fatalf("Error occurred: %v", err)
+	}
+
+	if head := source.Head(); head.Head != block.SignedHeader.BlockHash {
+		t.Fatalf("Unexpected head: %s", head.Head)
+	}
+
+	if err = broadcaster.BroadcastBlock(block); err != nil {
+		t.Logf("Error occurred as expected: %v", err)
+	} else {
+		t.Fatal("Unexpected result: returned nil while expecting an error")
+	}
+}
@@ -17,6 +17,7 @@
 package sqlchain
 
 import (
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -48,11 +49,91 @@ func testSetup() {
 	f.Close()
 	kms.InitPublicKeyStore(f.Name(), nil)
 
+	// Setup local key pair for block producing
+	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		panic(err)
+	}
+
+	nodeID := hash.THashH(pub.Serialize())
+	kms.InitLocalKeyStore()
+	kms.SetLocalKeyPair(priv, pub)
+	kms.SetLocalNodeIDNonce(nodeID[:], &cpuminer.Uint256{})
+
 	log.SetOutput(os.Stdout)
 	log.SetLevel(log.DebugLevel)
 }
 
-func createRandomBlock(parent hash.Hash, isGenesis bool) (b *Block, err error) {
+func createRandomQuery() (q *Query) {
+	q = &Query{
+		Type:      WriteQuery,
+		Statement: "INSERT INTO `t` VALUES (?, ?)",
+		Args:      []string{fmt.Sprintf("%d", rand.Int()), fmt.Sprintf("%d", rand.Int())},
+		Timestamp: time.Now().UTC(),
+	}
+
+	rand.Read(q.TxnID[:])
+	return
+}
+
+func createRandomSignedQuery() (q *Query, err error) {
+	q = createRandomQuery()
+	client, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		return
+	}
+
+	miner, _, err := asymmetric.GenSecp256k1KeyPair()
+
+	if err != nil {
+		return
+	}
+
+	if err = q.Sign(client); err != nil {
+		return
+	}
+
+	result := make([]byte, 64)
+	rand.Read(result)
+	_, err = q.Respond(result, miner)
+	return
+}
+
+// blockOption sets a field of the block created by createRandomBlock before it's signed.
+type blockOption func(b *Block)
+
+// withQueries replaces the random queries of the block.
+func withQueries(queries Queries) blockOption {
+	return func(b *Block) {
+		b.Queries = queries
+	}
+}
+
+// withLogIndex sets the index of the last kayak log packed into the chain up to the block.
+func withLogIndex(index uint64) blockOption {
+	return func(b *Block) {
+		b.SignedHeader.LogIndex = index
+	}
+}
+
+// withProofResults records the storage proof results in the block.
+func withProofResults(results ProofResults) blockOption {
+	return func(b *Block) {
+		b.ProofResults = results
+	}
+}
+
+// withReplicas sets the replica set of the database recorded in the block.
+func withReplicas(replicas []proto.NodeID) blockOption {
+	return func(b *Block) {
+		b.SignedHeader.Replicas = replicas
+	}
+}
+
+func createRandomBlock(parent hash.Hash, isGenesis bool, opts ...blockOption) (
+	b *Block, err error) {
 	// Generate key pair
 	priv, pub, err := asymmetric.GenSecp256k1KeyPair()
 
@@ -79,13 +160,17 @@ func createRandomBlock(parent hash.Hash, isGenesis bool) (b *Block, err error) {
 
 	b.SignedHeader.Header.Producer = proto.NodeID(h.String())
 
-	for i := 0; i < len(b.Queries); i++ {
-		b.Queries[i] = new(Query)
-		rand.Read(b.Queries[i].TxnID[:])
+	for i := range b.Queries {
+		if i%2 == 0 {
+			b.Queries[i] = createRandomQuery()
+		} else if b.Queries[i], err = createRandomSignedQuery(); err != nil {
+			return
+		}
 	}
 
-	// TODO(leventeliu): use merkle package to generate this field from queries.
-	rand.Read(b.SignedHeader.Header.MerkleRoot[:])
+	for _, opt := range opts {
+		opt(b)
+	}
 
 	if isGenesis {
 		// Compute nonce with public key
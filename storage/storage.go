@@ -29,6 +29,7 @@
 package storage
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
 	"sync"
@@ -70,6 +71,14 @@ type Storage struct {
 	dsn   string
 	table string
 	db    *sql.DB
+
+	// Expiration sweeper
+	sweeper struct {
+		sync.Mutex
+		onExpire func(kvs []KV)
+		stopCh   chan struct{}
+		doneCh   chan struct{}
+	}
 }
 
 // KV represents a key-value pair.
@@ -89,14 +98,22 @@ func OpenStorage(dsn string, table string) (st *Storage, err error) {
 	}
 
 	// Ensure table
-	stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (`key` TEXT PRIMARY KEY, `value` BLOB)",
-		table)
+	stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (`key` TEXT PRIMARY KEY, `value` BLOB, "+
+		"`expire` INTEGER)", table)
 
 	if _, err = db.Exec(stmt); err != nil {
 		return st, err
 	}
 
-	st = &Storage{dsn, table, db}
+	if err = ensureExpireColumn(db, table); err != nil {
+		return st, err
+	}
+
+	st = &Storage{
+		dsn:   dsn,
+		table: table,
+		db:    db,
+	}
 	return st, err
 }
 
@@ -110,8 +127,7 @@ func (s *Storage) SetValue(key string, value []byte) (err error) {
 
 // SetValueIfNotExist sets the value to key if it doesn't exist.
 func (s *Storage) SetValueIfNotExist(key string, value []byte) (err error) {
-	stmt := fmt.Sprintf("INSERT OR IGNORE INTO `%s` (`key`, `value`) VALUES (?, ?)", s.table)
-	_, err = s.db.Exec(stmt, key, value)
+	_, err = s.db.Exec(s.insertIfNotExistStmt(), key, value, now())
 
 	return err
 }
@@ -126,9 +142,9 @@ func (s *Storage) DelValue(key string) (err error) {
 
 // GetValue fetches the value of key.
 func (s *Storage) GetValue(key string) (value []byte, err error) {
-	stmt := fmt.Sprintf("SELECT `value` FROM `%s` WHERE `key` = ?", s.table)
+	stmt := fmt.Sprintf("SELECT `value` FROM `%s` WHERE `key` = ? AND %s", s.table, aliveCond)
 
-	if err = s.db.QueryRow(stmt, key).Scan(&value); err == sql.ErrNoRows {
+	if err = s.db.QueryRow(stmt, key, now()).Scan(&value); err == sql.ErrNoRows {
 		err = nil
 	}
 
@@ -163,8 +179,7 @@ func (s *Storage) SetValues(kvs []KV) (err error) {
 // Note that this is not a transaction. We use a prepared statement to send these queries. Each
 // call may fail while part of the queries succeed.
 func (s *Storage) SetValuesIfNotExist(kvs []KV) (err error) {
-	stmt := fmt.Sprintf("INSERT OR IGNORE INTO `%s` (`key`, `value`) VALUES (?, ?)", s.table)
-	pStmt, err := s.db.Prepare(stmt)
+	pStmt, err := s.db.Prepare(s.insertIfNotExistStmt())
 
 	if err != nil {
 		return err
@@ -173,7 +188,7 @@ func (s *Storage) SetValuesIfNotExist(kvs []KV) (err error) {
 	defer pStmt.Close()
 
 	for _, row := range kvs {
-		if _, err = pStmt.Exec(row.Key, row.Value); err != nil {
+		if _, err = pStmt.Exec(row.Key, row.Value, now()); err != nil {
 			return err
 		}
 	}
@@ -210,7 +225,7 @@ func (s *Storage) DelValues(keys []string) (err error) {
 // call may fail while part of the queries succeed and some values may be altered during the
 // queries. But the results will be returned only if all the queries succeed.
 func (s *Storage) GetValues(keys []string) (kvs []KV, err error) {
-	stmt := fmt.Sprintf("SELECT `value` FROM `%s` WHERE `key` = ?", s.table)
+	stmt := fmt.Sprintf("SELECT `value` FROM `%s` WHERE `key` = ? AND %s", s.table, aliveCond)
 	pStmt, err := s.db.Prepare(stmt)
 
 	if err != nil {
@@ -224,7 +239,9 @@ func (s *Storage) GetValues(keys []string) (kvs []KV, err error) {
 	for index, key := range keys {
 		kvs[index].Key = key
 
-		if err = pStmt.QueryRow(key).Scan(&kvs[index].Value); err != nil && err != sql.ErrNoRows {
+		err = pStmt.QueryRow(key, now()).Scan(&kvs[index].Value)
+
+		if err != nil && err != sql.ErrNoRows {
 			return nil, err
 		}
 	}
@@ -287,8 +304,7 @@ func (s *Storage) SetValuesIfNotExistTx(kvs []KV) (err error) {
 	}()
 
 	// Prepare statement
-	stmt := fmt.Sprintf("INSERT OR IGNORE INTO `%s` (`key`, `value`) VALUES (?, ?)", s.table)
-	pStmt, err := tx.Prepare(stmt)
+	pStmt, err := tx.Prepare(s.insertIfNotExistStmt())
 
 	if err != nil {
 		return err
@@ -298,7 +314,7 @@ func (s *Storage) SetValuesIfNotExistTx(kvs []KV) (err error) {
 
 	// Execute queries
 	for _, row := range kvs {
-		if _, err = pStmt.Exec(row.Key, row.Value); err != nil {
+		if _, err = pStmt.Exec(row.Key, row.Value, now()); err != nil {
 			return err
 		}
 	}
@@ -361,7 +377,7 @@ func (s *Storage) GetValuesTx(keys []string) (kvs []KV, err error) {
 	}()
 
 	// Prepare statement
-	stmt := fmt.Sprintf("SELECT `value` FROM `%s` WHERE `key` = ?", s.table)
+	stmt := fmt.Sprintf("SELECT `value` FROM `%s` WHERE `key` = ? AND %s", s.table, aliveCond)
 	pStmt, err := tx.Prepare(stmt)
 
 	if err != nil {
@@ -375,7 +391,7 @@ func (s *Storage) GetValuesTx(keys []string) (kvs []KV, err error) {
 
 	for index, key := range keys {
 		kvs[index].Key = key
-		err = pStmt.QueryRow(key).Scan(&kvs[index].Value)
+		err = pStmt.QueryRow(key, now()).Scan(&kvs[index].Value)
 
 		if err != nil && err != sql.ErrNoRows {
 			return nil, err
@@ -384,3 +400,239 @@ func (s *Storage) GetValuesTx(keys []string) (kvs []KV, err error) {
 
 	return kvs, nil
 }
+
+// prefixEnd returns the smallest key which is greater than all the keys with the prefix, or an
+// empty string if there is no such key.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	return ""
+}
+
+// Iterate calls fn with each key-value pair whose key is in range [start, end) in ascending key
+// order. An empty end means no upper bound. The iteration stops as soon as fn returns an error,
+// which is then returned by Iterate.
+//
+// Note that the rows are read while fn is being called, so fn should not write to the same
+// storage.
+func (s *Storage) Iterate(start, end string, fn func(key string, value []byte) error) (
+	err error) {
+	var rows *sql.Rows
+
+	if end == "" {
+		stmt := fmt.Sprintf("SELECT `key`, `value` FROM `%s` WHERE `key` >= ? AND %s "+
+			"ORDER BY `key`", s.table, aliveCond)
+		rows, err = s.db.Query(stmt, start, now())
+	} else {
+		stmt := fmt.Sprintf("SELECT `key`, `value` FROM `%s` WHERE `key` >= ? AND `key` < ? "+
+			"AND %s ORDER BY `key`", s.table, aliveCond)
+		rows, err = s.db.Query(stmt, start, end, now())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var value []byte
+
+		if err = rows.Scan(&key, &value); err != nil {
+			return err
+		}
+
+		if err = fn(key, value); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetRange fetches at most limit key-value pairs whose key is in range [start, end) in
+// ascending key order. An empty end means no upper bound and a non-positive limit means no
+// limit.
+func (s *Storage) GetRange(start, end string, limit int) (kvs []KV, err error) {
+	args := []interface{}{now(), start}
+	stmt := fmt.Sprintf("SELECT `key`, `value` FROM `%s` WHERE %s AND `key` >= ?", s.table,
+		aliveCond)
+
+	if end != "" {
+		stmt += " AND `key` < ?"
+		args = append(args, end)
+	}
+
+	stmt += " ORDER BY `key`"
+
+	if limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(stmt, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var row KV
+
+		if err = rows.Scan(&row.Key, &row.Value); err != nil {
+			return nil, err
+		}
+
+		kvs = append(kvs, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return kvs, nil
+}
+
+// GetPrefix fetches a page of at most limit key-value pairs whose key has the prefix, starting
+// after the cursor key. An empty cursor starts from the first key. The returned next cursor
+// should be passed to the subsequent call to fetch the next page, it's empty when there are no
+// more pages.
+func (s *Storage) GetPrefix(prefix, cursor string, limit int) (kvs []KV, next string,
+	err error) {
+	start := prefix
+
+	if cursor != "" {
+		if cursor < prefix {
+			cursor = prefix
+		}
+
+		// The smallest key greater than cursor.
+		start = cursor + "\x00"
+	}
+
+	// Fetch one more row to see if there is a next page.
+	fetch := limit
+
+	if limit > 0 {
+		fetch = limit + 1
+	}
+
+	if kvs, err = s.GetRange(start, prefixEnd(prefix), fetch); err != nil {
+		return nil, "", err
+	}
+
+	if limit > 0 && len(kvs) > limit {
+		kvs = kvs[:limit]
+		next = kvs[limit-1].Key
+	}
+
+	return kvs, next, nil
+}
+
+// CompareAndSwap sets the value of key to value if its current value equals old. A nil old
+// means that the key should not exist. It returns whether the value was swapped.
+func (s *Storage) CompareAndSwap(key string, old, value []byte) (swapped bool, err error) {
+	return s.CompareAndSwapTx([]KV{{key, old}}, []KV{{key, value}})
+}
+
+// CompareAndSwapTx sets or replaces the key-value pairs in news as a transaction if all the
+// current values of the key-value pairs in olds match. A nil value in olds means that the key
+// should not exist, and a nil value in news deletes the key. It returns whether the values were
+// swapped.
+func (s *Storage) CompareAndSwapTx(olds []KV, news []KV) (swapped bool, err error) {
+	// Begin transaction
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		if err != nil || !swapped {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// Compare values
+	stmt := fmt.Sprintf("SELECT `value` FROM `%s` WHERE `key` = ? AND %s", s.table, aliveCond)
+
+	for _, row := range olds {
+		var value []byte
+		err = tx.QueryRow(stmt, row.Key, now()).Scan(&value)
+
+		if err == sql.ErrNoRows {
+			if err = nil; row.Value != nil {
+				return false, nil
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		if row.Value == nil || !bytes.Equal(row.Value, value) {
+			return false, nil
+		}
+	}
+
+	// Swap values
+	if err = updateValuesTx(tx, s.table, news); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// UpdateValuesTx sets or replaces the key-value pairs in kvs as a transaction, a nil value
+// deletes the key.
+func (s *Storage) UpdateValuesTx(kvs []KV) (err error) {
+	// Begin transaction
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	return updateValuesTx(tx, s.table, kvs)
+}
+
+func updateValuesTx(tx *sql.Tx, table string, kvs []KV) (err error) {
+	set := fmt.Sprintf("INSERT OR REPLACE INTO `%s` (`key`, `value`) VALUES (?, ?)", table)
+	del := fmt.Sprintf("DELETE FROM `%s` WHERE `key` = ?", table)
+
+	for _, row := range kvs {
+		if row.Value == nil {
+			_, err = tx.Exec(del, row.Key)
+		} else {
+			_, err = tx.Exec(set, row.Key, row.Value)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -17,10 +17,13 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"reflect"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -904,3 +907,193 @@ func TestConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestIterate(t *testing.T) {
+	// Open storage
+	fl, err := ioutil.TempFile("", "db")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	st, err := OpenStorage(fl.Name(), "test-iterate")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if err = st.SetValues(sampleTexts); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	// Iterate all keys
+	sort.Strings(keysOfSampleTexts)
+	var keys []string
+
+	if err = st.Iterate("", "", func(key string, value []byte) error {
+		if !reflect.DeepEqual(value, replacedSampleTexts[key]) {
+			t.Fatalf("Unexpected output result: input = %v, output = %v",
+				replacedSampleTexts[key], value)
+		}
+
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(keys, keysOfSampleTexts) {
+		t.Fatalf("Unexpected output result: input = %v, output = %v", keysOfSampleTexts, keys)
+	}
+
+	// Stop iteration
+	stop := errors.New("stop")
+	count := 0
+
+	if err = st.Iterate("", "", func(key string, value []byte) error {
+		if count++; count == 3 {
+			return stop
+		}
+
+		return nil
+	}); err != stop {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Get range
+	kvs, err := st.GetRange("H", "John", 0)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	keys = keys[:0]
+
+	for _, row := range kvs {
+		keys = append(keys, row.Key)
+	}
+
+	if expected := []string{"H. G. Wells", "Harry Harrison", "Isaac Asimov"}; !reflect.DeepEqual(
+		keys, expected) {
+		t.Fatalf("Unexpected output result: input = %v, output = %v", expected, keys)
+	}
+
+	if kvs, err = st.GetRange("", "", 2); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if len(kvs) != 2 || kvs[0].Key != keysOfSampleTexts[0] {
+		t.Fatalf("Unexpected output result: %v", kvs)
+	}
+
+	// Get prefix with pagination
+	for i := 0; i < 10; i++ {
+		if err = st.SetValue(fmt.Sprintf("page/%02d", i), []byte{byte(i)}); err != nil {
+			t.Fatalf("Error occurred: %s", err.Error())
+		}
+	}
+
+	if err = st.SetValue("pagf", []byte("out of prefix")); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	var cursor string
+	keys = keys[:0]
+	pages := 0
+
+	for {
+		kvs, cursor, err = st.GetPrefix("page/", cursor, 4)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %s", err.Error())
+		}
+
+		for _, row := range kvs {
+			keys = append(keys, row.Key)
+		}
+
+		pages++
+
+		if cursor == "" {
+			break
+		}
+	}
+
+	if pages != 3 || len(keys) != 10 || keys[0] != "page/00" || keys[9] != "page/09" {
+		t.Fatalf("Unexpected output result: pages = %d, keys = %v", pages, keys)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	// Open storage
+	fl, err := ioutil.TempFile("", "db")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	st, err := OpenStorage(fl.Name(), "test-compare-and-swap")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	cases := []struct {
+		old, value []byte
+		swapped    bool
+	}{
+		{[]byte("v0"), []byte("v1"), false},
+		{nil, []byte("v1"), true},
+		{nil, []byte("v2"), false},
+		{[]byte("v0"), []byte("v2"), false},
+		{[]byte("v1"), []byte("v2"), true},
+		{[]byte("v2"), nil, true},
+		{nil, []byte("v3"), true},
+	}
+
+	for i, c := range cases {
+		swapped, err := st.CompareAndSwap("key", c.old, c.value)
+
+		if err != nil {
+			t.Fatalf("Error occurred: %s", err.Error())
+		}
+
+		if swapped != c.swapped {
+			t.Fatalf("Unexpected output result: case = %d, swapped = %v", i, swapped)
+		}
+	}
+
+	// Multi-key swap
+	if swapped, err := st.CompareAndSwapTx(
+		[]KV{{"key", []byte("v3")}, {"other", []byte("x")}},
+		[]KV{{"key", []byte("v4")}, {"other", []byte("y")}},
+	); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	} else if swapped {
+		t.Fatal("Unexpected output result: values swapped")
+	}
+
+	if err = st.UpdateValuesTx([]KV{{"other", []byte("x")}, {"deleted", nil}}); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if swapped, err := st.CompareAndSwapTx(
+		[]KV{{"key", []byte("v3")}, {"other", []byte("x")}},
+		[]KV{{"key", []byte("v4")}, {"other", nil}},
+	); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	} else if !swapped {
+		t.Fatal("Unexpected output result: values not swapped")
+	}
+
+	kvs, err := st.GetValues([]string{"key", "other"})
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if expected := []KV{{"key", []byte("v4")}, {"other", nil}}; !reflect.DeepEqual(
+		kvs, expected) {
+		t.Fatalf("Unexpected output result: input = %v, output = %v", expected, kvs)
+	}
+}
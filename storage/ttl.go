@@ -0,0 +1,266 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// aliveCond is the condition which filters out expired rows, it takes current time as argument.
+const aliveCond = "(`expire` IS NULL OR `expire` > ?)"
+
+var (
+	// ErrSweeperStarted indicates that the expiration sweeper of the storage is already running.
+	ErrSweeperStarted = errors.New("sweeper already started")
+)
+
+func now() int64 {
+	return time.Now().UnixNano()
+}
+
+// ensureExpireColumn adds the expire column to tables created before TTL was introduced.
+func ensureExpireColumn(db *sql.DB, table string) (err error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(`%s`)", table))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt interface{}
+
+		if err = rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return err
+		}
+
+		if name == "expire" {
+			return nil
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `expire` INTEGER", table))
+	return err
+}
+
+// insertIfNotExistStmt returns an insert statement which ignores the row if the key exists and is
+// not expired. It takes key, value and current time as arguments.
+func (s *Storage) insertIfNotExistStmt() string {
+	return fmt.Sprintf("INSERT INTO `%s` (`key`, `value`) VALUES (?, ?) ON CONFLICT(`key`) "+
+		"DO UPDATE SET `value` = excluded.`value`, `expire` = NULL "+
+		"WHERE `expire` IS NOT NULL AND `expire` <= ?", s.table)
+}
+
+// SetValueWithTTL sets or replaces the value to key, the key expires after ttl. Expired keys are
+// invisible to reads and removed by Sweep.
+func (s *Storage) SetValueWithTTL(key string, value []byte, ttl time.Duration) (err error) {
+	stmt := fmt.Sprintf("INSERT OR REPLACE INTO `%s` (`key`, `value`, `expire`) VALUES (?, ?, ?)",
+		s.table)
+	_, err = s.db.Exec(stmt, key, value, time.Now().Add(ttl).UnixNano())
+
+	return err
+}
+
+// SetValuesWithTTL sets or replaces the key-value pairs in kvs as a transaction, the keys expire
+// after ttl.
+func (s *Storage) SetValuesWithTTL(kvs []KV, ttl time.Duration) (err error) {
+	// Begin transaction
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// Prepare statement
+	stmt := fmt.Sprintf("INSERT OR REPLACE INTO `%s` (`key`, `value`, `expire`) VALUES (?, ?, ?)",
+		s.table)
+	pStmt, err := tx.Prepare(stmt)
+
+	if err != nil {
+		return err
+	}
+
+	defer pStmt.Close()
+
+	// Execute queries
+	expire := time.Now().Add(ttl).UnixNano()
+
+	for _, row := range kvs {
+		if _, err = pStmt.Exec(row.Key, row.Value, expire); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetTTL returns the remaining time to live of key. It returns ok = false if the key doesn't
+// exist, and ttl = 0 if the key never expires.
+func (s *Storage) GetTTL(key string) (ttl time.Duration, ok bool, err error) {
+	stmt := fmt.Sprintf("SELECT `expire` FROM `%s` WHERE `key` = ? AND %s", s.table, aliveCond)
+	var expire sql.NullInt64
+	t := now()
+
+	if err = s.db.QueryRow(stmt, key, t).Scan(&expire); err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	if expire.Valid {
+		ttl = time.Duration(expire.Int64 - t)
+	}
+
+	return ttl, true, nil
+}
+
+// Sweep deletes the expired key-value pairs as a transaction and returns them.
+func (s *Storage) Sweep() (kvs []KV, err error) {
+	// Begin transaction
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	t := now()
+	stmt := fmt.Sprintf("SELECT `key`, `value` FROM `%s` WHERE `expire` <= ? ORDER BY `key`",
+		s.table)
+	rows, err := tx.Query(stmt, t)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var row KV
+
+		if err = rows.Scan(&row.Key, &row.Value); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		kvs = append(kvs, row)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stmt = fmt.Sprintf("DELETE FROM `%s` WHERE `expire` <= ?", s.table)
+
+	if _, err = tx.Exec(stmt, t); err != nil {
+		return nil, err
+	}
+
+	return kvs, nil
+}
+
+// SetExpireHandler sets the handler which is called by the sweeper with the expired key-value
+// pairs removed in each sweep.
+func (s *Storage) SetExpireHandler(fn func(kvs []KV)) {
+	s.sweeper.Lock()
+	defer s.sweeper.Unlock()
+	s.sweeper.onExpire = fn
+}
+
+// StartSweeper starts a background goroutine which calls Sweep every interval.
+func (s *Storage) StartSweeper(interval time.Duration) error {
+	s.sweeper.Lock()
+	defer s.sweeper.Unlock()
+
+	if s.sweeper.stopCh != nil {
+		return ErrSweeperStarted
+	}
+
+	s.sweeper.stopCh = make(chan struct{})
+	s.sweeper.doneCh = make(chan struct{})
+	go s.sweep(interval, s.sweeper.stopCh, s.sweeper.doneCh)
+
+	return nil
+}
+
+// StopSweeper stops the background sweeper and waits for it to exit.
+func (s *Storage) StopSweeper() {
+	s.sweeper.Lock()
+	stopCh, doneCh := s.sweeper.stopCh, s.sweeper.doneCh
+	s.sweeper.stopCh, s.sweeper.doneCh = nil, nil
+	s.sweeper.Unlock()
+
+	if stopCh != nil {
+		close(stopCh)
+		<-doneCh
+	}
+}
+
+func (s *Storage) sweep(interval time.Duration, stopCh <-chan struct{}, doneCh chan<- struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		// Errors such as a locked database are transient, just retry in the next round.
+		kvs, err := s.Sweep()
+
+		if err != nil || len(kvs) == 0 {
+			continue
+		}
+
+		s.sweeper.Lock()
+		fn := s.sweeper.onExpire
+		s.sweeper.Unlock()
+
+		if fn != nil {
+			fn(kvs)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	// Open storage
+	fl, err := ioutil.TempFile("", "db")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	st, err := OpenStorage(fl.Name(), "test-ttl")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if err = st.SetValue("persistent", []byte("v")); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if err = st.SetValueWithTTL("short", []byte("v"), 50*time.Millisecond); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if err = st.SetValuesWithTTL([]KV{{"long1", []byte("v")}, {"long2", []byte("v")}},
+		time.Hour); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if ttl, ok, err := st.GetTTL("persistent"); err != nil || !ok || ttl != 0 {
+		t.Fatalf("Unexpected output result: ttl = %v, ok = %v, err = %v", ttl, ok, err)
+	}
+
+	if ttl, ok, err := st.GetTTL("long1"); err != nil || !ok || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("Unexpected output result: ttl = %v, ok = %v, err = %v", ttl, ok, err)
+	}
+
+	if v, err := st.GetValue("short"); err != nil || v == nil {
+		t.Fatalf("Unexpected output result: value = %v, err = %v", v, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Lazy expiry on reads
+	if v, err := st.GetValue("short"); err != nil || v != nil {
+		t.Fatalf("Unexpected output result: value = %v, err = %v", v, err)
+	}
+
+	if _, ok, err := st.GetTTL("short"); err != nil || ok {
+		t.Fatalf("Unexpected output result: ok = %v, err = %v", ok, err)
+	}
+
+	kvs, err := st.GetRange("", "", 0)
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if len(kvs) != 3 {
+		t.Fatalf("Unexpected output result: %v", kvs)
+	}
+
+	// An expired key does not exist
+	if err = st.SetValueIfNotExist("short", []byte("v2")); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if v, err := st.GetValue("short"); err != nil || string(v) != "v2" {
+		t.Fatalf("Unexpected output result: value = %v, err = %v", v, err)
+	}
+
+	// Setting without TTL clears the TTL
+	if err = st.SetValue("long1", []byte("v2")); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if ttl, ok, err := st.GetTTL("long1"); err != nil || !ok || ttl != 0 {
+		t.Fatalf("Unexpected output result: ttl = %v, ok = %v, err = %v", ttl, ok, err)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	// Open storage
+	fl, err := ioutil.TempFile("", "db")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	st, err := OpenStorage(fl.Name(), "test-sweeper")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	expired := make(chan []KV, 1)
+	st.SetExpireHandler(func(kvs []KV) { expired <- kvs })
+
+	if err = st.StartSweeper(10 * time.Millisecond); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	defer st.StopSweeper()
+
+	if err = st.StartSweeper(10 * time.Millisecond); err != ErrSweeperStarted {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err = st.SetValue("persistent", []byte("v")); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if err = st.SetValuesWithTTL([]KV{{"k1", []byte("v1")}, {"k2", []byte("v2")}},
+		20*time.Millisecond); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	select {
+	case kvs := <-expired:
+		if expected := []KV{{"k1", []byte("v1")}, {"k2", []byte("v2")}}; !reflect.DeepEqual(
+			kvs, expected) {
+			t.Fatalf("Unexpected output result: input = %v, output = %v", expected, kvs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expiration not observed")
+	}
+
+	var count int
+	stmt := fmt.Sprintf("SELECT COUNT(*) FROM `%s`", st.table)
+
+	if err = st.db.QueryRow(stmt).Scan(&count); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if count != 1 {
+		t.Fatalf("Unexpected output result: count = %d", count)
+	}
+}
+
+func TestExpireColumnMigration(t *testing.T) {
+	fl, err := ioutil.TempFile("", "db")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	// Create a table with the legacy schema
+	db, err := sql.Open("sqlite3", fl.Name())
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if _, err = db.Exec(
+		"CREATE TABLE `test-migration` (`key` TEXT PRIMARY KEY, `value` BLOB)"); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if _, err = db.Exec("INSERT INTO `test-migration` VALUES ('k', 'v')"); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	db.Close()
+
+	st, err := OpenStorage(fl.Name(), "test-migration")
+
+	if err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+
+	if v, err := st.GetValue("k"); err != nil || string(v) != "v" {
+		t.Fatalf("Unexpected output result: value = %v, err = %v", v, err)
+	}
+
+	if err = st.SetValueWithTTL("k", []byte("v"), time.Hour); err != nil {
+		t.Fatalf("Error occurred: %s", err.Error())
+	}
+}
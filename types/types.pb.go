@@ -41,6 +41,53 @@ func (TxType) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_types_3531794a76385e97, []int{0}
 }
 
+type BPTxType int32
+
+const (
+	BPTxType_TRANSFER             BPTxType = 0
+	BPTxType_CREATE_DATABASE      BPTxType = 1
+	BPTxType_DEPOSIT              BPTxType = 2
+	BPTxType_WITHDRAW             BPTxType = 3
+	BPTxType_GRANT_PERMISSION     BPTxType = 4
+	BPTxType_REVOKE_PERMISSION    BPTxType = 5
+	BPTxType_UPDATE_REPLICA_COUNT BPTxType = 6
+	BPTxType_REGISTER_MINER       BPTxType = 7
+	BPTxType_BILLING              BPTxType = 8
+	BPTxType_REPLACE_REPLICA      BPTxType = 9
+)
+
+var BPTxType_name = map[int32]string{
+	0: "TRANSFER",
+	1: "CREATE_DATABASE",
+	2: "DEPOSIT",
+	3: "WITHDRAW",
+	4: "GRANT_PERMISSION",
+	5: "REVOKE_PERMISSION",
+	6: "UPDATE_REPLICA_COUNT",
+	7: "REGISTER_MINER",
+	8: "BILLING",
+	9: "REPLACE_REPLICA",
+}
+var BPTxType_value = map[string]int32{
+	"TRANSFER":             0,
+	"CREATE_DATABASE":      1,
+	"DEPOSIT":              2,
+	"WITHDRAW":             3,
+	"GRANT_PERMISSION":     4,
+	"REVOKE_PERMISSION":    5,
+	"UPDATE_REPLICA_COUNT": 6,
+	"REGISTER_MINER":       7,
+	"BILLING":              8,
+	"REPLACE_REPLICA":      9,
+}
+
+func (x BPTxType) String() string {
+	return proto.EnumName(BPTxType_name, int32(x))
+}
+func (BPTxType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{1}
+}
+
 type Signature struct {
 	R                    string   `protobuf:"bytes,1,opt,name=R,proto3" json:"R,omitempty"`
 	S                    string   `protobuf:"bytes,2,opt,name=S,proto3" json:"S,omitempty"`
@@ -284,6 +331,7 @@ type UtxoHeader struct {
 	PrevTxHash           *Hash      `protobuf:"bytes,2,opt,name=PrevTxHash,proto3" json:"PrevTxHash,omitempty"`
 	Signee               *PublicKey `protobuf:"bytes,3,opt,name=Signee,proto3" json:"Signee,omitempty"`
 	Signature            *Signature `protobuf:"bytes,4,opt,name=Signature,proto3" json:"Signature,omitempty"`
+	PrevOutputIndex      uint32     `protobuf:"varint,5,opt,name=PrevOutputIndex,proto3" json:"PrevOutputIndex,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
 	XXX_unrecognized     []byte     `json:"-"`
 	XXX_sizecache        int32      `json:"-"`
@@ -341,6 +389,13 @@ func (m *UtxoHeader) GetSignature() *Signature {
 	return nil
 }
 
+func (m *UtxoHeader) GetPrevOutputIndex() uint32 {
+	if m != nil {
+		return m.PrevOutputIndex
+	}
+	return 0
+}
+
 type Tx struct {
 	UtxoIn               []*Utxo  `protobuf:"bytes,1,rep,name=UtxoIn,proto3" json:"UtxoIn,omitempty"`
 	UtxoOut              []*Utxo  `protobuf:"bytes,2,rep,name=UtxoOut,proto3" json:"UtxoOut,omitempty"`
@@ -718,6 +773,8 @@ type BPTxData struct {
 	Payload              []byte          `protobuf:"bytes,4,opt,name=Payload,proto3" json:"Payload,omitempty"`
 	Signature            *Signature      `protobuf:"bytes,5,opt,name=Signature,proto3" json:"Signature,omitempty"`
 	Signee               *PublicKey      `protobuf:"bytes,6,opt,name=Signee,proto3" json:"Signee,omitempty"`
+	Fee                  uint64          `protobuf:"varint,7,opt,name=Fee,proto3" json:"Fee,omitempty"`
+	Type                 BPTxType        `protobuf:"varint,8,opt,name=Type,proto3,enum=types.BPTxType" json:"Type,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
 	XXX_unrecognized     []byte          `json:"-"`
 	XXX_sizecache        int32           `json:"-"`
@@ -789,6 +846,20 @@ func (m *BPTxData) GetSignee() *PublicKey {
 	return nil
 }
 
+func (m *BPTxData) GetFee() uint64 {
+	if m != nil {
+		return m.Fee
+	}
+	return 0
+}
+
+func (m *BPTxData) GetType() BPTxType {
+	if m != nil {
+		return m.Type
+	}
+	return BPTxType_TRANSFER
+}
+
 type BPHeader struct {
 	Version              int32           `protobuf:"varint,1,opt,name=Version,proto3" json:"Version,omitempty"`
 	Producer             *AccountAddress `protobuf:"bytes,2,opt,name=Producer,proto3" json:"Producer,omitempty"`
@@ -796,6 +867,7 @@ type BPHeader struct {
 	Parent               *Hash           `protobuf:"bytes,4,opt,name=Parent,proto3" json:"Parent,omitempty"`
 	MerkleRoot           *Hash           `protobuf:"bytes,5,opt,name=MerkleRoot,proto3" json:"MerkleRoot,omitempty"`
 	Timestamp            int64           `protobuf:"varint,6,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	ParamsHash           *Hash           `protobuf:"bytes,7,opt,name=ParamsHash,proto3" json:"ParamsHash,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
 	XXX_unrecognized     []byte          `json:"-"`
 	XXX_sizecache        int32           `json:"-"`
@@ -867,6 +939,13 @@ func (m *BPHeader) GetTimestamp() int64 {
 	return 0
 }
 
+func (m *BPHeader) GetParamsHash() *Hash {
+	if m != nil {
+		return m.ParamsHash
+	}
+	return nil
+}
+
 type BPSignedHeader struct {
 	Header               *BPHeader  `protobuf:"bytes,1,opt,name=Header,proto3" json:"Header,omitempty"`
 	BlockHash            *Hash      `protobuf:"bytes,2,opt,name=BlockHash,proto3" json:"BlockHash,omitempty"`
@@ -975,6 +1054,552 @@ func (m *BPBlock) GetTx() []*BPTx {
 	return nil
 }
 
+type CreateDatabasePayload struct {
+	ReplicaCount         uint32   `protobuf:"varint,1,opt,name=ReplicaCount,proto3" json:"ReplicaCount,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CreateDatabasePayload) Reset()         { *m = CreateDatabasePayload{} }
+func (m *CreateDatabasePayload) String() string { return proto.CompactTextString(m) }
+func (*CreateDatabasePayload) ProtoMessage()    {}
+func (*CreateDatabasePayload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{17}
+}
+func (m *CreateDatabasePayload) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateDatabasePayload.Unmarshal(m, b)
+}
+func (m *CreateDatabasePayload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateDatabasePayload.Marshal(b, m, deterministic)
+}
+func (dst *CreateDatabasePayload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateDatabasePayload.Merge(dst, src)
+}
+func (m *CreateDatabasePayload) XXX_Size() int {
+	return xxx_messageInfo_CreateDatabasePayload.Size(m)
+}
+func (m *CreateDatabasePayload) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateDatabasePayload.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateDatabasePayload proto.InternalMessageInfo
+
+func (m *CreateDatabasePayload) GetReplicaCount() uint32 {
+	if m != nil {
+		return m.ReplicaCount
+	}
+	return 0
+}
+
+type DepositPayload struct {
+	DatabaseID           string   `protobuf:"bytes,1,opt,name=DatabaseID,proto3" json:"DatabaseID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DepositPayload) Reset()         { *m = DepositPayload{} }
+func (m *DepositPayload) String() string { return proto.CompactTextString(m) }
+func (*DepositPayload) ProtoMessage()    {}
+func (*DepositPayload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{18}
+}
+func (m *DepositPayload) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DepositPayload.Unmarshal(m, b)
+}
+func (m *DepositPayload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DepositPayload.Marshal(b, m, deterministic)
+}
+func (dst *DepositPayload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DepositPayload.Merge(dst, src)
+}
+func (m *DepositPayload) XXX_Size() int {
+	return xxx_messageInfo_DepositPayload.Size(m)
+}
+func (m *DepositPayload) XXX_DiscardUnknown() {
+	xxx_messageInfo_DepositPayload.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DepositPayload proto.InternalMessageInfo
+
+func (m *DepositPayload) GetDatabaseID() string {
+	if m != nil {
+		return m.DatabaseID
+	}
+	return ""
+}
+
+type WithdrawPayload struct {
+	DatabaseID           string   `protobuf:"bytes,1,opt,name=DatabaseID,proto3" json:"DatabaseID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WithdrawPayload) Reset()         { *m = WithdrawPayload{} }
+func (m *WithdrawPayload) String() string { return proto.CompactTextString(m) }
+func (*WithdrawPayload) ProtoMessage()    {}
+func (*WithdrawPayload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{19}
+}
+func (m *WithdrawPayload) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WithdrawPayload.Unmarshal(m, b)
+}
+func (m *WithdrawPayload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WithdrawPayload.Marshal(b, m, deterministic)
+}
+func (dst *WithdrawPayload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WithdrawPayload.Merge(dst, src)
+}
+func (m *WithdrawPayload) XXX_Size() int {
+	return xxx_messageInfo_WithdrawPayload.Size(m)
+}
+func (m *WithdrawPayload) XXX_DiscardUnknown() {
+	xxx_messageInfo_WithdrawPayload.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WithdrawPayload proto.InternalMessageInfo
+
+func (m *WithdrawPayload) GetDatabaseID() string {
+	if m != nil {
+		return m.DatabaseID
+	}
+	return ""
+}
+
+type GrantPermissionPayload struct {
+	DatabaseID           string          `protobuf:"bytes,1,opt,name=DatabaseID,proto3" json:"DatabaseID,omitempty"`
+	User                 *AccountAddress `protobuf:"bytes,2,opt,name=User,proto3" json:"User,omitempty"`
+	Permission           uint32          `protobuf:"varint,3,opt,name=Permission,proto3" json:"Permission,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *GrantPermissionPayload) Reset()         { *m = GrantPermissionPayload{} }
+func (m *GrantPermissionPayload) String() string { return proto.CompactTextString(m) }
+func (*GrantPermissionPayload) ProtoMessage()    {}
+func (*GrantPermissionPayload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{20}
+}
+func (m *GrantPermissionPayload) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GrantPermissionPayload.Unmarshal(m, b)
+}
+func (m *GrantPermissionPayload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GrantPermissionPayload.Marshal(b, m, deterministic)
+}
+func (dst *GrantPermissionPayload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GrantPermissionPayload.Merge(dst, src)
+}
+func (m *GrantPermissionPayload) XXX_Size() int {
+	return xxx_messageInfo_GrantPermissionPayload.Size(m)
+}
+func (m *GrantPermissionPayload) XXX_DiscardUnknown() {
+	xxx_messageInfo_GrantPermissionPayload.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GrantPermissionPayload proto.InternalMessageInfo
+
+func (m *GrantPermissionPayload) GetDatabaseID() string {
+	if m != nil {
+		return m.DatabaseID
+	}
+	return ""
+}
+
+func (m *GrantPermissionPayload) GetUser() *AccountAddress {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+func (m *GrantPermissionPayload) GetPermission() uint32 {
+	if m != nil {
+		return m.Permission
+	}
+	return 0
+}
+
+type RevokePermissionPayload struct {
+	DatabaseID           string          `protobuf:"bytes,1,opt,name=DatabaseID,proto3" json:"DatabaseID,omitempty"`
+	User                 *AccountAddress `protobuf:"bytes,2,opt,name=User,proto3" json:"User,omitempty"`
+	Permission           uint32          `protobuf:"varint,3,opt,name=Permission,proto3" json:"Permission,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *RevokePermissionPayload) Reset()         { *m = RevokePermissionPayload{} }
+func (m *RevokePermissionPayload) String() string { return proto.CompactTextString(m) }
+func (*RevokePermissionPayload) ProtoMessage()    {}
+func (*RevokePermissionPayload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{21}
+}
+func (m *RevokePermissionPayload) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RevokePermissionPayload.Unmarshal(m, b)
+}
+func (m *RevokePermissionPayload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RevokePermissionPayload.Marshal(b, m, deterministic)
+}
+func (dst *RevokePermissionPayload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RevokePermissionPayload.Merge(dst, src)
+}
+func (m *RevokePermissionPayload) XXX_Size() int {
+	return xxx_messageInfo_RevokePermissionPayload.Size(m)
+}
+func (m *RevokePermissionPayload) XXX_DiscardUnknown() {
+	xxx_messageInfo_RevokePermissionPayload.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RevokePermissionPayload proto.InternalMessageInfo
+
+func (m *RevokePermissionPayload) GetDatabaseID() string {
+	if m != nil {
+		return m.DatabaseID
+	}
+	return ""
+}
+
+func (m *RevokePermissionPayload) GetUser() *AccountAddress {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+func (m *RevokePermissionPayload) GetPermission() uint32 {
+	if m != nil {
+		return m.Permission
+	}
+	return 0
+}
+
+type UpdateReplicaCountPayload struct {
+	DatabaseID           string   `protobuf:"bytes,1,opt,name=DatabaseID,proto3" json:"DatabaseID,omitempty"`
+	ReplicaCount         uint32   `protobuf:"varint,2,opt,name=ReplicaCount,proto3" json:"ReplicaCount,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UpdateReplicaCountPayload) Reset()         { *m = UpdateReplicaCountPayload{} }
+func (m *UpdateReplicaCountPayload) String() string { return proto.CompactTextString(m) }
+func (*UpdateReplicaCountPayload) ProtoMessage()    {}
+func (*UpdateReplicaCountPayload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{22}
+}
+func (m *UpdateReplicaCountPayload) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UpdateReplicaCountPayload.Unmarshal(m, b)
+}
+func (m *UpdateReplicaCountPayload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UpdateReplicaCountPayload.Marshal(b, m, deterministic)
+}
+func (dst *UpdateReplicaCountPayload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpdateReplicaCountPayload.Merge(dst, src)
+}
+func (m *UpdateReplicaCountPayload) XXX_Size() int {
+	return xxx_messageInfo_UpdateReplicaCountPayload.Size(m)
+}
+func (m *UpdateReplicaCountPayload) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpdateReplicaCountPayload.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UpdateReplicaCountPayload proto.InternalMessageInfo
+
+func (m *UpdateReplicaCountPayload) GetDatabaseID() string {
+	if m != nil {
+		return m.DatabaseID
+	}
+	return ""
+}
+
+func (m *UpdateReplicaCountPayload) GetReplicaCount() uint32 {
+	if m != nil {
+		return m.ReplicaCount
+	}
+	return 0
+}
+
+type RegisterMinerPayload struct {
+	NodeID               *NodeID  `protobuf:"bytes,1,opt,name=NodeID,proto3" json:"NodeID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RegisterMinerPayload) Reset()         { *m = RegisterMinerPayload{} }
+func (m *RegisterMinerPayload) String() string { return proto.CompactTextString(m) }
+func (*RegisterMinerPayload) ProtoMessage()    {}
+func (*RegisterMinerPayload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{23}
+}
+func (m *RegisterMinerPayload) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RegisterMinerPayload.Unmarshal(m, b)
+}
+func (m *RegisterMinerPayload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RegisterMinerPayload.Marshal(b, m, deterministic)
+}
+func (dst *RegisterMinerPayload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RegisterMinerPayload.Merge(dst, src)
+}
+func (m *RegisterMinerPayload) XXX_Size() int {
+	return xxx_messageInfo_RegisterMinerPayload.Size(m)
+}
+func (m *RegisterMinerPayload) XXX_DiscardUnknown() {
+	xxx_messageInfo_RegisterMinerPayload.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RegisterMinerPayload proto.InternalMessageInfo
+
+func (m *RegisterMinerPayload) GetNodeID() *NodeID {
+	if m != nil {
+		return m.NodeID
+	}
+	return nil
+}
+
+type BillingItem struct {
+	Miner                *NodeID  `protobuf:"bytes,1,opt,name=Miner,proto3" json:"Miner,omitempty"`
+	QueryCount           uint64   `protobuf:"varint,2,opt,name=QueryCount,proto3" json:"QueryCount,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BillingItem) Reset()         { *m = BillingItem{} }
+func (m *BillingItem) String() string { return proto.CompactTextString(m) }
+func (*BillingItem) ProtoMessage()    {}
+func (*BillingItem) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{24}
+}
+func (m *BillingItem) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BillingItem.Unmarshal(m, b)
+}
+func (m *BillingItem) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BillingItem.Marshal(b, m, deterministic)
+}
+func (dst *BillingItem) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BillingItem.Merge(dst, src)
+}
+func (m *BillingItem) XXX_Size() int {
+	return xxx_messageInfo_BillingItem.Size(m)
+}
+func (m *BillingItem) XXX_DiscardUnknown() {
+	xxx_messageInfo_BillingItem.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BillingItem proto.InternalMessageInfo
+
+func (m *BillingItem) GetMiner() *NodeID {
+	if m != nil {
+		return m.Miner
+	}
+	return nil
+}
+
+func (m *BillingItem) GetQueryCount() uint64 {
+	if m != nil {
+		return m.QueryCount
+	}
+	return 0
+}
+
+type BillingPayload struct {
+	DatabaseID           string              `protobuf:"bytes,1,opt,name=DatabaseID,proto3" json:"DatabaseID,omitempty"`
+	StartHeight          int32               `protobuf:"varint,2,opt,name=StartHeight,proto3" json:"StartHeight,omitempty"`
+	EndHeight            int32               `protobuf:"varint,3,opt,name=EndHeight,proto3" json:"EndHeight,omitempty"`
+	Items                []*BillingItem      `protobuf:"bytes,4,rep,name=Items,proto3" json:"Items,omitempty"`
+	EndBlockHash         *Hash               `protobuf:"bytes,5,opt,name=EndBlockHash,proto3" json:"EndBlockHash,omitempty"`
+	Signatures           []*BillingSignature `protobuf:"bytes,6,rep,name=Signatures,proto3" json:"Signatures,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *BillingPayload) Reset()         { *m = BillingPayload{} }
+func (m *BillingPayload) String() string { return proto.CompactTextString(m) }
+func (*BillingPayload) ProtoMessage()    {}
+func (*BillingPayload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{25}
+}
+func (m *BillingPayload) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BillingPayload.Unmarshal(m, b)
+}
+func (m *BillingPayload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BillingPayload.Marshal(b, m, deterministic)
+}
+func (dst *BillingPayload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BillingPayload.Merge(dst, src)
+}
+func (m *BillingPayload) XXX_Size() int {
+	return xxx_messageInfo_BillingPayload.Size(m)
+}
+func (m *BillingPayload) XXX_DiscardUnknown() {
+	xxx_messageInfo_BillingPayload.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BillingPayload proto.InternalMessageInfo
+
+func (m *BillingPayload) GetDatabaseID() string {
+	if m != nil {
+		return m.DatabaseID
+	}
+	return ""
+}
+
+func (m *BillingPayload) GetStartHeight() int32 {
+	if m != nil {
+		return m.StartHeight
+	}
+	return 0
+}
+
+func (m *BillingPayload) GetEndHeight() int32 {
+	if m != nil {
+		return m.EndHeight
+	}
+	return 0
+}
+
+func (m *BillingPayload) GetItems() []*BillingItem {
+	if m != nil {
+		return m.Items
+	}
+	return nil
+}
+
+func (m *BillingPayload) GetEndBlockHash() *Hash {
+	if m != nil {
+		return m.EndBlockHash
+	}
+	return nil
+}
+
+func (m *BillingPayload) GetSignatures() []*BillingSignature {
+	if m != nil {
+		return m.Signatures
+	}
+	return nil
+}
+
+type ReplaceReplicaPayload struct {
+	DatabaseID           string              `protobuf:"bytes,1,opt,name=DatabaseID,proto3" json:"DatabaseID,omitempty"`
+	Miner                *NodeID             `protobuf:"bytes,2,opt,name=Miner,proto3" json:"Miner,omitempty"`
+	Term                 uint64              `protobuf:"varint,3,opt,name=Term,proto3" json:"Term,omitempty"`
+	Signatures           []*BillingSignature `protobuf:"bytes,4,rep,name=Signatures,proto3" json:"Signatures,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *ReplaceReplicaPayload) Reset()         { *m = ReplaceReplicaPayload{} }
+func (m *ReplaceReplicaPayload) String() string { return proto.CompactTextString(m) }
+func (*ReplaceReplicaPayload) ProtoMessage()    {}
+func (*ReplaceReplicaPayload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{26}
+}
+func (m *ReplaceReplicaPayload) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReplaceReplicaPayload.Unmarshal(m, b)
+}
+func (m *ReplaceReplicaPayload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReplaceReplicaPayload.Marshal(b, m, deterministic)
+}
+func (dst *ReplaceReplicaPayload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReplaceReplicaPayload.Merge(dst, src)
+}
+func (m *ReplaceReplicaPayload) XXX_Size() int {
+	return xxx_messageInfo_ReplaceReplicaPayload.Size(m)
+}
+func (m *ReplaceReplicaPayload) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReplaceReplicaPayload.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReplaceReplicaPayload proto.InternalMessageInfo
+
+func (m *ReplaceReplicaPayload) GetDatabaseID() string {
+	if m != nil {
+		return m.DatabaseID
+	}
+	return ""
+}
+
+func (m *ReplaceReplicaPayload) GetMiner() *NodeID {
+	if m != nil {
+		return m.Miner
+	}
+	return nil
+}
+
+func (m *ReplaceReplicaPayload) GetTerm() uint64 {
+	if m != nil {
+		return m.Term
+	}
+	return 0
+}
+
+func (m *ReplaceReplicaPayload) GetSignatures() []*BillingSignature {
+	if m != nil {
+		return m.Signatures
+	}
+	return nil
+}
+
+type BillingSignature struct {
+	Signee               *PublicKey `protobuf:"bytes,1,opt,name=Signee,proto3" json:"Signee,omitempty"`
+	Nonce                []byte     `protobuf:"bytes,2,opt,name=Nonce,proto3" json:"Nonce,omitempty"`
+	Signature            *Signature `protobuf:"bytes,3,opt,name=Signature,proto3" json:"Signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *BillingSignature) Reset()         { *m = BillingSignature{} }
+func (m *BillingSignature) String() string { return proto.CompactTextString(m) }
+func (*BillingSignature) ProtoMessage()    {}
+func (*BillingSignature) Descriptor() ([]byte, []int) {
+	return fileDescriptor_types_3531794a76385e97, []int{27}
+}
+func (m *BillingSignature) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BillingSignature.Unmarshal(m, b)
+}
+func (m *BillingSignature) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BillingSignature.Marshal(b, m, deterministic)
+}
+func (dst *BillingSignature) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BillingSignature.Merge(dst, src)
+}
+func (m *BillingSignature) XXX_Size() int {
+	return xxx_messageInfo_BillingSignature.Size(m)
+}
+func (m *BillingSignature) XXX_DiscardUnknown() {
+	xxx_messageInfo_BillingSignature.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BillingSignature proto.InternalMessageInfo
+
+func (m *BillingSignature) GetSignee() *PublicKey {
+	if m != nil {
+		return m.Signee
+	}
+	return nil
+}
+
+func (m *BillingSignature) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
+func (m *BillingSignature) GetSignature() *Signature {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Signature)(nil), "types.Signature")
 	proto.RegisterType((*PublicKey)(nil), "types.PublicKey")
@@ -994,63 +1619,107 @@ func init() {
 	proto.RegisterType((*BPHeader)(nil), "types.BPHeader")
 	proto.RegisterType((*BPSignedHeader)(nil), "types.BPSignedHeader")
 	proto.RegisterType((*BPBlock)(nil), "types.BPBlock")
+	proto.RegisterType((*CreateDatabasePayload)(nil), "types.CreateDatabasePayload")
+	proto.RegisterType((*DepositPayload)(nil), "types.DepositPayload")
+	proto.RegisterType((*WithdrawPayload)(nil), "types.WithdrawPayload")
+	proto.RegisterType((*GrantPermissionPayload)(nil), "types.GrantPermissionPayload")
+	proto.RegisterType((*RevokePermissionPayload)(nil), "types.RevokePermissionPayload")
+	proto.RegisterType((*UpdateReplicaCountPayload)(nil), "types.UpdateReplicaCountPayload")
+	proto.RegisterType((*RegisterMinerPayload)(nil), "types.RegisterMinerPayload")
+	proto.RegisterType((*BillingItem)(nil), "types.BillingItem")
+	proto.RegisterType((*BillingPayload)(nil), "types.BillingPayload")
+	proto.RegisterType((*ReplaceReplicaPayload)(nil), "types.ReplaceReplicaPayload")
+	proto.RegisterType((*BillingSignature)(nil), "types.BillingSignature")
 	proto.RegisterEnum("types.TxType", TxType_name, TxType_value)
+	proto.RegisterEnum("types.BPTxType", BPTxType_name, BPTxType_value)
 }
 
 func init() { proto.RegisterFile("types.proto", fileDescriptor_types_3531794a76385e97) }
 
 var fileDescriptor_types_3531794a76385e97 = []byte{
-	// 825 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x56, 0xcd, 0x6e, 0xdb, 0x46,
-	0x10, 0x2e, 0x25, 0x92, 0x92, 0x86, 0x92, 0xab, 0x2e, 0xea, 0x42, 0x70, 0x8b, 0x56, 0xa6, 0xeb,
-	0x5a, 0x6e, 0x51, 0x01, 0x96, 0x2f, 0x46, 0x4e, 0x91, 0x6c, 0x27, 0x16, 0x02, 0xdb, 0xcc, 0x8a,
-	0x0e, 0x90, 0xe3, 0x5a, 0x5a, 0xd8, 0x84, 0x25, 0x92, 0xe0, 0x8f, 0x21, 0x3d, 0x84, 0x1f, 0x25,
-	0x2f, 0x90, 0x43, 0x1e, 0x25, 0xe7, 0xdc, 0xf2, 0x0a, 0xc1, 0x2c, 0x97, 0x22, 0x29, 0xdb, 0xf1,
-	0x29, 0x80, 0x4f, 0xe2, 0xcc, 0x37, 0xbb, 0x3b, 0xf3, 0xcd, 0x37, 0x03, 0x81, 0x11, 0x2d, 0x7c,
-	0x1e, 0x76, 0xfd, 0xc0, 0x8b, 0x3c, 0xa2, 0x09, 0xc3, 0xdc, 0x81, 0xda, 0xc8, 0xb9, 0x72, 0x59,
-	0x14, 0x07, 0x9c, 0xd4, 0x41, 0xa1, 0x2d, 0xa5, 0xad, 0x74, 0x6a, 0x54, 0xa1, 0x68, 0x8d, 0x5a,
-	0xa5, 0xc4, 0x1a, 0x99, 0xbb, 0x50, 0xb3, 0xe2, 0xcb, 0xa9, 0x33, 0x7e, 0xc3, 0x17, 0xe4, 0x8f,
-	0x9c, 0x21, 0x0e, 0xd4, 0x69, 0xe6, 0x30, 0x37, 0x40, 0x3d, 0x61, 0xe1, 0x35, 0x21, 0xc9, 0xaf,
-	0x0c, 0x10, 0xdf, 0xe6, 0x5d, 0x09, 0x6a, 0x17, 0xd1, 0xdc, 0x3b, 0x76, 0xa3, 0x60, 0x41, 0xfe,
-	0x04, 0x18, 0x86, 0x87, 0x9e, 0xe3, 0x5e, 0xb2, 0x90, 0x8b, 0xb8, 0x2a, 0xcd, 0x79, 0xc8, 0xdf,
-	0xd0, 0x78, 0x15, 0x78, 0xb3, 0x53, 0xe6, 0xb8, 0x87, 0xd7, 0xcc, 0x71, 0x45, 0x3a, 0x55, 0x5a,
-	0x74, 0x92, 0x36, 0x18, 0x83, 0xa9, 0x37, 0xbe, 0x39, 0xe1, 0xce, 0xd5, 0x75, 0xd4, 0x2a, 0xb7,
-	0x95, 0x4e, 0x83, 0xe6, 0x5d, 0x64, 0x08, 0x8d, 0x91, 0xcf, 0x82, 0x90, 0x9f, 0xc7, 0x91, 0x1f,
-	0x47, 0x61, 0x4b, 0x6d, 0x97, 0x3b, 0x46, 0x6f, 0xab, 0x9b, 0x30, 0xb2, 0x4c, 0xa8, 0x5b, 0x88,
-	0x12, 0x2e, 0x5a, 0x3c, 0xb9, 0x71, 0x0a, 0xe4, 0x7e, 0x10, 0x69, 0x42, 0xf9, 0x46, 0x52, 0xd1,
-	0xa0, 0xf8, 0x49, 0x36, 0x41, 0xbb, 0x65, 0xd3, 0x98, 0x8b, 0x94, 0x8d, 0x9e, 0x91, 0x7b, 0x8a,
-	0x26, 0xc8, 0x8b, 0xd2, 0x81, 0x62, 0x5e, 0x81, 0x8a, 0x2e, 0xb2, 0x07, 0x80, 0xbf, 0x27, 0x9c,
-	0x4d, 0x78, 0x20, 0xee, 0x31, 0x7a, 0xbf, 0xe4, 0xce, 0x24, 0x00, 0xcd, 0x05, 0x91, 0x5f, 0x41,
-	0x1b, 0xf9, 0xdc, 0x8d, 0x24, 0x29, 0x89, 0x41, 0x7e, 0x03, 0x9d, 0xcd, 0xbc, 0xd8, 0x4d, 0x78,
-	0x50, 0xa9, 0xb4, 0xcc, 0x0f, 0x4a, 0xfe, 0x05, 0xd2, 0x82, 0xca, 0x3b, 0x1e, 0x84, 0x8e, 0xe7,
-	0x8a, 0xc7, 0x34, 0x9a, 0x9a, 0xe4, 0x3f, 0x00, 0x2b, 0xe0, 0xb7, 0xf6, 0x5c, 0xf4, 0xae, 0x98,
-	0x3d, 0xba, 0x68, 0x0e, 0x26, 0x1d, 0xd0, 0x51, 0x3e, 0x9c, 0x8b, 0xd7, 0x8c, 0x5e, 0x53, 0x06,
-	0x2e, 0xc5, 0x40, 0x25, 0x4e, 0xba, 0x39, 0xa1, 0xb5, 0xd4, 0x42, 0xf0, 0xd2, 0x4f, 0xb3, 0x10,
-	0xf3, 0x4e, 0x81, 0x92, 0x3d, 0x27, 0x5b, 0xa0, 0x63, 0xd6, 0x43, 0x4c, 0xb3, 0xbc, 0xca, 0xa3,
-	0x84, 0xc8, 0x36, 0x54, 0xf0, 0xeb, 0x3c, 0x46, 0x2e, 0xee, 0x45, 0xa5, 0x18, 0xd9, 0x04, 0x15,
-	0xdd, 0x22, 0xd5, 0xb5, 0x5e, 0x43, 0xc6, 0xd8, 0x73, 0x7b, 0xe1, 0x73, 0x2a, 0x20, 0xa4, 0xe5,
-	0xd0, 0x73, 0x23, 0x64, 0x55, 0x15, 0xca, 0x4f, 0x4d, 0xb3, 0x0d, 0xfa, 0x99, 0x37, 0xe1, 0xc3,
-	0x23, 0x64, 0x38, 0xf9, 0x92, 0xa3, 0x22, 0x2d, 0xf3, 0x00, 0xd6, 0xfa, 0xe3, 0x31, 0x92, 0xdd,
-	0x9f, 0x4c, 0x02, 0x1e, 0x86, 0xe4, 0x9f, 0x55, 0x8f, 0x3c, 0xb1, 0xe2, 0x35, 0x3f, 0x2b, 0xa0,
-	0x3f, 0xd9, 0x97, 0x5d, 0xa8, 0x5a, 0x81, 0x37, 0x89, 0xc7, 0x3c, 0x90, 0x5d, 0x49, 0x2b, 0x48,
-	0xde, 0xa7, 0x4b, 0x98, 0xfc, 0x05, 0x2a, 0xf5, 0xbc, 0x48, 0xf6, 0xa4, 0xd0, 0x3c, 0x01, 0x20,
-	0xab, 0x16, 0x0b, 0xd2, 0x2a, 0x57, 0x42, 0x24, 0x84, 0x42, 0x38, 0xe5, 0xc1, 0xcd, 0x94, 0x8b,
-	0xbb, 0xb4, 0x07, 0x84, 0x90, 0xc1, 0xb8, 0x11, 0x6c, 0x67, 0xc6, 0xc3, 0x88, 0xcd, 0xfc, 0x96,
-	0xde, 0x56, 0x3a, 0x65, 0x9a, 0x39, 0xcc, 0x8f, 0x0a, 0xd4, 0x85, 0x0e, 0x26, 0xb2, 0xcc, 0xed,
-	0xb4, 0x60, 0x29, 0xf5, 0xb4, 0x14, 0x29, 0xf3, 0x94, 0x8d, 0x5d, 0xa8, 0x25, 0x63, 0xfc, 0x88,
-	0x14, 0x33, 0xf4, 0x07, 0x2a, 0xf1, 0x25, 0x68, 0xa3, 0x88, 0x45, 0x1c, 0x69, 0xc5, 0xbc, 0x64,
-	0xca, 0x45, 0x5a, 0x11, 0x40, 0x65, 0xc8, 0x1d, 0x54, 0x12, 0xbd, 0x93, 0x96, 0x69, 0x83, 0x3a,
-	0xb0, 0x12, 0x31, 0xcb, 0xb1, 0x7a, 0xe0, 0x0a, 0x09, 0x91, 0x1d, 0x0c, 0x3a, 0x62, 0x11, 0x93,
-	0x05, 0xff, 0x2c, 0x83, 0xf0, 0x06, 0x74, 0x53, 0x09, 0x9b, 0x5f, 0x15, 0xa8, 0xa6, 0x4e, 0x62,
-	0x42, 0x5d, 0x8a, 0xea, 0xcc, 0x73, 0xc7, 0xc9, 0x2e, 0x55, 0x69, 0xc1, 0x47, 0xf6, 0xa1, 0x46,
-	0xf9, 0xd8, 0xf1, 0x9d, 0x74, 0x69, 0x18, 0xbd, 0x75, 0x79, 0x79, 0x51, 0x90, 0x34, 0x8b, 0xc3,
-	0x9a, 0xfa, 0xd9, 0x3e, 0xa9, 0x53, 0x69, 0xa1, 0x50, 0x2d, 0xb6, 0x98, 0x7a, 0x6c, 0x22, 0x38,
-	0xac, 0xd3, 0xd4, 0x2c, 0xf2, 0xab, 0x3d, 0xc9, 0x6f, 0xae, 0x73, 0xfa, 0xf7, 0x3b, 0x67, 0x7e,
-	0x11, 0x15, 0x3f, 0x39, 0x29, 0x7b, 0xf7, 0x26, 0xe5, 0x91, 0x32, 0x9f, 0xf1, 0xc4, 0x7c, 0x52,
-	0x60, 0x6d, 0x60, 0x15, 0x66, 0x66, 0x67, 0x65, 0x66, 0x32, 0x61, 0x3c, 0xc7, 0xa9, 0xb9, 0x80,
-	0xca, 0xc0, 0x12, 0x0f, 0x91, 0xff, 0x57, 0x12, 0x5f, 0x5f, 0x26, 0x9e, 0xaf, 0x6f, 0x99, 0xfe,
-	0xef, 0xb8, 0xf8, 0x57, 0x16, 0x39, 0xea, 0x9c, 0x96, 0xec, 0xf9, 0xbf, 0x6d, 0x9c, 0x0e, 0x5c,
-	0xd8, 0xa4, 0x06, 0xda, 0xdb, 0x8b, 0x63, 0xfa, 0xbe, 0xf9, 0x13, 0x31, 0xa0, 0x32, 0xb2, 0xcf,
-	0x69, 0xff, 0xf5, 0x71, 0x53, 0xb9, 0xd4, 0xc5, 0xff, 0x9b, 0xfd, 0x6f, 0x01, 0x00, 0x00, 0xff,
-	0xff, 0x67, 0x18, 0x57, 0xf0, 0xee, 0x08, 0x00, 0x00,
+	// 1339 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x57, 0x4d, 0x73, 0xdb, 0x44,
+	0x18, 0xae, 0x6c, 0xc9, 0x1f, 0xaf, 0x9d, 0x44, 0x5d, 0x92, 0x56, 0x14, 0xa6, 0xb8, 0x1b, 0x4a,
+	0x9d, 0x32, 0x04, 0x9a, 0x1e, 0xe8, 0xc0, 0x30, 0x83, 0x3f, 0xd4, 0x44, 0xd3, 0xc6, 0x56, 0x57,
+	0x72, 0x3b, 0x9c, 0x32, 0x5b, 0x7b, 0x27, 0xd1, 0xc4, 0x96, 0x3c, 0x92, 0x1c, 0x92, 0x2b, 0xf4,
+	0xda, 0x23, 0x7f, 0x81, 0x19, 0xfe, 0x02, 0x07, 0x6e, 0xfc, 0x06, 0x6e, 0xfc, 0x16, 0x66, 0x57,
+	0x2b, 0x5b, 0x72, 0x52, 0xe2, 0x0b, 0x43, 0x2f, 0xb1, 0xde, 0xe7, 0xfd, 0xd8, 0xf7, 0x7b, 0x37,
+	0x50, 0x8b, 0x2f, 0xa6, 0x2c, 0xda, 0x9d, 0x86, 0x41, 0x1c, 0x20, 0x4d, 0x10, 0xf8, 0x01, 0x54,
+	0x1d, 0xef, 0xd8, 0xa7, 0xf1, 0x2c, 0x64, 0xa8, 0x0e, 0x0a, 0x31, 0x94, 0x86, 0xd2, 0xac, 0x12,
+	0x85, 0x70, 0xca, 0x31, 0x0a, 0x09, 0xe5, 0xe0, 0x1d, 0xa8, 0xda, 0xb3, 0xd7, 0x63, 0x6f, 0xf8,
+	0x8c, 0x5d, 0xa0, 0x8f, 0x33, 0x84, 0x50, 0xa8, 0x93, 0x05, 0x80, 0xef, 0x80, 0x7a, 0x40, 0xa3,
+	0x13, 0x84, 0x92, 0x5f, 0x29, 0x20, 0xbe, 0xf1, 0xdb, 0x02, 0x54, 0x07, 0xf1, 0x79, 0x60, 0xfa,
+	0x71, 0x78, 0x81, 0xee, 0x02, 0x58, 0x51, 0x27, 0xf0, 0xfc, 0xd7, 0x34, 0x62, 0x42, 0xae, 0x42,
+	0x32, 0x08, 0xfa, 0x14, 0xd6, 0x9e, 0x86, 0xc1, 0xe4, 0x90, 0x7a, 0x7e, 0xe7, 0x84, 0x7a, 0xbe,
+	0x70, 0xa7, 0x42, 0xf2, 0x20, 0x6a, 0x40, 0xad, 0x3d, 0x0e, 0x86, 0xa7, 0x07, 0xcc, 0x3b, 0x3e,
+	0x89, 0x8d, 0x62, 0x43, 0x69, 0xae, 0x91, 0x2c, 0x84, 0x2c, 0x58, 0x73, 0xa6, 0x34, 0x8c, 0x58,
+	0x7f, 0x16, 0x4f, 0x67, 0x71, 0x64, 0xa8, 0x8d, 0x62, 0xb3, 0xb6, 0xb7, 0xbd, 0x9b, 0x64, 0x64,
+	0xee, 0xd0, 0x6e, 0x4e, 0x4a, 0x40, 0x24, 0xaf, 0x79, 0xe7, 0x10, 0xd0, 0x65, 0x21, 0xa4, 0x43,
+	0xf1, 0x54, 0xa6, 0x62, 0x8d, 0xf0, 0x4f, 0x74, 0x0f, 0xb4, 0x33, 0x3a, 0x9e, 0x31, 0xe1, 0x72,
+	0x6d, 0xaf, 0x96, 0x39, 0x8a, 0x24, 0x9c, 0x6f, 0x0a, 0x4f, 0x14, 0x7c, 0x0c, 0x2a, 0x87, 0xd0,
+	0x23, 0x00, 0xfe, 0x7b, 0xc0, 0xe8, 0x88, 0x85, 0xc2, 0x4e, 0x6d, 0xef, 0x66, 0x46, 0x27, 0x61,
+	0x90, 0x8c, 0x10, 0xda, 0x04, 0xcd, 0x99, 0x32, 0x3f, 0x96, 0x49, 0x49, 0x08, 0x74, 0x0b, 0x4a,
+	0x74, 0x12, 0xcc, 0xfc, 0x24, 0x0f, 0x2a, 0x91, 0x14, 0xfe, 0x4b, 0xc9, 0x9e, 0x80, 0x0c, 0x28,
+	0xbf, 0x64, 0x61, 0xe4, 0x05, 0xbe, 0x38, 0x4c, 0x23, 0x29, 0x89, 0x3e, 0x07, 0xb0, 0x43, 0x76,
+	0xe6, 0x9e, 0x8b, 0xda, 0xe5, 0xbd, 0xe7, 0x10, 0xc9, 0xb0, 0x51, 0x13, 0x4a, 0xbc, 0x7d, 0x18,
+	0x13, 0xa7, 0xd5, 0xf6, 0x74, 0x29, 0x38, 0x6f, 0x06, 0x22, 0xf9, 0x68, 0x37, 0xd3, 0x68, 0x86,
+	0x9a, 0x13, 0x9e, 0xe3, 0x64, 0x21, 0x82, 0x9a, 0xb0, 0xc1, 0xcf, 0x49, 0xb2, 0x6c, 0xf9, 0x23,
+	0x76, 0x6e, 0x68, 0x22, 0xbb, 0xcb, 0x30, 0x7e, 0xab, 0x40, 0xc1, 0x3d, 0x47, 0xdb, 0x50, 0xe2,
+	0xf1, 0x59, 0x3c, 0xa0, 0xe2, 0x72, 0xc6, 0x25, 0x0b, 0xdd, 0x87, 0x32, 0xff, 0xea, 0xcf, 0x78,
+	0xd6, 0x2e, 0x49, 0xa5, 0x3c, 0x74, 0x0f, 0x54, 0x0e, 0x8b, 0xa0, 0xd6, 0xf7, 0xd6, 0xa4, 0x8c,
+	0x7b, 0xee, 0x5e, 0x4c, 0x19, 0x11, 0x2c, 0x9e, 0xc0, 0x4e, 0xe0, 0xc7, 0x3c, 0xff, 0xaa, 0x98,
+	0x91, 0x94, 0xc4, 0x0d, 0x28, 0xf5, 0x82, 0x11, 0xb3, 0xba, 0xe8, 0x56, 0xfa, 0x25, 0x87, 0x4a,
+	0x52, 0xf8, 0x09, 0xac, 0xb7, 0x86, 0x43, 0x5e, 0x96, 0xd6, 0x68, 0x14, 0xb2, 0x28, 0x42, 0x9f,
+	0x2d, 0x23, 0x52, 0x63, 0x09, 0xc5, 0x7f, 0x2b, 0x50, 0xba, 0xb6, 0x82, 0x3b, 0x50, 0xb1, 0xc3,
+	0x60, 0x34, 0x1b, 0xb2, 0x50, 0xd6, 0x2f, 0x8d, 0x20, 0x39, 0x9f, 0xcc, 0xd9, 0xe8, 0x13, 0x50,
+	0x49, 0x10, 0xc4, 0xb2, 0x7a, 0xb9, 0x32, 0x0b, 0x06, 0xcf, 0xaa, 0x4d, 0xc3, 0x34, 0xca, 0x25,
+	0x11, 0xc9, 0xe2, 0x2d, 0x73, 0xc8, 0xc2, 0xd3, 0x31, 0x13, 0xb6, 0xb4, 0xcb, 0x82, 0x19, 0x36,
+	0xdf, 0x1d, 0xae, 0x37, 0x61, 0x51, 0x4c, 0x27, 0x53, 0xa3, 0xd4, 0x50, 0x9a, 0x45, 0xb2, 0x00,
+	0xf0, 0xef, 0x0a, 0xd4, 0x45, 0xc7, 0x8c, 0x64, 0x98, 0xf7, 0xd3, 0x80, 0x0d, 0x25, 0x17, 0x4a,
+	0x02, 0x92, 0x34, 0x1b, 0x3b, 0x50, 0x4d, 0x06, 0xfe, 0x1d, 0x4d, 0xbb, 0xe0, 0xfe, 0x77, 0x3d,
+	0x8b, 0xbf, 0x07, 0xcd, 0x89, 0x69, 0xcc, 0x78, 0x5a, 0xb9, 0x5f, 0x86, 0x72, 0xd9, 0x11, 0xc1,
+	0xe0, 0x9d, 0x21, 0xb7, 0x55, 0x41, 0xd4, 0x4e, 0x52, 0xd8, 0x05, 0xb5, 0x6d, 0x27, 0xcd, 0x2c,
+	0x07, 0xf0, 0x0a, 0x13, 0x92, 0x85, 0x1e, 0x70, 0xa1, 0x2e, 0x8d, 0xa9, 0x0c, 0x78, 0x43, 0x0a,
+	0xb5, 0xed, 0x04, 0x26, 0x92, 0x8d, 0x7f, 0x2d, 0x40, 0x25, 0x05, 0x11, 0x86, 0xba, 0x6c, 0xaa,
+	0x5e, 0xe0, 0x0f, 0x93, 0xad, 0xab, 0x92, 0x1c, 0x86, 0x1e, 0x43, 0x95, 0xb0, 0xa1, 0x37, 0xf5,
+	0xd2, 0xf5, 0x52, 0xdb, 0xdb, 0x92, 0xc6, 0xf3, 0x0d, 0x49, 0x16, 0x72, 0x3c, 0xa6, 0xd6, 0x62,
+	0xf3, 0xd4, 0x89, 0xa4, 0x78, 0xa3, 0xda, 0xf4, 0x62, 0x1c, 0xd0, 0x91, 0xc8, 0x61, 0x9d, 0xa4,
+	0x64, 0x3e, 0xbf, 0xda, 0x2a, 0x3b, 0x21, 0xad, 0x5c, 0xe9, 0x9a, 0xca, 0xe9, 0x50, 0x7c, 0xca,
+	0x98, 0x51, 0x16, 0xb1, 0xf1, 0x4f, 0xb4, 0x0d, 0x2a, 0x9f, 0x5e, 0xa3, 0x22, 0x46, 0x3a, 0x9b,
+	0xaa, 0x64, 0xa8, 0xf9, 0x5f, 0xfc, 0x8b, 0x48, 0xd4, 0xb5, 0x03, 0xf6, 0xe8, 0xd2, 0x80, 0xbd,
+	0x23, 0x3b, 0xef, 0xef, 0xa0, 0x89, 0x35, 0x4f, 0x43, 0x3a, 0x89, 0x44, 0x97, 0x95, 0xaf, 0x5a,
+	0xf3, 0x73, 0x36, 0xfe, 0x43, 0x81, 0xf5, 0xb6, 0x9d, 0x9b, 0xcb, 0x07, 0x4b, 0x73, 0xb9, 0xc8,
+	0xe8, 0xfb, 0x38, 0x99, 0x03, 0x28, 0xb7, 0x6d, 0x71, 0x10, 0xfa, 0x62, 0xc9, 0xf1, 0xad, 0xb9,
+	0xe3, 0xd9, 0xf8, 0xe6, 0xee, 0x7f, 0xc4, 0x2f, 0x97, 0xa5, 0xcb, 0x82, 0x77, 0x0d, 0x29, 0xb8,
+	0xe7, 0xf8, 0x5b, 0xd8, 0xea, 0x84, 0x8c, 0xc6, 0x8c, 0x4f, 0x16, 0x7f, 0xb1, 0xa4, 0x9d, 0x8d,
+	0xa1, 0x4e, 0xd8, 0x74, 0xec, 0x0d, 0x69, 0x47, 0x4c, 0x44, 0xf2, 0x30, 0xc8, 0x61, 0xf8, 0x2b,
+	0x58, 0xef, 0xb2, 0x69, 0x10, 0x79, 0x71, 0xaa, 0x75, 0x17, 0x20, 0x35, 0x34, 0xbf, 0x33, 0x32,
+	0x08, 0x7e, 0x04, 0x1b, 0xaf, 0xbc, 0xf8, 0x64, 0x14, 0xd2, 0x1f, 0x57, 0x55, 0xf9, 0x59, 0x81,
+	0x5b, 0xfb, 0x21, 0xf5, 0x63, 0x9b, 0x85, 0x13, 0x2f, 0xe2, 0xed, 0xbb, 0xa2, 0x2a, 0xda, 0x01,
+	0x75, 0x10, 0x5d, 0xd7, 0xe1, 0x42, 0x84, 0x9b, 0x5a, 0xd8, 0x97, 0x0f, 0xb0, 0x0c, 0x82, 0xdf,
+	0x28, 0x70, 0x9b, 0xb0, 0xb3, 0xe0, 0x94, 0xfd, 0xaf, 0x6e, 0x1c, 0xc1, 0x87, 0x83, 0xe9, 0x88,
+	0xc6, 0x2c, 0x5b, 0x87, 0x55, 0xfd, 0x58, 0x2e, 0x69, 0xe1, 0x8a, 0x92, 0x7e, 0x07, 0x9b, 0x84,
+	0x1d, 0x7b, 0x51, 0xcc, 0xc2, 0x43, 0xcf, 0x67, 0x61, 0x6a, 0xfb, 0x7e, 0xee, 0x21, 0x70, 0xe9,
+	0x3e, 0x96, 0x4c, 0x4c, 0xa0, 0xd6, 0xf6, 0xc6, 0x63, 0xcf, 0x3f, 0xb6, 0x62, 0x36, 0x41, 0xdb,
+	0xa0, 0x09, 0x2b, 0x57, 0x2b, 0x25, 0x3c, 0xee, 0xf6, 0x8b, 0x19, 0x0b, 0x2f, 0x16, 0x4e, 0xa9,
+	0x24, 0x83, 0xe0, 0x37, 0x05, 0x58, 0x97, 0x46, 0x57, 0x8d, 0xb4, 0x01, 0x35, 0x27, 0xa6, 0x61,
+	0x9c, 0xbb, 0xa1, 0xb2, 0x10, 0x5f, 0x2d, 0xa6, 0x3f, 0xca, 0xbc, 0xb7, 0x35, 0xb2, 0x00, 0x50,
+	0x13, 0x34, 0xee, 0x7f, 0xfa, 0xca, 0x46, 0xe9, 0xd4, 0x2c, 0x42, 0x23, 0x89, 0x00, 0xfa, 0x12,
+	0xea, 0xa6, 0x3f, 0x5a, 0xac, 0x87, 0x2b, 0x36, 0x5a, 0x4e, 0x00, 0x7d, 0x0d, 0x30, 0x1f, 0xea,
+	0xc8, 0x28, 0x09, 0xfb, 0xb7, 0xf3, 0xf6, 0xe7, 0x7c, 0x92, 0x11, 0xc5, 0xbf, 0x29, 0xb0, 0xc5,
+	0x4b, 0x45, 0x87, 0x69, 0xf1, 0x57, 0xcd, 0xc6, 0xbc, 0x0a, 0x85, 0x7f, 0xa9, 0x02, 0x02, 0xd5,
+	0x65, 0xe1, 0x44, 0xbe, 0xb9, 0xc5, 0xf7, 0x92, 0xaf, 0xea, 0xea, 0xbe, 0xfe, 0xa4, 0x80, 0xbe,
+	0x2c, 0x90, 0xd9, 0x8d, 0xca, 0x35, 0xbb, 0x71, 0x13, 0xb4, 0xe4, 0x66, 0x2f, 0x88, 0xdb, 0x36,
+	0x21, 0xf2, 0x1b, 0xb3, 0x78, 0xed, 0xc6, 0x7c, 0xd8, 0xe0, 0x8f, 0x0b, 0x7e, 0x29, 0xa2, 0x2a,
+	0x68, 0x2f, 0x06, 0x26, 0xf9, 0x41, 0xbf, 0x81, 0x6a, 0x50, 0x76, 0xdc, 0x3e, 0x69, 0xed, 0x9b,
+	0xba, 0xf2, 0xf0, 0x4f, 0x25, 0x79, 0x55, 0x08, 0xa1, 0x3a, 0x54, 0x5c, 0xd2, 0xea, 0x39, 0x4f,
+	0x4d, 0xa2, 0xdf, 0x40, 0x1f, 0xc0, 0x46, 0x87, 0x98, 0x2d, 0xd7, 0x3c, 0xea, 0xb6, 0xdc, 0x56,
+	0xbb, 0xe5, 0x98, 0xba, 0xc2, 0x95, 0xbb, 0xa6, 0xdd, 0x77, 0x2c, 0x57, 0x2f, 0x70, 0xf9, 0x57,
+	0x96, 0x7b, 0xd0, 0x25, 0xad, 0x57, 0x7a, 0x11, 0x6d, 0x82, 0xbe, 0x4f, 0x5a, 0x3d, 0xf7, 0xc8,
+	0x36, 0xc9, 0xa1, 0xe5, 0x38, 0x56, 0xbf, 0xa7, 0xab, 0x68, 0x0b, 0x6e, 0x12, 0xf3, 0x65, 0xff,
+	0x99, 0x99, 0x85, 0x35, 0x64, 0xc0, 0xe6, 0xc0, 0xee, 0x72, 0xe3, 0xc4, 0xb4, 0x9f, 0x5b, 0x9d,
+	0xd6, 0x51, 0xa7, 0x3f, 0xe8, 0xb9, 0x7a, 0x09, 0x21, 0x58, 0x27, 0xe6, 0xbe, 0xe5, 0xb8, 0x26,
+	0x39, 0x3a, 0xb4, 0x7a, 0x26, 0xd1, 0xcb, 0xfc, 0xd4, 0xb6, 0xf5, 0xfc, 0xb9, 0xd5, 0xdb, 0xd7,
+	0x2b, 0xdc, 0x2f, 0xae, 0xd3, 0xea, 0xcc, 0x75, 0xf5, 0xea, 0xeb, 0x92, 0xf8, 0x87, 0xf8, 0xf1,
+	0x3f, 0x03, 0x00, 0xdc, 0x5d, 0x25, 0x49, 0x1f, 0x0f, 0x00, 0x00,
 }